@@ -0,0 +1,101 @@
+// Command weather-plugin-example is a sample backend plugin, to start from when writing one. It answers every location
+// with the same conditions, taken from the "config" of the backend in the config.json of the server, i.e.
+//
+//	{
+//	  "name": "example",
+//	  "type": "plugin",
+//	  "command": ["weather-plugin-example"],
+//	  "config": {"temperature": 21.5, "amplitude": 4, "description": "Sunny", "forecastDays": 3}
+//	}
+//
+// The server talks to it over stdin and stdout, everything written to stderr ends up in the logs of the server.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go-weather-app/server/backends/plugin"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+)
+
+// config is the "config" of the backend
+type config struct {
+	Temperature  float32 `json:"temperature"`
+	Amplitude    float32 `json:"amplitude"`    // how far the minimum and maximum are from the temperature
+	Description  string  `json:"description"`  // defaults to "Clear"
+	ForecastDays int     `json:"forecastDays"` // defaults to 5
+	Delay        string  `json:"delay"`        // how long every answer takes, i.e. to try out timeouts
+}
+
+// model is the backend served by the plugin
+type model struct {
+	config
+	delay time.Duration
+}
+
+func main() {
+	err := plugin.Serve(os.Stdin, os.Stdout, func(name string, raw json.RawMessage) (types.WeatherBackend, error) {
+		m := &model{config: config{Description: "Clear", ForecastDays: 5}}
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &m.config); err != nil {
+				return nil, errors.New("Invalid config: " + err.Error())
+			}
+		}
+		if m.Delay != "" {
+			var err error
+			if m.delay, err = time.ParseDuration(m.Delay); err != nil {
+				return nil, errors.New("Invalid delay: " + m.Delay)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "serving", name)
+		return m, nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// GetWeather answers every location with the configured conditions
+func (m *model) GetWeather(loc location.Location) types.Weather {
+	time.Sleep(m.delay)
+	if loc.Name == "" && loc.Coordinates == nil {
+		return types.Weather{Error: "No location specified"}
+	}
+	return types.Weather{
+		Temperature:     m.Temperature,
+		TemperatureMin:  m.Temperature - m.Amplitude,
+		TemperatureMax:  m.Temperature + m.Amplitude,
+		MainDescription: m.Description,
+		Location:        &types.ResolvedLocation{Location: loc},
+	}
+}
+
+// GetForecast answers every location with the configured conditions, for every day starting today in the timezone of
+// the location
+func (m *model) GetForecast(loc location.Location) types.Forecast {
+	time.Sleep(m.delay)
+	if loc.Name == "" && loc.Coordinates == nil {
+		return types.Forecast{Error: "No location specified"}
+	}
+	tz, err := time.LoadLocation(loc.Timezone)
+	if err != nil {
+		tz = time.UTC
+	}
+	today := time.Now().In(tz)
+	days := []types.ForecastDay{}
+	for i := 0; i < m.ForecastDays; i++ {
+		days = append(days, types.ForecastDay{
+			Date:            today.AddDate(0, 0, i).Format("2006-01-02"),
+			TemperatureMin:  m.Temperature - m.Amplitude,
+			TemperatureMax:  m.Temperature + m.Amplitude,
+			MainDescription: m.Description,
+		})
+	}
+	return types.Forecast{Days: days, Location: &types.ResolvedLocation{Location: loc}}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-weather-app/server/api"
+)
+
+// Client calls the /v2 API of a weather server
+type Client struct {
+	BaseURL  string // i.e. "http://localhost:8080"
+	Backends []string
+	APIKey   string // the API key or token of the client, when the server authenticates its clients
+	HTTP     *http.Client
+}
+
+// ProblemError is returned when the server answered with a problem
+type ProblemError struct {
+	Problem api.Problem
+}
+
+func (e *ProblemError) Error() string {
+	if e.Problem.Detail != "" {
+		return e.Problem.Detail
+	}
+	return e.Problem.Title
+}
+
+// Weather gets the current weather for the city. When every source failed, the sources are returned along with the
+// ProblemError.
+func (c *Client) Weather(city string) (*api.WeatherResponseV2, []byte, error) {
+	response := &api.WeatherResponseV2{}
+	body, err := c.get(cityPath("/v2/weather/", city), true, response)
+	if problem, ok := err.(*ProblemError); ok {
+		failed := struct {
+			api.Problem
+			Sources []api.WeatherSource `json:"sources"`
+		}{}
+		if json.Unmarshal(body, &failed) == nil {
+			response = &api.WeatherResponseV2{City: city, Sources: failed.Sources}
+		}
+		return response, body, problem
+	}
+	return response, body, err
+}
+
+// Forecast gets the daily forecast for the city. When every source failed, the sources are returned along with the
+// ProblemError.
+func (c *Client) Forecast(city string) (*api.ForecastResponseV2, []byte, error) {
+	response := &api.ForecastResponseV2{}
+	body, err := c.get(cityPath("/v2/forecast/", city), true, response)
+	if problem, ok := err.(*ProblemError); ok {
+		failed := struct {
+			api.Problem
+			Sources []api.ForecastSource `json:"sources"`
+		}{}
+		if json.Unmarshal(body, &failed) == nil {
+			response = &api.ForecastResponseV2{City: city, Sources: failed.Sources}
+		}
+		return response, body, problem
+	}
+	return response, body, err
+}
+
+// ListBackends lists the backends of the server and how the last call to each of them went
+func (c *Client) ListBackends() (*api.BackendsResponseV2, []byte, error) {
+	response := &api.BackendsResponseV2{}
+	body, err := c.get("/v2/backends", false, response)
+	return response, body, err
+}
+
+// cityPath escapes the city the same way browsers do: the server only unescapes paths that are escaped the standard way,
+// so i.e. the comma of "Springfield, IL" has to be left alone
+func cityPath(prefix string, city string) string {
+	return (&url.URL{Path: prefix + city}).EscapedPath()
+}
+
+// get decodes the json response to the path into v, it also returns the body as it was sent
+func (c *Client) get(path string, withBackends bool, v interface{}) ([]byte, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + path
+	if withBackends && len(c.Backends) > 0 {
+		u += "?" + url.Values{"backend": {strings.Join(c.Backends, ",")}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, "+api.MIMEApplicationProblemJSON)
+	req.Header.Set("User-Agent", "go-weather-app-cli")
+	if c.APIKey != "" {
+		req.Header.Set(api.HeaderAPIKey, c.APIKey)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), api.MIMEApplicationProblemJSON) {
+		problem := api.Problem{}
+		if err := json.Unmarshal(body, &problem); err != nil {
+			return body, errors.New("Unable to decode problem from server: " + err.Error())
+		}
+		return body, &ProblemError{Problem: problem}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, errors.New("Unexpected response from server: " + resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return body, errors.New("Unable to decode response from server: " + err.Error())
+	}
+	return body, nil
+}
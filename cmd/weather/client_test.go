@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-weather-app/server/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Weather(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.RequestURI()
+		switch r.URL.Path {
+		case "/v2/weather/Springfield, IL":
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{"city":"Springfield, IL","location":{"name":"Springfield"},"sources":[{"source":"foo","status":"ok","data":{"temperature":12}}]}`))
+		case "/v2/weather/nowhere":
+			w.Header().Set("Content-Type", api.MIMEApplicationProblemJSON)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"type":"urn:go-weather-app:problem:all_sources_failed","title":"Every source failed","status":502,"detail":"None of the requested backends returned data","code":"all_sources_failed","sources":[{"source":"foo","status":"error","error":{"code":"backend_unavailable","message":"Error communicating to backend"}}]}`))
+		case "/v2/weather/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write([]byte(`{`))
+		}
+	}))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL + "/", Backends: []string{"foo", "bar"}}
+
+	response, body, err := client.Weather("Springfield, IL")
+	require.NoError(t, err)
+	require.Equal(t, "/v2/weather/Springfield,%20IL?backend=foo%2Cbar", requested)
+	require.Equal(t, "Springfield", response.Location.Name)
+	require.Equal(t, []api.WeatherSource{{Source: "foo", Status: api.SourceStatusOK, Data: &api.WeatherData{Temperature: 12}}}, response.Sources)
+	require.Contains(t, string(body), `"city":"Springfield, IL"`)
+
+	// the sources that failed are still returned
+	response, _, err = client.Weather("nowhere")
+	require.EqualError(t, err, "None of the requested backends returned data")
+	require.Equal(t, api.ProblemAllSourcesFailed, err.(*ProblemError).Problem.Code)
+	require.Equal(t, []api.WeatherSource{{Source: "foo", Status: api.SourceStatusError, Error: &api.SourceError{Code: api.SourceErrorUnavailable, Message: "Error communicating to backend"}}}, response.Sources)
+
+	_, _, err = client.Weather("teapot")
+	require.EqualError(t, err, "Unexpected response from server: 418 I'm a teapot")
+
+	_, _, err = client.Weather("garbage")
+	require.EqualError(t, err, "Unable to decode response from server: unexpected end of JSON input")
+}
+
+func TestClient_ListBackends(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v2/backends", r.URL.RequestURI())
+		require.Equal(t, "0123456789abcdef", r.Header.Get(api.HeaderAPIKey))
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(`{"backends":[{"name":"foo","default":true,"supports_forecasts":false}]}`))
+	}))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL, Backends: []string{"foo"}, APIKey: "0123456789abcdef"}
+
+	response, _, err := client.ListBackends()
+	require.NoError(t, err)
+	require.Equal(t, []api.BackendV2{{Name: "foo", Default: true}}, response.Backends)
+
+	server.Close()
+	_, _, err = client.ListBackends()
+	require.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/backends"
+	_ "go-weather-app/server/backends/builtin"
+	"go-weather-app/server/location"
+	"go-weather-app/server/server"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/gommon/log"
+)
+
+// weatherClient gets the weather either from a server (Client) or straight from the backends (localClient)
+type weatherClient interface {
+	Weather(city string) (*api.WeatherResponseV2, []byte, error)
+	Forecast(city string) (*api.ForecastResponseV2, []byte, error)
+	ListBackends() (*api.BackendsResponseV2, []byte, error)
+}
+
+// localClient queries the backends configured in the configuration of a server in-process, and answers like the server
+// would. Nothing is cached and the backends aren't tracked, so every call goes upstream.
+type localClient struct {
+	backends        map[string]types.WeatherBackend
+	defaultBackends []string
+	resolver        location.Resolver
+	targetBackends  []string // defaults to defaultBackends
+}
+
+// newLocalClient configures the backends and the location resolver from the configuration of a server, loaded like the
+// server does (see server.LoadConfig) from its configuration file and environ. The backends call their APIs with
+// httpClient and log to logOutput.
+func newLocalClient(serverConfigFile string, environ []string, targetBackends []string, httpClient *http.Client, logOutput io.Writer) (*localClient, error) {
+	config, err := server.LoadConfig(serverConfigFile, environ)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New("weather")
+	logger.SetOutput(logOutput)
+	configured, defaultBackends, err := backends.Configure(config.Backends, backends.Environment{Logger: logger, HTTPClient: httpClient})
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := location.LoadResolver(config.Location.GeoNamesFile, config.Location.Admin1CodesFile, config.Location.PostalCodesFile)
+	if err != nil {
+		return nil, err
+	}
+	return &localClient{backends: configured, defaultBackends: defaultBackends, resolver: resolver, targetBackends: targetBackends}, nil
+}
+
+// Weather gets the current weather for the city from every target backend
+func (c *localClient) Weather(city string) (*api.WeatherResponseV2, []byte, error) {
+	loc, targetBackends, problem := c.resolve(city)
+	if problem != nil {
+		return &api.WeatherResponseV2{}, marshalBody(problem), &ProblemError{Problem: *problem}
+	}
+
+	data := []types.Weather{}
+	for _, backend := range targetBackends {
+		data = append(data, c.backends[backend].GetWeather(loc))
+	}
+	response, problem := api.NewWeatherResponseV2(city, loc, targetBackends, data)
+	if problem != nil {
+		return response, marshalBody(problem), &ProblemError{Problem: *problem}
+	}
+	return response, marshalBody(response), nil
+}
+
+// Forecast gets the daily forecast for the city from every target backend
+func (c *localClient) Forecast(city string) (*api.ForecastResponseV2, []byte, error) {
+	loc, targetBackends, problem := c.resolve(city)
+	if problem != nil {
+		return &api.ForecastResponseV2{}, marshalBody(problem), &ProblemError{Problem: *problem}
+	}
+
+	data := []types.Forecast{}
+	for _, backend := range targetBackends {
+		forecastBackend, ok := c.backends[backend].(types.ForecastBackend)
+		if !ok {
+			data = append(data, types.ForecastError(types.ErrForecastsNotSupported))
+			continue
+		}
+		data = append(data, forecastBackend.GetForecast(loc))
+	}
+	response, problem := api.NewForecastResponseV2(city, loc, targetBackends, data)
+	if problem != nil {
+		return response, marshalBody(problem), &ProblemError{Problem: *problem}
+	}
+	return response, marshalBody(response), nil
+}
+
+// ListBackends lists the configured backends, none of them has a status since they are only called on demand
+func (c *localClient) ListBackends() (*api.BackendsResponseV2, []byte, error) {
+	isDefault := map[string]bool{}
+	for _, backend := range c.defaultBackends {
+		isDefault[backend] = true
+	}
+
+	response := &api.BackendsResponseV2{Backends: []api.BackendV2{}}
+	for name, weatherBackend := range c.backends {
+		_, supportsForecasts := weatherBackend.(types.ForecastBackend)
+		response.Backends = append(response.Backends, api.BackendV2{Name: name, Default: isDefault[name], SupportsForecasts: supportsForecasts})
+	}
+	sort.Slice(response.Backends, func(i, j int) bool { return response.Backends[i].Name < response.Backends[j].Name })
+	return response, marshalBody(response), nil
+}
+
+// resolve validates the target backends and resolves the city like the server does, returning the problem it would
+func (c *localClient) resolve(city string) (location.Location, []string, *api.Problem) {
+	city = strings.TrimSpace(city)
+	if len(city) == 0 {
+		return location.Location{}, nil, api.NewProblem(http.StatusBadRequest, api.ProblemCityMissing, "No city specified. Please provide a city.")
+	}
+
+	targetBackends := c.targetBackends
+	if len(targetBackends) == 0 {
+		targetBackends = c.defaultBackends
+	}
+	for _, backend := range targetBackends {
+		if c.backends[backend] == nil {
+			return location.Location{}, nil, api.NewProblem(http.StatusBadRequest, api.ProblemInvalidBackend, "Backend specified is invalid or inactive: "+backend)
+		}
+	}
+
+	loc, err := c.resolver.Resolve(city)
+	if err != nil {
+		return location.Location{}, nil, api.NewProblem(http.StatusBadRequest, api.ProblemInvalidLocation, err.Error())
+	}
+	return loc, targetBackends, nil
+}
+
+// marshalBody encodes v the way the server would have sent it
+func marshalBody(v interface{}) []byte {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	return append(b, '\n')
+}
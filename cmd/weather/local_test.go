@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/backends/accuweather"
+	"go-weather-app/server/backends/openweathermap"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockWeatherBackend struct {
+	returnWeather types.Weather
+}
+
+func (m mockWeatherBackend) GetWeather(loc location.Location) types.Weather {
+	return m.returnWeather
+}
+
+type mockForecastBackend struct {
+	mockWeatherBackend
+	returnForecast types.Forecast
+}
+
+func (m mockForecastBackend) GetForecast(loc location.Location) types.Forecast {
+	return m.returnForecast
+}
+
+func newTestLocalClient(targetBackends []string) *localClient {
+	return &localClient{
+		backends: map[string]types.WeatherBackend{
+			"foo": mockForecastBackend{
+				mockWeatherBackend: mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12, MainDescription: "Rain"}},
+				returnForecast:     types.Forecast{Source: "foo", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20}}},
+			},
+			"bar": mockWeatherBackend{returnWeather: types.WeatherError(types.ErrUnavailable)},
+		},
+		defaultBackends: []string{"bar", "foo"},
+		resolver:        location.Resolver{Geocoder: location.Passthrough{}},
+		targetBackends:  targetBackends,
+	}
+}
+
+func Test_newLocalClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "owm")
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte("owm-key\n"), 0600))
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("backends:\n  - type: openweathermap\n    apiKey: file:"+secretFile+"\n"), 0644))
+
+	// the configuration is loaded like the server does: secret references are resolved, and the environment is applied
+	client, err := newLocalClient(configFile, []string{"WEATHER_BACKENDS_ACCUWEATHER_APIKEY=accu-key"}, nil, http.DefaultClient, ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"accuweather", "openweathermap"}, client.defaultBackends)
+	require.Equal(t, "owm-key", client.backends["openweathermap"].(openweathermap.Openweathermap).APIKey)
+	require.Equal(t, "accu-key", client.backends["accuweather"].(accuweather.Accuweather).APIKey)
+}
+
+func TestLocalClient_Weather(t *testing.T) {
+	client := newTestLocalClient(nil)
+	response, body, err := client.Weather("Ottawa")
+	require.NoError(t, err)
+	require.Equal(t, "Ottawa", response.City)
+	require.Equal(t, []api.WeatherSource{
+		{Source: "bar", Status: api.SourceStatusError, Error: &api.SourceError{Code: api.SourceErrorUnavailable, Message: "Error communicating to backend"}},
+		{Source: "foo", Status: api.SourceStatusOK, Data: &api.WeatherData{Temperature: 12, MainDescription: "Rain"}},
+	}, response.Sources)
+	require.Contains(t, string(body), "\"city\": \"Ottawa\"")
+
+	// the sources that failed are still returned
+	client = newTestLocalClient([]string{"bar"})
+	response, body, err = client.Weather("Ottawa")
+	require.EqualError(t, err, "None of the requested backends returned data")
+	require.Equal(t, api.ProblemAllSourcesFailed, err.(*ProblemError).Problem.Code)
+	require.Len(t, response.Sources, 1)
+	require.Contains(t, string(body), "\"title\": \"Every source failed\"")
+
+	client = newTestLocalClient([]string{"baz"})
+	_, _, err = client.Weather("Ottawa")
+	require.EqualError(t, err, "Backend specified is invalid or inactive: baz")
+	require.Equal(t, api.ProblemInvalidBackend, err.(*ProblemError).Problem.Code)
+
+	client = newTestLocalClient(nil)
+	_, _, err = client.Weather(" ")
+	require.Equal(t, api.ProblemCityMissing, err.(*ProblemError).Problem.Code)
+}
+
+func TestLocalClient_Forecast(t *testing.T) {
+	client := newTestLocalClient(nil)
+	response, _, err := client.Forecast("Ottawa")
+	require.NoError(t, err)
+	require.Equal(t, []api.ForecastSource{
+		{Source: "bar", Status: api.SourceStatusError, Error: &api.SourceError{Code: api.SourceErrorNotSupported, Message: "Forecasts are not supported by this backend"}},
+		{Source: "foo", Status: api.SourceStatusOK, Data: &api.ForecastData{Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20}}}},
+	}, response.Sources)
+
+	client = newTestLocalClient([]string{"bar"})
+	_, _, err = client.Forecast("Ottawa")
+	require.Equal(t, api.ProblemAllSourcesUnsupported, err.(*ProblemError).Problem.Code)
+}
+
+func TestLocalClient_ListBackends(t *testing.T) {
+	client := newTestLocalClient(nil)
+	client.defaultBackends = []string{"foo"}
+	response, _, err := client.ListBackends()
+	require.NoError(t, err)
+	require.Equal(t, []api.BackendV2{{Name: "bar"}, {Name: "foo", Default: true, SupportsForecasts: true}}, response.Backends)
+}
@@ -0,0 +1,282 @@
+// Command weather gets the current weather, forecasts and backends from a weather server, i.e.
+//
+//	weather current Ottawa "Springfield, IL, US"
+//	weather -o csv forecast Ottawa > forecast.csv
+//	weather backends
+//
+// With -local, the backends configured in the configuration file of a server are queried in-process instead, i.e.
+//
+//	weather -local server/config.json current Ottawa
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit codes, scripts can tell a partial failure (some cities or backends failed) from a complete one
+const (
+	ExitOK      = 0
+	ExitFailure = 1 // nothing could be fetched
+	ExitUsage   = 2
+	ExitPartial = 3 // some of the cities or backends failed, the others were written
+)
+
+// Config is the configuration file of the client, its values are used when the matching flags aren't given
+type Config struct {
+	Server   string   `json:"server"`   // defaults to DefaultServer
+	Backends []string `json:"backends"` // defaults to the default backends of the server
+	Output   string   `json:"output"`   // defaults to OutputTable
+	Timeout  string   `json:"timeout"`  // i.e. "5s", defaults to DefaultTimeout
+	APIKey   string   `json:"apiKey"`   // the API key or token sent to the server, APIKeyEnv overrides it
+}
+
+// APIKeyEnv is the environment variable the API key is read from. It starts with WEATHER_CLIENT_, which the server
+// leaves alone: the other WEATHER_ variables are settings of the server, so that both can run in the same environment.
+const APIKeyEnv = "WEATHER_CLIENT_API_KEY"
+
+// DefaultServer is the server used when none is configured
+const DefaultServer = "http://localhost:8080"
+
+// DefaultTimeout is how long requests to the server can take when no timeout is configured
+const DefaultTimeout = 10 * time.Second
+
+// defaultConfigFile is where the configuration is read from when no -config is given, it doesn't have to exist
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "weather", "config.json")
+}
+
+func loadConfig(path string, mustExist bool) (Config, error) {
+	config := Config{}
+	if path == "" {
+		return config, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, errors.New("Unable to parse config file " + path + ": " + err.Error())
+	}
+	return config, nil
+}
+
+const usage = `Usage: weather [flags] <command> [arguments]
+
+Commands:
+  current CITY...   the current weather for each city, from every backend
+  forecast CITY...  the daily forecast for each city, from every backend
+  backends          the backends of the server and how the last call to each went, or the configured backends with -local
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("weather", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "the configuration file (default ~/.config/weather/config.json)")
+	server := fs.String("server", "", "the URL of the server (default "+DefaultServer+")")
+	backends := fs.String("backend", "", "comma separated backends to use (default the default backends of the server)")
+	output := fs.String("o", "", "the output format: "+strings.Join(outputs, ", ")+" (default "+OutputTable+")")
+	timeout := fs.Duration("timeout", 0, "how long requests to the server (or to each backend with -local) can take (default "+DefaultTimeout.String()+")")
+	local := fs.String("local", "", "the configuration file of a server, to query its backends in-process instead of the server")
+
+	// flags can be given before and after the command, but not after the cities
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	command := fs.Arg(0)
+	if command != "current" && command != "forecast" && command != "backends" {
+		fmt.Fprintln(stderr, "Unknown command specified: "+command)
+		fs.Usage()
+		return ExitUsage
+	}
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return ExitUsage
+	}
+
+	path, mustExist := *configFile, true
+	if path == "" {
+		path, mustExist = defaultConfigFile(), false
+	}
+	config, err := loadConfig(path, mustExist)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+	if *server != "" {
+		config.Server = *server
+	}
+	if config.Server == "" {
+		config.Server = DefaultServer
+	}
+	// there is no flag for the API key, flags can be seen by every user of the machine
+	if key := os.Getenv(APIKeyEnv); key != "" {
+		config.APIKey = key
+	}
+	if *backends != "" {
+		config.Backends = strings.Split(*backends, ",")
+	}
+	if *output != "" {
+		config.Output = *output
+	}
+	if config.Output == "" {
+		config.Output = OutputTable
+	}
+	if config.Output != OutputTable && config.Output != OutputJSON && config.Output != OutputCSV {
+		fmt.Fprintln(stderr, "Unknown output format specified: "+config.Output)
+		return ExitUsage
+	}
+	requestTimeout := DefaultTimeout
+	if config.Timeout != "" {
+		if requestTimeout, err = time.ParseDuration(config.Timeout); err != nil {
+			fmt.Fprintln(stderr, "Invalid timeout specified: "+config.Timeout)
+			return ExitUsage
+		}
+	}
+	if *timeout != 0 {
+		requestTimeout = *timeout
+	}
+
+	httpClient := &http.Client{Timeout: requestTimeout}
+	var client weatherClient = &Client{BaseURL: config.Server, Backends: config.Backends, APIKey: config.APIKey, HTTP: httpClient}
+	if *local != "" {
+		if client, err = newLocalClient(*local, os.Environ(), config.Backends, httpClient, stderr); err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitUsage
+		}
+	}
+	cities := fs.Args()
+	if command == "backends" {
+		return listBackends(client, config.Output, stdout, stderr)
+	}
+	if len(cities) == 0 {
+		fmt.Fprintln(stderr, "No city specified. Please provide at least one city.")
+		return ExitUsage
+	}
+	if command == "forecast" {
+		return forecast(client, cities, config.Output, stdout, stderr)
+	}
+	return current(client, cities, config.Output, stdout, stderr)
+}
+
+func current(client weatherClient, cities []string, output string, stdout io.Writer, stderr io.Writer) int {
+	results := []weatherResult{}
+	outcomes := []int{}
+	for _, city := range cities {
+		response, body, err := client.Weather(city)
+		results = append(results, weatherResult{result: result{city: city, body: body, err: err}, response: response})
+
+		outcome := ExitOK
+		for _, source := range response.Sources {
+			if source.Error != nil {
+				outcome = ExitPartial
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", city, err)
+			outcome = ExitFailure
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if err := writeWeather(stdout, output, results); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	return exitCode(outcomes)
+}
+
+func forecast(client weatherClient, cities []string, output string, stdout io.Writer, stderr io.Writer) int {
+	results := []forecastResult{}
+	outcomes := []int{}
+	for _, city := range cities {
+		response, body, err := client.Forecast(city)
+		results = append(results, forecastResult{result: result{city: city, body: body, err: err}, response: response})
+
+		outcome := ExitOK
+		for _, source := range response.Sources {
+			if source.Error != nil {
+				outcome = ExitPartial
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", city, err)
+			outcome = ExitFailure
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if err := writeForecast(stdout, output, results); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	return exitCode(outcomes)
+}
+
+func listBackends(client weatherClient, output string, stdout io.Writer, stderr io.Writer) int {
+	response, body, err := client.ListBackends()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	if err := writeBackends(stdout, output, body, response); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	for _, backend := range response.Backends {
+		if backend.LastError != "" {
+			return ExitPartial
+		}
+	}
+	return ExitOK
+}
+
+// exitCode combines the outcomes of the cities: ExitOK when every city succeeded, ExitFailure when every city failed and
+// ExitPartial otherwise
+func exitCode(outcomes []int) int {
+	ok, failed := 0, 0
+	for _, outcome := range outcomes {
+		switch outcome {
+		case ExitOK:
+			ok++
+		case ExitFailure:
+			failed++
+		}
+	}
+	switch {
+	case ok == len(outcomes):
+		return ExitOK
+	case failed == len(outcomes):
+		return ExitFailure
+	default:
+		return ExitPartial
+	}
+}
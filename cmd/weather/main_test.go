@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-weather-app/server/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer() *httptest.Server {
+	responses := map[string]string{
+		"/v2/weather/Ottawa": `{"city":"Ottawa","location":{"name":"Ottawa","admin_region":"ON","country":"CA"},"sources":[` +
+			`{"source":"foo","status":"ok","data":{"temperature":12.5,"temperature_min":9,"temperature_max":14,"main_description":"Rain","detailed_description":"light rain"}},` +
+			`{"source":"bar","status":"error","error":{"code":"backend_unavailable","message":"Error communicating to backend"}}]}`,
+		"/v2/weather/Paris": `{"city":"Paris","location":{},"sources":[` +
+			`{"source":"foo","status":"ok","data":{"temperature":20,"temperature_min":15,"temperature_max":22,"main_description":"Clear","detailed_description":"clear"}}]}`,
+		"/v2/forecast/Ottawa": `{"city":"Ottawa","location":{"name":"Ottawa"},"sources":[` +
+			`{"source":"foo","status":"ok","data":{"days":[{"date":"2019-06-01","temperature_min":2,"temperature_max":20,"main_description":"Clouds"},{"date":"2019-06-02","temperature_min":-3,"temperature_max":1,"main_description":"Snow"}]}}]}`,
+		"/v2/backends": `{"backends":[{"name":"bar","default":false,"supports_forecasts":false,"last_error":"Error communicating to backend","last_checked":"2019-06-01T12:00:00Z"},` +
+			`{"name":"foo","default":true,"supports_forecasts":true}]}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", api.MIMEApplicationProblemJSON)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"urn:go-weather-app:problem:location_not_found","title":"Location not found","status":404,"detail":"Location not found: nowhere","code":"location_not_found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(response))
+	}))
+}
+
+func Test_run(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "weather")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	emptyConfig := filepath.Join(dir, "empty.json")
+	require.NoError(t, ioutil.WriteFile(emptyConfig, []byte(`{}`), 0644))
+	csvConfig := filepath.Join(dir, "csv.json")
+	require.NoError(t, ioutil.WriteFile(csvConfig, []byte(`{"server":"`+server.URL+`","output":"csv","timeout":"1s"}`), 0644))
+	noBackendsConfig := filepath.Join(dir, "server.json")
+	require.NoError(t, ioutil.WriteFile(noBackendsConfig, []byte(`{"backends":[]}`), 0644))
+	badConfig := filepath.Join(dir, "bad.json")
+	require.NoError(t, ioutil.WriteFile(badConfig, []byte(`{"server":`), 0644))
+
+	tests := []struct {
+		name             string
+		args             []string
+		expectedExitCode int
+		expectedStdout   string
+		expectedStderr   string
+	}{
+		{
+			name:             "current weather with a failed backend",
+			args:             []string{"-config", emptyConfig, "-server", server.URL, "current", "Ottawa", "Paris"},
+			expectedExitCode: ExitPartial,
+			expectedStdout: "" +
+				"CITY            SOURCE  TEMPERATURE  TEMPERATURE MIN  TEMPERATURE MAX  DESCRIPTION        ERROR\n" +
+				"Ottawa, ON, CA  foo     12.5°C       9°C              14°C             Rain (light rain)\n" +
+				"Ottawa, ON, CA  bar                                                                       Error communicating to backend\n" +
+				"Paris           foo     20°C         15°C             22°C             Clear\n",
+		},
+		{
+			name:             "current weather for a city that wasn't found",
+			args:             []string{"-config", emptyConfig, "-server", server.URL, "current", "Paris", "nowhere"},
+			expectedExitCode: ExitPartial,
+			expectedStdout: "" +
+				"CITY     SOURCE  TEMPERATURE  TEMPERATURE MIN  TEMPERATURE MAX  DESCRIPTION  ERROR\n" +
+				"Paris    foo     20°C         15°C             22°C             Clear\n" +
+				"nowhere                                                                      Location not found: nowhere\n",
+			expectedStderr: "nowhere: Location not found: nowhere\n",
+		},
+		{
+			name:             "every city failed",
+			args:             []string{"-config", emptyConfig, "-server", server.URL, "-o", "csv", "current", "nowhere"},
+			expectedExitCode: ExitFailure,
+			expectedStdout:   "city,source,temperature,temperature_min,temperature_max,description,error\nnowhere,,,,,,Location not found: nowhere\n",
+			expectedStderr:   "nowhere: Location not found: nowhere\n",
+		},
+		{
+			name:             "the config file sets the server and output, flags after the command",
+			args:             []string{"-config", csvConfig, "current", "-o", "json", "Paris"},
+			expectedExitCode: ExitOK,
+			expectedStdout:   "[\n  {\n    \"city\": \"Paris\",\n    \"location\": {},\n    \"sources\": [\n      {\n        \"source\": \"foo\",\n        \"status\": \"ok\",\n        \"data\": {\n          \"temperature\": 20,\n          \"temperature_min\": 15,\n          \"temperature_max\": 22,\n          \"main_description\": \"Clear\",\n          \"detailed_description\": \"clear\"\n        }\n      }\n    ]\n  }\n]\n",
+		},
+		{
+			name:             "forecast",
+			args:             []string{"-config", csvConfig, "forecast", "Ottawa"},
+			expectedExitCode: ExitOK,
+			expectedStdout:   "city,source,date,temperature_min,temperature_max,description,error\nOttawa,foo,2019-06-01,2,20,Clouds,\nOttawa,foo,2019-06-02,-3,1,Snow,\n",
+		},
+		{
+			name:             "backends",
+			args:             []string{"-config", emptyConfig, "-server", server.URL, "backends"},
+			expectedExitCode: ExitPartial,
+			expectedStdout: "" +
+				"NAME  DEFAULT  FORECASTS  STATUS   LAST CHECKED          LAST ERROR\n" +
+				"bar   false    false      error    2019-06-01T12:00:00Z  Error communicating to backend\n" +
+				"foo   true     true       unknown\n",
+		},
+		{
+			name:             "unreachable server",
+			args:             []string{"-config", emptyConfig, "-server", "http://127.0.0.1:0", "backends"},
+			expectedExitCode: ExitFailure,
+			expectedStderr:   "Get \"http://127.0.0.1:0/v2/backends\": dial tcp 127.0.0.1:0: connect: connection refused\n",
+		},
+		{
+			name:             "local config without backends",
+			args:             []string{"-config", emptyConfig, "-local", noBackendsConfig, "backends"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "Invalid configuration: " + noBackendsConfig + ": missing required setting backends\n",
+		},
+		{
+			name:             "invalid local config file",
+			args:             []string{"-config", emptyConfig, "-local", badConfig, "current", "Ottawa"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "Unable to parse config file " + badConfig + ": unexpected EOF\n",
+		},
+		{
+			name:             "no city",
+			args:             []string{"-config", emptyConfig, "current"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "No city specified. Please provide at least one city.\n",
+		},
+		{
+			name:             "unknown output",
+			args:             []string{"-config", emptyConfig, "-o", "yaml", "backends"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "Unknown output format specified: yaml\n",
+		},
+		{
+			name:             "invalid config file",
+			args:             []string{"-config", badConfig, "backends"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "Unable to parse config file " + badConfig + ": unexpected end of JSON input\n",
+		},
+		{
+			name:             "missing config file",
+			args:             []string{"-config", filepath.Join(dir, "does-not-exist.json"), "backends"},
+			expectedExitCode: ExitUsage,
+			expectedStderr:   "open " + filepath.Join(dir, "does-not-exist.json") + ": no such file or directory\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			require.Equal(t, tc.expectedExitCode, run(tc.args, stdout, stderr), stderr.String())
+			require.Equal(t, tc.expectedStdout, stdout.String())
+			require.Equal(t, tc.expectedStderr, stderr.String())
+		})
+	}
+}
+
+func Test_runUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"tomorrow"}, {"-bogus"}} {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		require.Equal(t, ExitUsage, run(args, stdout, stderr))
+		require.Contains(t, stderr.String(), "Usage: weather [flags] <command> [arguments]")
+		require.Empty(t, stdout.String())
+	}
+}
+
+func Test_runAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "0123456789abcdef", r.Header.Get(api.HeaderAPIKey))
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Write([]byte(`{"backends":[{"name":"foo","default":true,"supports_forecasts":false}]}`))
+	}))
+	defer server.Close()
+	os.Setenv(APIKeyEnv, "0123456789abcdef")
+	defer os.Unsetenv(APIKeyEnv)
+
+	dir, err := ioutil.TempDir("", "weather")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`{"server":"`+server.URL+`","apiKey":"overridden"}`), 0644))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, ExitOK, run([]string{"-config", config, "backends"}, stdout, stderr), stderr.String())
+}
+
+func Test_exitCode(t *testing.T) {
+	require.Equal(t, ExitOK, exitCode([]int{ExitOK, ExitOK}))
+	require.Equal(t, ExitPartial, exitCode([]int{ExitOK, ExitPartial}))
+	require.Equal(t, ExitPartial, exitCode([]int{ExitOK, ExitFailure}))
+	require.Equal(t, ExitPartial, exitCode([]int{ExitPartial, ExitFailure}))
+	require.Equal(t, ExitFailure, exitCode([]int{ExitFailure, ExitFailure}))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-weather-app/server/api"
+)
+
+// Output formats
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+var outputs = []string{OutputTable, OutputJSON, OutputCSV}
+
+// result is the outcome of a request for a single city
+type result struct {
+	city string
+	body []byte // as sent by the server, if it answered at all
+	err  error
+}
+
+type weatherResult struct {
+	result
+	response *api.WeatherResponseV2
+}
+
+type forecastResult struct {
+	result
+	response *api.ForecastResponseV2
+}
+
+// table writes rows as aligned columns, or as csv
+type table interface {
+	Write(row []string) error
+	Flush() error
+}
+
+func newTable(w io.Writer, output string, header []string) table {
+	var t table
+	if output == OutputCSV {
+		t = csvTable{csv.NewWriter(w)}
+	} else {
+		buf := &bytes.Buffer{}
+		t = tabTable{Writer: tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0), buf: buf, out: w}
+		for i := range header {
+			header[i] = strings.ToUpper(strings.Replace(header[i], "_", " ", -1))
+		}
+	}
+	t.Write(header)
+	return t
+}
+
+type csvTable struct {
+	*csv.Writer
+}
+
+func (t csvTable) Flush() error {
+	t.Writer.Flush()
+	return t.Error()
+}
+
+type tabTable struct {
+	*tabwriter.Writer
+	buf *bytes.Buffer
+	out io.Writer
+}
+
+func (t tabTable) Write(row []string) error {
+	_, err := io.WriteString(t.Writer, strings.Join(row, "\t")+"\n")
+	return err
+}
+
+// Flush writes the aligned rows without the spaces that pad empty cells at the end of them
+func (t tabTable) Flush() error {
+	if err := t.Writer.Flush(); err != nil {
+		return err
+	}
+	for _, line := range strings.SplitAfter(t.buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := io.WriteString(t.out, strings.TrimRight(line, " \n")+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSON writes the bodies sent by the server as an array, with an error for requests the server didn't answer
+func writeJSON(w io.Writer, results []result) error {
+	bodies := []json.RawMessage{}
+	for _, r := range results {
+		body := r.body
+		if !json.Valid(body) {
+			body, _ = json.Marshal(map[string]string{"city": r.city, "error": r.err.Error()})
+		}
+		bodies = append(bodies, body)
+	}
+	b, err := json.MarshalIndent(bodies, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func writeWeather(w io.Writer, output string, results []weatherResult) error {
+	if output == OutputJSON {
+		plain := []result{}
+		for _, r := range results {
+			plain = append(plain, r.result)
+		}
+		return writeJSON(w, plain)
+	}
+
+	t := newTable(w, output, []string{"city", "source", "temperature", "temperature_min", "temperature_max", "description", "error"})
+	for _, r := range results {
+		label := resultLabel(r.city, r.response.Location.String())
+		if r.err != nil && len(r.response.Sources) == 0 {
+			t.Write([]string{label, "", "", "", "", "", r.err.Error()})
+			continue
+		}
+		for _, source := range r.response.Sources {
+			if source.Error != nil {
+				t.Write([]string{label, source.Source, "", "", "", "", source.Error.Message})
+				continue
+			}
+			t.Write([]string{
+				label, source.Source,
+				temperature(output, source.Data.Temperature), temperature(output, source.Data.TemperatureMin), temperature(output, source.Data.TemperatureMax),
+				description(source.Data.MainDescription, source.Data.DetailedDescription), "",
+			})
+		}
+	}
+	return t.Flush()
+}
+
+func writeForecast(w io.Writer, output string, results []forecastResult) error {
+	if output == OutputJSON {
+		plain := []result{}
+		for _, r := range results {
+			plain = append(plain, r.result)
+		}
+		return writeJSON(w, plain)
+	}
+
+	t := newTable(w, output, []string{"city", "source", "date", "temperature_min", "temperature_max", "description", "error"})
+	for _, r := range results {
+		label := resultLabel(r.city, r.response.Location.String())
+		if r.err != nil && len(r.response.Sources) == 0 {
+			t.Write([]string{label, "", "", "", "", "", r.err.Error()})
+			continue
+		}
+		for _, source := range r.response.Sources {
+			if source.Error != nil {
+				t.Write([]string{label, source.Source, "", "", "", "", source.Error.Message})
+				continue
+			}
+			for _, day := range source.Data.Days {
+				t.Write([]string{
+					label, source.Source, day.Date,
+					temperature(output, day.TemperatureMin), temperature(output, day.TemperatureMax),
+					description(day.MainDescription, day.DetailedDescription), "",
+				})
+			}
+		}
+	}
+	return t.Flush()
+}
+
+func writeBackends(w io.Writer, output string, body []byte, response *api.BackendsResponseV2) error {
+	if output == OutputJSON {
+		_, err := w.Write(body)
+		return err
+	}
+
+	t := newTable(w, output, []string{"name", "default", "forecasts", "status", "last_checked", "last_error"})
+	for _, backend := range response.Backends {
+		lastChecked := ""
+		if backend.LastChecked != nil {
+			lastChecked = backend.LastChecked.Format(time.RFC3339)
+		}
+		t.Write([]string{
+			backend.Name, strconv.FormatBool(backend.Default), strconv.FormatBool(backend.SupportsForecasts),
+			backendStatus(backend), lastChecked, backend.LastError,
+		})
+	}
+	return t.Flush()
+}
+
+// backendStatus is "unknown" for backends that weren't called since the server started
+func backendStatus(backend api.BackendV2) string {
+	switch {
+	case backend.LastError != "":
+		return api.SourceStatusError
+	case backend.LastChecked == nil:
+		return "unknown"
+	default:
+		return api.SourceStatusOK
+	}
+}
+
+// resultLabel names the location the server resolved the city to, or the city as it was asked for
+func resultLabel(city string, location string) string {
+	if location != "" {
+		return location
+	}
+	return city
+}
+
+// temperature has a unit in tables, and is a plain number in csv for spreadsheets
+func temperature(output string, temperature float32) string {
+	s := strconv.FormatFloat(float64(temperature), 'f', -1, 32)
+	if output == OutputCSV {
+		return s
+	}
+	return s + "°C"
+}
+
+func description(main string, detailed string) string {
+	if detailed == "" || strings.EqualFold(main, detailed) {
+		return main
+	}
+	return fmt.Sprintf("%s (%s)", main, detailed)
+}
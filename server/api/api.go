@@ -0,0 +1,245 @@
+// Package api defines the requests and responses of the /v2 REST API, so that the server and its clients (i.e.
+// cmd/weather) can't disagree on them
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+)
+
+// MIMEApplicationProblemJSON is the content type of every /v2 error, see Problem
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// HeaderHops is the number of servers a request was relayed through by federated backends. Servers don't relay requests
+// that already went through another server, so that servers federated with each other can't loop.
+const HeaderHops = "X-Weather-Hops"
+
+// HeaderAPIKey is the header clients pass their API key or token in, when the server authenticates its clients
+const HeaderAPIKey = "X-API-Key"
+
+// Problem codes, the machine-readable part of a Problem
+const (
+	ProblemCityMissing           = "city_missing"
+	ProblemInvalidBackend        = "invalid_backend"
+	ProblemInvalidLocation       = "invalid_location"
+	ProblemLocationNotFound      = "location_not_found"
+	ProblemAllSourcesFailed      = "all_sources_failed"
+	ProblemAllSourcesUnsupported = "all_sources_unsupported"
+	ProblemNotFound              = "not_found"
+	ProblemMethodNotAllowed      = "method_not_allowed"
+	ProblemUnauthorized          = "unauthorized"
+	ProblemForbidden             = "forbidden"
+	ProblemTooManyRequests       = "too_many_requests"
+	ProblemUnavailable           = "unavailable"
+	ProblemBadRequest            = "bad_request"
+	ProblemInternal              = "internal_error"
+)
+
+// ProblemTitles are the short, human-readable summaries of the problem codes
+var ProblemTitles = map[string]string{
+	ProblemCityMissing:           "No city specified",
+	ProblemInvalidBackend:        "Invalid backend",
+	ProblemInvalidLocation:       "Invalid location",
+	ProblemLocationNotFound:      "Location not found",
+	ProblemAllSourcesFailed:      "Every source failed",
+	ProblemAllSourcesUnsupported: "No source supports this request",
+	ProblemNotFound:              "Not found",
+	ProblemMethodNotAllowed:      "Method not allowed",
+	ProblemUnauthorized:          "Unauthorized",
+	ProblemForbidden:             "Forbidden",
+	ProblemTooManyRequests:       "Too many requests",
+	ProblemUnavailable:           "Service unavailable",
+	ProblemBadRequest:            "Bad request",
+	ProblemInternal:              "Internal server error",
+}
+
+// Source error codes, the machine-readable part of a SourceError
+const (
+	SourceErrorUnavailable      = types.ErrorUnavailable
+	SourceErrorBadResponse      = types.ErrorBadResponse
+	SourceErrorLocationNotFound = types.ErrorLocationNotFound
+	SourceErrorNotSupported     = types.ErrorNotSupported
+	SourceErrorUnknown          = types.ErrorUnknown
+)
+
+// Source statuses, see WeatherSource and ForecastSource
+const (
+	SourceStatusOK    = "ok"
+	SourceStatusError = "error"
+)
+
+// Problem defines an RFC 7807 problem details response, used for every /v2 error
+type Problem struct {
+	Type     string `json:"type"` // identifies the code, i.e. "urn:go-weather-app:problem:city_missing"
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"` // the request URI
+	Code     string `json:"code"`
+
+	Sources interface{} `json:"sources,omitempty"` // the failed sources, when every source failed
+}
+
+// NewProblem creates a problem with the type and title of the code
+func NewProblem(status int, code string, detail string) *Problem {
+	return &Problem{Type: "urn:go-weather-app:problem:" + code, Title: ProblemTitles[code], Status: status, Code: code, Detail: detail}
+}
+
+// SourceError defines why a single source failed
+type SourceError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WeatherResponseV2 defines the /v2/weather/{city} response, which has a result for every requested source
+type WeatherResponseV2 struct {
+	City             string            `json:"city"`
+	Location         location.Location `json:"location"`
+	Sources          []WeatherSource   `json:"sources"`
+	LocationMismatch *LocationMismatch `json:"location_mismatch,omitempty"`
+}
+
+// WeatherSource defines the result of a single source, either its data or its error depending on the status
+type WeatherSource struct {
+	Source string       `json:"source"`
+	Status string       `json:"status"`
+	Data   *WeatherData `json:"data,omitempty"`
+	Error  *SourceError `json:"error,omitempty"`
+}
+
+// WeatherData defines the current weather reported by a source
+type WeatherData struct {
+	Temperature         float32                 `json:"temperature"`
+	TemperatureMin      float32                 `json:"temperature_min"`
+	TemperatureMax      float32                 `json:"temperature_max"`
+	MainDescription     string                  `json:"main_description"`
+	DetailedDescription string                  `json:"detailed_description"`
+	Location            *types.ResolvedLocation `json:"location,omitempty"`
+}
+
+// ForecastResponseV2 defines the /v2/forecast/{city} response, which has a result for every requested source
+type ForecastResponseV2 struct {
+	City     string            `json:"city"`
+	Location location.Location `json:"location"`
+	Sources  []ForecastSource  `json:"sources"`
+}
+
+// ForecastSource defines the result of a single source, either its data or its error depending on the status
+type ForecastSource struct {
+	Source string        `json:"source"`
+	Status string        `json:"status"`
+	Data   *ForecastData `json:"data,omitempty"`
+	Error  *SourceError  `json:"error,omitempty"`
+}
+
+// ForecastData defines the daily forecast reported by a source
+type ForecastData struct {
+	Days     []types.ForecastDay     `json:"days"`
+	Location *types.ResolvedLocation `json:"location,omitempty"`
+}
+
+// BackendsResponseV2 defines the /v2/backends response
+type BackendsResponseV2 struct {
+	Backends []BackendV2 `json:"backends"`
+}
+
+// BackendV2 defines a configured backend and how the last call to it went
+type BackendV2 struct {
+	Name              string     `json:"name"`
+	Default           bool       `json:"default"`
+	SupportsForecasts bool       `json:"supports_forecasts"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastChecked       *time.Time `json:"last_checked,omitempty"`
+}
+
+// LocationMismatch defines the two sources whose resolved locations are furthest apart, when that is further than the
+// location.mismatchDistanceKm of the server
+type LocationMismatch struct {
+	Sources    []string `json:"sources" xml:"sources>source"`
+	DistanceKm float64  `json:"distance_km" xml:"distance_km"`
+}
+
+// NewSourceError is the SourceError of a backend that failed with an error message and code, see types.ErrorCode.
+// Backends that don't tell why they failed get SourceErrorUnknown.
+func NewSourceError(code string, message string) *SourceError {
+	if code == "" {
+		code = SourceErrorUnknown
+	}
+	return &SourceError{Code: code, Message: message}
+}
+
+// sourcesProblem returns the problem to report when none of the sources succeeded, or nil when at least one did
+func sourcesProblem(errs []*SourceError, sources interface{}) *Problem {
+	if len(errs) == 0 {
+		return nil
+	}
+	unsupported := 0
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		if err.Code == SourceErrorNotSupported {
+			unsupported++
+		}
+	}
+	if unsupported == len(errs) {
+		problem := NewProblem(http.StatusBadRequest, ProblemAllSourcesUnsupported, "None of the requested backends support this request")
+		problem.Sources = sources
+		return problem
+	}
+	problem := NewProblem(http.StatusBadGateway, ProblemAllSourcesFailed, "None of the requested backends returned data")
+	problem.Sources = sources
+	return problem
+}
+
+// NewWeatherResponseV2 builds the response from the weather fetched from each of the target backends, along with the
+// problem to report instead when none of them succeeded
+func NewWeatherResponseV2(city string, loc location.Location, targetBackends []string, data []types.Weather) (*WeatherResponseV2, *Problem) {
+	response := &WeatherResponseV2{City: city, Location: loc, Sources: []WeatherSource{}}
+	errs := []*SourceError{}
+	for i, weather := range data {
+		// backends don't name themselves when they fail, the requested name is always right
+		source := WeatherSource{Source: targetBackends[i], Status: SourceStatusOK}
+		if weather.Error != "" {
+			source.Status = SourceStatusError
+			source.Error = NewSourceError(weather.ErrorCode, weather.Error)
+		} else {
+			source.Data = &WeatherData{
+				Temperature:         weather.Temperature,
+				TemperatureMin:      weather.TemperatureMin,
+				TemperatureMax:      weather.TemperatureMax,
+				MainDescription:     weather.MainDescription,
+				DetailedDescription: weather.DetailedDescription,
+				Location:            weather.Location,
+			}
+		}
+		response.Sources = append(response.Sources, source)
+		errs = append(errs, source.Error)
+	}
+	return response, sourcesProblem(errs, response.Sources)
+}
+
+// NewForecastResponseV2 builds the response from the forecast fetched from each of the target backends, along with the
+// problem to report instead when none of them succeeded
+func NewForecastResponseV2(city string, loc location.Location, targetBackends []string, data []types.Forecast) (*ForecastResponseV2, *Problem) {
+	response := &ForecastResponseV2{City: city, Location: loc, Sources: []ForecastSource{}}
+	errs := []*SourceError{}
+	for i, forecast := range data {
+		source := ForecastSource{Source: targetBackends[i], Status: SourceStatusOK}
+		if forecast.Error != "" {
+			source.Status = SourceStatusError
+			source.Error = NewSourceError(forecast.ErrorCode, forecast.Error)
+		} else {
+			source.Data = &ForecastData{Days: forecast.Days, Location: forecast.Location}
+			if source.Data.Days == nil {
+				source.Data.Days = []types.ForecastDay{}
+			}
+		}
+		response.Sources = append(response.Sources, source)
+		errs = append(errs, source.Error)
+	}
+	return response, sourcesProblem(errs, response.Sources)
+}
@@ -0,0 +1,270 @@
+// Package auth authenticates the clients of the API and enforces their limits: the endpoints they can call (their
+// scopes), the backends they can use, how many requests they can make per second and how many per period (their quota).
+// Clients authenticate with a static API key, of which only the hash is configured, or with a token signed with their
+// token secret, see SignToken. Users, i.e. of the UI, authenticate with the JWTs of an identity provider, see
+// JWTValidator.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-weather-app/server/ratelimit"
+)
+
+// DefaultQuotaPeriod is the period quotas are counted over when none is configured
+const DefaultQuotaPeriod = 24 * time.Hour
+
+// Scopes of the clients
+const (
+	ScopeRead  = "read"  // the weather API
+	ScopeAdmin = "admin" // the admin endpoints, i.e. reloading the configuration, admins can read too
+)
+
+// Errors of Authenticate and JWTValidator.Validate
+var (
+	ErrMissingCredential = errors.New("No API key or token provided")
+	ErrInvalidCredential = errors.New("Invalid API key or token")
+	ErrExpiredToken      = errors.New("Expired token")
+)
+
+// ErrInsufficientScope is returned when a client calls an endpoint its scopes don't grant, see Client.HasScope
+var ErrInsufficientScope = errors.New("The API key or token doesn't grant access to this endpoint")
+
+// ClientOptions defines a client of the API
+type ClientOptions struct {
+	Name              string
+	KeyHashes         []string      // the hashes of the API keys of the client, see HashKey
+	TokenSecret       string        // signs the tokens of the client, see SignToken, tokens aren't accepted when empty
+	Scopes            []string      // ScopeRead and/or ScopeAdmin, defaults to ScopeRead
+	Backends          []string      // the backends the client can use, every one when empty
+	RequestsPerSecond float64       // unlimited when 0
+	Burst             int           // how many requests can be made at once, defaults to RequestsPerSecond rounded up
+	Quota             int           // how many requests can be made per QuotaPeriod, unlimited when 0
+	QuotaPeriod       time.Duration // defaults to DefaultQuotaPeriod
+}
+
+// Client is an authenticated client of the API
+type Client struct {
+	Name string
+
+	scopes      map[string]bool
+	backends    map[string]bool   // the backends the client can use, nil when it can use every one
+	limiter     *ratelimit.Bucket // nil when the client isn't rate limited
+	quota       *ratelimit.Quota  // nil when the client doesn't have a quota
+	tokenSecret []byte
+}
+
+// HasScope tells whether the client was granted a scope
+func (c *Client) HasScope(scope string) bool {
+	return c.scopes[scope]
+}
+
+// grantScopes returns the scopes of a client, admins can read too
+func grantScopes(scopes []string) map[string]bool {
+	granted := map[string]bool{}
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	if granted[ScopeAdmin] {
+		granted[ScopeRead] = true
+	}
+	return granted
+}
+
+// AllowsBackend tells whether the client can use a backend
+func (c *Client) AllowsBackend(backend string) bool {
+	return c.backends == nil || c.backends[backend]
+}
+
+// LimitError is returned by Take when a client made too many requests
+type LimitError struct {
+	Message    string
+	RetryAfter time.Duration // how long until the client can make a request again
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Take counts a request of the client, it returns a *LimitError when the client is over its rate limit or quota
+func (c *Client) Take() error {
+	return c.TakeN(1)
+}
+
+// TakeN counts n requests of the client at once, i.e. for the items of a batch request, see ratelimit.Bucket.TakeN and
+// ratelimit.Quota.TakeN
+func (c *Client) TakeN(n int) error {
+	if c.limiter != nil {
+		if allowed, retryAfter := c.limiter.TakeN(n); !allowed {
+			return &LimitError{Message: "Rate limit exceeded, please slow down", RetryAfter: retryAfter}
+		}
+	}
+	if c.quota != nil {
+		if allowed, retryAfter := c.quota.TakeN(n); !allowed {
+			return &LimitError{Message: "Quota exceeded", RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// Authenticator finds the clients API keys and tokens belong to
+type Authenticator struct {
+	clients map[string]*Client // by name
+	keys    map[string]*Client // by the hash of their API keys
+	now     func() time.Time
+}
+
+// New creates an authenticator of clients, now tells the current time. It fails when a client is invalid, i.e.
+// without any way to authenticate, or when an API key is given to several clients.
+func New(clients []ClientOptions, now func() time.Time) (*Authenticator, error) {
+	a := &Authenticator{clients: map[string]*Client{}, keys: map[string]*Client{}, now: now}
+	for _, options := range clients {
+		client, err := newClient(options, now)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := a.clients[client.Name]; ok {
+			return nil, errors.New("Client configured twice: " + client.Name)
+		}
+		a.clients[client.Name] = client
+		for _, hash := range options.KeyHashes {
+			hash = strings.ToLower(hash)
+			if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+				return nil, errors.New("Invalid API key hash for client " + client.Name + ", expected the hex encoded SHA-256 hash of the key")
+			}
+			if other, ok := a.keys[hash]; ok {
+				return nil, errors.New("API key of client " + client.Name + " is already the one of client " + other.Name)
+			}
+			a.keys[hash] = client
+		}
+	}
+	return a, nil
+}
+
+func newClient(options ClientOptions, now func() time.Time) (*Client, error) {
+	if options.Name == "" || strings.Contains(options.Name, ".") {
+		return nil, errors.New("Invalid client name, it can't be empty or have dots: " + options.Name)
+	}
+	if len(options.KeyHashes) == 0 && options.TokenSecret == "" {
+		return nil, errors.New("No API key hash or token secret configured for client " + options.Name)
+	}
+	if options.RequestsPerSecond < 0 || options.Burst < 0 || options.Quota < 0 || options.QuotaPeriod < 0 {
+		return nil, errors.New("Limits of client " + options.Name + " can't be negative")
+	}
+
+	scopes := options.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeRead}
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeAdmin {
+			return nil, errors.New("Unknown scope of client " + options.Name + ": " + scope + ", expected " + ScopeRead + " or " + ScopeAdmin)
+		}
+	}
+
+	client := &Client{Name: options.Name, scopes: grantScopes(scopes)}
+	client.limit(options, now)
+	if options.TokenSecret != "" {
+		client.tokenSecret = []byte(options.TokenSecret)
+	}
+	return client, nil
+}
+
+// limit restricts the client to the backends of options, and gives it their rate limit and quota
+func (c *Client) limit(options ClientOptions, now func() time.Time) {
+	if len(options.Backends) > 0 {
+		c.backends = map[string]bool{}
+		for _, backend := range options.Backends {
+			c.backends[backend] = true
+		}
+	}
+	if options.RequestsPerSecond > 0 {
+		burst := options.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(options.RequestsPerSecond))
+		}
+		c.limiter = ratelimit.NewBucket(options.RequestsPerSecond, burst, now)
+	}
+	if options.Quota > 0 {
+		period := options.QuotaPeriod
+		if period == 0 {
+			period = DefaultQuotaPeriod
+		}
+		c.quota = ratelimit.NewQuota(options.Quota, period, now)
+	}
+}
+
+// Authenticate returns the client an API key or a token belongs to
+func (a *Authenticator) Authenticate(credential string) (*Client, error) {
+	if credential == "" {
+		return nil, ErrMissingCredential
+	}
+	if parts := strings.Split(credential, "."); len(parts) == 3 {
+		if client, ok := a.clients[parts[0]]; ok && client.tokenSecret != nil {
+			return a.authenticateToken(client, parts[1], parts[2])
+		}
+	}
+	client, ok := a.keys[HashKey(credential)]
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+	return client, nil
+}
+
+func (a *Authenticator) authenticateToken(client *Client, expires string, signature string) (*Client, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, sign(client.tokenSecret, client.Name+"."+expires)) {
+		return nil, ErrInvalidCredential
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if !a.now().Before(time.Unix(expiry, 0)) {
+		return nil, ErrExpiredToken
+	}
+	return client, nil
+}
+
+// HashKey returns the hash of an API key that is configured in its place, the hex encoded SHA-256 hash of the key,
+// i.e. what `echo -n "$KEY" | sha256sum` prints. Keys must be long random strings, i.e. `openssl rand -hex 32`.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignToken returns a token of a client that is valid until expires, signed with the token secret of the client.
+// Tokens are the name of the client, their expiry in seconds since the epoch and the base64url encoded HMAC-SHA256 of
+// both, separated by dots, i.e. "ui.1559390400.<signature>".
+func SignToken(client string, secret string, expires time.Time) string {
+	payload := client + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(secret), payload))
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx with the client that made the request
+func NewContext(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, client)
+}
+
+// FromContext returns the client that made the request, or nil when clients aren't authenticated
+func FromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(contextKey{}).(*Client)
+	return client
+}
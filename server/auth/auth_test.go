@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	hash := HashKey("0123456789abcdef")
+	tests := []struct {
+		name        string
+		clients     []ClientOptions
+		expectedErr error
+	}{
+		{
+			name:    "clients with a key or a token secret are created",
+			clients: []ClientOptions{{Name: "ui", KeyHashes: []string{hash}}, {Name: "eu", TokenSecret: "secret", RequestsPerSecond: 0.5, Quota: 100}},
+		},
+		{
+			name:        "client without a name returns error",
+			clients:     []ClientOptions{{KeyHashes: []string{hash}}},
+			expectedErr: errors.New("Invalid client name, it can't be empty or have dots: "),
+		},
+		{
+			name:        "client with a dot in its name returns error",
+			clients:     []ClientOptions{{Name: "ui.v2", KeyHashes: []string{hash}}},
+			expectedErr: errors.New("Invalid client name, it can't be empty or have dots: ui.v2"),
+		},
+		{
+			name:        "client without a way to authenticate returns error",
+			clients:     []ClientOptions{{Name: "ui"}},
+			expectedErr: errors.New("No API key hash or token secret configured for client ui"),
+		},
+		{
+			name:        "unknown scope returns error",
+			clients:     []ClientOptions{{Name: "ui", KeyHashes: []string{hash}, Scopes: []string{"write"}}},
+			expectedErr: errors.New("Unknown scope of client ui: write, expected read or admin"),
+		},
+		{
+			name:        "negative limits return error",
+			clients:     []ClientOptions{{Name: "ui", KeyHashes: []string{hash}, Quota: -1}},
+			expectedErr: errors.New("Limits of client ui can't be negative"),
+		},
+		{
+			name:        "key instead of its hash returns error",
+			clients:     []ClientOptions{{Name: "ui", KeyHashes: []string{"0123456789abcdef"}}},
+			expectedErr: errors.New("Invalid API key hash for client ui, expected the hex encoded SHA-256 hash of the key"),
+		},
+		{
+			name:        "client configured twice returns error",
+			clients:     []ClientOptions{{Name: "ui", KeyHashes: []string{hash}}, {Name: "ui", TokenSecret: "secret"}},
+			expectedErr: errors.New("Client configured twice: ui"),
+		},
+		{
+			name:        "key shared by clients returns error",
+			clients:     []ClientOptions{{Name: "ui", KeyHashes: []string{hash}}, {Name: "cli", KeyHashes: []string{hash}}},
+			expectedErr: errors.New("API key of client cli is already the one of client ui"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.clients, time.Now)
+			require.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, err := New([]ClientOptions{
+		{Name: "ui", KeyHashes: []string{HashKey("0123456789abcdef"), HashKey("fedcba9876543210")}},
+		{Name: "eu", TokenSecret: "secret"},
+	}, func() time.Time { return now })
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		credential     string
+		expectedClient string
+		expectedErr    error
+	}{
+		{
+			name:           "API key is authenticated",
+			credential:     "0123456789abcdef",
+			expectedClient: "ui",
+		},
+		{
+			name:           "every API key of the client is authenticated",
+			credential:     "fedcba9876543210",
+			expectedClient: "ui",
+		},
+		{
+			name:        "unknown API key returns error",
+			credential:  "0123456789abcdeg",
+			expectedErr: ErrInvalidCredential,
+		},
+		{
+			name:        "missing credential returns error",
+			expectedErr: ErrMissingCredential,
+		},
+		{
+			name:           "token is authenticated",
+			credential:     SignToken("eu", "secret", now.Add(time.Hour)),
+			expectedClient: "eu",
+		},
+		{
+			name:        "expired token returns error",
+			credential:  SignToken("eu", "secret", now),
+			expectedErr: ErrExpiredToken,
+		},
+		{
+			name:        "token signed with another secret returns error",
+			credential:  SignToken("eu", "other", now.Add(time.Hour)),
+			expectedErr: ErrInvalidCredential,
+		},
+		{
+			name:        "token of a client without a token secret returns error",
+			credential:  SignToken("ui", "", now.Add(time.Hour)),
+			expectedErr: ErrInvalidCredential,
+		},
+		{
+			name:        "token with a tampered expiry returns error",
+			credential:  "eu.1893456000." + SignToken("eu", "secret", now.Add(time.Hour))[len("eu.1559394000."):],
+			expectedErr: ErrInvalidCredential,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := a.Authenticate(tc.credential)
+			require.Equal(t, tc.expectedErr, err)
+			if tc.expectedClient == "" {
+				require.Nil(t, client)
+				return
+			}
+			require.Equal(t, tc.expectedClient, client.Name)
+		})
+	}
+}
+
+func TestClient_Take(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, err := New([]ClientOptions{
+		{Name: "limited", TokenSecret: "secret", RequestsPerSecond: 1, Burst: 2, Quota: 3, QuotaPeriod: time.Hour},
+		{Name: "unlimited", TokenSecret: "secret"},
+	}, func() time.Time { return now })
+	require.NoError(t, err)
+	limited, unlimited := a.clients["limited"], a.clients["unlimited"]
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, limited.Take(), "request %d", i)
+	}
+	require.Equal(t, &LimitError{Message: "Rate limit exceeded, please slow down", RetryAfter: time.Second}, limited.Take())
+
+	now = now.Add(time.Second)
+	require.NoError(t, limited.Take())
+	now = now.Add(time.Second)
+	require.Equal(t, &LimitError{Message: "Quota exceeded", RetryAfter: time.Hour - 2*time.Second}, limited.Take())
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, unlimited.Take(), "request %d", i)
+	}
+}
+
+func TestClient_TakeN(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	a, err := New([]ClientOptions{
+		{Name: "limited", TokenSecret: "secret", RequestsPerSecond: 1, Burst: 2},
+		{Name: "quota", TokenSecret: "secret", Quota: 10, QuotaPeriod: time.Hour},
+	}, func() time.Time { return now })
+	require.NoError(t, err)
+	limited, quota := a.clients["limited"], a.clients["quota"]
+
+	// the batch goes over the burst, the next requests wait until it is paid off
+	require.NoError(t, limited.TakeN(5))
+	require.Equal(t, &LimitError{Message: "Rate limit exceeded, please slow down", RetryAfter: 4 * time.Second}, limited.Take())
+	now = now.Add(4 * time.Second)
+	require.NoError(t, limited.Take())
+
+	// the quota doesn't count batches it can't take whole
+	require.NoError(t, quota.TakeN(5))
+	require.Equal(t, &LimitError{Message: "Quota exceeded", RetryAfter: time.Hour - 4*time.Second}, quota.TakeN(6))
+	require.NoError(t, quota.TakeN(5))
+}
+
+func TestClient_AllowsBackend(t *testing.T) {
+	a, err := New([]ClientOptions{
+		{Name: "ui", TokenSecret: "secret", Backends: []string{"openweathermap"}},
+		{Name: "cli", TokenSecret: "secret"},
+	}, time.Now)
+	require.NoError(t, err)
+
+	require.True(t, a.clients["ui"].AllowsBackend("openweathermap"))
+	require.False(t, a.clients["ui"].AllowsBackend("accuweather"))
+	require.True(t, a.clients["cli"].AllowsBackend("accuweather"), "every backend is allowed by default")
+}
+
+func TestClient_HasScope(t *testing.T) {
+	a, err := New([]ClientOptions{
+		{Name: "ui", TokenSecret: "secret"},
+		{Name: "ops", TokenSecret: "secret", Scopes: []string{ScopeAdmin}},
+	}, time.Now)
+	require.NoError(t, err)
+
+	require.True(t, a.clients["ui"].HasScope(ScopeRead), "clients can read by default")
+	require.False(t, a.clients["ui"].HasScope(ScopeAdmin))
+	require.True(t, a.clients["ops"].HasScope(ScopeAdmin))
+	require.True(t, a.clients["ops"].HasScope(ScopeRead), "admins can read too")
+}
+
+func TestFromContext(t *testing.T) {
+	require.Nil(t, FromContext(context.Background()))
+	client := &Client{Name: "ui"}
+	require.Equal(t, client, FromContext(NewContext(context.Background(), client)))
+}
@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Defaults of JWTOptions
+const (
+	DefaultReadScope       = "weather:read"
+	DefaultAdminScope      = "weather:admin"
+	DefaultRefreshInterval = time.Hour
+	DefaultLeeway          = time.Minute
+)
+
+// minRefreshInterval is how long a JWTValidator waits before fetching the keys again, after a failed fetch or for a
+// token signed with an unknown key, so that forged tokens can't make it hammer the identity provider
+const minRefreshInterval = 10 * time.Second
+
+// ErrKeysUnavailable is returned by Validate when the signing keys of the identity provider were never fetched
+var ErrKeysUnavailable = errors.New("Unable to fetch the signing keys of the identity provider")
+
+// jwtSigningMethods are the algorithms tokens can be signed with, the ones of public keys only: a token signed with a
+// shared secret could have been signed by anyone who knows the public key
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// JWTOptions defines the JWTs an identity provider issues for the users of the API, i.e. the users of the UI
+type JWTOptions struct {
+	Issuer          string        // the iss claim of the tokens, the OpenID configuration is found under it
+	Audience        string        // one of the aud claim of the tokens
+	JWKSURL         string        // the signing keys, defaults to the jwks_uri of the OpenID configuration of Issuer
+	ReadScope       string        // the scope of the tokens granting ScopeRead, defaults to DefaultReadScope
+	AdminScope      string        // the scope of the tokens granting ScopeAdmin, defaults to DefaultAdminScope
+	RefreshInterval time.Duration // how long the keys are cached, defaults to DefaultRefreshInterval
+	Leeway          time.Duration // how far the clocks can drift when checking expiries, defaults to DefaultLeeway
+
+	// the backends and limits of every user, like the ones of ClientOptions: each user (by subject) has a rate limit
+	// and a quota of their own
+	Backends          []string
+	RequestsPerSecond float64
+	Burst             int
+	Quota             int
+	QuotaPeriod       time.Duration
+}
+
+// JWTValidator authenticates the users of the API by the JWTs of an identity provider, whose signing keys it fetches
+// from the provider's JWKS and caches. Keys are fetched again once RefreshInterval is over, and sooner for tokens signed
+// with a key it doesn't know yet (i.e. once the provider rotated its keys). It is safe for concurrent use.
+type JWTValidator struct {
+	options    JWTOptions
+	httpClient *http.Client
+	now        func() time.Time
+
+	// mu guards the following, it isn't held while fetching: calls needing the keys meanwhile wait for the fetch instead
+	mu        sync.Mutex
+	jwksURL   string                // the one of the options, or the one of the OpenID configuration once found
+	keys      map[string]signingKey // by key id, nil until they are fetched
+	fetched   time.Time             // when the keys were fetched
+	attempted time.Time             // when the keys were last asked for, successfully or not
+	fetching  *keyFetch             // the fetch in flight, nil when there is none
+
+	// users are the limits of the users seen so far, by subject, they are only tracked when users are limited
+	usersMu sync.Mutex
+	users   map[string]*Client
+}
+
+// keyFetch is a fetch of the keys in flight, err is set once done is closed
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// signingKey is a public key of a JWKS
+type signingKey struct {
+	key crypto.PublicKey
+	alg string // the algorithm the key is restricted to, if any
+}
+
+// NewJWTValidator creates a validator of the JWTs of an identity provider, fetching its keys with httpClient, now
+// tells the current time. The keys are only fetched when the first token is validated, see Refresh.
+func NewJWTValidator(options JWTOptions, httpClient *http.Client, now func() time.Time) (*JWTValidator, error) {
+	if options.Issuer == "" || options.Audience == "" {
+		return nil, errors.New("JWT issuer and audience must both be configured")
+	}
+	jwksURL := options.JWKSURL
+	if jwksURL == "" {
+		jwksURL = options.Issuer
+	}
+	if u, err := url.Parse(jwksURL); err != nil || !u.IsAbs() {
+		return nil, errors.New("Invalid JWKS URL, expected an absolute URL: " + jwksURL)
+	}
+	if options.RefreshInterval < 0 || options.Leeway < 0 {
+		return nil, errors.New("JWT refresh interval and leeway can't be negative")
+	}
+	if options.RequestsPerSecond < 0 || options.Burst < 0 || options.Quota < 0 || options.QuotaPeriod < 0 {
+		return nil, errors.New("Limits of JWT users can't be negative")
+	}
+	if options.ReadScope == "" {
+		options.ReadScope = DefaultReadScope
+	}
+	if options.AdminScope == "" {
+		options.AdminScope = DefaultAdminScope
+	}
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = DefaultRefreshInterval
+	}
+	if options.Leeway == 0 {
+		options.Leeway = DefaultLeeway
+	}
+	return &JWTValidator{options: options, httpClient: httpClient, now: now, jwksURL: options.JWKSURL, users: map[string]*Client{}}, nil
+}
+
+// IsJWT tells whether a credential is a JWT rather than an API key or a token of SignToken: JWTs are three base64url
+// encoded parts separated by dots, the first of which is a json object
+func IsJWT(credential string) bool {
+	parts := strings.Split(credential, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	return err == nil && len(header) > 0 && header[0] == '{' && json.Valid(header)
+}
+
+// Refresh fetches the keys of the identity provider, i.e. when the server starts so that a misconfigured provider is
+// noticed before the first user logs in
+func (v *JWTValidator) Refresh() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.refresh(v.now())
+}
+
+// Validate checks the signature and the claims of a JWT, returning a client named after its subject with the scopes
+// the token grants, and the backends and limits of the options.
+func (v *JWTValidator) Validate(token string) (*Client, error) {
+	claims := &jwtClaims{}
+	parser := &jwt.Parser{ValidMethods: jwtSigningMethods, SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, v.signingKey); err != nil {
+		if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Inner == ErrKeysUnavailable {
+			return nil, ErrKeysUnavailable
+		}
+		return nil, ErrInvalidCredential
+	}
+
+	now := v.now()
+	if claims.Issuer != v.options.Issuer || !claims.Audience.contains(v.options.Audience) || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidCredential
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0).Add(v.options.Leeway)) {
+		return nil, ErrExpiredToken
+	}
+	if claims.NotBefore != 0 && now.Add(v.options.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidCredential
+	}
+
+	scopes := []string{}
+	for _, scope := range claims.scopes() {
+		switch scope {
+		case v.options.ReadScope:
+			scopes = append(scopes, ScopeRead)
+		case v.options.AdminScope:
+			scopes = append(scopes, ScopeAdmin)
+		}
+	}
+	user := v.user(claims.Subject)
+	return &Client{Name: claims.Subject, scopes: grantScopes(scopes), backends: user.backends, limiter: user.limiter, quota: user.quota}, nil
+}
+
+// user returns the backends and limits of a user, the same limits every time for the same subject
+func (v *JWTValidator) user(subject string) *Client {
+	limits := ClientOptions{
+		Backends:          v.options.Backends,
+		RequestsPerSecond: v.options.RequestsPerSecond,
+		Burst:             v.options.Burst,
+		Quota:             v.options.Quota,
+		QuotaPeriod:       v.options.QuotaPeriod,
+	}
+	if limits.RequestsPerSecond == 0 && limits.Quota == 0 {
+		user := &Client{Name: subject}
+		user.limit(limits, v.now)
+		return user
+	}
+
+	v.usersMu.Lock()
+	defer v.usersMu.Unlock()
+	user, ok := v.users[subject]
+	if !ok {
+		user = &Client{Name: subject}
+		user.limit(limits, v.now)
+		v.users[subject] = user
+	}
+	return user
+}
+
+// signingKey is the jwt.Keyfunc of Validate, it returns the key the token was signed with
+func (v *JWTValidator) signingKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	key, found := v.lookup(kid)
+	if v.fetching != nil || (!found || now.Sub(v.fetched) >= v.options.RefreshInterval) && now.Sub(v.attempted) >= minRefreshInterval {
+		// the current keys are kept when they can't be fetched again, the provider may only be down for a moment, and
+		// a fetch already in flight is waited for
+		v.refresh(now)
+		key, found = v.lookup(kid)
+	}
+	if v.keys == nil {
+		return nil, ErrKeysUnavailable
+	}
+	if !found || (key.alg != "" && key.alg != token.Method.Alg()) {
+		return nil, errors.New("Unknown signing key: " + kid)
+	}
+	return key.key, nil
+}
+
+// lookup finds a key by its id, tokens without a key id can only be signed with the one key of the JWKS
+func (v *JWTValidator) lookup(kid string) (signingKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the keys again, or waits for the fetch in flight. v.mu must be held, it is released during the fetch.
+func (v *JWTValidator) refresh(now time.Time) error {
+	if fetching := v.fetching; fetching != nil {
+		v.mu.Unlock()
+		<-fetching.done
+		v.mu.Lock()
+		return fetching.err
+	}
+	fetching := &keyFetch{done: make(chan struct{})}
+	v.fetching, v.attempted = fetching, now
+	jwksURL := v.jwksURL
+	v.mu.Unlock()
+
+	jwksURL, keys, err := v.fetchKeys(jwksURL)
+
+	v.mu.Lock()
+	v.jwksURL = jwksURL
+	if err == nil {
+		v.keys, v.fetched = keys, now
+	}
+	v.fetching, fetching.err = nil, err
+	close(fetching.done)
+	return err
+}
+
+// fetchKeys fetches the keys of the JWKS, finding the JWKS from the OpenID configuration of the issuer first when its
+// URL isn't known yet. It returns the URL of the JWKS, once found, along with the keys.
+func (v *JWTValidator) fetchKeys(jwksURL string) (string, map[string]signingKey, error) {
+	if jwksURL == "" {
+		configuration := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := v.getJSON(strings.TrimSuffix(v.options.Issuer, "/")+"/.well-known/openid-configuration", &configuration); err != nil {
+			return "", nil, err
+		}
+		if configuration.JWKSURI == "" {
+			return "", nil, errors.New("No jwks_uri in the OpenID configuration of " + v.options.Issuer)
+		}
+		jwksURL = configuration.JWKSURI
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := v.getJSON(jwksURL, &jwks); err != nil {
+		return jwksURL, nil, err
+	}
+	keys := map[string]signingKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of other types, i.e. the symmetric ones, can't verify tokens and are left out
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = signingKey{key: key, alg: jwk.Alg}
+		}
+	}
+	if len(keys) == 0 {
+		return jwksURL, nil, errors.New("No signing key in the JWKS at " + jwksURL)
+	}
+	return jwksURL, keys, nil
+}
+
+func (v *JWTValidator) getJSON(u string, value interface{}) error {
+	resp, err := v.httpClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Unexpected response from " + u + ": " + resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return errors.New("Unable to decode the response from " + u + ": " + err.Error())
+	}
+	return nil
+}
+
+// jsonWebKey is a key of a JWKS, see RFC 7517, only the public RSA and EC keys are supported
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("Invalid RSA exponent of key " + k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, errors.New("Unsupported curve of key " + k.Kid + ": " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("Invalid EC point of key " + k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("Unsupported type of key " + k.Kid + ": " + k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("Invalid key parameter: " + s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwtClaims are the claims of the tokens Validate checks, the scopes are either in scope (space separated, RFC 8693)
+// or in scp (an array, i.e. Okta and Azure AD)
+type jwtClaims struct {
+	Issuer    string     `json:"iss"`
+	Subject   string     `json:"sub"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt int64      `json:"exp"`
+	NotBefore int64      `json:"nbf"`
+	Scope     string     `json:"scope"`
+	Scp       stringList `json:"scp"`
+}
+
+// Valid is left to Validate, which checks the claims with the clock of the validator
+func (c *jwtClaims) Valid() error {
+	return nil
+}
+
+func (c *jwtClaims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	for _, scp := range c.Scp {
+		scopes = append(scopes, strings.Fields(scp)...)
+	}
+	return scopes
+}
+
+// stringList is a claim that is either a string or an array of strings, like aud
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s != "" {
+			*l = stringList{s}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l stringList) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
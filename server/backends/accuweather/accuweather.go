@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
 	"go-weather-app/server/types"
 	"net/http"
 
@@ -12,8 +14,42 @@ import (
 
 // Accuweather defines the configuration for an Accuweather backend
 type Accuweather struct {
-	APIKey string `json:"apiKey"`
-	Logger echo.Logger
+	Name       string       `json:"-"` // reported as the source of readings, defaults to Type
+	APIKey     string       `json:"apiKey" config:"required"`
+	Logger     echo.Logger  `json:"-"`
+	HTTPClient *http.Client `json:"-"` // defaults to http.DefaultClient
+}
+
+// Type is what accuweather backends are configured with in config.json
+const Type = "accuweather"
+
+func init() {
+	backends.Register(backends.Factory{
+		Type:    Type,
+		Options: Accuweather{},
+		New: func(name string, options interface{}, env backends.Environment) (types.WeatherBackend, error) {
+			o := options.(Accuweather)
+			if o.APIKey == "" {
+				return nil, errors.New("No API key configured for backend: " + name)
+			}
+			o.Name, o.Logger, o.HTTPClient = name, env.Logger, env.HTTPClient
+			return o, nil
+		},
+	})
+}
+
+func (o Accuweather) source() string {
+	if o.Name == "" {
+		return Type
+	}
+	return o.Name
+}
+
+func (o Accuweather) httpClient() *http.Client {
+	if o.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return o.HTTPClient
 }
 
 type locationCurrentWeatherResp []struct {
@@ -43,79 +79,160 @@ type Minimum struct {
 type Maximum struct {
 	Value float32 `json:"Value"`
 }
-type locationKeyResp []struct {
-	Key string `json:"Key"`
+type location5DayForecastResp struct {
+	DailyForecasts []struct {
+		Date                     string `json:"Date"` // i.e. "2019-06-01T07:00:00-04:00", in the location's time zone
+		TemperatureDailyForecast `json:"Temperature"`
+		Day                      struct {
+			IconPhrase string `json:"IconPhrase"`
+		} `json:"Day"`
+	} `json:"DailyForecasts"`
+}
+type locationKeyResp []locationResp
+type locationResp struct {
+	Key                string `json:"Key"`
+	LocalizedName      string `json:"LocalizedName"`
+	Country            `json:"Country"`
+	AdministrativeArea `json:"AdministrativeArea"`
+	GeoPosition        *GeoPosition `json:"GeoPosition"`
+	TimeZone           `json:"TimeZone"`
+}
+type Country struct {
+	ID string `json:"ID"`
+}
+type AdministrativeArea struct {
+	LocalizedName string `json:"LocalizedName"`
+}
+type GeoPosition struct {
+	Latitude  float64 `json:"Latitude"`
+	Longitude float64 `json:"Longitude"`
+}
+type TimeZone struct {
+	Name string `json:"Name"`
 }
 
 var citySearchURIF = "https://dataservice.accuweather.com/locations/v1/cities/search?q=%s&apikey=%s"
+var countryCitySearchURIF = "https://dataservice.accuweather.com/locations/v1/cities/%s/search?q=%s&apikey=%s"
+var postalCodeSearchURIF = "https://dataservice.accuweather.com/locations/v1/postalcodes/search?q=%s&apikey=%s"
+var geopositionSearchURIF = "https://dataservice.accuweather.com/locations/v1/cities/geoposition/search?q=%f,%f&apikey=%s"
 var locationCurrentWeatherURIF = "https://dataservice.accuweather.com/currentconditions/v1/%s?apikey=%s"
 var location1DayForecastURIF = "https://dataservice.accuweather.com/forecasts/v1/daily/1day/%s?apikey=%s"
+var location5DayForecastURIF = "https://dataservice.accuweather.com/forecasts/v1/daily/5day/%s?apikey=%s&metric=true"
 
-// GetWeather gets the whether for the specified city with via Accuweather
-func (o Accuweather) GetWeather(city string) types.Weather {
-	locationKey, err := o.getLocationKey(city)
+// GetWeather gets the whether for the specified location with via Accuweather
+func (o Accuweather) GetWeather(loc location.Location) types.Weather {
+	resolved, err := o.getLocation(loc)
 	if err != nil {
-		return types.Weather{
-			Error: err.Error(),
-		}
+		return types.WeatherError(err)
 	}
+	locationKey := resolved.ProviderLocationID
 	cwr, err := o.getCurrentWeather(locationKey)
-	if err != nil || len(cwr) == 0 {
-		return types.Weather{
-			Error: err.Error(),
-		}
+	if err != nil {
+		return types.WeatherError(err)
+	}
+	if len(cwr) == 0 {
+		return types.WeatherError(types.ErrBadResponse)
 	}
 
 	odf, err := o.get1DayForecast(locationKey)
-	if err != nil || len(odf.DailyForecasts) == 0 {
-		return types.Weather{
-			Error: err.Error(),
-		}
+	if err != nil {
+		return types.WeatherError(err)
+	}
+	if len(odf.DailyForecasts) == 0 {
+		return types.WeatherError(types.ErrBadResponse)
 	}
 
 	return types.Weather{
-		Source:          types.ACCUWEATHER,
+		Source:          o.source(),
 		Temperature:     cwr[0].TemperatureCurrentWeather.Metric.Value,
 		TemperatureMax:  odf.DailyForecasts[0].TemperatureDailyForecast.Maximum.Value,
 		TemperatureMin:  odf.DailyForecasts[0].TemperatureDailyForecast.Minimum.Value,
 		MainDescription: cwr[0].WeatherText,
+		Location:        resolved,
 	}
 }
 
-func (o Accuweather) getLocationKey(city string) (string, error) {
-	citySearchURI := fmt.Sprintf(citySearchURIF, city, o.APIKey)
-	resp, err := http.Get(citySearchURI)
+// getLocation finds the accuweather location (and its location key) for the provided location
+func (o Accuweather) getLocation(loc location.Location) (*types.ResolvedLocation, error) {
+	if loc.Coordinates != nil {
+		return o.getGeopositionLocation(*loc.Coordinates)
+	}
+
+	var searchURI string
+	switch {
+	case loc.PostalCode != "":
+		searchURI = fmt.Sprintf(postalCodeSearchURIF, loc.PostalCode, o.APIKey)
+	case loc.Country != "":
+		searchURI = fmt.Sprintf(countryCitySearchURIF, loc.Country, loc.Name, o.APIKey)
+	default:
+		searchURI = fmt.Sprintf(citySearchURIF, loc.Name, o.APIKey)
+	}
+	resp, err := o.httpClient().Get(searchURI)
 	if err != nil {
-		return "", err
+		return nil, types.NewBackendError(types.ErrorUnavailable, err.Error())
 	}
 	if resp.StatusCode != 200 {
 		o.Logger.Error("accuweather encountered status code error:", resp.StatusCode)
-		return "", errors.New("Error communicating to backend")
+		return nil, types.ErrUnavailable
 	}
 	locResp := locationKeyResp{}
 	err = json.NewDecoder(resp.Body).Decode(&locResp)
-	if err != nil || locResp[0].Key == "" {
-		return "", errors.New("Unable to determine location for provided city")
+	if err != nil || len(locResp) == 0 || locResp[0].Key == "" {
+		return nil, types.ErrCityNotFound
+	}
+	return locResp[0].resolvedLocation(), nil
+}
+
+func (o Accuweather) getGeopositionLocation(c location.Coordinates) (*types.ResolvedLocation, error) {
+	geopositionSearchURI := fmt.Sprintf(geopositionSearchURIF, c.Latitude, c.Longitude, o.APIKey)
+	resp, err := o.httpClient().Get(geopositionSearchURI)
+	if err != nil {
+		return nil, types.NewBackendError(types.ErrorUnavailable, err.Error())
+	}
+	if resp.StatusCode != 200 {
+		o.Logger.Error("accuweather encountered status code error for geoposition search:", resp.StatusCode)
+		return nil, types.ErrUnavailable
+	}
+	geoResp := locationResp{}
+	err = json.NewDecoder(resp.Body).Decode(&geoResp)
+	if err != nil || geoResp.Key == "" {
+		return nil, types.ErrCoordinatesNotFound
+	}
+	return geoResp.resolvedLocation(), nil
+}
+
+func (l locationResp) resolvedLocation() *types.ResolvedLocation {
+	resolved := &types.ResolvedLocation{
+		Location: location.Location{
+			Name:        l.LocalizedName,
+			AdminRegion: l.AdministrativeArea.LocalizedName,
+			Country:     l.Country.ID,
+			Timezone:    l.TimeZone.Name,
+		},
+		ProviderLocationID: l.Key,
 	}
-	return locResp[0].Key, nil
+	if l.GeoPosition != nil {
+		resolved.Coordinates = &location.Coordinates{Latitude: l.GeoPosition.Latitude, Longitude: l.GeoPosition.Longitude}
+	}
+	return resolved
 }
 
 func (o Accuweather) get1DayForecast(locationKey string) (location1DayForecastResp, error) {
 	odf := location1DayForecastResp{}
 	weatherURI := fmt.Sprintf(location1DayForecastURIF, locationKey, o.APIKey)
-	resp, err := http.Get(weatherURI)
+	resp, err := o.httpClient().Get(weatherURI)
 	if err != nil {
-		return odf, err
+		return odf, types.NewBackendError(types.ErrorUnavailable, err.Error())
 	}
 	if resp.StatusCode != 200 {
 		o.Logger.Error("accuweather encountered status code error for 1dayforecast:", resp.StatusCode)
-		return odf, errors.New("Error communicating to backend")
+		return odf, types.ErrUnavailable
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(&odf)
 	if err != nil {
 		o.Logger.Error("accuweather encountered error decoding response for 1dayforecast:", err)
-		return odf, errors.New("Unable to decode response from backend")
+		return odf, types.ErrBadResponse
 	}
 	return odf, nil
 }
@@ -123,19 +240,71 @@ func (o Accuweather) get1DayForecast(locationKey string) (location1DayForecastRe
 func (o Accuweather) getCurrentWeather(locationKey string) (locationCurrentWeatherResp, error) {
 	cwr := locationCurrentWeatherResp{}
 	weatherURI := fmt.Sprintf(locationCurrentWeatherURIF, locationKey, o.APIKey)
-	resp, err := http.Get(weatherURI)
+	resp, err := o.httpClient().Get(weatherURI)
 	if err != nil {
-		return cwr, err
+		return cwr, types.NewBackendError(types.ErrorUnavailable, err.Error())
 	}
 	if resp.StatusCode != 200 {
 		o.Logger.Error("accuweather encountered status code error for current weather:", resp.StatusCode)
-		return cwr, errors.New("Error communicating to backend")
+		return cwr, types.ErrUnavailable
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(&cwr)
 	if err != nil {
 		o.Logger.Error("accuweather encountered error decoding response for current weather:", err)
-		return cwr, errors.New("Unable to decode response from backend")
+		return cwr, types.ErrBadResponse
 	}
 	return cwr, nil
 }
+
+// GetForecast gets the daily forecast for the specified location via Accuweather
+func (o Accuweather) GetForecast(loc location.Location) types.Forecast {
+	resolved, err := o.getLocation(loc)
+	if err != nil {
+		return types.ForecastError(err)
+	}
+	fdf, err := o.get5DayForecast(resolved.ProviderLocationID)
+	if err != nil {
+		return types.ForecastError(err)
+	}
+
+	days := []types.ForecastDay{}
+	for _, daily := range fdf.DailyForecasts {
+		date := daily.Date
+		if len(date) > len("2006-01-02") {
+			date = date[:len("2006-01-02")]
+		}
+		days = append(days, types.ForecastDay{
+			Date:            date,
+			TemperatureMin:  daily.TemperatureDailyForecast.Minimum.Value,
+			TemperatureMax:  daily.TemperatureDailyForecast.Maximum.Value,
+			MainDescription: daily.Day.IconPhrase,
+		})
+	}
+	return types.Forecast{
+		Source:   o.source(),
+		Days:     days,
+		Location: resolved,
+	}
+}
+
+func (o Accuweather) get5DayForecast(locationKey string) (location5DayForecastResp, error) {
+	fdf := location5DayForecastResp{}
+	forecastURI := fmt.Sprintf(location5DayForecastURIF, locationKey, o.APIKey)
+	resp, err := o.httpClient().Get(forecastURI)
+	if err != nil {
+		return fdf, types.NewBackendError(types.ErrorUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		o.Logger.Error("accuweather encountered status code error for 5dayforecast:", resp.StatusCode)
+		return fdf, types.ErrUnavailable
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&fdf)
+	if err != nil {
+		o.Logger.Error("accuweather encountered error decoding response for 5dayforecast:", err)
+		return fdf, types.ErrBadResponse
+	}
+	return fdf, nil
+}
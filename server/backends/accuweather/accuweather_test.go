@@ -1,23 +1,27 @@
 package accuweather
 
 import (
+	"encoding/json"
 	"errors"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
 	"go-weather-app/server/types"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
 	"github.com/stretchr/testify/require"
 )
 
-func TestAccuweather_getLocationKey(t *testing.T) {
+func TestAccuweather_getLocation(t *testing.T) {
 	tests := []struct {
 		name          string
 		logger        echo.Logger
 		serverHandler func(http.ResponseWriter, *http.Request)
 		city          string
-		want          string
+		want          *types.ResolvedLocation
 		expectedErr   error
 	}{
 		{
@@ -26,7 +30,7 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 			serverHandler: func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusInternalServerError)
 			},
-			want:        "",
+			want:        nil,
 			expectedErr: errors.New("Error communicating to backend"),
 		},
 		{
@@ -35,7 +39,7 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 			logger: echo.New().Logger,
 			serverHandler: func(w http.ResponseWriter, r *http.Request) {
 			},
-			want:        "",
+			want:        nil,
 			expectedErr: errors.New("net/url: invalid control character in URL"),
 		},
 		{
@@ -44,7 +48,7 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 			serverHandler: func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
 			},
-			want:        "",
+			want:        nil,
 			expectedErr: errors.New("Unable to determine location for provided city"),
 		},
 		{
@@ -55,7 +59,7 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
 				w.Write([]byte("[{\"foo\":\"bar\"}]"))
 			},
-			want:        "",
+			want:        nil,
 			expectedErr: errors.New("Unable to determine location for provided city"),
 		},
 		{
@@ -66,7 +70,29 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
 				w.Write([]byte("[{\"Key\":\"1234\"}]"))
 			},
-			want:        "1234",
+			want: &types.ResolvedLocation{
+				ProviderLocationID: "1234",
+			},
+			expectedErr: nil,
+		},
+		{
+			name:   "resolved location when backend returns full location details",
+			logger: echo.New().Logger,
+			serverHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("[{\"Key\":\"55489\",\"LocalizedName\":\"London\",\"Country\":{\"ID\":\"CA\"},\"AdministrativeArea\":{\"LocalizedName\":\"Ontario\"},\"TimeZone\":{\"Name\":\"America/Toronto\"},\"GeoPosition\":{\"Latitude\":42.984,\"Longitude\":-81.245}}]"))
+			},
+			want: &types.ResolvedLocation{
+				Location: location.Location{
+					Name:        "London",
+					AdminRegion: "Ontario",
+					Country:     "CA",
+					Coordinates: &location.Coordinates{Latitude: 42.984, Longitude: -81.245},
+					Timezone:    "America/Toronto",
+				},
+				ProviderLocationID: "55489",
+			},
 			expectedErr: nil,
 		},
 	}
@@ -84,7 +110,7 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 				APIKey: "fookey",
 				Logger: tc.logger,
 			}
-			got, err := o.getLocationKey(tc.city)
+			got, err := o.getLocation(location.Location{Name: tc.city})
 
 			if tc.expectedErr != nil {
 				require.Contains(t, err.Error(), tc.expectedErr.Error())
@@ -96,6 +122,72 @@ func TestAccuweather_getLocationKey(t *testing.T) {
 	}
 }
 
+func TestAccuweather_getLocationSearchURI(t *testing.T) {
+	tests := []struct {
+		name        string
+		loc         location.Location
+		expectedURI string
+	}{
+		{
+			name:        "city search when only a name is known",
+			loc:         location.Location{Name: "Ottawa"},
+			expectedURI: "/cities/search?q=Ottawa&apikey=fookey",
+		},
+		{
+			name:        "country city search when the country is known",
+			loc:         location.Location{Name: "London", Country: "CA"},
+			expectedURI: "/cities/CA/search?q=London&apikey=fookey",
+		},
+		{
+			name:        "postal code search when a postal code is known",
+			loc:         location.Location{PostalCode: "62701", Country: "US"},
+			expectedURI: "/postalcodes/search?q=62701&apikey=fookey",
+		},
+		{
+			name:        "geoposition search when coordinates are known",
+			loc:         location.Location{Name: "Ottawa", Coordinates: &location.Coordinates{Latitude: 45.41, Longitude: -75.7}},
+			expectedURI: "/cities/geoposition/search?q=45.410000,-75.700000&apikey=fookey",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requestURI string
+			// setup fake backend
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestURI = r.RequestURI
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				if r.URL.Path == "/cities/geoposition/search" {
+					w.Write([]byte("{\"Key\":\"1234\"}"))
+				} else {
+					w.Write([]byte("[{\"Key\":\"1234\"}]"))
+				}
+			}))
+			defer ts.Close()
+			// override URLs so we can use our test server above instead
+			origCitySearchURIF, origCountryCitySearchURIF := citySearchURIF, countryCitySearchURIF
+			origPostalCodeSearchURIF, origGeopositionSearchURIF := postalCodeSearchURIF, geopositionSearchURIF
+			citySearchURIF = ts.URL + "/cities/search?q=%s&apikey=%s"
+			countryCitySearchURIF = ts.URL + "/cities/%s/search?q=%s&apikey=%s"
+			postalCodeSearchURIF = ts.URL + "/postalcodes/search?q=%s&apikey=%s"
+			geopositionSearchURIF = ts.URL + "/cities/geoposition/search?q=%f,%f&apikey=%s"
+			defer func() {
+				citySearchURIF, countryCitySearchURIF = origCitySearchURIF, origCountryCitySearchURIF
+				postalCodeSearchURIF, geopositionSearchURIF = origPostalCodeSearchURIF, origGeopositionSearchURIF
+			}()
+
+			o := Accuweather{
+				APIKey: "fookey",
+				Logger: echo.New().Logger,
+			}
+			got, err := o.getLocation(tc.loc)
+			require.NoError(t, err)
+			require.Equal(t, "1234", got.ProviderLocationID)
+			require.Equal(t, tc.expectedURI, requestURI)
+		})
+	}
+}
+
 func TestAccuweather_get1DayForecast(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -288,7 +380,8 @@ func TestAccuweather_GetWeather(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 			},
 			want: types.Weather{
-				Error: "Error communicating to backend",
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
 			},
 		},
 		{
@@ -303,7 +396,8 @@ func TestAccuweather_GetWeather(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 			},
 			want: types.Weather{
-				Error: "Error communicating to backend",
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
 			},
 		},
 		{
@@ -323,7 +417,49 @@ func TestAccuweather_GetWeather(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 			},
 			want: types.Weather{
-				Error: "Error communicating to backend",
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
+			},
+		},
+		{
+			name:   "error when current weather backend returns no conditions",
+			logger: echo.New().Logger,
+			lkServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("[{\"Key\":\"1234\"}]"))
+			},
+			cwServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("[]"))
+			},
+			want: types.Weather{
+				Error:     "Unable to decode response from backend",
+				ErrorCode: types.ErrorBadResponse,
+			},
+		},
+		{
+			name:   "error when 1day forecast backend returns no daily forecasts",
+			logger: echo.New().Logger,
+			lkServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("[{\"Key\":\"1234\"}]"))
+			},
+			cwServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("[{\"Temperature\":{\"Metric\":{\"Value\":20}},\"WeatherText\":\"Sunny\"}]"))
+			},
+			odfServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("{}"))
+			},
+			want: types.Weather{
+				Error:     "Unable to decode response from backend",
+				ErrorCode: types.ErrorBadResponse,
 			},
 		},
 		{
@@ -345,11 +481,14 @@ func TestAccuweather_GetWeather(t *testing.T) {
 				w.Write([]byte("{\"DailyForecasts\":[{\"Temperature\":{\"Minimum\":{\"Value\":15},\"Maximum\":{\"Value\":22}}}]}"))
 			},
 			want: types.Weather{
-				Source:          types.ACCUWEATHER,
+				Source:          Type,
 				Temperature:     20,
 				TemperatureMax:  22,
 				TemperatureMin:  15,
 				MainDescription: "Sunny",
+				Location: &types.ResolvedLocation{
+					ProviderLocationID: "1234",
+				},
 			},
 		},
 	}
@@ -381,8 +520,122 @@ func TestAccuweather_GetWeather(t *testing.T) {
 				APIKey: "fookey",
 				Logger: tc.logger,
 			}
-			got := o.GetWeather(tc.city)
+			got := o.GetWeather(location.Location{Name: tc.city})
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAccuweather_unreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close() // nothing answers anymore
+	origCitySearchURIF := citySearchURIF
+	citySearchURIF = ts.URL + "?q=%s&apiKey=%s"
+	defer func() { citySearchURIF = origCitySearchURIF }()
+
+	o := Accuweather{APIKey: "fookey", Logger: echo.New().Logger}
+	weather := o.GetWeather(location.Location{Name: "foo"})
+	require.Equal(t, types.ErrorUnavailable, weather.ErrorCode)
+	require.Contains(t, weather.Error, "connection refused")
+	forecast := o.GetForecast(location.Location{Name: "foo"})
+	require.Equal(t, types.ErrorUnavailable, forecast.ErrorCode)
+}
+
+func TestAccuweather_GetForecast(t *testing.T) {
+	tests := []struct {
+		name             string
+		lkServerHandler  func(http.ResponseWriter, *http.Request)
+		fdfServerHandler func(http.ResponseWriter, *http.Request)
+		want             types.Forecast
+	}{
+		{
+			name: "error when locationKey backend returns non 200 status code",
+			lkServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			want: types.Forecast{
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
+			},
+		},
+		{
+			name: "error when 5day forecast backend returns non 200 status code",
+			lkServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("[{\"Key\":\"1234\"}]"))
+			},
+			fdfServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			want: types.Forecast{
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
+			},
+		},
+		{
+			name: "proper forecast response when all backends return proper response",
+			lkServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("[{\"Key\":\"1234\"}]"))
+			},
+			fdfServerHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("{\"DailyForecasts\":[" +
+					"{\"Date\":\"2019-06-01T07:00:00-04:00\",\"Temperature\":{\"Minimum\":{\"Value\":15},\"Maximum\":{\"Value\":22}},\"Day\":{\"IconPhrase\":\"Sunny\"}}," +
+					"{\"Date\":\"2019-06-02T07:00:00-04:00\",\"Temperature\":{\"Minimum\":{\"Value\":12},\"Maximum\":{\"Value\":18}},\"Day\":{\"IconPhrase\":\"Showers\"}}" +
+					"]}"))
+			},
+			want: types.Forecast{
+				Source: Type,
+				Days: []types.ForecastDay{
+					{Date: "2019-06-01", TemperatureMin: 15, TemperatureMax: 22, MainDescription: "Sunny"},
+					{Date: "2019-06-02", TemperatureMin: 12, TemperatureMax: 18, MainDescription: "Showers"},
+				},
+				Location: &types.ResolvedLocation{
+					ProviderLocationID: "1234",
+				},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup fake backends
+			lkts := httptest.NewServer(http.HandlerFunc(tc.lkServerHandler))
+			defer lkts.Close()
+			// override URL so we can use our test server above instead
+			origCitySearchURIF := citySearchURIF
+			citySearchURIF = lkts.URL + "?q=%s&apiKey=%s"
+			defer func() { citySearchURIF = origCitySearchURIF }()
+
+			fdfts := httptest.NewServer(http.HandlerFunc(tc.fdfServerHandler))
+			defer fdfts.Close()
+			// override URL so we can use our test server above instead
+			origLocation5DayForecastURIF := location5DayForecastURIF
+			location5DayForecastURIF = fdfts.URL + "?q=%s&apikey=%s"
+			defer func() { location5DayForecastURIF = origLocation5DayForecastURIF }()
+
+			o := Accuweather{
+				APIKey: "fookey",
+				Logger: echo.New().Logger,
+			}
+			got := o.GetForecast(location.Location{Name: "foo"})
 			require.Equal(t, tc.want, got)
 		})
 	}
 }
+
+func TestAccuweather_factory(t *testing.T) {
+	env := backends.Environment{Logger: log.New("test"), HTTPClient: &http.Client{}}
+	config := backends.Config{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "accuweather", "apiKey": "foo"}, {"name": "eu", "type": "accuweather", "apiKey": "bar"}]`), &config))
+
+	configured, names, err := backends.Configure(config, env)
+	require.NoError(t, err)
+	require.Equal(t, []string{Type, "eu"}, names)
+	require.Equal(t, Accuweather{Name: Type, APIKey: "foo", Logger: env.Logger, HTTPClient: env.HTTPClient}, configured[Type])
+	require.Equal(t, Accuweather{Name: "eu", APIKey: "bar", Logger: env.Logger, HTTPClient: env.HTTPClient}, configured["eu"])
+
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "accuweather"}]`), &config))
+	_, _, err = backends.Configure(config, env)
+	require.EqualError(t, err, "No API key configured for backend: accuweather")
+}
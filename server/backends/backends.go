@@ -0,0 +1,250 @@
+// Package backends configures the weather backends from the "backends" of config.json, so that they can be queried
+// without the server (i.e. by cmd/weather). Every type of backend registers a Factory, see Register, and config.json
+// lists the instances to create, any number of them per type.
+package backends
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Environment is what every backend is given when it is created, on top of its own options
+type Environment struct {
+	Logger     echo.Logger
+	HTTPClient *http.Client // the client backends call their APIs with
+}
+
+// Factory creates the backends of a single type
+type Factory struct {
+	// Type is what instances are configured with, i.e. "openweathermap", it is also their default name
+	Type string
+	// Options is the struct the options of an instance are decoded into, its json fields are the options the type
+	// accepts, anything else is rejected. Fields tagged `config:"required"` must be configured, and the ones tagged
+	// `config:"duration"` are durations like "30s", which the validation of the configuration checks.
+	Options interface{}
+	// New creates a backend called name, options is a value of the same type as Options
+	New func(name string, options interface{}, env Environment) (types.WeatherBackend, error)
+	// NewSources is used instead of New by types whose instances stand for several sources, i.e. the backends of
+	// another server. It creates a backend per source, which Configure names after the instance and the source, i.e.
+	// "eu/openweathermap".
+	NewSources func(name string, options interface{}, env Environment) (map[string]types.WeatherBackend, error)
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a type of backend available to Configure. Backend packages register themselves when they are
+// imported, see the builtin package. It panics when the same type is registered twice, or when the factory doesn't
+// have exactly one of New and NewSources.
+func Register(factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory.Type == "" || factory.Options == nil || (factory.New == nil) == (factory.NewSources == nil) {
+		panic("backends: Register needs a type, options and a constructor")
+	}
+	if _, ok := factories[factory.Type]; ok {
+		panic("backends: Register called twice for type " + factory.Type)
+	}
+	factories[factory.Type] = factory
+}
+
+// Factories returns every registered factory, sorted by type
+func Factories() []Factory {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	registered := make([]Factory, 0, len(factories))
+	for _, factory := range factories {
+		registered = append(registered, factory)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Type < registered[j].Type })
+	return registered
+}
+
+// Lookup returns the factory registered for a type of backend
+func Lookup(backendType string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[backendType]
+	return factory, ok
+}
+
+// Instance is the configuration of a single backend. In json its options sit next to its name and type, i.e.
+// {"name": "owm-eu", "type": "openweathermap", "apiKey": "..."}.
+type Instance struct {
+	Name    string          // defaults to Type
+	Type    string          // the Type of a registered Factory
+	Options json.RawMessage // the options of the type, "name" and "type" are ignored
+}
+
+// UnmarshalJSON keeps the whole object as the options of the instance, they are only decoded by Configure
+func (i *Instance) UnmarshalJSON(b []byte) error {
+	header := struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return err
+	}
+	i.Name, i.Type, i.Options = header.Name, header.Type, append(json.RawMessage{}, b...)
+	return nil
+}
+
+// MarshalJSON puts the name and type back next to the options
+func (i Instance) MarshalJSON() ([]byte, error) {
+	fields, err := i.options()
+	if err != nil {
+		return nil, err
+	}
+	if i.Name != "" {
+		fields["name"], _ = json.Marshal(i.Name)
+	}
+	fields["type"], _ = json.Marshal(i.Type)
+	return json.Marshal(fields)
+}
+
+// options returns the options of the instance by name, without its name and type
+func (i Instance) options() (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(i.Options)) > 0 {
+		if err := json.Unmarshal(i.Options, &fields); err != nil {
+			return nil, err
+		}
+	}
+	delete(fields, "name")
+	delete(fields, "type")
+	return fields, nil
+}
+
+// Config lists the backends to create
+type Config []Instance
+
+// UnmarshalJSON also accepts the object config.json used to have, with a single backend per type named after it, i.e.
+// {"openweathermap": {"apiKey": "..."}}. Backends without an API key were left out then, and still are.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return json.Unmarshal(b, (*[]Instance)(c))
+	}
+
+	byType := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &byType); err != nil {
+		return err
+	}
+	*c = Config{}
+	for backendType, options := range byType {
+		key := struct {
+			APIKey string `json:"apiKey"`
+		}{}
+		if err := json.Unmarshal(options, &key); err != nil {
+			return err
+		}
+		if key.APIKey != "" {
+			*c = append(*c, Instance{Type: backendType, Options: options})
+		}
+	}
+	sort.Slice(*c, func(i, j int) bool { return (*c)[i].Type < (*c)[j].Type })
+	return nil
+}
+
+// Configure creates the backends of config, by name, along with their names sorted. All of them are used when no
+// backends are specified explicitly. When any of them can't be created, the ones already created are closed.
+func Configure(config Config, env Environment) (map[string]types.WeatherBackend, []string, error) {
+	configured := map[string]types.WeatherBackend{}
+	names := []string{}
+	fail := func(err error) (map[string]types.WeatherBackend, []string, error) {
+		closeBackends(configured)
+		return map[string]types.WeatherBackend{}, []string{}, err
+	}
+
+	for _, instance := range config {
+		name := instance.Name
+		if name == "" {
+			name = instance.Type
+		}
+		if _, ok := configured[name]; ok {
+			return fail(errors.New("Backend configured twice: " + name))
+		}
+		created, err := newBackends(name, instance, env)
+		if err != nil {
+			return fail(err)
+		}
+		for name := range created {
+			if _, ok := configured[name]; ok {
+				closeBackends(created)
+				return fail(errors.New("Backend configured twice: " + name))
+			}
+		}
+		for name, backend := range created {
+			configured[name] = backend
+		}
+	}
+
+	if len(configured) == 0 {
+		return configured, names, errors.New("No weather backends configured")
+	}
+
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return configured, names, nil
+}
+
+// closeBackends closes the backends that hold resources (see io.Closer), i.e. plugins and their processes
+func closeBackends(backends map[string]types.WeatherBackend) {
+	for _, backend := range backends {
+		if closer, ok := backend.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// newBackends creates the backend of an instance, or the backends of its sources, by name
+func newBackends(name string, instance Instance, env Environment) (map[string]types.WeatherBackend, error) {
+	factory, ok := Lookup(instance.Type)
+	if !ok {
+		return nil, errors.New("Unknown backend type for backend " + name + ": " + instance.Type)
+	}
+
+	fields, err := instance.options()
+	if err != nil {
+		return nil, errors.New("Invalid options for backend " + name + ": " + err.Error())
+	}
+	b, _ := json.Marshal(fields)
+	options := reflect.New(reflect.TypeOf(factory.Options))
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(options.Interface()); err != nil {
+		return nil, errors.New("Invalid options for backend " + name + ": " + strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	if factory.NewSources == nil {
+		backend, err := factory.New(name, options.Elem().Interface(), env)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]types.WeatherBackend{name: backend}, nil
+	}
+
+	sources, err := factory.NewSources(name, options.Elem().Interface(), env)
+	if err != nil {
+		return nil, err
+	}
+	created := map[string]types.WeatherBackend{}
+	for source, backend := range sources {
+		created[name+"/"+source] = backend
+	}
+	return created, nil
+}
@@ -0,0 +1,263 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"testing"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/require"
+)
+
+type mockOptions struct {
+	APIKey string `json:"apiKey"`
+	Region string `json:"region"`
+}
+
+type mockBackend struct {
+	name    string
+	options mockOptions
+	env     Environment
+}
+
+func (m mockBackend) GetWeather(loc location.Location) types.Weather {
+	return types.Weather{Source: m.name}
+}
+
+// closedBackends are the names of the closingBackends closed so far
+var closedBackends []string
+
+// closingBackend holds resources, like plugins do
+type closingBackend struct {
+	mockBackend
+}
+
+func (c closingBackend) Close() error {
+	closedBackends = append(closedBackends, c.name)
+	return nil
+}
+
+func init() {
+	Register(Factory{
+		Type:    "mock",
+		Options: mockOptions{},
+		New: func(name string, options interface{}, env Environment) (types.WeatherBackend, error) {
+			if options.(mockOptions).APIKey == "" {
+				return nil, errors.New("No API key configured for backend: " + name)
+			}
+			return mockBackend{name: name, options: options.(mockOptions), env: env}, nil
+		},
+	})
+	Register(Factory{
+		Type:    "mockclosing",
+		Options: mockOptions{},
+		New: func(name string, options interface{}, env Environment) (types.WeatherBackend, error) {
+			return closingBackend{mockBackend{name: name}}, nil
+		},
+	})
+	Register(Factory{
+		Type:    "mockremote",
+		Options: mockOptions{},
+		NewSources: func(name string, options interface{}, env Environment) (map[string]types.WeatherBackend, error) {
+			return map[string]types.WeatherBackend{
+				"mock": mockBackend{name: name + "/mock", options: options.(mockOptions)},
+				"eu":   mockBackend{name: name + "/eu", options: options.(mockOptions)},
+			}, nil
+		},
+	})
+}
+
+func TestConfig_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		json           string
+		expectedConfig Config
+		expectedErr    string
+	}{
+		{
+			name: "list of instances",
+			json: `[{"name": "eu", "type": "mock", "apiKey": "foo"}, {"type": "mock", "apiKey": "bar"}]`,
+			expectedConfig: Config{
+				{Name: "eu", Type: "mock", Options: json.RawMessage(`{"name": "eu", "type": "mock", "apiKey": "foo"}`)},
+				{Type: "mock", Options: json.RawMessage(`{"type": "mock", "apiKey": "bar"}`)},
+			},
+		},
+		{
+			name: "object by type, without the backends missing an API key",
+			json: `{"mock": {"apiKey": "foo"}, "other": {"apiKey": ""}, "another": {}}`,
+			expectedConfig: Config{
+				{Type: "mock", Options: json.RawMessage(`{"apiKey": "foo"}`)},
+			},
+		},
+		{
+			name:        "invalid instance",
+			json:        `[{"type": 1}]`,
+			expectedErr: "json: cannot unmarshal number into Go struct field .type of type string",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := Config{}
+			err := json.Unmarshal([]byte(tc.json), &config)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedConfig, config)
+		})
+	}
+}
+
+func TestInstance_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Config{
+		{Name: "eu", Type: "mock", Options: json.RawMessage(`{"name": "ignored", "apiKey": "foo"}`)},
+		{Type: "mock"},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"name": "eu", "type": "mock", "apiKey": "foo"}, {"type": "mock"}]`, string(b))
+}
+
+func TestConfigure(t *testing.T) {
+	env := Environment{Logger: log.New("test"), HTTPClient: &http.Client{}}
+
+	tests := []struct {
+		name                       string
+		config                     string
+		expectedConfiguredBackends map[string]types.WeatherBackend
+		expectedDefaultBackends    []string
+		expectedErr                error
+	}{
+		{
+			name:                       "no configured backends returns error",
+			config:                     `[]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("No weather backends configured"),
+		},
+		{
+			name:   "instances of the same type are named apart",
+			config: `[{"type": "mock", "apiKey": "foo"}, {"name": "eu", "type": "mock", "apiKey": "bar", "region": "eu"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{
+				"mock": mockBackend{name: "mock", options: mockOptions{APIKey: "foo"}, env: env},
+				"eu":   mockBackend{name: "eu", options: mockOptions{APIKey: "bar", Region: "eu"}, env: env},
+			},
+			expectedDefaultBackends: []string{"eu", "mock"},
+		},
+		{
+			name:   "instances standing for several sources are expanded into a backend per source",
+			config: `[{"type": "mock", "apiKey": "foo"}, {"name": "remote", "type": "mockremote", "region": "eu"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{
+				"mock":        mockBackend{name: "mock", options: mockOptions{APIKey: "foo"}, env: env},
+				"remote/mock": mockBackend{name: "remote/mock", options: mockOptions{Region: "eu"}},
+				"remote/eu":   mockBackend{name: "remote/eu", options: mockOptions{Region: "eu"}},
+			},
+			expectedDefaultBackends: []string{"mock", "remote/eu", "remote/mock"},
+		},
+		{
+			name:                       "a source named like another backend returns error",
+			config:                     `[{"name": "remote/eu", "type": "mock", "apiKey": "foo"}, {"name": "remote", "type": "mockremote"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("Backend configured twice: remote/eu"),
+		},
+		{
+			name:                       "the same name twice returns error",
+			config:                     `[{"type": "mock", "apiKey": "foo"}, {"name": "mock", "type": "mock", "apiKey": "bar"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("Backend configured twice: mock"),
+		},
+		{
+			name:                       "unknown type returns error",
+			config:                     `[{"name": "foo", "type": "unknown"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("Unknown backend type for backend foo: unknown"),
+		},
+		{
+			name:                       "unknown option returns error",
+			config:                     `[{"type": "mock", "apiKey": "foo", "apiKeys": "bar"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New(`Invalid options for backend mock: unknown field "apiKeys"`),
+		},
+		{
+			name:                       "invalid options return the error of the factory",
+			config:                     `[{"name": "eu", "type": "mock"}]`,
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("No API key configured for backend: eu"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := Config{}
+			require.NoError(t, json.Unmarshal([]byte(tc.config), &config))
+
+			configured, names, err := Configure(config, env)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedConfiguredBackends, configured)
+			require.Equal(t, tc.expectedDefaultBackends, names)
+		})
+	}
+}
+
+func TestConfigure_closesCreatedBackends(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		expectedClosed []string
+	}{
+		{
+			name:           "a backend that can't be created",
+			config:         `[{"name": "a", "type": "mockclosing"}, {"name": "b", "type": "mockclosing"}, {"name": "c", "type": "unknown"}]`,
+			expectedClosed: []string{"a", "b"},
+		},
+		{
+			name:           "a source named like another backend",
+			config:         `[{"name": "remote/eu", "type": "mockclosing"}, {"name": "remote", "type": "mockremote"}]`,
+			expectedClosed: []string{"remote/eu"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			closedBackends = nil
+			config := Config{}
+			require.NoError(t, json.Unmarshal([]byte(tc.config), &config))
+
+			_, _, err := Configure(config, Environment{})
+			require.Error(t, err)
+			sort.Strings(closedBackends)
+			require.Equal(t, tc.expectedClosed, closedBackends)
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	require.PanicsWithValue(t, "backends: Register called twice for type mock", func() {
+		Register(Factory{Type: "mock", Options: mockOptions{}, New: func(string, interface{}, Environment) (types.WeatherBackend, error) { return nil, nil }})
+	})
+	require.PanicsWithValue(t, "backends: Register needs a type, options and a constructor", func() {
+		Register(Factory{Type: "other"})
+	})
+	require.PanicsWithValue(t, "backends: Register needs a type, options and a constructor", func() {
+		Register(Factory{
+			Type:       "other",
+			Options:    mockOptions{},
+			New:        func(string, interface{}, Environment) (types.WeatherBackend, error) { return nil, nil },
+			NewSources: func(string, interface{}, Environment) (map[string]types.WeatherBackend, error) { return nil, nil },
+		})
+	})
+
+	registered := []string{}
+	for _, factory := range Factories() {
+		registered = append(registered, factory.Type)
+	}
+	require.Equal(t, []string{"mock", "mockclosing", "mockremote"}, registered)
+}
@@ -0,0 +1,12 @@
+// Package builtin registers the backends that come with the server, import it for its side effects:
+//
+//	import _ "go-weather-app/server/backends/builtin"
+package builtin
+
+import (
+	// every backend registers itself with the backends package
+	_ "go-weather-app/server/backends/accuweather"
+	_ "go-weather-app/server/backends/federated"
+	_ "go-weather-app/server/backends/openweathermap"
+	_ "go-weather-app/server/backends/plugin"
+)
@@ -0,0 +1,200 @@
+// Package federated uses another server of this app as a source, i.e. the server of another region, so that regional
+// deployments share the readings their upstream APIs were already asked for. Every configured backend of the other
+// server becomes a backend of its own, named after the federated backend and the backend of the other server:
+//
+//	{"name": "eu", "type": "federated", "url": "https://eu.weather.example.com", "token": "...", "backends": ["openweathermap", "accuweather"]}
+//
+// configures "eu/openweathermap" and "eu/accuweather". Requests are relayed with the api.HeaderHops header, and
+// the other server doesn't relay them any further, so that servers federated with each other can't loop.
+package federated
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Type is what federated backends are configured with in config.json
+const Type = "federated"
+
+// Options defines the configuration of a federated backend
+type Options struct {
+	URL      string   `json:"url" config:"required"`      // the other server, i.e. "https://eu.weather.example.com"
+	Token    string   `json:"token,omitempty"`            // sent as a bearer token, i.e. an API key or token of the other server
+	Backends []string `json:"backends" config:"required"` // the backends of the other server to use
+}
+
+func init() {
+	backends.Register(backends.Factory{
+		Type:    Type,
+		Options: Options{},
+		NewSources: func(name string, options interface{}, env backends.Environment) (map[string]types.WeatherBackend, error) {
+			return New(name, options.(Options), env.HTTPClient, env.Logger)
+		},
+	})
+}
+
+// Remote is another server of this app
+type Remote struct {
+	name       string
+	url        string
+	token      string
+	httpClient *http.Client
+	logger     echo.Logger
+}
+
+// Source is a backend of another server, it is a types.ForecastBackend and a types.RelayBackend
+type Source struct {
+	remote *Remote
+	source string // the name of the backend on the other server
+}
+
+// remoteWeatherResponse is the part of the responses of /v1/weather/{city} that is used
+type remoteWeatherResponse struct {
+	Data  []types.Weather `json:"data"`
+	Error string          `json:"error"`
+}
+
+// remoteForecastResponse is the part of the responses of /v1/forecast/{city} that is used
+type remoteForecastResponse struct {
+	Data  []types.Forecast `json:"data"`
+	Error string           `json:"error"`
+}
+
+// New creates the backends of the federated backend called name, by the name of the backend of the other server they
+// stand for. The other server isn't called until they are, so that it being down can't keep this one from starting.
+// The http client and the logger default to http.DefaultClient and a logger of its own.
+func New(name string, options Options, httpClient *http.Client, logger echo.Logger) (map[string]types.WeatherBackend, error) {
+	u, err := url.Parse(options.URL)
+	if options.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Invalid URL for federated backend " + name + ": " + options.URL)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if logger == nil {
+		logger = log.New(name)
+	}
+	r := &Remote{name: name, url: strings.TrimSuffix(options.URL, "/"), token: options.Token, httpClient: httpClient, logger: logger}
+
+	if len(options.Backends) == 0 {
+		return nil, errors.New("No backends configured for federated backend: " + name)
+	}
+
+	sources := map[string]types.WeatherBackend{}
+	for _, source := range options.Backends {
+		sources[source] = Source{remote: r, source: source}
+	}
+	return sources, nil
+}
+
+// GetWeather gets the weather for the location from the backend of the other server
+func (s Source) GetWeather(loc location.Location) types.Weather {
+	resp := remoteWeatherResponse{}
+	if err := s.remote.get(s.path("/v1/weather/", loc), &resp); err != nil {
+		return types.WeatherError(err)
+	}
+	if resp.Error != "" {
+		return types.WeatherError(errors.New(resp.Error))
+	}
+	if len(resp.Data) != 1 {
+		return types.WeatherError(types.ErrBadResponse)
+	}
+	weather := resp.Data[0]
+	if weather.Error == "" {
+		weather.Source = s.name()
+	} else {
+		weather.Source = ""
+	}
+	return weather
+}
+
+// GetForecast gets the forecast for the location from the backend of the other server
+func (s Source) GetForecast(loc location.Location) types.Forecast {
+	resp := remoteForecastResponse{}
+	if err := s.remote.get(s.path("/v1/forecast/", loc), &resp); err != nil {
+		return types.ForecastError(err)
+	}
+	if resp.Error != "" {
+		return types.ForecastError(errors.New(resp.Error))
+	}
+	if len(resp.Data) != 1 {
+		return types.ForecastError(types.ErrBadResponse)
+	}
+	forecast := resp.Data[0]
+	if forecast.Error == "" {
+		forecast.Source = s.name()
+	} else {
+		forecast.Source = ""
+	}
+	return forecast
+}
+
+// RelaysTo returns the URL of the other server
+func (s Source) RelaysTo() string {
+	return s.remote.url
+}
+
+// name is the name of the source on this server, which says where its readings come from, i.e. "eu/openweathermap"
+func (s Source) name() string {
+	return s.remote.name + "/" + s.source
+}
+
+func (s Source) path(prefix string, loc location.Location) string {
+	return prefix + url.PathEscape(query(loc)) + "?backend=" + url.QueryEscape(s.source)
+}
+
+// query formats the location the way the other server accepts it, i.e. "Springfield, IL, US". Coordinates are sent
+// when there are any, so that the other server asks its backends about the very same place instead of geocoding the
+// name again (i.e. London, ON rather than London, UK).
+func query(loc location.Location) string {
+	if loc.Coordinates != nil {
+		return loc.Coordinates.String()
+	}
+	if loc.Name == "" && loc.PostalCode != "" {
+		if loc.Country == "" {
+			return loc.PostalCode
+		}
+		return loc.PostalCode + ", " + loc.Country
+	}
+	return loc.String()
+}
+
+// get calls the other server, the responses of failed requests are decoded too since they say what went wrong
+func (r *Remote) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, r.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	req.Header.Set(api.HeaderHops, "1")
+	if r.token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+r.token)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		r.logger.Error("federated backend ", r.name, " encountered error calling ", r.url, ": ", err)
+		return types.ErrUnavailable
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		r.logger.Error("federated backend ", r.name, " encountered status code error: ", resp.StatusCode)
+		return types.ErrUnavailable
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		r.logger.Error("federated backend ", r.name, " encountered error decoding response: ", err)
+		return types.ErrBadResponse
+	}
+	return nil
+}
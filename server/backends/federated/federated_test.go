@@ -0,0 +1,182 @@
+package federated
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/require"
+)
+
+// newRemote fakes another server with an openweathermap and an accuweather backend, recording the requests it gets
+func newRemote(t *testing.T) (*httptest.Server, *[]*http.Request) {
+	requests := &[]*http.Request{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/weather/", func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		switch r.URL.Query().Get("backend") {
+		case "openweathermap":
+			w.Write([]byte(`{"city": "Ottawa, ON, CA", "data": [{"source": "openweathermap", "temperature": 20, "temperature_min": 15, "temperature_max": 22, "main_description": "Sunny"}]}`))
+		case "accuweather":
+			w.Write([]byte(`{"city": "Ottawa, ON, CA", "data": [{"source": "accuweather", "error": "Error communicating to backend", "error_code": "backend_unavailable"}]}`))
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"city": "Ottawa, ON, CA", "error": "Backend specified is invalid or inactive: ` + r.URL.Query().Get("backend") + `"}`))
+		}
+	})
+	mux.HandleFunc("/v1/forecast/", func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		w.Write([]byte(`{"city": "Ottawa, ON, CA", "data": [{"source": "openweathermap", "days": [{"date": "2019-06-01", "temperature_min": 15, "temperature_max": 22}]}]}`))
+	})
+	return httptest.NewServer(mux), requests
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         Options
+		expectedSources []string
+		expectedErr     error
+	}{
+		{
+			name:            "configured backends are used as is",
+			options:         Options{URL: "https://eu.weather.example.com/", Backends: []string{"openweathermap", "accuweather"}},
+			expectedSources: []string{"accuweather", "openweathermap"},
+		},
+		{
+			name:            "the other server isn't called",
+			options:         Options{URL: "http://127.0.0.1:1", Backends: []string{"openweathermap"}},
+			expectedSources: []string{"openweathermap"},
+		},
+		{
+			name:        "missing URL returns error",
+			options:     Options{Backends: []string{"openweathermap"}},
+			expectedErr: errors.New("Invalid URL for federated backend eu: "),
+		},
+		{
+			name:        "URL without a scheme returns error",
+			options:     Options{URL: "eu.weather.example.com", Backends: []string{"openweathermap"}},
+			expectedErr: errors.New("Invalid URL for federated backend eu: eu.weather.example.com"),
+		},
+		{
+			name:        "no backends returns error",
+			options:     Options{URL: "https://eu.weather.example.com"},
+			expectedErr: errors.New("No backends configured for federated backend: eu"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sources, err := New("eu", tc.options, nil, log.New("test"))
+			require.Equal(t, tc.expectedErr, err)
+			names := []string{}
+			for name, source := range sources {
+				names = append(names, name)
+				require.Equal(t, strings.TrimSuffix(tc.options.URL, "/"), source.(types.RelayBackend).RelaysTo())
+			}
+			sort.Strings(names)
+			if tc.expectedErr == nil {
+				require.Equal(t, tc.expectedSources, names)
+			}
+		})
+	}
+}
+
+func TestSource_GetWeather(t *testing.T) {
+	remote, requests := newRemote(t)
+	defer remote.Close()
+
+	tests := []struct {
+		name     string
+		source   string
+		loc      location.Location
+		want     types.Weather
+		wantPath string
+	}{
+		{
+			name:     "reading of the other server is named after where it comes from",
+			source:   "openweathermap",
+			loc:      location.Location{Name: "Ottawa", AdminRegion: "ON", Country: "CA"},
+			want:     types.Weather{Source: "eu/openweathermap", Temperature: 20, TemperatureMin: 15, TemperatureMax: 22, MainDescription: "Sunny"},
+			wantPath: "/v1/weather/Ottawa, ON, CA",
+		},
+		{
+			name:     "resolved location is sent by its coordinates",
+			source:   "openweathermap",
+			loc:      location.Location{Name: "London", AdminRegion: "ON", Country: "CA", Coordinates: &location.Coordinates{Latitude: 42.98, Longitude: -81.25}},
+			want:     types.Weather{Source: "eu/openweathermap", Temperature: 20, TemperatureMin: 15, TemperatureMax: 22, MainDescription: "Sunny"},
+			wantPath: "/v1/weather/42.98,-81.25",
+		},
+		{
+			name:     "failed reading of the other server keeps its error",
+			source:   "accuweather",
+			loc:      location.Location{PostalCode: "K1A 0B1", Country: "CA"},
+			want:     types.WeatherError(types.ErrUnavailable),
+			wantPath: "/v1/weather/K1A 0B1, CA",
+		},
+		{
+			name:     "rejected request returns the error of the other server",
+			source:   "unknown",
+			loc:      location.Location{Coordinates: &location.Coordinates{Latitude: 45.42, Longitude: -75.69}},
+			want:     types.Weather{Error: "Backend specified is invalid or inactive: unknown", ErrorCode: types.ErrorUnknown},
+			wantPath: "/v1/weather/45.42,-75.69",
+		},
+		{
+			name:     "failing server returns error",
+			source:   "broken",
+			loc:      location.Location{Name: "Ottawa"},
+			want:     types.WeatherError(types.ErrUnavailable),
+			wantPath: "/v1/weather/Ottawa",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sources, err := New("eu", Options{URL: remote.URL, Token: "secret", Backends: []string{tc.source}}, nil, log.New("test"))
+			require.NoError(t, err)
+
+			got := sources[tc.source].GetWeather(tc.loc)
+			require.Equal(t, tc.want, got)
+
+			req := (*requests)[len(*requests)-1]
+			require.Equal(t, tc.wantPath, req.URL.Path)
+			require.Equal(t, tc.source, req.URL.Query().Get("backend"))
+			require.Equal(t, "1", req.Header.Get(api.HeaderHops))
+			require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+		})
+	}
+}
+
+func TestSource_GetForecast(t *testing.T) {
+	remote, _ := newRemote(t)
+	defer remote.Close()
+
+	sources, err := New("eu", Options{URL: remote.URL, Backends: []string{"openweathermap"}}, nil, log.New("test"))
+	require.NoError(t, err)
+
+	got := sources["openweathermap"].(types.ForecastBackend).GetForecast(location.Location{Name: "Ottawa"})
+	require.Equal(t, types.Forecast{Source: "eu/openweathermap", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 15, TemperatureMax: 22}}}, got)
+}
+
+func TestFederated_factory(t *testing.T) {
+	remote, _ := newRemote(t)
+	defer remote.Close()
+
+	config := backends.Config{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"name": "eu", "type": "federated", "url": "`+remote.URL+`", "backends": ["openweathermap", "accuweather"]}]`), &config))
+
+	configured, names, err := backends.Configure(config, backends.Environment{Logger: log.New("test"), HTTPClient: &http.Client{}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu/accuweather", "eu/openweathermap"}, names)
+	require.Equal(t, "eu/openweathermap", configured["eu/openweathermap"].GetWeather(location.Location{Name: "Ottawa"}).Source)
+}
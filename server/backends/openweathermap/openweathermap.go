@@ -4,21 +4,72 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
 	"go-weather-app/server/types"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo"
 )
 
 // Openweathermap defines the configuration for an openweathermap backend
 type Openweathermap struct {
-	APIKey string `json:"apiKey"`
-	Logger echo.Logger
+	Name       string       `json:"-"` // reported as the source of readings, defaults to Type
+	APIKey     string       `json:"apiKey" config:"required"`
+	Logger     echo.Logger  `json:"-"`
+	HTTPClient *http.Client `json:"-"` // defaults to http.DefaultClient
+}
+
+// Type is what openweathermap backends are configured with in config.json
+const Type = "openweathermap"
+
+func init() {
+	backends.Register(backends.Factory{
+		Type:    Type,
+		Options: Openweathermap{},
+		New: func(name string, options interface{}, env backends.Environment) (types.WeatherBackend, error) {
+			o := options.(Openweathermap)
+			if o.APIKey == "" {
+				return nil, errors.New("No API key configured for backend: " + name)
+			}
+			o.Name, o.Logger, o.HTTPClient = name, env.Logger, env.HTTPClient
+			return o, nil
+		},
+	})
+}
+
+func (o Openweathermap) source() string {
+	if o.Name == "" {
+		return Type
+	}
+	return o.Name
+}
+
+func (o Openweathermap) httpClient() *http.Client {
+	if o.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return o.HTTPClient
 }
 
 type cityWeatherResp struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Coord          *Coord `json:"coord"`
 	WeatherDetails `json:"weather"`
 	MainDetails    `json:"main"`
+	SysDetails     `json:"sys"`
+}
+
+type Coord struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+type SysDetails struct {
+	Country string `json:"country"`
 }
 
 type WeatherDetails []struct {
@@ -32,42 +83,182 @@ type MainDetails struct {
 }
 
 var cityWeatherURIF = "https://api.openweathermap.org/data/2.5/weather?q=%s&units=metric&APPID=%s"
+var postalCodeWeatherURIF = "https://api.openweathermap.org/data/2.5/weather?zip=%s&units=metric&APPID=%s"
+var coordinatesWeatherURIF = "https://api.openweathermap.org/data/2.5/weather?lat=%f&lon=%f&units=metric&APPID=%s"
+var cityForecastURIF = "https://api.openweathermap.org/data/2.5/forecast?q=%s&units=metric&APPID=%s"
+var postalCodeForecastURIF = "https://api.openweathermap.org/data/2.5/forecast?zip=%s&units=metric&APPID=%s"
+var coordinatesForecastURIF = "https://api.openweathermap.org/data/2.5/forecast?lat=%f&lon=%f&units=metric&APPID=%s"
 
-// GetWeather gets the whether for the specified city with via openweathermap
-func (o Openweathermap) GetWeather(city string) types.Weather {
-	cwr, err := o.getWeather(city)
+// forecastResp is the 5 day forecast, in 3 hour steps
+type forecastResp struct {
+	City forecastCity    `json:"city"`
+	List []forecastEntry `json:"list"`
+}
+type forecastCity struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Coord    *Coord `json:"coord"`
+	Country  string `json:"country"`
+	Timezone int    `json:"timezone"` // offset from UTC in seconds
+}
+type forecastEntry struct {
+	Dt             int64 `json:"dt"`
+	MainDetails    `json:"main"`
+	WeatherDetails `json:"weather"`
+}
+
+// GetWeather gets the whether for the specified location with via openweathermap
+func (o Openweathermap) GetWeather(loc location.Location) types.Weather {
+	cwr, err := o.getWeather(loc)
 	if err != nil {
-		return types.Weather{
-			Error: err.Error(),
-		}
+		return types.WeatherError(err)
 	}
 
-	return types.Weather{
-		Source:              types.OPENWEATHERMAP,
-		Temperature:         cwr.MainDetails.Temp,
-		TemperatureMax:      cwr.MainDetails.TempMax,
-		TemperatureMin:      cwr.MainDetails.TempMin,
-		MainDescription:     cwr.WeatherDetails[0].Main,
-		DetailedDescription: cwr.WeatherDetails[0].Description,
+	weather := types.Weather{
+		Source:         o.source(),
+		Temperature:    cwr.MainDetails.Temp,
+		TemperatureMax: cwr.MainDetails.TempMax,
+		TemperatureMin: cwr.MainDetails.TempMin,
+		Location:       cwr.resolvedLocation(),
+	}
+	// like the forecasts, readings without weather conditions are left without descriptions
+	if len(cwr.WeatherDetails) > 0 {
+		weather.MainDescription = cwr.WeatherDetails[0].Main
+		weather.DetailedDescription = cwr.WeatherDetails[0].Description
 	}
+	return weather
 }
 
-func (o Openweathermap) getWeather(city string) (*cityWeatherResp, error) {
-	weatherURI := fmt.Sprintf(cityWeatherURIF, city, o.APIKey)
-	resp, err := http.Get(weatherURI)
+func (cwr cityWeatherResp) resolvedLocation() *types.ResolvedLocation {
+	resolved := &types.ResolvedLocation{
+		Location: location.Location{
+			Name:    cwr.Name,
+			Country: cwr.SysDetails.Country,
+		},
+	}
+	if cwr.ID != 0 {
+		resolved.ProviderLocationID = strconv.FormatInt(cwr.ID, 10)
+	}
+	if cwr.Coord != nil {
+		resolved.Coordinates = &location.Coordinates{Latitude: cwr.Coord.Lat, Longitude: cwr.Coord.Lon}
+	}
+	return resolved
+}
+
+// weatherURI picks the most precise way of asking openweathermap about a location
+func (o Openweathermap) weatherURI(loc location.Location) string {
+	return o.locationURI(loc, cityWeatherURIF, postalCodeWeatherURIF, coordinatesWeatherURIF)
+}
+
+// forecastURI is weatherURI for forecasts
+func (o Openweathermap) forecastURI(loc location.Location) string {
+	return o.locationURI(loc, cityForecastURIF, postalCodeForecastURIF, coordinatesForecastURIF)
+}
+
+func (o Openweathermap) locationURI(loc location.Location, cityURIF, postalCodeURIF, coordinatesURIF string) string {
+	if loc.Coordinates != nil {
+		return fmt.Sprintf(coordinatesURIF, loc.Coordinates.Latitude, loc.Coordinates.Longitude, o.APIKey)
+	}
+	if loc.PostalCode != "" {
+		return fmt.Sprintf(postalCodeURIF, queryTerms(loc.PostalCode, loc.Country), o.APIKey)
+	}
+	return fmt.Sprintf(cityURIF, queryTerms(loc.Name, loc.AdminRegion, loc.Country), o.APIKey)
+}
+
+// queryTerms joins the non-empty terms the way openweathermap expects them, i.e. "Springfield,IL,US"
+func queryTerms(terms ...string) string {
+	nonEmpty := []string{}
+	for _, term := range terms {
+		if term != "" {
+			nonEmpty = append(nonEmpty, term)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
+
+func (o Openweathermap) getWeather(loc location.Location) (*cityWeatherResp, error) {
+	resp, err := o.httpClient().Get(o.weatherURI(loc))
 	if err != nil {
-		return nil, err
+		return nil, types.NewBackendError(types.ErrorUnavailable, err.Error())
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		o.Logger.Error("openweathermap encountered status code error:", resp.StatusCode)
-		return nil, errors.New("Error communicating to backend")
+		return nil, types.ErrUnavailable
 	}
 	cwr := &cityWeatherResp{}
 	err = json.NewDecoder(resp.Body).Decode(cwr)
 	if err != nil {
 		o.Logger.Error("openweathermap encountered error decoding response:", err)
-		return nil, errors.New("Unable to decode response from backend")
+		return nil, types.ErrBadResponse
 	}
 	return cwr, nil
 }
+
+// GetForecast gets the daily forecast for the specified location via openweathermap
+func (o Openweathermap) GetForecast(loc location.Location) types.Forecast {
+	fr, err := o.getForecast(loc)
+	if err != nil {
+		return types.ForecastError(err)
+	}
+
+	resolved := cityWeatherResp{ID: fr.City.ID, Name: fr.City.Name, Coord: fr.City.Coord, SysDetails: SysDetails{Country: fr.City.Country}}.resolvedLocation()
+	return types.Forecast{
+		Source:   o.source(),
+		Days:     fr.days(),
+		Location: resolved,
+	}
+}
+
+// days summarizes the 3 hour steps into days in the location's local time, described by the step closest to noon
+func (fr forecastResp) days() []types.ForecastDay {
+	days := []types.ForecastDay{}
+	noonDistance := []int{}
+	for _, entry := range fr.List {
+		local := time.Unix(entry.Dt, 0).UTC().Add(time.Duration(fr.City.Timezone) * time.Second)
+		date := local.Format("2006-01-02")
+		distance := local.Hour() - 12
+		if distance < 0 {
+			distance = -distance
+		}
+
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, types.ForecastDay{Date: date, TemperatureMin: entry.TempMin, TemperatureMax: entry.TempMax})
+			noonDistance = append(noonDistance, 24)
+		}
+		day := &days[len(days)-1]
+		if entry.TempMin < day.TemperatureMin {
+			day.TemperatureMin = entry.TempMin
+		}
+		if entry.TempMax > day.TemperatureMax {
+			day.TemperatureMax = entry.TempMax
+		}
+		if len(entry.WeatherDetails) > 0 && distance < noonDistance[len(days)-1] {
+			day.MainDescription = entry.WeatherDetails[0].Main
+			day.DetailedDescription = entry.WeatherDetails[0].Description
+			noonDistance[len(days)-1] = distance
+		}
+	}
+	return days
+}
+
+func (o Openweathermap) getForecast(loc location.Location) (*forecastResp, error) {
+	resp, err := o.httpClient().Get(o.forecastURI(loc))
+	if err != nil {
+		return nil, types.NewBackendError(types.ErrorUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		o.Logger.Error("openweathermap encountered status code error for forecast:", resp.StatusCode)
+		return nil, types.ErrUnavailable
+	}
+	fr := &forecastResp{}
+	err = json.NewDecoder(resp.Body).Decode(fr)
+	if err != nil {
+		o.Logger.Error("openweathermap encountered error decoding response for forecast:", err)
+		return nil, types.ErrBadResponse
+	}
+	return fr, nil
+}
@@ -1,13 +1,17 @@
 package openweathermap
 
 import (
+	"encoding/json"
 	"errors"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
 	"go-weather-app/server/types"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/labstack/echo"
+	"github.com/labstack/gommon/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,7 +30,8 @@ func TestOpenweathermap_GetWeather(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 			},
 			want: types.Weather{
-				Error: "Error communicating to backend",
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
 			},
 		},
 		{
@@ -35,15 +40,39 @@ func TestOpenweathermap_GetWeather(t *testing.T) {
 			serverHandler: func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
 				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
-				w.Write([]byte("{\"weather\":[{\"main\":\"Sunny\",\"description\":\"Mainly sunny\"}],\"main\":{\"temp\":20,\"temp_min\":15,\"temp_max\":22}}"))
+				w.Write([]byte("{\"id\":6058560,\"name\":\"London\",\"coord\":{\"lat\":42.98,\"lon\":-81.23},\"sys\":{\"country\":\"CA\"},\"weather\":[{\"main\":\"Sunny\",\"description\":\"Mainly sunny\"}],\"main\":{\"temp\":20,\"temp_min\":15,\"temp_max\":22}}"))
 			},
 			want: types.Weather{
-				Source:              types.OPENWEATHERMAP,
+				Source:              Type,
 				Temperature:         20,
 				TemperatureMax:      22,
 				TemperatureMin:      15,
 				MainDescription:     "Sunny",
 				DetailedDescription: "Mainly sunny",
+				Location: &types.ResolvedLocation{
+					Location: location.Location{
+						Name:        "London",
+						Country:     "CA",
+						Coordinates: &location.Coordinates{Latitude: 42.98, Longitude: -81.23},
+					},
+					ProviderLocationID: "6058560",
+				},
+			},
+		},
+		{
+			name:   "weather without descriptions when backend returns no weather conditions",
+			logger: echo.New().Logger,
+			serverHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header()["Content-Type"] = []string{"application/json; charset=utf-8"}
+				w.Write([]byte("{\"name\":\"London\",\"weather\":[],\"main\":{\"temp\":20,\"temp_min\":15,\"temp_max\":22}}"))
+			},
+			want: types.Weather{
+				Source:         Type,
+				Temperature:    20,
+				TemperatureMax: 22,
+				TemperatureMin: 15,
+				Location:       &types.ResolvedLocation{Location: location.Location{Name: "London"}},
 			},
 		},
 	}
@@ -61,7 +90,7 @@ func TestOpenweathermap_GetWeather(t *testing.T) {
 				APIKey: "fookey",
 				Logger: tc.logger,
 			}
-			got := o.GetWeather("foo")
+			got := o.GetWeather(location.Location{Name: "foo"})
 
 			require.Equal(t, tc.want, got)
 
@@ -69,6 +98,21 @@ func TestOpenweathermap_GetWeather(t *testing.T) {
 	}
 }
 
+func TestOpenweathermap_unreachable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close() // nothing answers anymore
+	origCityWeatherURIF, origCityForecastURIF := cityWeatherURIF, cityForecastURIF
+	cityWeatherURIF, cityForecastURIF = ts.URL+"?q=%s&APPID=%s", ts.URL+"?q=%s&APPID=%s"
+	defer func() { cityWeatherURIF, cityForecastURIF = origCityWeatherURIF, origCityForecastURIF }()
+
+	o := Openweathermap{APIKey: "fookey", Logger: echo.New().Logger}
+	weather := o.GetWeather(location.Location{Name: "foo"})
+	require.Equal(t, types.ErrorUnavailable, weather.ErrorCode)
+	require.Contains(t, weather.Error, "connection refused")
+	forecast := o.GetForecast(location.Location{Name: "foo"})
+	require.Equal(t, types.ErrorUnavailable, forecast.ErrorCode)
+}
+
 func TestOpenweathermap_getWeather(t *testing.T) {
 
 	tests := []struct {
@@ -144,7 +188,7 @@ func TestOpenweathermap_getWeather(t *testing.T) {
 				APIKey: "fookey",
 				Logger: tc.logger,
 			}
-			got, err := o.getWeather(tc.city)
+			got, err := o.getWeather(location.Location{Name: tc.city})
 
 			if tc.expectedErr != nil {
 				require.Contains(t, err.Error(), tc.expectedErr.Error())
@@ -161,3 +205,121 @@ func TestOpenweathermap_getWeather(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenweathermap_weatherURI(t *testing.T) {
+	tests := []struct {
+		name string
+		loc  location.Location
+		want string
+	}{
+		{
+			name: "city name",
+			loc:  location.Location{Name: "Ottawa"},
+			want: "https://api.openweathermap.org/data/2.5/weather?q=Ottawa&units=metric&APPID=fookey",
+		},
+		{
+			name: "city name with admin region and country",
+			loc:  location.Location{Name: "Springfield", AdminRegion: "IL", Country: "US"},
+			want: "https://api.openweathermap.org/data/2.5/weather?q=Springfield,IL,US&units=metric&APPID=fookey",
+		},
+		{
+			name: "postal code",
+			loc:  location.Location{PostalCode: "62701", Country: "US"},
+			want: "https://api.openweathermap.org/data/2.5/weather?zip=62701,US&units=metric&APPID=fookey",
+		},
+		{
+			name: "coordinates take precedence",
+			loc:  location.Location{Name: "Ottawa", Coordinates: &location.Coordinates{Latitude: 45.41, Longitude: -75.7}},
+			want: "https://api.openweathermap.org/data/2.5/weather?lat=45.410000&lon=-75.700000&units=metric&APPID=fookey",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := Openweathermap{APIKey: "fookey"}
+			require.Equal(t, tc.want, o.weatherURI(tc.loc))
+		})
+	}
+}
+
+func TestOpenweathermap_GetForecast(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverHandler func(http.ResponseWriter, *http.Request)
+		want          types.Forecast
+	}{
+		{
+			name: "error when backend returns non 200 status code",
+			serverHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			want: types.Forecast{
+				Error:     "Error communicating to backend",
+				ErrorCode: types.ErrorUnavailable,
+			},
+		},
+		{
+			name: "3 hour steps are summarized into local days",
+			serverHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				// UTC-4, the first two steps are 20:00 and 23:00 on the 1st, the others are 02:00, 11:00 and 14:00 on the 2nd
+				w.Write([]byte("{\"city\":{\"id\":6094817,\"name\":\"Ottawa\",\"coord\":{\"lat\":45.4112,\"lon\":-75.6981},\"country\":\"CA\",\"timezone\":-14400},\"list\":[" +
+					"{\"dt\":1559433600,\"main\":{\"temp_min\":18,\"temp_max\":19},\"weather\":[{\"main\":\"Clouds\",\"description\":\"broken clouds\"}]}," +
+					"{\"dt\":1559444400,\"main\":{\"temp_min\":15,\"temp_max\":16},\"weather\":[{\"main\":\"Clear\",\"description\":\"clear sky\"}]}," +
+					"{\"dt\":1559455200,\"main\":{\"temp_min\":12,\"temp_max\":13},\"weather\":[{\"main\":\"Clear\",\"description\":\"clear sky\"}]}," +
+					"{\"dt\":1559487600,\"main\":{\"temp_min\":20,\"temp_max\":21},\"weather\":[{\"main\":\"Rain\",\"description\":\"light rain\"}]}," +
+					"{\"dt\":1559498400,\"main\":{\"temp_min\":22,\"temp_max\":24},\"weather\":[{\"main\":\"Clouds\",\"description\":\"few clouds\"}]}" +
+					"]}"))
+			},
+			want: types.Forecast{
+				Source: Type,
+				Days: []types.ForecastDay{
+					{Date: "2019-06-01", TemperatureMin: 15, TemperatureMax: 19, MainDescription: "Clouds", DetailedDescription: "broken clouds"},
+					{Date: "2019-06-02", TemperatureMin: 12, TemperatureMax: 24, MainDescription: "Rain", DetailedDescription: "light rain"},
+				},
+				Location: &types.ResolvedLocation{
+					Location: location.Location{
+						Name:        "Ottawa",
+						Country:     "CA",
+						Coordinates: &location.Coordinates{Latitude: 45.4112, Longitude: -75.6981},
+					},
+					ProviderLocationID: "6094817",
+				},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup fake backend
+			ts := httptest.NewServer(http.HandlerFunc(tc.serverHandler))
+			defer ts.Close()
+			// override URL so we can use our test server above instead
+			origCityForecastURIF := cityForecastURIF
+			cityForecastURIF = ts.URL + "?q=%s&APPID=%s"
+			defer func() { cityForecastURIF = origCityForecastURIF }()
+
+			o := Openweathermap{
+				APIKey: "fookey",
+				Logger: echo.New().Logger,
+			}
+			got := o.GetForecast(location.Location{Name: "Ottawa"})
+
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestOpenweathermap_factory(t *testing.T) {
+	env := backends.Environment{Logger: log.New("test"), HTTPClient: &http.Client{}}
+	config := backends.Config{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "openweathermap", "apiKey": "foo"}, {"name": "eu", "type": "openweathermap", "apiKey": "bar"}]`), &config))
+
+	configured, names, err := backends.Configure(config, env)
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu", Type}, names)
+	require.Equal(t, Openweathermap{Name: Type, APIKey: "foo", Logger: env.Logger, HTTPClient: env.HTTPClient}, configured[Type])
+	require.Equal(t, Openweathermap{Name: "eu", APIKey: "bar", Logger: env.Logger, HTTPClient: env.HTTPClient}, configured["eu"])
+
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "openweathermap"}]`), &config))
+	_, _, err = backends.Configure(config, env)
+	require.EqualError(t, err, "No API key configured for backend: openweathermap")
+}
@@ -0,0 +1,417 @@
+// Package plugin runs backends out of process, i.e. proprietary forecast models that can't live in this repository.
+// The server starts the executable configured for the backend and calls it with JSON-RPC 2.0 over its stdin and
+// stdout (see protocol.go), health checks it, and restarts it when it crashes or stops answering. Its stderr is logged.
+//
+// Plugins are configured like any other backend:
+//
+//	{"name": "in-house", "type": "plugin", "command": ["/opt/models/forecast", "-v"], "config": {"model": "v2"}}
+//
+// Plugins written in Go only need to call Serve, see cmd/weather-plugin-example.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Type is what plugin backends are configured with in config.json
+const Type = "plugin"
+
+// Defaults for the options of plugins
+const (
+	DefaultTimeout             = 10 * time.Second
+	DefaultHealthCheckInterval = 30 * time.Second
+	DefaultRestartDelay        = time.Second
+)
+
+// Options defines the configuration of a plugin backend
+type Options struct {
+	Command             []string        `json:"command" config:"required"`                       // the executable of the plugin, then its arguments
+	Config              json.RawMessage `json:"config,omitempty"`                                // sent to the plugin as is in the handshake
+	Timeout             string          `json:"timeout,omitempty" config:"duration"`             // how long a call can take, i.e. "5s", defaults to DefaultTimeout
+	HealthCheckInterval string          `json:"healthCheckInterval,omitempty" config:"duration"` // defaults to DefaultHealthCheckInterval
+	RestartDelay        string          `json:"restartDelay,omitempty" config:"duration"`        // the least time between two starts, defaults to DefaultRestartDelay
+}
+
+func init() {
+	backends.Register(backends.Factory{
+		Type:    Type,
+		Options: Options{},
+		New: func(name string, options interface{}, env backends.Environment) (types.WeatherBackend, error) {
+			return New(name, options.(Options), env.Logger)
+		},
+	})
+}
+
+// Plugin is a backend served by a plugin. Call Close to stop the plugin.
+type Plugin struct {
+	name                string
+	options             Options
+	timeout             time.Duration
+	healthCheckInterval time.Duration
+	restartDelay        time.Duration
+	logger              echo.Logger
+	supportsForecasts   bool
+
+	mu         sync.Mutex
+	process    *process // nil once closed
+	lastStart  time.Time
+	restarting chan struct{} // closed once the restart in progress is over, nil when there is none
+	closed     bool
+	stop       chan struct{}
+	wg         sync.WaitGroup // checkHealth and restarts, see Close
+}
+
+// forecastPlugin is a Plugin whose plugin supports forecasts
+type forecastPlugin struct {
+	*Plugin
+}
+
+// New starts the plugin of a backend called name, it returns a types.ForecastBackend when the plugin supports
+// forecasts. The logger defaults to a logger of its own.
+func New(name string, options Options, logger echo.Logger) (types.WeatherBackend, error) {
+	if len(options.Command) == 0 || options.Command[0] == "" {
+		return nil, errors.New("No command configured for plugin backend: " + name)
+	}
+	if logger == nil {
+		logger = log.New(name)
+	}
+	p := &Plugin{name: name, options: options, logger: logger, stop: make(chan struct{})}
+	for _, d := range []struct {
+		option       string
+		value        string
+		defaultValue time.Duration
+		target       *time.Duration
+	}{
+		{"timeout", options.Timeout, DefaultTimeout, &p.timeout},
+		{"healthCheckInterval", options.HealthCheckInterval, DefaultHealthCheckInterval, &p.healthCheckInterval},
+		{"restartDelay", options.RestartDelay, DefaultRestartDelay, &p.restartDelay},
+	} {
+		*d.target = d.defaultValue
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration <= 0 {
+			return nil, errors.New("Invalid " + d.option + " for plugin backend " + name + ": " + d.value)
+		}
+		*d.target = duration
+	}
+
+	p.lastStart = time.Now()
+	proc, result, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	// the capabilities of a plugin are fixed once it is configured, restarts can't change them
+	p.process, p.supportsForecasts = proc, result.SupportsForecasts
+	p.wg.Add(1)
+	go p.checkHealth()
+
+	if p.supportsForecasts {
+		return forecastPlugin{p}, nil
+	}
+	return p, nil
+}
+
+// GetWeather gets the weather for the location from the plugin
+func (p *Plugin) GetWeather(loc location.Location) types.Weather {
+	weather := types.Weather{}
+	if err := p.call(MethodGetWeather, LocationParams{Location: loc}, &weather); err != nil {
+		return types.WeatherError(err)
+	}
+	if weather.Error == "" {
+		weather.Source = p.name
+	}
+	return weather
+}
+
+// GetForecast gets the forecast for the location from the plugin
+func (p forecastPlugin) GetForecast(loc location.Location) types.Forecast {
+	forecast := types.Forecast{}
+	if err := p.call(MethodGetForecast, LocationParams{Location: loc}, &forecast); err != nil {
+		return types.ForecastError(err)
+	}
+	if forecast.Error == "" {
+		forecast.Source = p.name
+	}
+	return forecast
+}
+
+// Close stops the plugin, giving it until the timeout to exit once its stdin is closed
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	proc := p.process
+	p.process = nil
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	proc.close(p.timeout)
+	return nil
+}
+
+func (p *Plugin) call(method string, params interface{}, result interface{}) error {
+	proc, err := p.running()
+	if err != nil {
+		return err
+	}
+	return proc.call(method, params, result, p.timeout)
+}
+
+// running returns the process of the plugin, restarting it if it exited, unless it was started too recently. The
+// plugin is restarted without holding p.mu, calls made meanwhile wait for the restart.
+func (p *Plugin) running() (*process, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, types.NewBackendError(types.ErrorUnavailable, "Plugin backend is closed")
+	}
+	if restarting := p.restarting; restarting != nil {
+		p.mu.Unlock()
+		<-restarting
+		return p.running()
+	}
+	exited := p.process
+	select {
+	case <-exited.exited:
+	default:
+		p.mu.Unlock()
+		return exited, nil
+	}
+
+	if time.Since(p.lastStart) < p.restartDelay {
+		p.mu.Unlock()
+		return nil, types.NewBackendError(types.ErrorUnavailable, "Plugin exited, restarting shortly: "+exited.err.Error())
+	}
+	p.logger.Warn("restarting plugin ", p.name, ", it exited: ", exited.err)
+	restarting := make(chan struct{})
+	p.restarting, p.lastStart = restarting, time.Now()
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	proc, _, err := p.start()
+
+	p.mu.Lock()
+	p.restarting = nil
+	close(restarting)
+	closed := p.closed
+	if err == nil && !closed {
+		p.process = proc
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return nil, types.NewBackendError(types.ErrorUnavailable, err.Error())
+	}
+	if closed {
+		proc.close(p.timeout)
+		return nil, types.NewBackendError(types.ErrorUnavailable, "Plugin backend is closed")
+	}
+	return proc, nil
+}
+
+// checkHealth calls the plugin every health check interval, restarting it when it doesn't answer
+func (p *Plugin) checkHealth() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		proc, err := p.running()
+		if err != nil {
+			continue // either closed or restarting, the next check sees which
+		}
+		if err := proc.call(MethodHealth, nil, &json.RawMessage{}, p.timeout); err != nil {
+			p.logger.Warn("plugin ", p.name, " failed its health check, stopping it: ", err)
+			proc.kill()
+		}
+	}
+}
+
+// start starts the plugin and shakes hands with it, it only reads the options of p so p.mu needn't be held
+func (p *Plugin) start() (*process, HandshakeResult, error) {
+	result := HandshakeResult{}
+	cmd := exec.Command(p.options.Command[0], p.options.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, result, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, result, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, result, errors.New("Unable to start plugin backend " + p.name + ": " + err.Error())
+	}
+
+	proc := &process{cmd: cmd, stdin: stdin, pending: map[uint64]chan Response{}, exited: make(chan struct{})}
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			p.logger.Info("plugin ", p.name, ": ", scanner.Text())
+		}
+	}()
+	go proc.readResponses(stdout, logged)
+
+	err = proc.call(MethodHandshake, HandshakeParams{ProtocolVersion: ProtocolVersion, Name: p.name, Config: p.options.Config}, &result, p.timeout)
+	if err == nil && result.ProtocolVersion != ProtocolVersion {
+		err = errors.New("unsupported protocol version " + strconv.Itoa(result.ProtocolVersion) + ", expected " + strconv.Itoa(ProtocolVersion))
+	}
+	if err != nil {
+		proc.kill()
+		<-proc.exited
+		return nil, result, errors.New("Unable to start plugin backend " + p.name + ": " + err.Error())
+	}
+	return proc, result, nil
+}
+
+// process is a running plugin
+type process struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan Response
+
+	exited chan struct{} // closed once the plugin exited, err says why
+	err    error
+}
+
+// call calls the plugin and waits for its answer, writing the request counts towards the timeout: a plugin that stopped
+// reading its stdin is killed, since it may have been sent part of a request
+func (proc *process) call(method string, params interface{}, result interface{}, timeout time.Duration) error {
+	proc.mu.Lock()
+	proc.nextID++
+	id := proc.nextID
+	answer := make(chan Response, 1)
+	proc.pending[id] = answer
+	proc.mu.Unlock()
+	defer func() {
+		proc.mu.Lock()
+		delete(proc.pending, id)
+		proc.mu.Unlock()
+	}()
+
+	req := Request{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		req.Params, _ = json.Marshal(params)
+	}
+	b, _ := json.Marshal(req)
+	written := make(chan error, 1)
+	go func() {
+		proc.writeMu.Lock()
+		defer proc.writeMu.Unlock()
+		_, err := proc.stdin.Write(append(b, '\n'))
+		written <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-written:
+		if err != nil {
+			return types.NewBackendError(types.ErrorUnavailable, "Unable to call plugin: "+err.Error())
+		}
+	case <-proc.exited:
+		return types.NewBackendError(types.ErrorUnavailable, "Plugin exited: "+proc.err.Error())
+	case <-timer.C:
+		proc.kill()
+		return types.NewBackendError(types.ErrorUnavailable, "Plugin timed out after "+timeout.String()+" reading its stdin")
+	}
+
+	select {
+	case resp := <-answer:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return types.NewBackendError(types.ErrorBadResponse, "Unable to parse plugin response: "+err.Error())
+		}
+		return nil
+	case <-proc.exited:
+		return types.NewBackendError(types.ErrorUnavailable, "Plugin exited: "+proc.err.Error())
+	case <-timer.C:
+		return types.NewBackendError(types.ErrorUnavailable, "Plugin timed out after "+timeout.String())
+	}
+}
+
+// readResponses hands the responses of the plugin to the calls waiting for them, until the plugin exits
+func (proc *process) readResponses(stdout io.Reader, logged chan struct{}) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for scanner.Scan() {
+		resp := Response{}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			continue // nothing is waiting for garbage
+		}
+		proc.mu.Lock()
+		answer, ok := proc.pending[resp.ID]
+		proc.mu.Unlock()
+		if ok {
+			select {
+			case answer <- resp:
+			default: // answered twice
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		proc.kill() // the plugin can't be understood anymore
+	}
+
+	// Wait must only be called once both pipes are read to the end
+	<-logged
+	err := proc.cmd.Wait()
+	if err == nil {
+		err = errors.New("exit status 0")
+	}
+	proc.err = err
+	close(proc.exited)
+}
+
+// kill stops the plugin right away, the next call restarts it
+func (proc *process) kill() {
+	proc.cmd.Process.Kill()
+}
+
+// close asks the plugin to exit by closing its stdin, and kills it if it doesn't within timeout
+func (proc *process) close(timeout time.Duration) {
+	proc.stdin.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(timeout):
+		proc.kill()
+		<-proc.exited
+	}
+}
@@ -0,0 +1,391 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/require"
+)
+
+// examplePlugin is cmd/weather-plugin-example, built by TestMain
+var examplePlugin string
+
+// helperEnv makes the test binary act as a misbehaving plugin instead of running the tests, see runHelper
+const helperEnv = "PLUGIN_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if behavior := os.Getenv(helperEnv); behavior != "" {
+		runHelper(behavior)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	examplePlugin = filepath.Join(dir, "weather-plugin-example")
+	out, err := exec.Command("go", "build", "-o", examplePlugin, "go-weather-app/cmd/weather-plugin-example").CombinedOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to build the example plugin:", err, string(out))
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runHelper answers the server like a broken plugin would
+func runHelper(behavior string) {
+	switch behavior {
+	case "exit":
+		os.Exit(3)
+	case "silent":
+		ioutil.ReadAll(os.Stdin)
+	case "version":
+		answer(2, -1)
+	case "stuck":
+		answer(1, 1) // then stops reading its stdin
+		time.Sleep(time.Hour)
+	case "slow":
+		time.Sleep(300 * time.Millisecond)
+		answer(1, -1)
+	}
+}
+
+// answer answers count requests (all of them when negative) with a handshake result of the protocol version
+func answer(version int, count int) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for i := 0; i != count && scanner.Scan(); i++ {
+		req := Request{}
+		json.Unmarshal(scanner.Bytes(), &req)
+		fmt.Printf(`{"jsonrpc":"2.0","id":%d,"result":{"protocolVersion":%d}}`+"\n", req.ID, version)
+	}
+}
+
+func helperCommand(behavior string) []string {
+	return []string{"env", helperEnv + "=" + behavior, os.Args[0]}
+}
+
+func newTestPlugin(t *testing.T, options Options) (*Plugin, types.WeatherBackend, *lockedWriter) {
+	logs := &lockedWriter{}
+	logger := log.New("test")
+	logger.SetOutput(logs)
+	backend, err := New("example", options, logger)
+	require.NoError(t, err)
+	if p, ok := backend.(forecastPlugin); ok {
+		return p.Plugin, backend, logs
+	}
+	return backend.(*Plugin), backend, logs
+}
+
+// lockedWriter keeps the logs of plugins, which are written from several goroutines, readable by tests
+type lockedWriter struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(b)
+}
+
+func (l *lockedWriter) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     Options
+		expectedErr string
+	}{
+		{
+			name:        "no command",
+			options:     Options{},
+			expectedErr: "No command configured for plugin backend: example",
+		},
+		{
+			name:        "invalid timeout",
+			options:     Options{Command: []string{examplePlugin}, Timeout: "soon"},
+			expectedErr: "Invalid timeout for plugin backend example: soon",
+		},
+		{
+			name:        "missing executable",
+			options:     Options{Command: []string{"/does/not/exist"}},
+			expectedErr: "Unable to start plugin backend example: fork/exec /does/not/exist: no such file or directory",
+		},
+		{
+			name:        "plugin exits right away",
+			options:     Options{Command: helperCommand("exit")},
+			expectedErr: "Unable to start plugin backend example: Plugin exited: exit status 3",
+		},
+		{
+			name:        "plugin never answers the handshake",
+			options:     Options{Command: helperCommand("silent"), Timeout: "100ms"},
+			expectedErr: "Unable to start plugin backend example: Plugin timed out after 100ms",
+		},
+		{
+			name:        "plugin speaks another version",
+			options:     Options{Command: helperCommand("version")},
+			expectedErr: "Unable to start plugin backend example: unsupported protocol version 2, expected 1",
+		},
+		{
+			name:        "plugin rejects its config",
+			options:     Options{Command: []string{examplePlugin}, Config: json.RawMessage(`{"delay": "later"}`)},
+			expectedErr: "Unable to start plugin backend example: Invalid delay: later",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backend, err := New("example", tc.options, log.New("test"))
+			require.EqualError(t, err, tc.expectedErr)
+			require.Nil(t, backend)
+		})
+	}
+}
+
+func TestPlugin_GetWeather(t *testing.T) {
+	p, backend, logs := newTestPlugin(t, Options{Command: []string{examplePlugin}, Config: json.RawMessage(`{"temperature": 20, "amplitude": 5, "description": "Sunny"}`)})
+
+	loc := location.Location{Name: "Ottawa", Country: "CA"}
+	require.Equal(t, types.Weather{
+		Source:          "example",
+		Temperature:     20,
+		TemperatureMin:  15,
+		TemperatureMax:  25,
+		MainDescription: "Sunny",
+		Location:        &types.ResolvedLocation{Location: loc},
+	}, backend.GetWeather(loc))
+	// plugins don't name themselves when they fail, like any other backend
+	require.Equal(t, types.Weather{Error: "No location specified"}, backend.GetWeather(location.Location{}))
+
+	// stderr is logged until the plugin exits
+	require.NoError(t, p.Close())
+	require.Contains(t, logs.String(), "plugin example: serving example")
+}
+
+func TestPlugin_GetForecast(t *testing.T) {
+	p, backend, _ := newTestPlugin(t, Options{Command: []string{examplePlugin}, Config: json.RawMessage(`{"temperature": 20, "forecastDays": 3}`)})
+	defer p.Close()
+
+	forecaster, ok := backend.(types.ForecastBackend)
+	require.True(t, ok)
+	forecast := forecaster.GetForecast(location.Location{Name: "Ottawa", Timezone: "America/Toronto"})
+	require.Equal(t, "example", forecast.Source)
+	require.Empty(t, forecast.Error)
+	require.Len(t, forecast.Days, 3)
+	require.Equal(t, "Clear", forecast.Days[2].MainDescription)
+}
+
+func TestPlugin_timeout(t *testing.T) {
+	p, backend, _ := newTestPlugin(t, Options{Command: []string{examplePlugin}, Config: json.RawMessage(`{"delay": "200ms"}`), Timeout: "100ms"})
+	defer p.Close()
+
+	// the handshake isn't delayed, the readings are
+	require.Equal(t, types.Weather{Error: "Plugin timed out after 100ms", ErrorCode: types.ErrorUnavailable}, backend.GetWeather(location.Location{Name: "Ottawa"}))
+}
+
+func TestPlugin_timeoutWriting(t *testing.T) {
+	p, backend, _ := newTestPlugin(t, Options{Command: helperCommand("stuck"), Timeout: "100ms", RestartDelay: "1h"})
+	defer p.Close()
+	p.mu.Lock()
+	proc := p.process
+	p.mu.Unlock()
+
+	// a request larger than the pipe can hold, which the plugin never reads
+	loc := location.Location{Name: strings.Repeat("a", 1<<20)}
+	require.Equal(t, types.Weather{Error: "Plugin timed out after 100ms reading its stdin", ErrorCode: types.ErrorUnavailable}, backend.GetWeather(loc))
+	<-proc.exited
+}
+
+func TestPlugin_restartUnlocked(t *testing.T) {
+	p, backend, _ := newTestPlugin(t, Options{Command: helperCommand("slow"), Timeout: "1s", RestartDelay: "10ms"})
+	defer p.Close()
+
+	p.mu.Lock()
+	proc := p.process
+	p.mu.Unlock()
+	proc.kill()
+	<-proc.exited
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan types.Weather)
+	go func() {
+		done <- backend.GetWeather(location.Location{Name: "Ottawa"})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// the plugin takes 300ms to start, the lock isn't held meanwhile
+	locked := time.Now()
+	p.mu.Lock()
+	restarting := p.restarting != nil
+	p.mu.Unlock()
+	require.True(t, restarting)
+	require.True(t, time.Since(locked) < 100*time.Millisecond)
+	require.Equal(t, "example", (<-done).Source)
+}
+
+func TestPlugin_restart(t *testing.T) {
+	p, backend, logs := newTestPlugin(t, Options{Command: []string{examplePlugin}, RestartDelay: "200ms"})
+	defer p.Close()
+
+	p.mu.Lock()
+	proc := p.process
+	p.mu.Unlock()
+	proc.kill()
+	<-proc.exited
+
+	// plugins that crash in a loop aren't restarted in a loop
+	require.Equal(t, types.Weather{Error: "Plugin exited, restarting shortly: signal: killed", ErrorCode: types.ErrorUnavailable}, backend.GetWeather(location.Location{Name: "Ottawa"}))
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, "example", backend.GetWeather(location.Location{Name: "Ottawa"}).Source)
+	p.mu.Lock()
+	require.NotEqual(t, proc, p.process)
+	p.mu.Unlock()
+	require.Contains(t, logs.String(), "restarting plugin example, it exited: signal: killed")
+}
+
+func TestPlugin_checkHealth(t *testing.T) {
+	p, _, _ := newTestPlugin(t, Options{Command: []string{examplePlugin}, HealthCheckInterval: "10ms", RestartDelay: "10ms"})
+	defer p.Close()
+
+	p.mu.Lock()
+	proc := p.process
+	p.mu.Unlock()
+	proc.kill()
+
+	// restarted without anyone asking for the weather
+	for i := 0; ; i++ {
+		require.True(t, i < 100, "the plugin wasn't restarted")
+		time.Sleep(10 * time.Millisecond)
+		p.mu.Lock()
+		restarted := p.process != proc
+		p.mu.Unlock()
+		if restarted {
+			break
+		}
+	}
+}
+
+func TestPlugin_Close(t *testing.T) {
+	p, backend, _ := newTestPlugin(t, Options{Command: []string{examplePlugin}})
+	proc := p.process
+
+	require.NoError(t, p.Close())
+	<-proc.exited
+	require.EqualError(t, proc.err, "exit status 0")
+	require.Equal(t, types.Weather{Error: "Plugin backend is closed", ErrorCode: types.ErrorUnavailable}, backend.GetWeather(location.Location{Name: "Ottawa"}))
+	require.NoError(t, p.Close())
+}
+
+func TestPlugin_factory(t *testing.T) {
+	config := backends.Config{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"name": "in-house", "type": "plugin", "command": ["`+examplePlugin+`"], "timeout": "5s"}]`), &config))
+
+	configured, names, err := backends.Configure(config, backends.Environment{Logger: log.New("test")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"in-house"}, names)
+	require.Equal(t, "in-house", configured["in-house"].GetWeather(location.Location{Name: "Ottawa"}).Source)
+	require.NoError(t, configured["in-house"].(forecastPlugin).Close())
+}
+
+type mockWeatherBackend struct{}
+
+func (m mockWeatherBackend) GetWeather(loc location.Location) types.Weather {
+	return types.Weather{Temperature: 12}
+}
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name             string
+		requests         []string
+		expectedResponse string // of the last request
+	}{
+		{
+			name:             "handshake",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocolVersion":1,"name":"foo"}}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":1,"supportsForecasts":false}}`,
+		},
+		{
+			name:             "handshake with another version",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocolVersion":2}}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":1,"supportsForecasts":false}}`,
+		},
+		{
+			name:             "handshake rejected by the backend",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocolVersion":1,"name":"bad"}}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"Unknown backend: bad"}}`,
+		},
+		{
+			name: "weather",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocolVersion":1,"name":"foo"}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"getWeather","params":{"location":{"name":"Ottawa"}}}`,
+			},
+			expectedResponse: `{"jsonrpc":"2.0","id":2,"result":{"source":"","temperature":12,"temperature_min":0,"temperature_max":0}}`,
+		},
+		{
+			name: "forecast of a backend without forecasts",
+			requests: []string{
+				`{"jsonrpc":"2.0","id":1,"method":"handshake","params":{"protocolVersion":1,"name":"foo"}}`,
+				`{"jsonrpc":"2.0","id":2,"method":"getForecast","params":{"location":{"name":"Ottawa"}}}`,
+			},
+			expectedResponse: `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"Forecasts are not supported by this backend"}}`,
+		},
+		{
+			name:             "weather before the handshake",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"getWeather","params":{"location":{"name":"Ottawa"}}}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"No handshake received"}}`,
+		},
+		{
+			name:             "health",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"health"}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			name:             "unknown method",
+			requests:         []string{`{"jsonrpc":"2.0","id":1,"method":"getClimate"}`},
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Unknown method: getClimate"}}`,
+		},
+		{
+			name:             "invalid request",
+			requests:         []string{`{"jsonrpc":`},
+			expectedResponse: `{"jsonrpc":"2.0","id":0,"error":{"code":-32700,"message":"Unable to parse request: unexpected end of JSON input"}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := Serve(strings.NewReader(strings.Join(tc.requests, "\n")), out, func(name string, config json.RawMessage) (types.WeatherBackend, error) {
+				if name == "bad" {
+					return nil, errors.New("Unknown backend: " + name)
+				}
+				return mockWeatherBackend{}, nil
+			})
+			require.NoError(t, err)
+			responses := strings.Split(strings.TrimSpace(out.String()), "\n")
+			require.Len(t, responses, len(tc.requests))
+			require.JSONEq(t, tc.expectedResponse, responses[len(responses)-1])
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+)
+
+// ProtocolVersion is the version of the protocol spoken with plugins. The server sends it in the handshake, and
+// plugins answer with the version they speak, which must be the same.
+const ProtocolVersion = 1
+
+// Methods called by the server. Every call is a JSON-RPC 2.0 request on a single line of the plugin's stdin, answered
+// by a single line on its stdout, in any order. Plugins must answer the handshake before anything else.
+const (
+	MethodHandshake   = "handshake"   // HandshakeParams, answered with HandshakeResult
+	MethodHealth      = "health"      // no params, answered with any result once the plugin can serve requests
+	MethodGetWeather  = "getWeather"  // LocationParams, answered with a types.Weather
+	MethodGetForecast = "getForecast" // LocationParams, answered with a types.Forecast
+)
+
+// JSON-RPC 2.0 error codes used by Serve
+const (
+	ErrorCodeParse          = -32700
+	ErrorCodeMethodNotFound = -32601
+	ErrorCodeInvalidParams  = -32602
+	ErrorCodeBackend        = -32000 // the backend couldn't answer, the message is reported as the error of the reading
+)
+
+// Request is a call from the server
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"` // always "2.0"
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the answer of a plugin, with either a result or an error
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is the error of a Response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// HandshakeParams are sent to the plugin when it starts
+type HandshakeParams struct {
+	ProtocolVersion int             `json:"protocolVersion"`
+	Name            string          `json:"name"`             // the name of the backend in the server
+	Config          json.RawMessage `json:"config,omitempty"` // the "config" of the backend in config.json, as is
+}
+
+// HandshakeResult tells the server what the plugin can do
+type HandshakeResult struct {
+	ProtocolVersion   int  `json:"protocolVersion"`
+	SupportsForecasts bool `json:"supportsForecasts"`
+}
+
+// LocationParams are the params of getWeather and getForecast
+type LocationParams struct {
+	Location location.Location `json:"location"`
+}
+
+// Serve answers the calls of the server read from r on w until r is closed, for plugins written in Go. The backend is
+// created by configure during the handshake, it supports forecasts when it is a types.ForecastBackend too.
+func Serve(r io.Reader, w io.Writer, configure func(name string, config json.RawMessage) (types.WeatherBackend, error)) error {
+	s := &server{w: w, configure: configure}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for scanner.Scan() {
+		req := Request{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.send(Response{Error: &Error{Code: ErrorCodeParse, Message: "Unable to parse request: " + err.Error()}})
+			continue
+		}
+		if req.Method == MethodHandshake {
+			// nothing else can be answered before the backend exists
+			s.send(s.handle(req))
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.send(s.handle(req))
+		}()
+	}
+	s.wg.Wait()
+	return scanner.Err()
+}
+
+// maxMessageBytes is the largest message exchanged with plugins, forecasts are much smaller than this
+const maxMessageBytes = 1024 * 1024
+
+type server struct {
+	w         io.Writer
+	configure func(name string, config json.RawMessage) (types.WeatherBackend, error)
+	backend   types.WeatherBackend
+	wg        sync.WaitGroup
+	writeMu   sync.Mutex
+}
+
+func (s *server) send(resp Response) {
+	resp.JSONRPC = "2.0"
+	b, _ := json.Marshal(resp)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.w.Write(append(b, '\n'))
+}
+
+func (s *server) handle(req Request) Response {
+	var result interface{}
+	var err error
+	switch req.Method {
+	case MethodHandshake:
+		result, err = s.handshake(req.Params)
+	case MethodHealth:
+		result = struct{}{}
+	case MethodGetWeather, MethodGetForecast:
+		result, err = s.get(req.Method, req.Params)
+	default:
+		return Response{ID: req.ID, Error: &Error{Code: ErrorCodeMethodNotFound, Message: "Unknown method: " + req.Method}}
+	}
+	if err != nil {
+		if rpcErr, ok := err.(*Error); ok {
+			return Response{ID: req.ID, Error: rpcErr}
+		}
+		return Response{ID: req.ID, Error: &Error{Code: ErrorCodeBackend, Message: err.Error()}}
+	}
+	b, _ := json.Marshal(result)
+	return Response{ID: req.ID, Result: b}
+}
+
+func (s *server) handshake(raw json.RawMessage) (interface{}, error) {
+	params := HandshakeParams{}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &Error{Code: ErrorCodeInvalidParams, Message: "Invalid handshake: " + err.Error()}
+	}
+	result := HandshakeResult{ProtocolVersion: ProtocolVersion}
+	if params.ProtocolVersion != ProtocolVersion {
+		// the server reports the mismatch
+		return result, nil
+	}
+	backend, err := s.configure(params.Name, params.Config)
+	if err != nil {
+		return nil, err
+	}
+	s.backend = backend
+	_, result.SupportsForecasts = backend.(types.ForecastBackend)
+	return result, nil
+}
+
+func (s *server) get(method string, raw json.RawMessage) (interface{}, error) {
+	if s.backend == nil {
+		return nil, errors.New("No handshake received")
+	}
+	params := LocationParams{}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &Error{Code: ErrorCodeInvalidParams, Message: "Invalid location: " + err.Error()}
+	}
+	if method == MethodGetWeather {
+		return s.backend.GetWeather(params.Location), nil
+	}
+	forecaster, ok := s.backend.(types.ForecastBackend)
+	if !ok {
+		return nil, &Error{Code: ErrorCodeMethodNotFound, Message: "Forecasts are not supported by this backend"}
+	}
+	return forecaster.GetForecast(params.Location), nil
+}
+
+// Error implements error, for errors returned by plugins
+func (e *Error) Error() string {
+	return e.Message
+}
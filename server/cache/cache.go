@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/metrics"
+	"go-weather-app/server/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cache is an in-memory cache of weather readings shared by every request, so that upstream APIs are only asked
+// about the same location once per TTL. Concurrent requests for the same key wait for a single fetch.
+type Cache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	metrics *metrics.Metrics
+
+	mu       sync.Mutex
+	entries  map[string]entry
+	inflight map[string]*call
+}
+
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+type call struct {
+	done  chan struct{}
+	value interface{} // nil when the fetch panicked
+}
+
+// New creates a cache that keeps successful readings for ttl, as told by now. A ttl of 0 disables caching, but concurrent
+// fetches for the same key are still shared.
+func New(ttl time.Duration, now func() time.Time, m *metrics.Metrics) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		now:      now,
+		metrics:  m,
+		entries:  map[string]entry{},
+		inflight: map[string]*call{},
+	}
+}
+
+// Key builds the cache key for a backend's reading of a location
+func Key(backend string, loc location.Location) string {
+	return backend + "|" + loc.Key()
+}
+
+// ForecastKey builds the cache key for a backend's forecast for a location
+func ForecastKey(backend string, loc location.Location) string {
+	return "forecast|" + Key(backend, loc)
+}
+
+// Get returns the cached reading for key, calling fetch to get a fresh one when there is none. Readings with an Error
+// are returned to the caller but never cached.
+func (c *Cache) Get(key string, fetch func() types.Weather) types.Weather {
+	value := c.get(key, func() (interface{}, bool) {
+		weather := fetch()
+		return weather, weather.Error == ""
+	})
+	if value == nil {
+		return types.WeatherError(types.ErrUnavailable)
+	}
+	return value.(types.Weather)
+}
+
+// GetForecast is Get for forecasts
+func (c *Cache) GetForecast(key string, fetch func() types.Forecast) types.Forecast {
+	value := c.get(key, func() (interface{}, bool) {
+		forecast := fetch()
+		return forecast, forecast.Error == ""
+	})
+	if value == nil {
+		return types.ForecastError(types.ErrUnavailable)
+	}
+	return value.(types.Forecast)
+}
+
+// get returns the cached value for key, calling fetch when there is none. Values are only cached when fetch says they
+// are ok to keep. The value is nil when fetch panicked.
+func (c *Cache) get(key string, fetch func() (interface{}, bool)) interface{} {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if c.now().Before(e.expires) {
+			c.mu.Unlock()
+			c.metrics.CacheRequestsTotal.With(prometheus.Labels{"result": "hit"}).Inc()
+			return e.value
+		}
+		delete(c.entries, key)
+	}
+	if inflight, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-inflight.done
+		c.metrics.CacheRequestsTotal.With(prometheus.Labels{"result": "shared"}).Inc()
+		return inflight.value
+	}
+	current := &call{done: make(chan struct{})}
+	c.inflight[key] = current
+	c.mu.Unlock()
+
+	c.metrics.CacheRequestsTotal.With(prometheus.Labels{"result": "miss"}).Inc()
+	keep := false
+	defer func() {
+		// always release the waiters, even if fetch panics
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if keep && c.ttl > 0 {
+			c.store(key, current.value)
+		}
+		c.mu.Unlock()
+		close(current.done)
+	}()
+	current.value, keep = fetch()
+	return current.value
+}
+
+// sweepThreshold is the number of entries past which expired entries are evicted when storing new ones
+const sweepThreshold = 1024
+
+// store caches a reading, c.mu must be held
+func (c *Cache) store(key string, value interface{}) {
+	now := c.now()
+	if len(c.entries) >= sweepThreshold {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = entry{value: value, expires: now.Add(c.ttl)}
+}
+
+// Len returns the number of readings currently cached (including expired ones that have not been evicted yet)
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/metrics"
+	"go-weather-app/server/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_Get(t *testing.T) {
+	tests := []struct {
+		name           string
+		ttl            time.Duration
+		elapsed        time.Duration
+		weather        types.Weather
+		expectedCalls  int32
+		expectedCached int
+	}{
+		{
+			name:           "readings are cached for the ttl",
+			ttl:            time.Minute,
+			elapsed:        30 * time.Second,
+			weather:        types.Weather{Source: "foo", Temperature: 12},
+			expectedCalls:  1,
+			expectedCached: 1,
+		},
+		{
+			name:           "expired readings are fetched again",
+			ttl:            time.Minute,
+			elapsed:        time.Minute,
+			weather:        types.Weather{Source: "foo", Temperature: 12},
+			expectedCalls:  2,
+			expectedCached: 1,
+		},
+		{
+			name:           "errors are not cached",
+			ttl:            time.Minute,
+			elapsed:        time.Second,
+			weather:        types.Weather{Error: "Error communicating to backend"},
+			expectedCalls:  2,
+			expectedCached: 0,
+		},
+		{
+			name:           "zero ttl disables caching",
+			ttl:            0,
+			elapsed:        0,
+			weather:        types.Weather{Source: "foo", Temperature: 12},
+			expectedCalls:  2,
+			expectedCached: 0,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Date(2019, 6, 14, 12, 0, 0, 0, time.UTC)
+			c := New(tc.ttl, time.Now, metrics.NewUnregistered())
+			c.now = func() time.Time { return now }
+
+			var calls int32
+			fetch := func() types.Weather {
+				atomic.AddInt32(&calls, 1)
+				return tc.weather
+			}
+
+			require.Equal(t, tc.weather, c.Get("foo", fetch))
+			now = now.Add(tc.elapsed)
+			require.Equal(t, tc.weather, c.Get("foo", fetch))
+			require.Equal(t, tc.expectedCalls, calls)
+			require.Equal(t, tc.expectedCached, c.Len())
+		})
+	}
+}
+
+func TestCache_GetSharesInflightFetches(t *testing.T) {
+	// callers that arrive after the fetch finished get the cached reading, so there is only ever one fetch
+	c := New(time.Minute, time.Now, metrics.NewUnregistered())
+	release := make(chan struct{})
+	var calls int32
+	fetch := func() types.Weather {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return types.Weather{Source: "foo"}
+	}
+
+	wg := sync.WaitGroup{}
+	results := make([]types.Weather, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.Get("foo", fetch)
+		}(i)
+	}
+	// wait for the first fetch to start, and give the others a chance to wait on it
+	for {
+		c.mu.Lock()
+		started := len(c.inflight) == 1
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls)
+	for _, result := range results {
+		require.Equal(t, types.Weather{Source: "foo"}, result)
+	}
+}
+
+func TestCache_GetPanickingFetch(t *testing.T) {
+	c := New(time.Minute, time.Now, metrics.NewUnregistered())
+	require.Panics(t, func() {
+		c.Get("foo", func() types.Weather { panic("boom") })
+	})
+	require.Equal(t, 0, c.Len())
+	require.Len(t, c.inflight, 0)
+}
+
+func TestCache_GetForecast(t *testing.T) {
+	c := New(time.Minute, time.Now, metrics.NewUnregistered())
+	calls := 0
+	fetch := func() types.Forecast {
+		calls++
+		return types.Forecast{Source: "foo", Days: []types.ForecastDay{{Date: "2019-06-14", TemperatureMin: 10, TemperatureMax: 20}}}
+	}
+	loc := location.Location{Name: "Ottawa"}
+
+	first := c.GetForecast(ForecastKey("foo", loc), fetch)
+	second := c.GetForecast(ForecastKey("foo", loc), fetch)
+	require.Equal(t, first, second)
+	require.Equal(t, 1, calls)
+
+	// forecasts and readings for the same location don't collide
+	weather := c.Get(Key("foo", loc), func() types.Weather { return types.Weather{Source: "foo"} })
+	require.Equal(t, types.Weather{Source: "foo"}, weather)
+	require.Equal(t, 2, c.Len())
+
+	require.Panics(t, func() {
+		c.GetForecast("bar", func() types.Forecast { panic("boom") })
+	})
+}
+
+func TestKey(t *testing.T) {
+	require.Equal(t, "foo|Ottawa||CA|", Key("foo", location.Location{Name: "Ottawa", Country: "CA"}))
+	require.Equal(t, "foo|||||45.42,-75.70", Key("foo", location.Location{Coordinates: &location.Coordinates{Latitude: 45.4215, Longitude: -75.6972}}))
+	require.Equal(t, "foo|Ottawa|||K1A|45.42,-75.70", Key("foo", location.Location{Name: "Ottawa", PostalCode: "K1A", Coordinates: &location.Coordinates{Latitude: 45.4215, Longitude: -75.6972}}))
+}
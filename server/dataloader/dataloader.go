@@ -0,0 +1,116 @@
+package dataloader
+
+import (
+	"sync"
+	"time"
+)
+
+// Key identifies a value to load, keys with the same String() are the same value
+type Key interface {
+	String() string
+}
+
+// BatchFunc loads the values of many keys at once, returning them in the same order as the keys
+type BatchFunc func(keys []Key) []interface{}
+
+// Loader collects the keys requested around the same time (i.e. by the resolvers of a single GraphQL query) into
+// batches, and loads every key only once. Loaders are meant to live as long as a single request, values are never
+// evicted.
+type Loader struct {
+	batchFn  BatchFunc
+	wait     time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	results map[string]*result
+	pending *batch
+}
+
+type result struct {
+	done  chan struct{}
+	value interface{}
+}
+
+type batch struct {
+	keys    []Key
+	results []*result
+}
+
+// New creates a loader that waits up to wait for more keys before calling batchFn, with at most maxBatch keys per call
+// (0 for no limit)
+func New(batchFn BatchFunc, wait time.Duration, maxBatch int) *Loader {
+	return &Loader{
+		batchFn:  batchFn,
+		wait:     wait,
+		maxBatch: maxBatch,
+		results:  map[string]*result{},
+	}
+}
+
+// Load loads the value of a single key, waiting for the batch it is part of
+func (l *Loader) Load(key Key) interface{} {
+	return l.LoadMany([]Key{key})[0]
+}
+
+// LoadMany loads the values of many keys, which always end up in the same batch unless it is full
+func (l *Loader) LoadMany(keys []Key) []interface{} {
+	results := make([]*result, len(keys))
+	l.mu.Lock()
+	for i, key := range keys {
+		r, ok := l.results[key.String()]
+		if !ok {
+			r = &result{done: make(chan struct{})}
+			l.results[key.String()] = r
+			l.add(key, r)
+		}
+		results[i] = r
+	}
+	l.mu.Unlock()
+
+	values := make([]interface{}, len(keys))
+	for i, r := range results {
+		<-r.done
+		values[i] = r.value
+	}
+	return values
+}
+
+// add adds the key to the pending batch, starting a new one if needed. l.mu must be held.
+func (l *Loader) add(key Key, r *result) {
+	if l.pending == nil {
+		b := &batch{}
+		l.pending = b
+		time.AfterFunc(l.wait, func() {
+			l.mu.Lock()
+			if l.pending != b {
+				// already dispatched for being full
+				l.mu.Unlock()
+				return
+			}
+			l.pending = nil
+			l.mu.Unlock()
+			l.dispatch(b)
+		})
+	}
+	b := l.pending
+	b.keys = append(b.keys, key)
+	b.results = append(b.results, r)
+	if l.maxBatch > 0 && len(b.keys) >= l.maxBatch {
+		l.pending = nil
+		go l.dispatch(b)
+	}
+}
+
+func (l *Loader) dispatch(b *batch) {
+	var values []interface{}
+	defer func() {
+		// always release the waiters, even if batchFn panics or returns too few values (those are left nil)
+		for i, r := range b.results {
+			if i < len(values) {
+				r.value = values[i]
+			}
+			close(r.done)
+		}
+	}()
+	values = l.batchFn(b.keys)
+}
@@ -0,0 +1,74 @@
+package dataloader
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type intKey int
+
+func (k intKey) String() string {
+	return strconv.Itoa(int(k))
+}
+
+// recordingBatchFn doubles every key, and records the batches it was called with
+type recordingBatchFn struct {
+	mu      sync.Mutex
+	batches [][]int
+}
+
+func (r *recordingBatchFn) load(keys []Key) []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	batch := []int{}
+	values := []interface{}{}
+	for _, key := range keys {
+		batch = append(batch, int(key.(intKey)))
+		values = append(values, 2*int(key.(intKey)))
+	}
+	r.batches = append(r.batches, batch)
+	return values
+}
+
+func TestLoader_LoadMany(t *testing.T) {
+	r := &recordingBatchFn{}
+	l := New(r.load, time.Millisecond, 0)
+
+	// keys requested together are loaded in one batch, each once
+	require.Equal(t, []interface{}{2, 4, 2}, l.LoadMany([]Key{intKey(1), intKey(2), intKey(1)}))
+	require.Equal(t, [][]int{{1, 2}}, r.batches)
+
+	// already loaded keys are never loaded again
+	require.Equal(t, []interface{}{4, 6}, l.LoadMany([]Key{intKey(2), intKey(3)}))
+	require.Equal(t, [][]int{{1, 2}, {3}}, r.batches)
+}
+
+func TestLoader_Load(t *testing.T) {
+	r := &recordingBatchFn{}
+	l := New(r.load, 20*time.Millisecond, 3)
+
+	// concurrent loads end up in the same batches, full batches don't wait
+	values := make([]interface{}, 5)
+	wg := sync.WaitGroup{}
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i] = l.Load(intKey(i))
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, []interface{}{0, 2, 4, 6, 8}, values)
+	require.Len(t, r.batches, 2)
+	require.Len(t, r.batches[0], 3)
+	require.Len(t, r.batches[1], 2)
+}
+
+func TestLoader_LoadMissingValues(t *testing.T) {
+	l := New(func(keys []Key) []interface{} { return nil }, time.Millisecond, 0)
+	require.Equal(t, []interface{}{nil, nil}, l.LoadMany([]Key{intKey(1), intKey(2)}))
+}
@@ -0,0 +1,123 @@
+package geoip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"go-weather-app/server/location"
+)
+
+// Locate returns the approximate location of an IP address using a GeoIP2/GeoLite2 City database
+func (r *Reader) Locate(ip net.IP) (location.Location, bool, error) {
+	record, found, err := r.Lookup(ip)
+	if err != nil || !found {
+		return location.Location{}, false, err
+	}
+	m, _ := record.(map[string]interface{})
+
+	loc := location.Location{
+		Name:       englishName(lookupPath(m, "city")),
+		Country:    stringAt(lookupPath(m, "country"), "iso_code"),
+		PostalCode: stringAt(lookupPath(m, "postal"), "code"),
+		Timezone:   stringAt(lookupPath(m, "location"), "time_zone"),
+	}
+	if subdivisions, ok := lookupPath(m, "subdivisions").([]interface{}); ok && len(subdivisions) > 0 {
+		loc.AdminRegion = englishName(subdivisions[0])
+	}
+	if l, ok := lookupPath(m, "location").(map[string]interface{}); ok {
+		lat, latOK := l["latitude"].(float64)
+		lon, lonOK := l["longitude"].(float64)
+		if latOK && lonOK {
+			loc.Coordinates = &location.Coordinates{Latitude: lat, Longitude: lon}
+		}
+	}
+	if loc.Coordinates == nil && loc.Name == "" {
+		// a country level match isn't precise enough to get the weather for
+		return location.Location{}, false, nil
+	}
+	return loc, true, nil
+}
+
+func lookupPath(m map[string]interface{}, key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m[key]
+}
+
+func stringAt(v interface{}, key string) string {
+	m, _ := v.(map[string]interface{})
+	s, _ := lookupPath(m, key).(string)
+	return s
+}
+
+func englishName(v interface{}) string {
+	m, _ := v.(map[string]interface{})
+	return stringAt(lookupPath(m, "names"), "en")
+}
+
+// ParseTrustedProxies parses a list of IP addresses and/or CIDR ranges
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP returns the IP address of the client that made the request. X-Forwarded-For is only honored when the request
+// came from a trusted proxy, and is walked from the right (closest hop) so that clients can't spoof their address by
+// sending their own header through the proxy.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrusted(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := []string{}
+	for _, header := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// garbage in the header; the last address we could trust is the best we can do
+			return ip
+		}
+		ip = hop
+		if !isTrusted(ip, trustedProxies) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Locator describes the interface for finding the approximate location of an IP address
+type Locator interface {
+	Locate(ip net.IP) (location.Location, bool, error)
+}
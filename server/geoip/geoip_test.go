@@ -0,0 +1,108 @@
+package geoip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name        string
+		proxies     []string
+		want        []string
+		expectedErr error
+	}{
+		{
+			name:    "single addresses and ranges",
+			proxies: []string{"10.0.0.1", "172.16.0.0/12", "::1"},
+			want:    []string{"10.0.0.1/32", "172.16.0.0/12", "::1/128"},
+		},
+		{
+			name:        "invalid proxy returns error",
+			proxies:     []string{"foo"},
+			expectedErr: &net.ParseError{Type: "CIDR address", Text: "foo/128"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTrustedProxies(tc.proxies)
+			require.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr != nil {
+				return
+			}
+			gotStrings := []string{}
+			for _, network := range got {
+				gotStrings = append(gotStrings, network.String())
+			}
+			require.Equal(t, tc.want, gotStrings)
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"172.16.0.0/12", "10.0.0.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{
+			name:       "direct connection",
+			remoteAddr: "81.2.69.160:51234",
+			want:       "81.2.69.160",
+		},
+		{
+			name:         "forwarded for is ignored from untrusted peers",
+			remoteAddr:   "81.2.69.160:51234",
+			forwardedFor: []string{"1.2.3.4"},
+			want:         "81.2.69.160",
+		},
+		{
+			name:         "forwarded for is honored from trusted proxies",
+			remoteAddr:   "172.18.0.3:51234",
+			forwardedFor: []string{"81.2.69.160"},
+			want:         "81.2.69.160",
+		},
+		{
+			name:         "spoofed addresses to the left of the first untrusted hop are ignored",
+			remoteAddr:   "172.18.0.3:51234",
+			forwardedFor: []string{"1.2.3.4, 81.2.69.160, 10.0.0.1"},
+			want:         "81.2.69.160",
+		},
+		{
+			name:         "multiple headers are treated as one list",
+			remoteAddr:   "172.18.0.3:51234",
+			forwardedFor: []string{"1.2.3.4", "81.2.69.160"},
+			want:         "81.2.69.160",
+		},
+		{
+			name:         "garbage stops at the last good address",
+			remoteAddr:   "172.18.0.3:51234",
+			forwardedFor: []string{"81.2.69.160, garbage, 10.0.0.1"},
+			want:         "10.0.0.1",
+		},
+		{
+			name:         "only trusted hops returns the furthest one",
+			remoteAddr:   "172.18.0.3:51234",
+			forwardedFor: []string{"10.0.0.1"},
+			want:         "10.0.0.1",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/weather/here", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, header := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			require.Equal(t, tc.want, ClientIP(req, trusted).String())
+		})
+	}
+}
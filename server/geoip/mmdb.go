@@ -0,0 +1,345 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// metadataStartMarker precedes the metadata map at the end of every MaxMind DB file
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparatorSize is the number of zero bytes between the search tree and the data section
+const dataSectionSeparatorSize = 16
+
+// data field types, see https://maxmind.github.io/MaxMind-DB/
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBoolean   = 14
+	typeFloat     = 15
+)
+
+// Metadata defines the parts of a MaxMind DB's metadata needed to read it
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+}
+
+// Reader reads MaxMind DB (.mmdb) files, i.e. GeoLite2-City.mmdb
+type Reader struct {
+	Metadata    Metadata
+	tree        []byte
+	data        []byte
+	ipv4Start   uint
+	nodeByteLen uint
+}
+
+// Open reads a MaxMind DB file from disk
+func Open(databaseFilePath string) (*Reader, error) {
+	b, err := ioutil.ReadFile(databaseFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(b)
+}
+
+// FromBytes reads a MaxMind DB from memory
+func FromBytes(b []byte) (*Reader, error) {
+	markerIdx := bytes.LastIndex(b, metadataStartMarker)
+	if markerIdx < 0 {
+		return nil, errors.New("Invalid MaxMind DB: metadata not found")
+	}
+	metadataStart := markerIdx + len(metadataStartMarker)
+	d := decoder{buffer: b[metadataStart:]}
+	raw, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MaxMind DB metadata: %v", err)
+	}
+	rawMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Invalid MaxMind DB metadata: not a map")
+	}
+
+	r := &Reader{}
+	r.Metadata.NodeCount = uint(toUint(rawMap["node_count"]))
+	r.Metadata.RecordSize = uint(toUint(rawMap["record_size"]))
+	r.Metadata.IPVersion = uint(toUint(rawMap["ip_version"]))
+	r.Metadata.DatabaseType, _ = rawMap["database_type"].(string)
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("Unsupported MaxMind DB record size: %d", r.Metadata.RecordSize)
+	}
+	if r.Metadata.IPVersion != 4 && r.Metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("Unsupported MaxMind DB ip version: %d", r.Metadata.IPVersion)
+	}
+
+	r.nodeByteLen = r.Metadata.RecordSize * 2 / 8
+	treeSize := r.Metadata.NodeCount * r.nodeByteLen
+	if treeSize+dataSectionSeparatorSize > uint(markerIdx) {
+		return nil, errors.New("Invalid MaxMind DB: search tree is larger than the file")
+	}
+	r.tree = b[:treeSize]
+	r.data = b[treeSize+dataSectionSeparatorSize : markerIdx]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases; find that node once instead of on every lookup
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the decoded data record for the provided IP address, if any
+func (r *Reader) Lookup(ip net.IP) (interface{}, bool, error) {
+	node := uint(0)
+	bits := ip.To16()
+	if ipv4 := ip.To4(); ipv4 != nil {
+		bits = ipv4
+		node = r.ipv4Start
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, false, nil // IPv6 addresses can't be found in an IPv4 only database
+	}
+	if bits == nil {
+		return nil, false, errors.New("Invalid IP address")
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.Metadata.NodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	if node == r.Metadata.NodeCount {
+		return nil, false, nil // empty record, address isn't in the database
+	}
+	if node < r.Metadata.NodeCount {
+		return nil, false, errors.New("Invalid MaxMind DB: search tree is deeper than an IP address")
+	}
+
+	offset := node - r.Metadata.NodeCount - dataSectionSeparatorSize
+	if offset >= uint(len(r.data)) {
+		return nil, false, errors.New("Invalid MaxMind DB: record points past the data section")
+	}
+	d := decoder{buffer: r.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of a search tree node
+func (r *Reader) readRecord(node uint, bit uint) uint {
+	b := r.tree[node*r.nodeByteLen : (node+1)*r.nodeByteLen]
+	switch r.Metadata.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5])
+	case 28:
+		// the middle byte holds the high nibble of both records
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		if bit == 0 {
+			return uint(binary.BigEndian.Uint32(b[0:4]))
+		}
+		return uint(binary.BigEndian.Uint32(b[4:8]))
+	}
+}
+
+// decoder decodes values from a MaxMind DB data section
+type decoder struct {
+	buffer []byte
+}
+
+// decode returns the value at offset and the offset right after it
+func (d decoder) decode(offset uint) (interface{}, uint, error) {
+	typeNum, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typeNum == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// pointers can't point to other pointers, which also keeps a corrupt file from sending us in circles
+		if targetType, _, _, err := d.decodeControl(pointer); err != nil || targetType == typePointer {
+			return nil, 0, errors.New("invalid pointer")
+		}
+		// pointers are followed, but the value pointed to never counts towards the caller's position
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	switch typeNum {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			key, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[keyString] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			value, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buffer)) {
+		return nil, 0, errors.New("value extends past the end of the data section")
+	}
+	b := d.buffer[offset : offset+size]
+	next := offset + size
+	switch typeNum {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte{}, b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		return decodeUint(b), next, nil
+	case typeUint128:
+		// nothing in the city databases uses these; keep the raw bytes rather than losing precision
+		return append([]byte{}, b...), next, nil
+	case typeInt32:
+		return int32(decodeUint(b)), next, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typeNum)
+}
+
+// decodeControl reads a field's control byte(s), returning its type, size and the offset of its payload
+func (d decoder) decodeControl(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buffer[offset]
+	offset++
+	typeNum := int(ctrl >> 5)
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buffer)) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		typeNum = 7 + int(d.buffer[offset])
+		offset++
+	}
+	if typeNum == typePointer {
+		// pointers encode their size differently, leave the raw control bits to decodePointer
+		return typeNum, uint(ctrl & 0x1F), offset, nil
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(d.buffer)) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		n := decodeUint(d.buffer[offset : offset+extra])
+		offset += extra
+		switch extra {
+		case 1:
+			size = 29 + uint(n)
+		case 2:
+			size = 285 + uint(n)
+		default:
+			size = 65821 + uint(n)
+		}
+	}
+	return typeNum, size, offset, nil
+}
+
+func (d decoder) decodePointer(ctrl uint, offset uint) (uint, uint, error) {
+	pointerSize := ((ctrl >> 3) & 0x3) + 1
+	if offset+pointerSize > uint(len(d.buffer)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.buffer[offset : offset+pointerSize]
+	next := offset + pointerSize
+	value := uint(decodeUint(b))
+	switch pointerSize {
+	case 1:
+		return (ctrl&0x7)<<8 | value, next, nil
+	case 2:
+		return ((ctrl&0x7)<<16 | value) + 2048, next, nil
+	case 3:
+		return ((ctrl&0x7)<<24 | value) + 526336, next, nil
+	default:
+		return value, next, nil
+	}
+}
+
+func decodeUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+func toUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
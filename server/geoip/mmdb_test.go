@@ -0,0 +1,298 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"sort"
+	"testing"
+
+	"go-weather-app/server/location"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDBWriter builds small MaxMind DBs so that the reader can be tested without shipping a GeoLite database
+type testDBWriter struct {
+	ipVersion  int
+	recordSize int
+	nodes      [][2]testRecord
+	data       bytes.Buffer
+}
+
+type testRecord struct {
+	kind  int // 0 empty, 1 node, 2 data
+	value int
+}
+
+func newTestDBWriter(ipVersion, recordSize int) *testDBWriter {
+	return &testDBWriter{ipVersion: ipVersion, recordSize: recordSize, nodes: [][2]testRecord{{}}}
+}
+
+func (w *testDBWriter) insert(t *testing.T, cidr string, value interface{}) {
+	_, network, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	bits := []byte(network.IP)
+	prefixLen, _ := network.Mask.Size()
+	if ipv4 := network.IP.To4(); ipv4 != nil && w.ipVersion == 6 {
+		bits = append(make([]byte, 12), ipv4...)
+		prefixLen += 96
+	}
+
+	offset := w.data.Len()
+	w.encode(value)
+
+	node := 0
+	for i := 0; i < prefixLen; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		if i == prefixLen-1 {
+			w.nodes[node][bit] = testRecord{kind: 2, value: offset}
+			break
+		}
+		if w.nodes[node][bit].kind != 1 {
+			w.nodes = append(w.nodes, [2]testRecord{})
+			w.nodes[node][bit] = testRecord{kind: 1, value: len(w.nodes) - 1}
+		}
+		node = w.nodes[node][bit].value
+	}
+}
+
+func (w *testDBWriter) bytes() []byte {
+	out := bytes.Buffer{}
+	nodeCount := len(w.nodes)
+	recordValue := func(r testRecord) uint32 {
+		switch r.kind {
+		case 1:
+			return uint32(r.value)
+		case 2:
+			return uint32(nodeCount + dataSectionSeparatorSize + r.value)
+		}
+		return uint32(nodeCount)
+	}
+	for _, node := range w.nodes {
+		left, right := recordValue(node[0]), recordValue(node[1])
+		switch w.recordSize {
+		case 24:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte((left>>24)<<4 | (right>>24)&0x0F), byte(right >> 16), byte(right >> 8), byte(right)})
+		default:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint32(b[0:4], left)
+			binary.BigEndian.PutUint32(b[4:8], right)
+			out.Write(b)
+		}
+	}
+	out.Write(make([]byte, dataSectionSeparatorSize))
+	out.Write(w.data.Bytes())
+	out.Write(metadataStartMarker)
+
+	metadata := &testDBWriter{}
+	metadata.encode(map[string]interface{}{
+		"node_count":                  uint64(nodeCount),
+		"record_size":                 uint64(w.recordSize),
+		"ip_version":                  uint64(w.ipVersion),
+		"database_type":               "GeoLite2-City",
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+	})
+	out.Write(metadata.data.Bytes())
+	return out.Bytes()
+}
+
+func (w *testDBWriter) encodeControl(typeNum int, size int) {
+	ctrl := []byte{0}
+	if typeNum > 7 {
+		ctrl = append(ctrl, byte(typeNum-7))
+	} else {
+		ctrl[0] = byte(typeNum << 5)
+	}
+	switch {
+	case size < 29:
+		ctrl[0] |= byte(size)
+	case size < 285:
+		ctrl[0] |= 29
+		ctrl = append(ctrl, byte(size-29))
+	case size < 65821:
+		ctrl[0] |= 30
+		ctrl = append(ctrl, byte((size-285)>>8), byte(size-285))
+	default:
+		ctrl[0] |= 31
+		ctrl = append(ctrl, byte((size-65821)>>16), byte((size-65821)>>8), byte(size-65821))
+	}
+	w.data.Write(ctrl)
+}
+
+func (w *testDBWriter) encode(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		w.encodeControl(typeString, len(v))
+		w.data.WriteString(v)
+	case float64:
+		w.encodeControl(typeDouble, 8)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		w.data.Write(b)
+	case uint64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		b = bytes.TrimLeft(b, "\x00")
+		w.encodeControl(typeUint64, len(b))
+		w.data.Write(b)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		w.encodeControl(typeBoolean, size)
+	case []interface{}:
+		w.encodeControl(typeArray, len(v))
+		for _, item := range v {
+			w.encode(item)
+		}
+	case map[string]interface{}:
+		w.encodeControl(typeMap, len(v))
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			w.encode(key)
+			w.encode(v[key])
+		}
+	}
+}
+
+func cityRecord(city, subdivision, country string, lat, lon float64, timezone string) map[string]interface{} {
+	return map[string]interface{}{
+		"city":         map[string]interface{}{"names": map[string]interface{}{"en": city}},
+		"subdivisions": []interface{}{map[string]interface{}{"iso_code": "X", "names": map[string]interface{}{"en": subdivision}}},
+		"country":      map[string]interface{}{"iso_code": country},
+		"location":     map[string]interface{}{"latitude": lat, "longitude": lon, "time_zone": timezone},
+	}
+}
+
+func TestReader_Lookup(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipVersion  int
+		recordSize int
+	}{
+		{name: "ipv4 database with 24 bit records", ipVersion: 4, recordSize: 24},
+		{name: "ipv6 database with 28 bit records", ipVersion: 6, recordSize: 28},
+		{name: "ipv6 database with 32 bit records", ipVersion: 6, recordSize: 32},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newTestDBWriter(tc.ipVersion, tc.recordSize)
+			w.insert(t, "81.2.69.0/24", cityRecord("London", "England", "GB", 51.5142, -0.0931, "Europe/London"))
+			w.insert(t, "216.160.83.56/29", cityRecord("Milton", "Washington", "US", 47.2513, -122.3149, "America/Los_Angeles"))
+			if tc.ipVersion == 6 {
+				w.insert(t, "2001:db8::/32", map[string]interface{}{"country": map[string]interface{}{"iso_code": "CA"}, "is_anonymous_proxy": false})
+			}
+
+			r, err := FromBytes(w.bytes())
+			require.NoError(t, err)
+			require.Equal(t, uint(tc.ipVersion), r.Metadata.IPVersion)
+			require.Equal(t, uint(tc.recordSize), r.Metadata.RecordSize)
+			require.Equal(t, "GeoLite2-City", r.Metadata.DatabaseType)
+
+			record, found, err := r.Lookup(net.ParseIP("81.2.69.160"))
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, "GB", record.(map[string]interface{})["country"].(map[string]interface{})["iso_code"])
+
+			_, found, err = r.Lookup(net.ParseIP("216.160.83.63"))
+			require.NoError(t, err)
+			require.True(t, found)
+
+			_, found, err = r.Lookup(net.ParseIP("216.160.83.64"))
+			require.NoError(t, err)
+			require.False(t, found)
+
+			_, found, err = r.Lookup(net.ParseIP("10.0.0.1"))
+			require.NoError(t, err)
+			require.False(t, found)
+
+			record, found, err = r.Lookup(net.ParseIP("2001:db8::1"))
+			require.NoError(t, err)
+			require.Equal(t, tc.ipVersion == 6, found)
+			if found {
+				require.Equal(t, false, record.(map[string]interface{})["is_anonymous_proxy"])
+			}
+		})
+	}
+}
+
+func TestReader_Locate(t *testing.T) {
+	w := newTestDBWriter(6, 24)
+	w.insert(t, "81.2.69.0/24", cityRecord("London", "England", "GB", 51.5142, -0.0931, "Europe/London"))
+	w.insert(t, "2001:db8::/32", map[string]interface{}{"country": map[string]interface{}{"iso_code": "CA"}})
+	r, err := FromBytes(w.bytes())
+	require.NoError(t, err)
+
+	loc, found, err := r.Locate(net.ParseIP("81.2.69.1"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, location.Location{
+		Name:        "London",
+		AdminRegion: "England",
+		Country:     "GB",
+		Coordinates: &location.Coordinates{Latitude: 51.5142, Longitude: -0.0931},
+		Timezone:    "Europe/London",
+	}, loc)
+
+	// country level matches aren't useful for weather
+	_, found, err = r.Locate(net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	require.False(t, found)
+
+	_, found, err = r.Locate(net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestFromBytes_errors(t *testing.T) {
+	_, err := FromBytes([]byte("not a database"))
+	require.EqualError(t, err, "Invalid MaxMind DB: metadata not found")
+
+	w := newTestDBWriter(6, 20)
+	_, err = FromBytes(w.bytes())
+	require.EqualError(t, err, "Unsupported MaxMind DB record size: 20")
+
+	_, err = Open("testdata/does-not-exist.mmdb")
+	require.Error(t, err)
+}
+
+func TestDecoder_decode(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), 300))
+	w := &testDBWriter{}
+	w.encode("foo")                                                                  // offset 0, 4 bytes
+	w.data.Write([]byte{typePointer<<5 | 0, 0})                                      // offset 4, pointer to "foo"
+	w.encode(long)                                                                   // offset 6, string with a 2 byte extended size
+	w.data.Write([]byte{typePointer<<5 | 0, 4})                                      // offset 309, pointer to a pointer
+	w.data.Write([]byte{typeExtended<<5 | 4, typeInt32 - 7, 0xFF, 0xFF, 0xFF, 0xFE}) // offset 311, int32
+
+	d := decoder{buffer: w.data.Bytes()}
+	value, next, err := d.decode(4)
+	require.NoError(t, err)
+	require.Equal(t, "foo", value)
+	require.Equal(t, uint(6), next)
+
+	value, next, err = d.decode(6)
+	require.NoError(t, err)
+	require.Equal(t, long, value)
+	require.Equal(t, uint(309), next)
+
+	_, _, err = d.decode(309)
+	require.EqualError(t, err, "invalid pointer")
+
+	value, _, err = d.decode(311)
+	require.NoError(t, err)
+	require.Equal(t, int32(-2), value)
+
+	_, _, err = d.decode(uint(len(w.data.Bytes())))
+	require.EqualError(t, err, "unexpected end of data")
+}
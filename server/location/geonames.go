@@ -0,0 +1,387 @@
+package location
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoNames is an offline Geocoder backed by the GeoNames cities dataset (i.e. cities15000.txt from
+// https://download.geonames.org/export/dump/), optionally enriched with admin1 region names and postal codes
+type GeoNames struct {
+	places []place
+	nearby *kdTree             // the places, for reverse geocoding
+	names  []nameEntry         // sorted by name so that prefix searches (autocomplete) can use a binary search
+	admin1 map[string]string   // "US.IL" -> "Illinois"
+	postal map[string][]postal // normalized postal code -> entries
+}
+
+type place struct {
+	Location
+	asciiName  string
+	admin1Code string
+	population int64
+}
+
+type nameEntry struct {
+	name      string
+	place     int
+	alternate bool
+}
+
+type postal struct {
+	Location
+	admin1Code string
+}
+
+// geonames "geoname" table columns, see https://download.geonames.org/export/dump/readme.txt
+const (
+	colName           = 1
+	colASCIIName      = 2
+	colAlternateNames = 3
+	colLatitude       = 4
+	colLongitude      = 5
+	colCountryCode    = 8
+	colAdmin1Code     = 10
+	colPopulation     = 14
+	colTimezone       = 17
+	geonamesColumns   = 19
+)
+
+// postal code dataset columns, see https://download.geonames.org/export/zip/readme.txt
+const (
+	colPostalCountryCode = 0
+	colPostalCode        = 1
+	colPostalPlaceName   = 2
+	colPostalAdmin1Name  = 3
+	colPostalAdmin1Code  = 4
+	colPostalLatitude    = 9
+	colPostalLongitude   = 10
+	postalColumns        = 11
+)
+
+// LoadGeoNames loads a GeoNames cities dataset from disk
+func LoadGeoNames(citiesFilePath string) (*GeoNames, error) {
+	citiesFile, err := os.Open(citiesFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer citiesFile.Close()
+	return ReadGeoNames(citiesFile)
+}
+
+// LoadResolver loads the datasets of a Resolver, the admin1 codes and postal codes are optional. Without a cities
+// dataset, locations are passed through to the backends as typed.
+func LoadResolver(citiesFilePath string, admin1CodesFilePath string, postalCodesFilePath string) (Resolver, error) {
+	if citiesFilePath == "" {
+		return Resolver{Geocoder: Passthrough{}}, nil
+	}
+	g, err := LoadGeoNames(citiesFilePath)
+	if err != nil {
+		return Resolver{}, err
+	}
+	if admin1CodesFilePath != "" {
+		if err := g.LoadAdmin1Codes(admin1CodesFilePath); err != nil {
+			return Resolver{}, err
+		}
+	}
+	if postalCodesFilePath != "" {
+		if err := g.LoadPostalCodes(postalCodesFilePath); err != nil {
+			return Resolver{}, err
+		}
+	}
+	return Resolver{Geocoder: g}, nil
+}
+
+// ReadGeoNames reads a GeoNames cities dataset
+func ReadGeoNames(r io.Reader) (*GeoNames, error) {
+	g := &GeoNames{
+		admin1: map[string]string{},
+		postal: map[string][]postal{},
+	}
+	err := readTSV(r, geonamesColumns, func(fields []string) error {
+		lat, err := strconv.ParseFloat(fields[colLatitude], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(fields[colLongitude], 64)
+		if err != nil {
+			return err
+		}
+		population, _ := strconv.ParseInt(fields[colPopulation], 10, 64)
+
+		p := place{
+			Location: Location{
+				Name:        fields[colName],
+				Country:     fields[colCountryCode],
+				Coordinates: &Coordinates{Latitude: lat, Longitude: lon},
+				Timezone:    fields[colTimezone],
+			},
+			asciiName:  fields[colASCIIName],
+			admin1Code: fields[colAdmin1Code],
+			population: population,
+		}
+		idx := len(g.places)
+		g.places = append(g.places, p)
+
+		seen := map[string]bool{}
+		for i, name := range append([]string{p.Name, p.asciiName}, strings.Split(fields[colAlternateNames], ",")...) {
+			name = normalize(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			g.names = append(g.names, nameEntry{name: name, place: idx, alternate: i > 1})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(g.names, func(i, j int) bool { return g.names[i].name < g.names[j].name })
+	coordinates := make([]Coordinates, len(g.places))
+	for i, p := range g.places {
+		coordinates[i] = *p.Coordinates
+	}
+	g.nearby = newKDTree(coordinates)
+	g.fillAdminRegions()
+	return g, nil
+}
+
+// LoadAdmin1Codes loads the GeoNames admin1CodesASCII.txt file from disk, so that regions can be displayed and matched by name
+func (g *GeoNames) LoadAdmin1Codes(admin1FilePath string) error {
+	admin1File, err := os.Open(admin1FilePath)
+	if err != nil {
+		return err
+	}
+	defer admin1File.Close()
+	return g.ReadAdmin1Codes(admin1File)
+}
+
+// ReadAdmin1Codes reads the GeoNames admin1CodesASCII.txt format ("US.IL<tab>Illinois<tab>...")
+func (g *GeoNames) ReadAdmin1Codes(r io.Reader) error {
+	err := readTSV(r, 2, func(fields []string) error {
+		g.admin1[fields[0]] = fields[1]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.fillAdminRegions()
+	return nil
+}
+
+// LoadPostalCodes loads a GeoNames postal code dataset (i.e. allCountries.txt from https://download.geonames.org/export/zip/) from disk
+func (g *GeoNames) LoadPostalCodes(postalFilePath string) error {
+	postalFile, err := os.Open(postalFilePath)
+	if err != nil {
+		return err
+	}
+	defer postalFile.Close()
+	return g.ReadPostalCodes(postalFile)
+}
+
+// ReadPostalCodes reads a GeoNames postal code dataset
+func (g *GeoNames) ReadPostalCodes(r io.Reader) error {
+	return readTSV(r, postalColumns, func(fields []string) error {
+		lat, err := strconv.ParseFloat(fields[colPostalLatitude], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(fields[colPostalLongitude], 64)
+		if err != nil {
+			return err
+		}
+		p := postal{
+			Location: Location{
+				Name:        fields[colPostalPlaceName],
+				AdminRegion: fields[colPostalAdmin1Name],
+				Country:     fields[colPostalCountryCode],
+				PostalCode:  fields[colPostalCode],
+				Coordinates: &Coordinates{Latitude: lat, Longitude: lon},
+			},
+			admin1Code: fields[colPostalAdmin1Code],
+		}
+		if nearest, found := g.Nearest(*p.Coordinates); found {
+			p.Timezone = nearest.Timezone
+		}
+		code := normalizePostalCode(p.PostalCode)
+		g.postal[code] = append(g.postal[code], p)
+		return nil
+	})
+}
+
+// Search returns the candidates matching the query, best match first
+func (g *GeoNames) Search(q Query, limit int) ([]Candidate, error) {
+	if q.Kind == KindPostalCode {
+		return g.searchPostalCode(q, limit), nil
+	}
+
+	name := normalize(q.Name)
+	scores := map[int]float64{}
+	start := sort.Search(len(g.names), func(i int) bool { return g.names[i].name >= name })
+	for i := start; i < len(g.names) && strings.HasPrefix(g.names[i].name, name); i++ {
+		entry := g.names[i]
+		score := 0.5 // prefix match, useful for autocomplete
+		if entry.name == name {
+			score = 1
+			if entry.alternate {
+				score = 0.8
+			}
+		}
+		p := g.places[entry.place]
+		qualifierScore, ok := matchQualifiers(q.Qualifiers, p.AdminRegion, p.admin1Code, p.Country)
+		if !ok {
+			continue
+		}
+		score += qualifierScore
+		if score > scores[entry.place] {
+			scores[entry.place] = score
+		}
+	}
+
+	candidates := []Candidate{}
+	for idx, score := range scores {
+		p := g.places[idx]
+		candidates = append(candidates, Candidate{Location: p.Location, Population: p.population, Score: score})
+	}
+	sortCandidates(candidates)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+func (g *GeoNames) searchPostalCode(q Query, limit int) []Candidate {
+	candidates := []Candidate{}
+	for _, p := range g.postal[normalizePostalCode(q.PostalCode)] {
+		qualifierScore, ok := matchQualifiers(q.Qualifiers, p.AdminRegion, p.admin1Code, p.Country)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, Candidate{Location: p.Location, Score: 1 + qualifierScore})
+	}
+	sortCandidates(candidates)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// Nearest returns the known place closest to the provided coordinates
+func (g *GeoNames) Nearest(c Coordinates) (Location, bool) {
+	best := g.nearby.nearest(c)
+	if best < 0 {
+		return Location{}, false
+	}
+	return g.places[best].Location, true
+}
+
+func (g *GeoNames) fillAdminRegions() {
+	for i, p := range g.places {
+		region, ok := g.admin1[p.Country+"."+p.admin1Code]
+		if !ok {
+			// fall back to the raw code (for the US this is the state abbreviation, which is what people expect anyways)
+			region = p.admin1Code
+		}
+		g.places[i].AdminRegion = region
+	}
+}
+
+// matchQualifiers checks the "admin region" and "country" parts of a query. When only one qualifier is given
+// it may be either. Every provided qualifier must match for the place to be a candidate.
+func matchQualifiers(qualifiers []string, adminRegion, admin1Code, country string) (float64, bool) {
+	matchesAdmin := func(s string) bool {
+		return strings.EqualFold(s, adminRegion) || strings.EqualFold(s, admin1Code)
+	}
+	matchesCountry := func(s string) bool {
+		return strings.EqualFold(s, country)
+	}
+	switch len(qualifiers) {
+	case 0:
+		return 0, true
+	case 1:
+		if matchesAdmin(qualifiers[0]) || matchesCountry(qualifiers[0]) {
+			return 0.5, true
+		}
+		return 0, false
+	default:
+		if matchesAdmin(qualifiers[0]) && matchesCountry(qualifiers[1]) {
+			return 1, true
+		}
+		return 0, false
+	}
+}
+
+func sortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		// bigger places are more likely to be what was meant when everything else is equal
+		if candidates[i].Population != candidates[j].Population {
+			return candidates[i].Population > candidates[j].Population
+		}
+		return candidates[i].Location.String() < candidates[j].Location.String()
+	})
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func normalizePostalCode(s string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(s), " ", "", -1))
+}
+
+// readTSV calls fn for every non-empty, non-comment line of a tab separated file with at least minColumns columns
+func readTSV(r io.Reader, minColumns int, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // alternate names can make for very long lines
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) < minColumns {
+			return fmt.Errorf("line %d: expected at least %d columns, found %d", line, minColumns, len(fields))
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// DistanceKm returns the great-circle distance between two points
+func DistanceKm(a, b Coordinates) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLon := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// FarthestPair returns the indexes of the two points that are furthest apart, along with the distance between them.
+// Fewer than two points are never apart.
+func FarthestPair(points []Coordinates) (int, int, float64) {
+	bestI, bestJ, bestDistance := 0, 0, 0.0
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			if d := DistanceKm(points[i], points[j]); d > bestDistance {
+				bestI, bestJ, bestDistance = i, j, d
+			}
+		}
+	}
+	return bestI, bestJ, bestDistance
+}
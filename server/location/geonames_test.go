@@ -0,0 +1,186 @@
+package location
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestGeoNames(t *testing.T) *GeoNames {
+	g, err := LoadGeoNames("testdata/cities.txt")
+	require.NoError(t, err)
+	require.NoError(t, g.LoadAdmin1Codes("testdata/admin1CodesASCII.txt"))
+	require.NoError(t, g.LoadPostalCodes("testdata/postalCodes.txt"))
+	return g
+}
+
+func TestLoadResolver(t *testing.T) {
+	r, err := LoadResolver("", "", "")
+	require.NoError(t, err)
+	require.Equal(t, Resolver{Geocoder: Passthrough{}}, r)
+
+	r, err = LoadResolver("testdata/cities.txt", "testdata/admin1CodesASCII.txt", "testdata/postalCodes.txt")
+	require.NoError(t, err)
+	require.Equal(t, loadTestGeoNames(t), r.Geocoder)
+
+	_, err = LoadResolver("testdata/cities.txt", "testdata/does-not-exist.txt", "")
+	require.Error(t, err)
+}
+
+func candidateNames(candidates []Candidate) []string {
+	names := []string{}
+	for _, c := range candidates {
+		names = append(names, c.Location.String())
+	}
+	return names
+}
+
+func TestGeoNames_Search(t *testing.T) {
+	g := loadTestGeoNames(t)
+
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "ambiguous name is ranked by population",
+			text:  "Springfield",
+			limit: 10,
+			want:  []string{"Springfield, Missouri, US", "Springfield, Massachusetts, US", "Springfield, Illinois, US"},
+		},
+		{
+			name:  "admin region code and country disambiguate",
+			text:  "Springfield, IL, US",
+			limit: 10,
+			want:  []string{"Springfield, Illinois, US"},
+		},
+		{
+			name:  "admin region name disambiguates",
+			text:  "springfield, massachusetts",
+			limit: 10,
+			want:  []string{"Springfield, Massachusetts, US"},
+		},
+		{
+			name:  "country alone disambiguates",
+			text:  "London, CA",
+			limit: 10,
+			want:  []string{"London, Ontario, CA"},
+		},
+		{
+			name:  "ascii and alternate names match",
+			text:  "Montreal",
+			limit: 10,
+			want:  []string{"Montréal, Quebec, CA"},
+		},
+		{
+			name:  "exact matches are ranked above alternate names",
+			text:  "Londres",
+			limit: 10,
+			want:  []string{"London, England, GB", "London, Ontario, CA"},
+		},
+		{
+			name:  "prefix matches for autocomplete",
+			text:  "Ott",
+			limit: 10,
+			want:  []string{"Ottawa, Ontario, CA"},
+		},
+		{
+			name:  "limit is respected",
+			text:  "Springfield",
+			limit: 1,
+			want:  []string{"Springfield, Missouri, US"},
+		},
+		{
+			name:  "postal code",
+			text:  "62701",
+			limit: 10,
+			want:  []string{"Springfield, Illinois, US"},
+		},
+		{
+			name:  "postal code with wrong country is not a match",
+			text:  "62701, CA",
+			limit: 10,
+			want:  []string{},
+		},
+		{
+			name:  "unknown name",
+			text:  "Atlantis",
+			limit: 10,
+			want:  []string{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := ParseQuery(tc.text)
+			require.NoError(t, err)
+			got, err := g.Search(q, tc.limit)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, candidateNames(got))
+		})
+	}
+}
+
+func TestGeoNames_SearchCanonicalLocation(t *testing.T) {
+	g := loadTestGeoNames(t)
+
+	r := Resolver{Geocoder: g}
+	got, err := r.Resolve("Springfield, IL, US")
+	require.NoError(t, err)
+	require.Equal(t, Location{
+		Name:        "Springfield",
+		AdminRegion: "Illinois",
+		Country:     "US",
+		Coordinates: &Coordinates{Latitude: 39.80172, Longitude: -89.64371},
+		Timezone:    "America/Chicago",
+	}, got)
+
+	got, err = r.Resolve("62701")
+	require.NoError(t, err)
+	require.Equal(t, "America/Chicago", got.Timezone)
+	require.Equal(t, "62701", got.PostalCode)
+}
+
+func TestGeoNames_Nearest(t *testing.T) {
+	g := loadTestGeoNames(t)
+
+	got, found := g.Nearest(Coordinates{Latitude: 45.4, Longitude: -75.7})
+	require.True(t, found)
+	require.Equal(t, "Ottawa", got.Name)
+
+	empty, err := ReadGeoNames(strings.NewReader(""))
+	require.NoError(t, err)
+	_, found = empty.Nearest(Coordinates{Latitude: 45.4, Longitude: -75.7})
+	require.False(t, found)
+}
+
+func TestReadGeoNames_errors(t *testing.T) {
+	_, err := ReadGeoNames(strings.NewReader("1\tfoo\n"))
+	require.EqualError(t, err, "line 1: expected at least 19 columns, found 2")
+
+	_, err = LoadGeoNames("testdata/does-not-exist.txt")
+	require.Error(t, err)
+}
+
+func TestDistanceKm(t *testing.T) {
+	ottawa := Coordinates{Latitude: 45.41117, Longitude: -75.69812}
+	montreal := Coordinates{Latitude: 45.50884, Longitude: -73.58781}
+	require.InDelta(t, 165, DistanceKm(ottawa, montreal), 1)
+	require.Equal(t, float64(0), DistanceKm(ottawa, ottawa))
+}
+
+func TestFarthestPair(t *testing.T) {
+	ottawa := Coordinates{Latitude: 45.41117, Longitude: -75.69812}
+	montreal := Coordinates{Latitude: 45.50884, Longitude: -73.58781}
+	londonGB := Coordinates{Latitude: 51.50853, Longitude: -0.12574}
+
+	i, j, d := FarthestPair([]Coordinates{ottawa, montreal, londonGB})
+	require.Equal(t, 0, i)
+	require.Equal(t, 2, j)
+	require.InDelta(t, 5370, d, 10)
+
+	_, _, d = FarthestPair([]Coordinates{ottawa})
+	require.Equal(t, float64(0), d)
+}
@@ -0,0 +1,82 @@
+package location
+
+import (
+	"math"
+	"sort"
+)
+
+// kdTree indexes points of the globe for nearest neighbour lookups. Points are stored as unit vectors, since the
+// straight line distance between two of them grows with the great-circle distance, so the closest vector is the closest
+// point. The tree is implicit: each range of nodes has its median on the splitting axis at its middle.
+type kdTree struct {
+	nodes []kdNode
+}
+
+type kdNode struct {
+	point [3]float64
+	index int // of the point in the slice the tree was built from
+}
+
+// newKDTree indexes the provided points
+func newKDTree(points []Coordinates) *kdTree {
+	t := &kdTree{nodes: make([]kdNode, len(points))}
+	for i, c := range points {
+		t.nodes[i] = kdNode{point: unitVector(c), index: i}
+	}
+	t.build(0, len(t.nodes), 0)
+	return t
+}
+
+func (t *kdTree) build(lo, hi, axis int) {
+	if hi-lo <= 1 {
+		return
+	}
+	nodes := t.nodes[lo:hi]
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].point[axis] < nodes[j].point[axis] })
+	mid := (lo + hi) / 2
+	t.build(lo, mid, (axis+1)%3)
+	t.build(mid+1, hi, (axis+1)%3)
+}
+
+// nearest returns the index of the point closest to c, or -1 when the tree is empty
+func (t *kdTree) nearest(c Coordinates) int {
+	best, bestDistance := -1, math.MaxFloat64
+	t.search(unitVector(c), 0, len(t.nodes), 0, &best, &bestDistance)
+	return best
+}
+
+func (t *kdTree) search(q [3]float64, lo, hi, axis int, best *int, bestDistance *float64) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	node := t.nodes[mid]
+	if d := squaredDistance(q, node.point); d < *bestDistance || (d == *bestDistance && node.index < *best) {
+		*best, *bestDistance = node.index, d
+	}
+
+	next := (axis + 1) % 3
+	diff := q[axis] - node.point[axis]
+	if diff < 0 {
+		t.search(q, lo, mid, next, best, bestDistance)
+		if diff*diff <= *bestDistance {
+			t.search(q, mid+1, hi, next, best, bestDistance)
+		}
+	} else {
+		t.search(q, mid+1, hi, next, best, bestDistance)
+		if diff*diff <= *bestDistance {
+			t.search(q, lo, mid, next, best, bestDistance)
+		}
+	}
+}
+
+func unitVector(c Coordinates) [3]float64 {
+	lat := c.Latitude * math.Pi / 180
+	lon := c.Longitude * math.Pi / 180
+	return [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
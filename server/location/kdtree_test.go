@@ -0,0 +1,33 @@
+package location
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKDTree_nearest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomCoordinates := func() Coordinates {
+		return Coordinates{Latitude: random.Float64()*180 - 90, Longitude: random.Float64()*360 - 180}
+	}
+	points := make([]Coordinates, 2000)
+	for i := range points {
+		points[i] = randomCoordinates()
+	}
+	tree := newKDTree(points)
+
+	for i := 0; i < 500; i++ {
+		c := randomCoordinates()
+		want, wantDistance := -1, 0.0
+		for j, p := range points {
+			if d := DistanceKm(c, p); want < 0 || d < wantDistance {
+				want, wantDistance = j, d
+			}
+		}
+		require.Equal(t, want, tree.nearest(c), "nearest point to %v", c)
+	}
+
+	require.Equal(t, -1, newKDTree(nil).nearest(Coordinates{}))
+}
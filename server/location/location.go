@@ -0,0 +1,241 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when a query could not be resolved to any location
+var ErrNotFound = errors.New("Unable to resolve location")
+
+// Coordinates defines a point on the globe in decimal degrees
+type Coordinates struct {
+	Latitude  float64 `json:"latitude" xml:"latitude"`
+	Longitude float64 `json:"longitude" xml:"longitude"`
+}
+
+// String formats the coordinates as "lat,lon", the way ParseQuery reads them, without exponents
+func (c Coordinates) String() string {
+	return strconv.FormatFloat(c.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(c.Longitude, 'f', -1, 64)
+}
+
+// Location defines a canonical, resolved location
+type Location struct {
+	Name        string       `json:"name" xml:"name"`
+	AdminRegion string       `json:"admin_region,omitempty" xml:"admin_region,omitempty"`
+	Country     string       `json:"country,omitempty" xml:"country,omitempty"`
+	PostalCode  string       `json:"postal_code,omitempty" xml:"postal_code,omitempty"`
+	Coordinates *Coordinates `json:"coordinates,omitempty" xml:"coordinates,omitempty"`
+	Timezone    string       `json:"timezone,omitempty" xml:"timezone,omitempty"`
+}
+
+// String formats the location the same way a user would type it, i.e. "Springfield, IL, US"
+func (l Location) String() string {
+	parts := []string{}
+	for _, part := range []string{l.Name, l.AdminRegion, l.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 && l.Coordinates != nil {
+		return l.Coordinates.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Key identifies the location, i.e. for caching. Coordinates are rounded to about a kilometer so that nearby "lat,lon"
+// lookups are treated as the same place.
+func (l Location) Key() string {
+	key := strings.Join([]string{l.Name, l.AdminRegion, l.Country, l.PostalCode}, "|")
+	if l.Coordinates != nil {
+		key += fmt.Sprintf("|%.2f,%.2f", l.Coordinates.Latitude, l.Coordinates.Longitude)
+	}
+	return key
+}
+
+// Candidate defines a possible match for a query, along with how well it matched (higher is better)
+type Candidate struct {
+	Location   Location `json:"location"`
+	Population int64    `json:"population,omitempty"`
+	Score      float64  `json:"score"`
+}
+
+// QueryKind describes what kind of free text a query was parsed as
+type QueryKind int
+
+const (
+	// KindName is a place name optionally followed by an admin region and/or country ("Springfield, IL, US")
+	KindName QueryKind = iota
+	// KindPostalCode is a postal code optionally followed by a country ("90210, US")
+	KindPostalCode
+	// KindCoordinates is a "lat,lon" pair
+	KindCoordinates
+)
+
+// Query defines free text that has been split into its components
+type Query struct {
+	Kind        QueryKind
+	Text        string
+	Name        string
+	Qualifiers  []string // admin region and/or country, in the order they were provided
+	PostalCode  string
+	Coordinates Coordinates
+}
+
+var coordinatesRegexp = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)\s*,\s*(-?\d+(?:\.\d+)?)\s*$`)
+
+// postalCodeRegexps match the common postal code shapes; anything else is treated as a place name
+var postalCodeRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`^\d{3,10}(?:[ -]\d{2,4})?$`),                       // numeric, i.e. US "90210" or "90210-1234", JP "100-0001"
+	regexp.MustCompile(`^\d{4} ?[A-Za-z]{2}$`),                             // NL "1012 AB"
+	regexp.MustCompile(`^[A-Za-z]\d[A-Za-z](?: ?\d[A-Za-z]\d)?$`),          // CA "K1A 0B1" or just the "K1A" forward sortation area
+	regexp.MustCompile(`^[A-Za-z]{1,2}\d[A-Za-z\d]?(?: ?\d[A-Za-z]{2})?$`), // UK "SW1A 1AA" or just the "SW1A" outward code
+}
+
+// ParseQuery splits free text into a Query
+func ParseQuery(text string) (Query, error) {
+	q := Query{Text: strings.TrimSpace(text)}
+	if q.Text == "" {
+		return q, errors.New("No location specified")
+	}
+
+	if m := coordinatesRegexp.FindStringSubmatch(q.Text); m != nil {
+		lat, _ := strconv.ParseFloat(m[1], 64)
+		lon, _ := strconv.ParseFloat(m[2], 64)
+		if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return q, errors.New("Coordinates are out of range: " + q.Text)
+		}
+		q.Kind = KindCoordinates
+		q.Coordinates = Coordinates{Latitude: lat, Longitude: lon}
+		return q, nil
+	}
+
+	parts := []string{}
+	for _, part := range strings.Split(q.Text, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return q, errors.New("No location specified")
+	}
+	q.Qualifiers = parts[1:]
+
+	if isPostalCode(parts[0]) {
+		q.Kind = KindPostalCode
+		q.PostalCode = parts[0]
+		return q, nil
+	}
+
+	q.Kind = KindName
+	q.Name = parts[0]
+	return q, nil
+}
+
+func isPostalCode(s string) bool {
+	for _, re := range postalCodeRegexps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Geocoder describes the interface for looking up candidate locations for a query
+type Geocoder interface {
+	Search(q Query, limit int) ([]Candidate, error)
+}
+
+// ReverseGeocoder describes the interface for finding the closest known location to a set of coordinates
+type ReverseGeocoder interface {
+	Nearest(c Coordinates) (Location, bool)
+}
+
+// Resolver turns free text into canonical locations using the configured Geocoder
+type Resolver struct {
+	Geocoder Geocoder
+}
+
+// DefaultSearchLimit is the number of candidates returned when no limit is specified, and MaxSearchLimit the most that
+// are returned whatever the limit
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 50
+)
+
+// Search returns the ranked candidates for the provided free text
+func (r Resolver) Search(text string, limit int) ([]Candidate, error) {
+	q, err := ParseQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	if q.Kind == KindCoordinates {
+		loc := Location{Coordinates: &Coordinates{Latitude: q.Coordinates.Latitude, Longitude: q.Coordinates.Longitude}}
+		if rg, ok := r.Geocoder.(ReverseGeocoder); ok {
+			if nearest, found := rg.Nearest(q.Coordinates); found {
+				// keep the exact coordinates that were asked for, but borrow the name and timezone of the closest place
+				nearest.Coordinates = loc.Coordinates
+				loc = nearest
+			}
+		}
+		return []Candidate{{Location: loc, Score: 1}}, nil
+	}
+
+	candidates, err := r.Geocoder.Search(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+// Resolve returns the best matching location for the provided free text. Places the dataset doesn't know are passed
+// through to the backends, which may well know them, so it only fails for text that isn't a location at all.
+func (r Resolver) Resolve(text string) (Location, error) {
+	candidates, err := r.Search(text, 1)
+	if err == ErrNotFound {
+		candidates, err = Resolver{Geocoder: Passthrough{}}.Search(text, 1)
+	}
+	if err != nil {
+		return Location{}, err
+	}
+	return candidates[0].Location, nil
+}
+
+// Passthrough is a Geocoder that does no lookups at all; it returns the query as-is, leaving resolution to the backends.
+// It is used when no location dataset is configured.
+type Passthrough struct{}
+
+// Search returns a single candidate built from the query. Qualifiers are only split off when the last one is a country
+// code, and a lone qualifier that could be a state or province is left alone too: without a dataset, there is no telling
+// whether "Paris, TX" is in Texas or in a country, so the backends get the text as typed.
+func (Passthrough) Search(q Query, limit int) ([]Candidate, error) {
+	loc := Location{Name: q.Name, PostalCode: q.PostalCode}
+	switch {
+	case len(q.Qualifiers) == 0:
+	case len(q.Qualifiers) == 1 && isCountryCode(q.Qualifiers[0]) && !subdivisionCodes[strings.ToUpper(q.Qualifiers[0])]:
+		loc.Country = q.Qualifiers[0]
+	case len(q.Qualifiers) == 2 && isCountryCode(q.Qualifiers[1]):
+		loc.AdminRegion = q.Qualifiers[0]
+		loc.Country = q.Qualifiers[1]
+	default:
+		loc = Location{Name: q.Text}
+	}
+	return []Candidate{{Location: loc, Score: 1}}, nil
+}
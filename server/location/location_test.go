@@ -0,0 +1,209 @@
+package location
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		want        Query
+		expectedErr error
+	}{
+		{
+			name:        "empty query returns error",
+			text:        "  ",
+			want:        Query{},
+			expectedErr: errors.New("No location specified"),
+		},
+		{
+			name: "city only",
+			text: "Springfield",
+			want: Query{Kind: KindName, Text: "Springfield", Name: "Springfield", Qualifiers: []string{}},
+		},
+		{
+			name: "city, admin region and country",
+			text: " Springfield, IL, US ",
+			want: Query{Kind: KindName, Text: "Springfield, IL, US", Name: "Springfield", Qualifiers: []string{"IL", "US"}},
+		},
+		{
+			name: "postal code with country",
+			text: "62701, US",
+			want: Query{Kind: KindPostalCode, Text: "62701, US", PostalCode: "62701", Qualifiers: []string{"US"}},
+		},
+		{
+			name: "uk postal code",
+			text: "SW1A 1AA",
+			want: Query{Kind: KindPostalCode, Text: "SW1A 1AA", PostalCode: "SW1A 1AA", Qualifiers: []string{}},
+		},
+		{
+			name: "names containing digits are not postal codes",
+			text: "Area 51",
+			want: Query{Kind: KindName, Text: "Area 51", Name: "Area 51", Qualifiers: []string{}},
+		},
+		{
+			name: "coordinates",
+			text: "45.42,-75.69",
+			want: Query{Kind: KindCoordinates, Text: "45.42,-75.69", Coordinates: Coordinates{Latitude: 45.42, Longitude: -75.69}},
+		},
+		{
+			name:        "coordinates out of range return error",
+			text:        "95,10",
+			want:        Query{Text: "95,10"},
+			expectedErr: errors.New("Coordinates are out of range: 95,10"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseQuery(tc.text)
+			require.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr == nil {
+				require.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+type mockGeocoder struct {
+	candidates []Candidate
+	nearest    *Location
+}
+
+func (m mockGeocoder) Search(q Query, limit int) ([]Candidate, error) {
+	return m.candidates, nil
+}
+
+func (m mockGeocoder) Nearest(c Coordinates) (Location, bool) {
+	if m.nearest == nil {
+		return Location{}, false
+	}
+	return *m.nearest, true
+}
+
+func TestResolver_Search(t *testing.T) {
+	tests := []struct {
+		name        string
+		geocoder    Geocoder
+		text        string
+		limit       int
+		want        []Candidate
+		expectedErr error
+	}{
+		{
+			name:        "no candidates returns ErrNotFound",
+			geocoder:    mockGeocoder{},
+			text:        "nowhere",
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:     "limit is capped",
+			geocoder: mockGeocoder{candidates: make([]Candidate, MaxSearchLimit+1)},
+			text:     "foo",
+			limit:    MaxSearchLimit + 1,
+			want:     make([]Candidate, MaxSearchLimit),
+		},
+		{
+			name: "candidates are limited",
+			geocoder: mockGeocoder{candidates: []Candidate{
+				{Location: Location{Name: "foo"}, Score: 2},
+				{Location: Location{Name: "bar"}, Score: 1},
+			}},
+			text:  "foo",
+			limit: 1,
+			want:  []Candidate{{Location: Location{Name: "foo"}, Score: 2}},
+		},
+		{
+			name:     "coordinates without a reverse geocoder are returned as-is",
+			geocoder: Passthrough{},
+			text:     "45.5,-73.5",
+			want:     []Candidate{{Location: Location{Coordinates: &Coordinates{Latitude: 45.5, Longitude: -73.5}}, Score: 1}},
+		},
+		{
+			name:     "coordinates keep their exact position but borrow the nearest place's details",
+			geocoder: mockGeocoder{nearest: &Location{Name: "Montréal", Country: "CA", Timezone: "America/Toronto", Coordinates: &Coordinates{Latitude: 45.50884, Longitude: -73.58781}}},
+			text:     "45.5,-73.5",
+			want:     []Candidate{{Location: Location{Name: "Montréal", Country: "CA", Timezone: "America/Toronto", Coordinates: &Coordinates{Latitude: 45.5, Longitude: -73.5}}, Score: 1}},
+		},
+		{
+			name:     "passthrough splits qualifiers into admin region and country",
+			geocoder: Passthrough{},
+			text:     "Springfield, IL, US",
+			want:     []Candidate{{Location: Location{Name: "Springfield", AdminRegion: "IL", Country: "US"}, Score: 1}},
+		},
+		{
+			name:     "passthrough splits off a country code",
+			geocoder: Passthrough{},
+			text:     "London, GB",
+			want:     []Candidate{{Location: Location{Name: "London", Country: "GB"}, Score: 1}},
+		},
+		{
+			name:     "passthrough keeps a lone state as typed",
+			geocoder: Passthrough{},
+			text:     "Paris, TX",
+			want:     []Candidate{{Location: Location{Name: "Paris, TX"}, Score: 1}},
+		},
+		{
+			name:     "passthrough keeps a lone state that is also a country code as typed",
+			geocoder: Passthrough{},
+			text:     "Springfield, IL",
+			want:     []Candidate{{Location: Location{Name: "Springfield, IL"}, Score: 1}},
+		},
+		{
+			name:     "passthrough keeps qualifiers that don't end with a country code as typed",
+			geocoder: Passthrough{},
+			text:     "Springfield, Illinois, United States",
+			want:     []Candidate{{Location: Location{Name: "Springfield, Illinois, United States"}, Score: 1}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Resolver{Geocoder: tc.geocoder}
+			got, err := r.Search(tc.text, tc.limit)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	r := Resolver{Geocoder: mockGeocoder{candidates: []Candidate{{Location: Location{Name: "London", Country: "CA"}, Score: 1}}}}
+	got, err := r.Resolve("London")
+	require.NoError(t, err)
+	require.Equal(t, Location{Name: "London", Country: "CA"}, got)
+
+	// places the dataset doesn't know are passed through to the backends
+	r = Resolver{Geocoder: mockGeocoder{}}
+	got, err = r.Resolve("Springfield, IL, US")
+	require.NoError(t, err)
+	require.Equal(t, Location{Name: "Springfield", AdminRegion: "IL", Country: "US"}, got)
+	_, err = r.Resolve("   ")
+	require.Error(t, err)
+}
+
+func TestLocation_String(t *testing.T) {
+	require.Equal(t, "Springfield, IL, US", Location{Name: "Springfield", AdminRegion: "IL", Country: "US"}.String())
+	require.Equal(t, "London, GB", Location{Name: "London", Country: "GB"}.String())
+	require.Equal(t, "1.5,-2", Location{Coordinates: &Coordinates{Latitude: 1.5, Longitude: -2}}.String())
+}
+
+func TestCoordinates_String(t *testing.T) {
+	c := Coordinates{Latitude: 0.00001, Longitude: 10}
+	require.Equal(t, "0.00001,10", c.String())
+
+	q, err := ParseQuery(c.String())
+	require.NoError(t, err)
+	require.Equal(t, KindCoordinates, q.Kind)
+	require.Equal(t, c, q.Coordinates)
+}
+
+func TestLocation_Key(t *testing.T) {
+	require.Equal(t, "Springfield|IL|US|62701", Location{Name: "Springfield", AdminRegion: "IL", Country: "US", PostalCode: "62701"}.Key())
+	require.Equal(t,
+		Location{Coordinates: &Coordinates{Latitude: 45.5012, Longitude: -73.5671}}.Key(),
+		Location{Coordinates: &Coordinates{Latitude: 45.4988, Longitude: -73.5704}}.Key(),
+		"coordinates a few hundred meters apart share a key")
+}
@@ -2,221 +2,176 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"io/ioutil"
-	"net/http"
+	"flag"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
-	"go-weather-app/server/backends/accuweather"
-	"go-weather-app/server/backends/openweathermap"
-	"go-weather-app/server/metrics"
-	"go-weather-app/server/types"
+	"go-weather-app/server/server"
 
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-
-	//metrics
+	"github.com/labstack/gommon/log"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// WeatherResponse defines a json response for multiple weather responses (i.e. from multiple backends)
-type WeatherResponse struct {
-	City  string          `json:"city"`
-	Data  []types.Weather `json:"data"`
-	Error string          `json:"error"` // this is used as a response whenever a bad request comes in
-}
-
-// BackendResponse defines a json response for the configured/known backends
-type BackendResponse struct {
-	Backends []string `json:"backends"`
-}
-
-// DefaultBackends defines the default backends to pull weather data from, when none are specified explicitly
-var DefaultBackends = []string{}
-
-// ConfiguredBackends is the map of known backend configuration interfaces
-var ConfiguredBackends map[string]types.WeatherBackend
-
-// requestMetricsMiddleware is used to gather metrics on every incoming request
-func requestMetricsMiddleware() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			metrics.HTTPRequestsTotal.With(prometheus.Labels{"path": c.Path(), "method": c.Request().Method}).Inc()
-			return next(c)
+// The configuration is layered, from lowest to highest precedence: the defaults, the configuration file, the
+// environment variables (see server.LoadConfig) and the flags
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s validate-config [-config file]\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	configFile := flag.String("config", "", "the configuration file, json, yaml or toml (defaults to the first of "+strings.Join(server.DefaultConfigFiles, ", ")+" in the working directory)")
+	address := flag.String("address", server.DefaultAddress, "where the REST API is served")
+	allowedOrigins := flag.String("cors-origins", strings.Join(server.DefaultAllowedOrigins, ","), "the browser origins allowed to call the API, comma separated")
+	backendTimeout := flag.Duration("backend-timeout", server.DefaultBackendTimeout, "how long a call to a backend can take")
+	readTimeout := flag.Duration("read-timeout", 0, "how long reading a request can take, 0 for unlimited")
+	shutdownTimeout := flag.Duration("shutdown-timeout", server.DefaultShutdownTimeout, "how long open requests are given to finish when the server is stopped")
+	logLevel := flag.String("log-level", "info", "debug, info, warn, error or off")
+	watchInterval := flag.Duration("watch-interval", server.DefaultConfigWatchInterval, "how often the configuration file is checked for changes to reload the backends, 0 to only reload them on SIGHUP")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, without its secrets, and exit")
+	flag.Parse()
+
+	logger := log.New("echo")
+	if *configFile == "" {
+		*configFile = server.FindConfigFile()
+	}
+	// only the flags that are given override the configuration, the same way whenever it is reloaded
+	loadConfig := func() (*server.Config, error) {
+		config, err := server.LoadConfig(*configFile, os.Environ())
+		if err != nil {
+			return nil, err
 		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "address":
+				config.HTTP.Address = *address
+			case "cors-origins":
+				config.HTTP.AllowedOrigins = strings.Split(*allowedOrigins, ",")
+			case "backend-timeout":
+				config.BackendTimeout = &server.Duration{Duration: *backendTimeout}
+			case "read-timeout":
+				config.HTTP.ReadTimeout = &server.Duration{Duration: *readTimeout}
+			case "shutdown-timeout":
+				config.HTTP.ShutdownTimeout = &server.Duration{Duration: *shutdownTimeout}
+			case "log-level":
+				config.Log.Level = *logLevel
+			}
+		})
+		return config, nil
+	}
+	config, err := loadConfig()
+	if err != nil {
+		logger.Fatal(err)
 	}
-}
-
-func main() {
-	// Echo instance
-	e := echo.New()
 
-	// Middleware
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.RequestID())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"http://localhost", "http://localhost:3000"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-	}))
-	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
-
-	v1Api := e.Group("/v1")
-	v1Api.Use(requestMetricsMiddleware()) // only track metrics on requests under /v1 (i.e. don't track /metrics)
+	if *printConfig {
+		b, err := server.RedactConfig(config)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
 
-	err := configureServer(e.Logger)
+	s, err := server.New(
+		server.WithConfig(config),
+		server.WithConfigLoader(loadConfig),
+		server.WithLogger(logger),
+		server.WithRegistry(prometheus.DefaultRegisterer, prometheus.DefaultGatherer),
+	)
 	if err != nil {
-		e.Logger.Fatal(err)
+		logger.Fatal(err)
 	}
 
-	v1Api.GET("/weather/:city", getWeather)
-	v1Api.OPTIONS("/weather", optionsWeather)
-	v1Api.GET("/backends", getBackends)
-
 	// Start server
+	httpServer := s.HTTPServer()
 	go func() {
-		if err := e.Start(":8080"); err != nil {
-			e.Logger.Info("shutting down the server")
+		logger.Info("http server started on ", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != nil {
+			logger.Info("shutting down the server")
 		}
 	}()
 
+	grpcServer := s.GRPCServer()
+	go func() {
+		listener, err := net.Listen("tcp", s.GRPCAddress())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Info("gRPC server started on ", s.GRPCAddress())
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Info("shutting down the gRPC server")
+		}
+	}()
+
+	// The backends are reloaded on SIGHUP and whenever the configuration file changes, the other settings need a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			s.Reload(loadConfig)
+		}
+	}()
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	if *configFile != "" && *watchInterval > 0 {
+		go server.WatchConfigFile(*configFile, *watchInterval, stopWatching, func() { s.Reload(loadConfig) })
+	}
+
 	/* Wait for interrupt signal to gracefully shutdown the server with
-	a timeout of 10 seconds. */
-	quit := make(chan os.Signal)
+	the shutdown timeout. */
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout())
 	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
-	}
-}
-
-// Config defines the server configurations
-type Config struct {
-	Backends Backends `json:"backends"`
-}
-
-// Backends defines the structure used to configure various weather backends for the server
-type Backends struct {
-	openweathermap.Openweathermap `json:"openweathermap"`
-	accuweather.Accuweather       `json:"accuweather"`
-}
-
-func loadConfigFile(configFilePath string) (*Config, error) {
-	configFile, err := os.Open(configFilePath)
-	defer configFile.Close()
-	if err != nil {
-		return nil, err
-	}
-	byteValue, err := ioutil.ReadAll(configFile)
-	if err != nil {
-		return nil, err
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Fatal(err)
 	}
-	config := &Config{}
-	err = json.Unmarshal(byteValue, &config)
-	if err != nil {
-		return nil, err
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcServer.Stop() // streams never finish on their own
 	}
-	return config, nil
+	s.Close()
 }
 
-func configureBackends(config *Config, logger echo.Logger) error {
-	ConfiguredBackends = map[string]types.WeatherBackend{} // init the map
-
-	if config.Backends.Accuweather.APIKey != "" {
-		config.Backends.Accuweather.Logger = logger
-		ConfiguredBackends[types.ACCUWEATHER] = config.Backends.Accuweather
-	}
-	if config.Backends.Openweathermap.APIKey != "" {
-		config.Backends.Openweathermap.Logger = logger
-		ConfiguredBackends[types.OPENWEATHERMAP] = config.Backends.Openweathermap
+// validateConfig reports every problem with the configuration file and the environment variables, without starting
+// the server, and returns the exit code: 0 when there are none, 1 when there are, 2 for invalid arguments
+func validateConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "the configuration file, json, yaml or toml (defaults to the first of "+strings.Join(server.DefaultConfigFiles, ", ")+" in the working directory)")
+	if err := fs.Parse(args); err != nil {
+		return 2
 	}
-
-	if len(ConfiguredBackends) == 0 {
-		return errors.New("No weather backends configured")
+	if *configFile == "" {
+		*configFile = server.FindConfigFile()
 	}
 
-	DefaultBackends = []string{}
-	// set our default backends to be all known backends, for cases where none is specified
-	for backend := range ConfiguredBackends {
-		DefaultBackends = append(DefaultBackends, backend)
+	problems := server.ValidateConfig(*configFile, os.Environ())
+	for _, problem := range problems {
+		fmt.Fprintln(stdout, problem)
 	}
-	return nil
-}
-
-func configureServer(logger echo.Logger) error {
-	config, err := loadConfigFile("config.json")
-	if err != nil {
-		return err
-	}
-
-	err = configureBackends(config, logger)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func validateBackends(backends []string) error {
-	for _, backend := range backends {
-		if ConfiguredBackends[backend] == nil {
-			return errors.New("Backend specified is invalid or inactive: " + backend)
-		}
+	if len(problems) > 0 {
+		return 1
 	}
-	return nil
-}
-
-func getWeather(c echo.Context) error {
-	response := &WeatherResponse{}
-
-	response.City = strings.TrimSpace(c.Param("city"))
-	if len(response.City) == 0 {
-		response.Error = "No city specified. Please provide a city query parameter."
-		return c.JSONPretty(http.StatusBadRequest, response, "  ")
-	}
-
-	backendParam := strings.TrimSpace(c.QueryParam("backend"))
-	var targetBackends []string
-
-	if len(backendParam) == 0 {
-		targetBackends = DefaultBackends
+	if *configFile == "" {
+		fmt.Fprintln(stdout, "No configuration file found, the environment variables are valid")
 	} else {
-		targetBackends = strings.Split(backendParam, ",")
-		err := validateBackends(targetBackends)
-		if err != nil {
-			response.Error = err.Error()
-			return c.JSONPretty(http.StatusBadRequest, response, "  ")
-		}
-	}
-
-	for _, backend := range targetBackends {
-		weather := ConfiguredBackends[backend].GetWeather(response.City)
-		response.Data = append(response.Data, weather)
+		fmt.Fprintln(stdout, *configFile+" is valid")
 	}
-
-	return c.JSONPretty(http.StatusOK, response, "  ")
-}
-
-var knownBackends BackendResponse
-
-func getBackends(c echo.Context) error {
-	// only compute known backends the first time to save time
-	if len(knownBackends.Backends) == 0 {
-		for backend := range ConfiguredBackends {
-			knownBackends.Backends = append(knownBackends.Backends, backend)
-		}
-	}
-	return c.JSONPretty(http.StatusOK, knownBackends, "  ")
-}
-
-func optionsWeather(c echo.Context) error {
-	c.Response().Header().Set(echo.HeaderAccept, "GET, OPTIONS")
-	return c.String(http.StatusOK, "")
+	return 0
 }
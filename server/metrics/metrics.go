@@ -1,14 +1,84 @@
+// Package metrics defines the Prometheus metrics of a server
 package metrics
 
 import (
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
+// Metrics are the metrics of a single server, every server registers its own so that several can run side by side
+type Metrics struct {
 	// HTTPRequestsTotal is used to count the number of http requests made to the server
-	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_requests_total",
-		Help: "Count of all HTTP requests",
-	}, []string{"path", "method"})
-)
\ No newline at end of file
+	HTTPRequestsTotal *prometheus.CounterVec
+
+	// CacheRequestsTotal is used to count weather cache lookups by result (hit, miss, or shared with an in-flight fetch)
+	CacheRequestsTotal *prometheus.CounterVec
+
+	// StreamSubscribers is used to track the number of clients currently subscribed to weather updates
+	StreamSubscribers prometheus.Gauge
+
+	// StreamSubscribersDroppedTotal is used to count subscribers dropped for not keeping up with weather updates
+	StreamSubscribersDroppedTotal prometheus.Counter
+
+	// ConfigReloadsTotal is used to count reloads of the configuration by result (success or failure)
+	ConfigReloadsTotal *prometheus.CounterVec
+
+	// ConfigLastReloadSuccessful is 1 when the last reload of the configuration succeeded, 0 when it failed
+	ConfigLastReloadSuccessful prometheus.Gauge
+
+	// ConfigLastReloadSuccessTimestamp is when the configuration was last reloaded successfully, in seconds since the epoch
+	ConfigLastReloadSuccessTimestamp prometheus.Gauge
+
+	// ClientRequestsTotal is used to count the requests of the clients of the API by result (allowed, limited, or
+	// unauthorized, without a client)
+	ClientRequestsTotal *prometheus.CounterVec
+}
+
+// New creates the metrics and registers them with registerer
+func New(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		HTTPRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Count of all HTTP requests",
+		}, []string{"path", "method"}),
+		CacheRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weather_cache_requests_total",
+			Help: "Count of weather cache lookups by result",
+		}, []string{"result"}),
+		StreamSubscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "weather_stream_subscribers",
+			Help: "Number of clients subscribed to weather updates",
+		}),
+		StreamSubscribersDroppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "weather_stream_subscribers_dropped_total",
+			Help: "Count of subscribers dropped for falling behind on weather updates",
+		}),
+		ConfigReloadsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weather_config_reloads_total",
+			Help: "Count of configuration reloads by result",
+		}, []string{"result"}),
+		ConfigLastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "weather_config_last_reload_successful",
+			Help: "Whether the last configuration reload succeeded",
+		}),
+		ConfigLastReloadSuccessTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "weather_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		}),
+		ClientRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "weather_client_requests_total",
+			Help: "Count of requests of the API clients by result",
+		}, []string{"client", "result"}),
+	}
+	for _, collector := range []prometheus.Collector{m.HTTPRequestsTotal, m.CacheRequestsTotal, m.StreamSubscribers, m.StreamSubscribersDroppedTotal, m.ConfigReloadsTotal, m.ConfigLastReloadSuccessful, m.ConfigLastReloadSuccessTimestamp, m.ClientRequestsTotal} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// NewUnregistered creates metrics that aren't exposed anywhere, i.e. for tests
+func NewUnregistered() *Metrics {
+	m, _ := New(prometheus.NewRegistry())
+	return m
+}
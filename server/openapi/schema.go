@@ -0,0 +1,231 @@
+// Package openapi generates OpenAPI 3 schemas from Go types, following the same rules encoding/json uses to serialize
+// them, so that the schemas can't drift from the json the handlers actually send.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// Generator turns Go types into schemas. Named struct types become components (see Schemas) that are referenced by
+// every schema using them, everything else is inlined.
+type Generator struct {
+	// Schemas are the components generated so far, by type name
+	Schemas map[string]*openapi3.SchemaRef
+
+	// Strict disallows properties that aren't fields of the struct the object was generated from, which is what the
+	// server sends but is too strict for what clients send
+	Strict bool
+
+	names map[reflect.Type]string
+}
+
+// NewGenerator creates a generator without any components
+func NewGenerator() *Generator {
+	return &Generator{
+		Schemas: map[string]*openapi3.SchemaRef{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+// SchemaRef returns the schema of the json encoding of v, which is only used for its type
+func (g *Generator) SchemaRef(v interface{}) *openapi3.SchemaRef {
+	return g.schemaRef(reflect.TypeOf(v), false)
+}
+
+// Component adds the type of v to the components even if no other schema uses it, i.e. for types that are only sent
+// over streams, and returns a reference to it
+func (g *Generator) Component(v interface{}) *openapi3.SchemaRef {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" {
+		panic("openapi: only named struct types can be components, not " + t.String())
+	}
+	return g.schemaRef(t, false)
+}
+
+// Name sets the name of the component of the type of v, for types whose own name is too vague outside of their
+// package. It must be called before the type is used.
+func (g *Generator) Name(v interface{}, name string) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := g.names[t]; ok {
+		panic("openapi: " + t.String() + " already has a name")
+	}
+	g.setName(t, name)
+}
+
+func (g *Generator) setName(t reflect.Type, name string) {
+	if g.taken(name) {
+		panic(fmt.Sprintf("openapi: %s and another type are both named %s", t, name))
+	}
+	g.names[t] = name
+}
+
+func (g *Generator) taken(name string) bool {
+	for _, n := range g.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Generator) schemaRef(t reflect.Type, nullable bool) *openapi3.SchemaRef {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return inline(openapi3.NewDateTimeSchema(), nullable)
+	case t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		// we can't tell what a custom encoding looks like, so anything goes
+		return inline(&openapi3.Schema{}, nullable)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return inline(openapi3.NewBoolSchema(), nullable)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return inline(openapi3.NewInt32Schema(), nullable)
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return inline(openapi3.NewInt64Schema(), nullable)
+	case reflect.Float32:
+		schema := openapi3.NewFloat64Schema()
+		schema.Format = "float"
+		return inline(schema, nullable)
+	case reflect.Float64:
+		schema := openapi3.NewFloat64Schema()
+		schema.Format = "double"
+		return inline(schema, nullable)
+	case reflect.String:
+		return inline(openapi3.NewStringSchema(), nullable)
+	case reflect.Interface:
+		return inline(&openapi3.Schema{}, false) // an empty schema already allows null
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return inline(openapi3.NewBytesSchema(), nullable)
+		}
+		schema := openapi3.NewArraySchema()
+		schema.Items = g.schemaRef(t.Elem(), t.Elem().Kind() == reflect.Ptr)
+		return inline(schema, nullable && t.Kind() == reflect.Slice)
+	case reflect.Map:
+		schema := openapi3.NewObjectSchema()
+		schema.AdditionalProperties = g.schemaRef(t.Elem(), t.Elem().Kind() == reflect.Ptr)
+		return inline(schema, nullable)
+	case reflect.Struct:
+		return g.structRef(t, nullable)
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// structRef returns a reference to the component of a named struct type, generating it the first time, or the inlined
+// schema of an anonymous one
+func (g *Generator) structRef(t reflect.Type, nullable bool) *openapi3.SchemaRef {
+	if t.Name() == "" {
+		return inline(g.structSchema(t), nullable)
+	}
+
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		if g.taken(name) {
+			// the first type keeps the plain name, i.e. location.Location is Location and errors.Location is
+			// ErrorsLocation
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+		g.setName(t, name)
+	}
+	if _, ok := g.Schemas[name]; !ok {
+		// the component is registered before its fields are generated, so that recursive types end up referencing it
+		ref := &openapi3.SchemaRef{Value: &openapi3.Schema{}}
+		g.Schemas[name] = ref
+		*ref.Value = *g.structSchema(t)
+	}
+
+	component := g.Schemas[name].Value
+	if nullable {
+		// siblings of $ref are ignored, so a nullable struct gets a copy of its component instead of a reference
+		schema := *component
+		schema.Nullable = true
+		return &openapi3.SchemaRef{Value: &schema}
+	}
+	return &openapi3.SchemaRef{Ref: "#/components/schemas/" + name, Value: component}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *openapi3.Schema {
+	schema := openapi3.NewObjectSchema()
+	if g.Strict {
+		schema.AdditionalPropertiesAllowed = openapi3.BoolPtr(false)
+	}
+	g.addFields(schema, t)
+	return schema
+}
+
+// addFields adds a property for every field encoding/json would encode, flattening embedded structs the same way
+func (g *Generator) addFields(schema *openapi3.Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := parseTag(tag)
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			g.addFields(schema, fieldType)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		omitEmpty := options["omitempty"]
+		nullable := false
+		switch field.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			nullable = !omitEmpty
+		}
+		schema.Properties[name] = g.schemaRef(field.Type, nullable)
+		if !omitEmpty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+func parseTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	options := map[string]bool{}
+	for _, option := range parts[1:] {
+		options[option] = true
+	}
+	return parts[0], options
+}
+
+func inline(schema *openapi3.Schema, nullable bool) *openapi3.SchemaRef {
+	schema.Nullable = nullable
+	return &openapi3.SchemaRef{Value: schema}
+}
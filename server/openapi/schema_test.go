@@ -0,0 +1,109 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCoordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type testEmbedded struct {
+	Name string `json:"name"`
+}
+
+type testResponse struct {
+	testEmbedded
+	ID        uint64            `json:"id,omitempty"`
+	Here      *testCoordinates  `json:"here,omitempty"`
+	There     *testCoordinates  `json:"there"`
+	Path      []testCoordinates `json:"path"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Extra     interface{}       `json:"extra,omitempty"`
+	Time      time.Time         `json:"time"`
+	Untagged  bool
+	Ignored   string `json:"-"`
+	unexposed string
+}
+
+func TestGenerator_SchemaRef(t *testing.T) {
+	g := NewGenerator()
+	ref := g.SchemaRef(testResponse{})
+	require.Equal(t, "#/components/schemas/testResponse", ref.Ref)
+
+	b, err := json.Marshal(g.Schemas)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"testCoordinates": {
+			"type": "object",
+			"properties": {
+				"latitude": {"type": "number", "format": "double"},
+				"longitude": {"type": "number", "format": "double"}
+			},
+			"required": ["latitude", "longitude"]
+		},
+		"testResponse": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"id": {"type": "integer", "format": "int64"},
+				"here": {"$ref": "#/components/schemas/testCoordinates"},
+				"there": {
+					"type": "object",
+					"nullable": true,
+					"properties": {
+						"latitude": {"type": "number", "format": "double"},
+						"longitude": {"type": "number", "format": "double"}
+					},
+					"required": ["latitude", "longitude"]
+				},
+				"path": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/testCoordinates"}},
+				"tags": {"type": "object", "additionalProperties": {"type": "string"}},
+				"extra": {},
+				"time": {"type": "string", "format": "date-time"},
+				"Untagged": {"type": "boolean"}
+			},
+			"required": ["name", "there", "path", "time", "Untagged"]
+		}
+	}`, string(b))
+}
+
+func TestGenerator_SchemaRefStrict(t *testing.T) {
+	g := NewGenerator()
+	g.Strict = true
+	ref := g.SchemaRef(testResponse{})
+
+	b, err := json.Marshal(testResponse{Path: []testCoordinates{{Latitude: 1, Longitude: 2}}})
+	require.NoError(t, err)
+	var value interface{}
+	require.NoError(t, json.Unmarshal(b, &value))
+	require.NoError(t, ref.Value.VisitJSON(value))
+
+	value.(map[string]interface{})["population"] = 5.0
+	require.Error(t, ref.Value.VisitJSON(value))
+}
+
+type testLocation struct{}
+
+func TestGenerator_ComponentNameCollision(t *testing.T) {
+	g := NewGenerator()
+	require.Equal(t, "#/components/schemas/testLocation", g.Component(testLocation{}).Ref)
+
+	// another type with the same name gets the name of its package as a prefix
+	type testLocation struct {
+		City string `json:"city"`
+	}
+	require.Equal(t, "#/components/schemas/OpenapitestLocation", g.Component(testLocation{}).Ref)
+}
+
+func TestGenerator_Name(t *testing.T) {
+	g := NewGenerator()
+	g.Name(testCoordinates{}, "Coordinates")
+	require.Equal(t, "#/components/schemas/Coordinates", g.SchemaRef(testCoordinates{}).Ref)
+	require.Panics(t, func() { g.Name(testEmbedded{}, "Coordinates") })
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket: it holds up to burst tokens, refilled at rate tokens per second, and every allowed call
+// takes one
+type Bucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket, now tells the current time
+func NewBucket(rate float64, burst int, now func() time.Time) *Bucket {
+	b := &Bucket{rate: rate, burst: float64(burst), now: now}
+	b.tokens = b.burst
+	b.last = b.now()
+	return b
+}
+
+// Allow takes a token from the bucket, it returns false when there are none left
+func (b *Bucket) Allow() bool {
+	allowed, _ := b.Take()
+	return allowed
+}
+
+// Take takes a token from the bucket, it returns false along with how long until there is one when there are none left
+func (b *Bucket) Take() (bool, time.Duration) {
+	return b.TakeN(1)
+}
+
+// TakeN takes n tokens from the bucket at once. It only needs one to be left: the bucket goes into debt for the others,
+// which the next calls wait for, so that calls taking more than the burst aren't refused forever.
+func (b *Bucket) TakeN(n int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return true, 0
+}
+
+// Quota allows up to limit calls per period. Periods start at the multiples of period since the zero time, so that a
+// daily quota is reset at midnight UTC.
+type Quota struct {
+	limit  int
+	period time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	start time.Time // of the current period
+	used  int       // during the current period
+}
+
+// NewQuota creates a quota, now tells the current time
+func NewQuota(limit int, period time.Duration, now func() time.Time) *Quota {
+	return &Quota{limit: limit, period: period, now: now}
+}
+
+// Take counts a call, it returns false along with how long until the next period when the limit is reached
+func (q *Quota) Take() (bool, time.Duration) {
+	return q.TakeN(1)
+}
+
+// TakeN counts n calls at once, none of them when they would go over the limit
+func (q *Quota) TakeN(n int) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if start := now.Truncate(q.period); !start.Equal(q.start) {
+		q.start, q.used = start, 0
+	}
+	if q.used+n > q.limit {
+		return false, q.start.Add(q.period).Sub(now)
+	}
+	q.used += n
+	return true, 0
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket_Allow(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBucket(2, 3, func() time.Time { return now })
+
+	// the burst is available right away
+	for i := 0; i < 3; i++ {
+		require.True(t, b.Allow(), "call %d", i)
+	}
+	require.False(t, b.Allow())
+
+	// refills at rate tokens per second
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, b.Allow())
+	require.False(t, b.Allow())
+
+	// but never holds more than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.True(t, b.Allow(), "call %d", i)
+	}
+	require.False(t, b.Allow())
+}
+
+func TestBucket_Take(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBucket(4, 1, func() time.Time { return now })
+
+	allowed, retryAfter := b.Take()
+	require.True(t, allowed)
+	require.Equal(t, time.Duration(0), retryAfter)
+
+	// the next token is a quarter of a second away
+	allowed, retryAfter = b.Take()
+	require.False(t, allowed)
+	require.Equal(t, 250*time.Millisecond, retryAfter)
+
+	now = now.Add(100 * time.Millisecond)
+	allowed, retryAfter = b.Take()
+	require.False(t, allowed)
+	require.Equal(t, 150*time.Millisecond, retryAfter)
+}
+
+func TestBucket_TakeN(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBucket(1, 2, func() time.Time { return now })
+
+	// taking more than the burst is allowed, the bucket goes into debt
+	allowed, _ := b.TakeN(5)
+	require.True(t, allowed)
+
+	// which is paid off before the next call
+	allowed, retryAfter := b.TakeN(1)
+	require.False(t, allowed)
+	require.Equal(t, 4*time.Second, retryAfter)
+
+	now = now.Add(4 * time.Second)
+	allowed, _ = b.TakeN(1)
+	require.True(t, allowed)
+	allowed, _ = b.TakeN(1)
+	require.False(t, allowed)
+}
+
+func TestQuota_Take(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuota(2, 24*time.Hour, func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := q.Take()
+		require.True(t, allowed, "call %d", i)
+	}
+
+	// the limit holds until the next period, at midnight
+	allowed, retryAfter := q.Take()
+	require.False(t, allowed)
+	require.Equal(t, 12*time.Hour, retryAfter)
+
+	now = now.Add(11 * time.Hour)
+	allowed, retryAfter = q.Take()
+	require.False(t, allowed)
+	require.Equal(t, time.Hour, retryAfter)
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		allowed, _ := q.Take()
+		require.True(t, allowed, "call %d", i)
+	}
+	allowed, _ = q.Take()
+	require.False(t, allowed)
+}
+
+func TestQuota_TakeN(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuota(5, 24*time.Hour, func() time.Time { return now })
+
+	allowed, _ := q.TakeN(3)
+	require.True(t, allowed)
+
+	// none of the calls are counted when they would go over the limit
+	allowed, retryAfter := q.TakeN(3)
+	require.False(t, allowed)
+	require.Equal(t, 12*time.Hour, retryAfter)
+
+	allowed, _ = q.TakeN(2)
+	require.True(t, allowed)
+	allowed, _ = q.TakeN(1)
+	require.False(t, allowed)
+}
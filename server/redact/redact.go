@@ -0,0 +1,145 @@
+// Package redact keeps credentials out of what the server lets out: responses, logs and metrics. Backends put their
+// API keys in the URLs they call (i.e. "...&APPID=<key>"), and the errors of http.Client quote those URLs, so any
+// error of a backend can carry its key. A Redactor replaces the secrets it is given, along with the values of the
+// query parameters that usually are credentials, wherever they appear.
+package redact
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder is what secrets are replaced with
+const Placeholder = "REDACTED"
+
+// MinSecretLength is the length under which secrets are ignored by Add, since replacing them would mangle ordinary
+// words (i.e. the "root" token of a development vault). They are still redacted from credential query parameters.
+const MinSecretLength = 6
+
+// credentialParameterRegexp matches the query parameters whose value is a credential, whatever the secret, i.e. the
+// APPID of openweathermap or the apikey of accuweather, in json too where & is escaped
+var credentialParameterRegexp = regexp.MustCompile(`(?i)((?:[?&;]|\\u0026)(?:appid|api[_-]?key|key|access[_-]?token|token|secret|password|sig|signature)=)[^&#\s"'\\]+`)
+
+// Redactor replaces secrets in text, it is safe for concurrent use
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string // longest first, so that a secret containing another one is replaced as a whole
+}
+
+// New returns a redactor of secrets, see Add
+func New(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add adds secrets to the ones that are replaced, along with their escaped form in URLs. Secrets are never removed,
+// since a rotated key is still a credential, and the ones shorter than MinSecretLength are ignored.
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	known := map[string]bool{}
+	for _, secret := range r.secrets {
+		known[secret] = true
+	}
+	for _, secret := range secrets {
+		if len(secret) < MinSecretLength {
+			continue
+		}
+		for _, form := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+			if !known[form] {
+				known[form] = true
+				r.secrets = append(r.secrets, form)
+			}
+		}
+	}
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// String returns s with its secrets replaced by Placeholder
+func (r *Redactor) String(s string) string {
+	if s == "" {
+		return s
+	}
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Placeholder, -1)
+	}
+	r.mu.RUnlock()
+	return credentialParameterRegexp.ReplaceAllString(s, "${1}"+Placeholder)
+}
+
+// Error returns err with its secrets replaced, or err itself when it doesn't have any. The URL of a *url.Error is
+// redacted in a copy, so that it is still a *url.Error.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		return &url.Error{Op: urlErr.Op, URL: r.String(urlErr.URL), Err: r.Error(urlErr.Err)}
+	}
+	message := err.Error()
+	if redacted := r.String(message); redacted != message {
+		return &redactedError{message: redacted, err: err}
+	}
+	return err
+}
+
+// redactedError is an error whose message was redacted, the original error can still be unwrapped
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the original error, secrets included
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer returns a writer redacting what it writes to w, i.e. the output of a logger. Every write is redacted on its
+// own, which suits loggers since they write an entry at a time.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &writer{redactor: r, w: w}
+}
+
+type writer struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Transport is an http.RoundTripper redacting the errors of Base, so that backends calling their APIs through it
+// don't get their keys back in errors. http.Client adds the URL of the request to the errors of its transport, so
+// the errors it returns still need to be redacted too, see Redactor.Error.
+type Transport struct {
+	Base     http.RoundTripper // defaults to http.DefaultTransport
+	Redactor *Redactor
+}
+
+// RoundTrip calls Base, redacting its error
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, t.Redactor.Error(err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_String(t *testing.T) {
+	r := New("0123456789abcdef", "s.vault+token/1", "root")
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "text without secrets is kept as it is",
+			value:    "Error communicating to backend",
+			expected: "Error communicating to backend",
+		},
+		{
+			name:     "secrets are replaced",
+			value:    "unable to reload: invalid API key 0123456789abcdef",
+			expected: "unable to reload: invalid API key REDACTED",
+		},
+		{
+			name:     "escaped secrets are replaced",
+			value:    `Get "https://vault.example.com/v1/secret?token=s.vault%2Btoken%2F1": EOF`,
+			expected: `Get "https://vault.example.com/v1/secret?token=REDACTED": EOF`,
+		},
+		{
+			name:     "credential parameters are replaced whatever their value",
+			value:    `Get "https://api.openweathermap.org/data/2.5/weather?q=Ottawa&APPID=unknown": dial tcp: i/o timeout`,
+			expected: `Get "https://api.openweathermap.org/data/2.5/weather?q=Ottawa&APPID=REDACTED": dial tcp: i/o timeout`,
+		},
+		{
+			name:     "every credential parameter is replaced",
+			value:    "/locations/v1/cities/search?apikey=abc&q=Ottawa&api_key=def",
+			expected: "/locations/v1/cities/search?apikey=REDACTED&q=Ottawa&api_key=REDACTED",
+		},
+		{
+			name:     "credential parameters are replaced in json",
+			value:    `{"error": "Get \"https://api.openweathermap.org/data/2.5/weather?q=Ottawa\u0026APPID=unknown\": EOF"}`,
+			expected: `{"error": "Get \"https://api.openweathermap.org/data/2.5/weather?q=Ottawa\u0026APPID=REDACTED\": EOF"}`,
+		},
+		{
+			name:     "short secrets are only replaced in credential parameters",
+			value:    "root cause: ?token=root",
+			expected: "root cause: ?token=REDACTED",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, r.String(tc.value))
+		})
+	}
+
+	r.Add("fedcba9876543210")
+	require.Equal(t, "REDACTED then REDACTED", r.String("0123456789abcdef then fedcba9876543210"), "added secrets are replaced along with the previous ones")
+}
+
+func TestRedactor_Error(t *testing.T) {
+	r := New("0123456789abcdef")
+
+	require.Nil(t, r.Error(nil))
+
+	err := errors.New("No API key configured for backend: openweathermap")
+	require.Equal(t, err, r.Error(err), "errors without secrets are kept as they are")
+
+	err = errors.New("invalid key 0123456789abcdef")
+	redacted := r.Error(err)
+	require.EqualError(t, redacted, "invalid key REDACTED")
+	require.Equal(t, err, redacted.(interface{ Unwrap() error }).Unwrap())
+
+	redacted = r.Error(&url.Error{Op: "Get", URL: "https://api.openweathermap.org/data/2.5/weather?q=Ottawa&APPID=0123456789abcdef", Err: errors.New("EOF")})
+	require.Equal(t, &url.Error{Op: "Get", URL: "https://api.openweathermap.org/data/2.5/weather?q=Ottawa&APPID=REDACTED", Err: errors.New("EOF")}, redacted)
+}
+
+func TestRedactor_Writer(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := New("0123456789abcdef").Writer(out)
+
+	entry := []byte(`{"level":"ERROR","message":"invalid key 0123456789abcdef"}` + "\n")
+	n, err := w.Write(entry)
+	require.NoError(t, err)
+	require.Equal(t, len(entry), n, "the length of what was given is written, not of what was written")
+	require.Equal(t, `{"level":"ERROR","message":"invalid key REDACTED"}`+"\n", out.String())
+}
+
+func TestTransport_RoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("APPID")))
+	}))
+	defer server.Close()
+	r := New("0123456789abcdef")
+
+	client := &http.Client{Transport: &Transport{Redactor: r}}
+	resp, err := client.Get(server.URL + "/weather?APPID=0123456789abcdef")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body := &bytes.Buffer{}
+	_, err = body.ReadFrom(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789abcdef", body.String(), "requests are sent as they are")
+
+	failing := &Transport{Redactor: r, Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("proxyconnect tcp: unable to reach " + req.URL.String())
+	})}
+	_, err = failing.RoundTrip(httptest.NewRequest(http.MethodGet, "https://api.openweathermap.org/data/2.5/weather?APPID=0123456789abcdef", nil))
+	require.EqualError(t, err, "proxyconnect tcp: unable to reach https://api.openweathermap.org/data/2.5/weather?APPID=REDACTED")
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
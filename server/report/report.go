@@ -0,0 +1,368 @@
+package report
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go-weather-app/server/types"
+)
+
+// Report is what a weather report shows: the current weather and the forecast of each backend for a location
+type Report struct {
+	Label    string // the location the report is for, as shown in its title
+	Weather  []types.Weather
+	Forecast []types.Forecast
+	Error    string // set when there is nothing else to show
+}
+
+// Options control how a report is drawn
+type Options struct {
+	Width int  // the number of columns of the terminal, longer lines are cut and fewer forecast days are shown
+	Color bool // whether to use ANSI colors
+}
+
+const (
+	// DefaultWidth is the width of a standard terminal
+	DefaultWidth = 80
+	// MinWidth is the narrowest report that still fits an icon with its description
+	MinWidth = 40
+	// MaxWidth is the widest report drawn
+	MaxWidth = 300
+)
+
+const (
+	iconWidth      = 13
+	dayColumnWidth = 20
+)
+
+const (
+	bold    = "1"
+	red     = "31"
+	green   = "32"
+	yellow  = "33"
+	blue    = "34"
+	cyan    = "36"
+	white   = "37"
+	gray    = "90"
+	noColor = ""
+)
+
+// condition is one of the conditions we have an icon for
+type condition int
+
+const (
+	unknown condition = iota
+	sunny
+	partlyCloudy
+	cloudy
+	fog
+	rain
+	snow
+	thunder
+)
+
+var icons = map[condition][]string{
+	unknown: {
+		"    .-.      ",
+		"     __)     ",
+		"    (        ",
+		"     `-'     ",
+		"      .      ",
+	},
+	sunny: {
+		"    \\   /    ",
+		"     .-.     ",
+		"  -- (   ) --",
+		"     `-'     ",
+		"    /   \\    ",
+	},
+	partlyCloudy: {
+		"   \\  /      ",
+		" _ /\"\".-.    ",
+		"   \\_(   ).  ",
+		"   /(___(__) ",
+		"             ",
+	},
+	cloudy: {
+		"             ",
+		"     .--.    ",
+		"  .-(    ).  ",
+		" (___.__)__) ",
+		"             ",
+	},
+	fog: {
+		"             ",
+		" _ - _ - _ - ",
+		"  _ - _ - _  ",
+		" _ - _ - _ - ",
+		"             ",
+	},
+	rain: {
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    ' ' ' '  ",
+		"   ' ' ' '   ",
+	},
+	snow: {
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    *  *  *  ",
+		"   *  *  *   ",
+	},
+	thunder: {
+		"     .-.     ",
+		"    (   ).   ",
+		"   (___(__)  ",
+		"    ,/_' ,/_ ",
+		"    /' ' /'  ",
+	},
+}
+
+var iconColors = map[condition]string{
+	unknown:      noColor,
+	sunny:        bold + ";" + yellow,
+	partlyCloudy: yellow,
+	cloudy:       white,
+	fog:          gray,
+	rain:         blue,
+	snow:         bold + ";" + white,
+	thunder:      bold + ";" + yellow,
+}
+
+// conditionKeywords are checked in order, so that i.e. "Partly sunny" isn't drawn as sunny and "Rain and snow" is snow
+var conditionKeywords = []struct {
+	condition condition
+	keywords  []string
+}{
+	{thunder, []string{"thunder", "t-storm", "storm"}},
+	{snow, []string{"snow", "sleet", "flurr", "ice", "hail"}},
+	{rain, []string{"rain", "drizzle", "shower"}},
+	{fog, []string{"fog", "mist", "haze", "hazy", "smoke", "dust"}},
+	{partlyCloudy, []string{"partly", "mostly sunny", "intermittent", "few clouds", "scattered clouds"}},
+	{cloudy, []string{"cloud", "overcast", "dreary"}},
+	{sunny, []string{"clear", "sun"}},
+}
+
+// conditionOf picks the icon for the descriptions of a backend
+func conditionOf(descriptions ...string) condition {
+	description := strings.ToLower(strings.Join(descriptions, " "))
+	for _, ck := range conditionKeywords {
+		for _, keyword := range ck.keywords {
+			if strings.Contains(description, keyword) {
+				return ck.condition
+			}
+		}
+	}
+	return unknown
+}
+
+// temperatureColor is blue for freezing and goes to red for hot
+func temperatureColor(temperature float32) string {
+	switch {
+	case temperature <= 0:
+		return blue
+	case temperature < 10:
+		return cyan
+	case temperature < 20:
+		return green
+	case temperature < 30:
+		return yellow
+	default:
+		return red
+	}
+}
+
+// Write draws the report
+func Write(w io.Writer, r Report, opts Options) error {
+	if opts.Width <= 0 {
+		opts.Width = DefaultWidth
+	}
+	if opts.Width < MinWidth {
+		opts.Width = MinWidth
+	}
+	if opts.Width > MaxWidth {
+		opts.Width = MaxWidth
+	}
+
+	lines := []line{{{text: "Weather report: "}, {text: r.Label, color: bold}}, {}}
+	if r.Error != "" {
+		lines = append(lines, line{{text: "error: " + r.Error, color: red}})
+	}
+	for _, weather := range r.Weather {
+		lines = append(lines, currentConditions(weather)...)
+	}
+	if len(r.Forecast) > 0 {
+		lines = append(lines, forecastTable(r.Forecast, opts.Width)...)
+	}
+
+	b := &strings.Builder{}
+	for _, l := range lines {
+		b.WriteString(l.cut(opts.Width).render(opts.Color))
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// currentConditions draws the icon of a backend's weather with its temperatures and descriptions beside it
+func currentConditions(weather types.Weather) []line {
+	lines := []line{{{text: weather.Source, color: bold}}}
+	if weather.Error != "" {
+		return append(lines, line{{text: "error: " + weather.Error, color: red}}, line{})
+	}
+
+	condition := conditionOf(weather.MainDescription, weather.DetailedDescription)
+	text := []line{
+		{{text: weather.MainDescription}},
+		{
+			temperature(weather.Temperature),
+			{text: " ("}, temperature(weather.TemperatureMin), {text: "/"}, temperature(weather.TemperatureMax), {text: ")"},
+		},
+		{{text: weather.DetailedDescription}},
+		{},
+		{},
+	}
+	if weather.Location != nil {
+		text[3] = line{{text: weather.Location.String(), color: gray}}
+	}
+	for i, iconLine := range icons[condition] {
+		lines = append(lines, append(line{{text: iconLine, color: iconColors[condition]}, {text: " "}}, text[i]...))
+	}
+	return append(lines, line{})
+}
+
+// forecastTable draws a row for every backend and a column for every day that fits in the width
+func forecastTable(forecasts []types.Forecast, width int) []line {
+	sourceWidth := len("Forecast")
+	dates := map[string]bool{}
+	for _, forecast := range forecasts {
+		if n := utf8.RuneCountInString(forecast.Source); n > sourceWidth {
+			sourceWidth = n
+		}
+		for _, day := range forecast.Days {
+			dates[day.Date] = true
+		}
+	}
+	sourceWidth += 2
+	if sourceWidth > width/3 {
+		sourceWidth = width / 3
+	}
+
+	columns := []string{}
+	for date := range dates {
+		columns = append(columns, date)
+	}
+	sort.Strings(columns)
+	fits := (width - sourceWidth) / dayColumnWidth
+	if fits < 1 {
+		fits = 1
+	}
+	if len(columns) > fits {
+		columns = columns[:fits]
+	}
+
+	header := line{{text: "Forecast", color: bold}}.cut(sourceWidth - 1).pad(sourceWidth)
+	for _, date := range columns {
+		header = append(header, line{{text: date, color: bold}}.pad(dayColumnWidth)...)
+	}
+	lines := []line{header}
+
+	for _, forecast := range forecasts {
+		row := line{{text: forecast.Source, color: bold}}.cut(sourceWidth - 1).pad(sourceWidth)
+		if forecast.Error != "" {
+			lines = append(lines, append(row, segment{text: "error: " + forecast.Error, color: red}))
+			continue
+		}
+		days := map[string]types.ForecastDay{}
+		for _, day := range forecast.Days {
+			days[day.Date] = day
+		}
+		for _, date := range columns {
+			day, ok := days[date]
+			if !ok {
+				row = append(row, line{{text: "-"}}.pad(dayColumnWidth)...)
+				continue
+			}
+			cell := line{
+				temperature(day.TemperatureMin), {text: "/"}, temperature(day.TemperatureMax),
+				{text: " "}, {text: day.MainDescription, color: iconColors[conditionOf(day.MainDescription, day.DetailedDescription)]},
+			}
+			row = append(row, cell.cut(dayColumnWidth-1).pad(dayColumnWidth)...)
+		}
+		lines = append(lines, row)
+	}
+	return lines
+}
+
+func temperature(temperature float32) segment {
+	return segment{text: strconv.FormatFloat(float64(temperature), 'f', -1, 32) + "°C", color: temperatureColor(temperature)}
+}
+
+// segment is text drawn in a single color
+type segment struct {
+	text  string
+	color string
+}
+
+// line is a line of the report, measured in runes so that colors don't count towards its width
+type line []segment
+
+func (l line) width() int {
+	n := 0
+	for _, s := range l {
+		n += utf8.RuneCountInString(s.text)
+	}
+	return n
+}
+
+// cut drops what doesn't fit in width
+func (l line) cut(width int) line {
+	cut := line{}
+	for _, s := range l {
+		if width <= 0 {
+			break
+		}
+		if n := utf8.RuneCountInString(s.text); n > width {
+			s.text = string([]rune(s.text)[:width])
+		}
+		width -= utf8.RuneCountInString(s.text)
+		cut = append(cut, s)
+	}
+	return cut
+}
+
+// pad fills the line with spaces up to width
+func (l line) pad(width int) line {
+	if n := l.width(); n < width {
+		return append(l, segment{text: strings.Repeat(" ", width-n)})
+	}
+	return l
+}
+
+// render writes the line without trailing spaces, which would make lines look longer when selected in a terminal
+func (l line) render(color bool) string {
+	for len(l) > 0 {
+		last := l[len(l)-1]
+		last.text = strings.TrimRight(last.text, " ")
+		if last.text != "" {
+			l = append(l[:len(l)-1:len(l)-1], last)
+			break
+		}
+		l = l[:len(l)-1]
+	}
+
+	b := &strings.Builder{}
+	for _, s := range l {
+		if !color || s.color == noColor || s.text == "" {
+			b.WriteString(s.text)
+			continue
+		}
+		b.WriteString("\x1b[" + s.color + "m" + s.text + "\x1b[0m")
+	}
+	return b.String()
+}
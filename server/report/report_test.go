@@ -0,0 +1,90 @@
+package report
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var testReport = Report{
+	Label: "Ottawa, ON, CA",
+	Weather: []types.Weather{
+		{
+			Source: "openweathermap", Temperature: 12.5, TemperatureMin: -2, TemperatureMax: 31,
+			MainDescription: "Clear", DetailedDescription: "clear sky",
+			Location: &types.ResolvedLocation{Location: location.Location{Name: "Ottawa", Country: "CA"}},
+		},
+		{Source: "accuweather", Temperature: 8, TemperatureMin: 4, TemperatureMax: 9, MainDescription: "Light rain shower"},
+		{Source: "foo", Error: "Error communicating to backend"},
+	},
+	Forecast: []types.Forecast{
+		{Source: "openweathermap", Days: []types.ForecastDay{
+			{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Clouds"},
+			{Date: "2019-06-02", TemperatureMin: -3, TemperatureMax: 1, MainDescription: "Snow"},
+			{Date: "2019-06-03", TemperatureMin: 15, TemperatureMax: 28, MainDescription: "Thunderstorm"},
+			{Date: "2019-06-04", TemperatureMin: 10, TemperatureMax: 12, MainDescription: "Mist"},
+		}},
+		{Source: "accuweather", Days: []types.ForecastDay{
+			{Date: "2019-06-02", TemperatureMin: -1, TemperatureMax: 3, MainDescription: "Partly sunny w/ flurries"},
+		}},
+		{Source: "foo", Error: "Forecasts are not supported by this backend"},
+	},
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		golden string
+		report Report
+		opts   Options
+	}{
+		{golden: "report.golden", report: testReport, opts: Options{Width: DefaultWidth}},
+		{golden: "report_color.golden", report: testReport, opts: Options{Width: DefaultWidth, Color: true}},
+		{golden: "report_narrow.golden", report: testReport, opts: Options{Width: MinWidth}},
+		{golden: "report_wide.golden", report: testReport, opts: Options{Width: 120}},
+		{golden: "report_weather.golden", report: Report{Label: "Ottawa", Weather: testReport.Weather[:1]}, opts: Options{}},
+		{golden: "report_forecast.golden", report: Report{Label: "Ottawa", Forecast: testReport.Forecast[:1]}, opts: Options{Width: DefaultWidth}},
+		{golden: "report_error.golden", report: Report{Label: "Ottawa", Error: "Location not found: Ottawa"}, opts: Options{Color: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.golden, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			require.NoError(t, Write(b, tc.report, tc.opts))
+
+			golden := filepath.Join("testdata", tc.golden)
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, b.Bytes(), 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), b.String())
+		})
+	}
+}
+
+func Test_conditionOf(t *testing.T) {
+	tests := []struct {
+		descriptions      []string
+		expectedCondition condition
+	}{
+		{descriptions: []string{"Clear", "clear sky"}, expectedCondition: sunny},
+		{descriptions: []string{"Mostly sunny"}, expectedCondition: partlyCloudy},
+		{descriptions: []string{"Clouds", "overcast clouds"}, expectedCondition: cloudy},
+		{descriptions: []string{"Rain", "light rain"}, expectedCondition: rain},
+		{descriptions: []string{"Rain and snow"}, expectedCondition: snow},
+		{descriptions: []string{"Thunderstorm", "thunderstorm with heavy rain"}, expectedCondition: thunder},
+		{descriptions: []string{"Haze"}, expectedCondition: fog},
+		{descriptions: []string{"", ""}, expectedCondition: unknown},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.expectedCondition, conditionOf(tc.descriptions...), "%v", tc.descriptions)
+	}
+}
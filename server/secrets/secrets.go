@@ -0,0 +1,145 @@
+// Package secrets resolves the references to secrets the configuration can have instead of the secrets themselves, so
+// that API keys don't sit in plaintext in config.json. A reference is a scheme, then what the provider of the scheme
+// needs to find the secret:
+//
+//	file:/run/secrets/openweathermap-api-key  the content of a file, i.e. a Docker or Kubernetes secret
+//	env:OPENWEATHERMAP_API_KEY                an environment variable
+//	vault:secret/data/weather#openweathermap  a key of a secret of a HashiCorp Vault compatible KV store, see Vault
+//
+// Other stores are added by registering a Provider for their scheme, see Register.
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schemes of the providers every Resolver has
+const (
+	SchemeFile  = "file"
+	SchemeEnv   = "env"
+	SchemeVault = "vault"
+)
+
+// Provider finds the secrets of a scheme, by what follows the scheme in their references, i.e.
+// "/run/secrets/api-key" for "file:/run/secrets/api-key"
+type Provider interface {
+	Resolve(reference string) (string, error)
+}
+
+// ProviderFunc lets an ordinary function be a Provider
+type ProviderFunc func(reference string) (string, error)
+
+// Resolve calls f
+func (f ProviderFunc) Resolve(reference string) (string, error) {
+	return f(reference)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// Register makes a provider available to every Resolver for the references of scheme, i.e. to read secrets from the
+// store of a cloud provider. It panics when the same scheme is registered twice, or when it is one of the schemes every
+// resolver has.
+func Register(scheme string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if scheme == "" || provider == nil {
+		panic("secrets: Register needs a scheme and a provider")
+	}
+	if _, ok := providers[scheme]; ok || scheme == SchemeFile || scheme == SchemeEnv || scheme == SchemeVault {
+		panic("secrets: Register called twice for scheme " + scheme)
+	}
+	providers[scheme] = provider
+}
+
+// Resolver resolves references with the provider of their scheme
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver returns a resolver with the registered providers, along with the ones of the file and env schemes (the
+// environment variables are looked up in environ, formatted like os.Environ). vault is the provider of the vault
+// scheme, the references of which fail when it is nil.
+func NewResolver(environ []string, vault Provider) *Resolver {
+	r := &Resolver{providers: map[string]Provider{
+		SchemeFile: ProviderFunc(readFile),
+		SchemeEnv:  Env(environ),
+		SchemeVault: ProviderFunc(func(string) (string, error) {
+			return "", errors.New("No vault configured to resolve the secret from")
+		}),
+	}}
+	if vault != nil {
+		r.providers[SchemeVault] = vault
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for scheme, provider := range providers {
+		r.providers[scheme] = provider
+	}
+	return r
+}
+
+// Schemes returns the schemes the resolver knows, sorted
+func (r *Resolver) Schemes() []string {
+	schemes := []string{}
+	for scheme := range r.providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// IsReference reports whether value is a reference to a secret rather than a value, i.e. "env:API_KEY". Values whose
+// scheme the resolver doesn't know, like URLs, are not references.
+func (r *Resolver) IsReference(value string) bool {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return false
+	}
+	_, ok := r.providers[value[:i]]
+	return ok
+}
+
+// Resolve returns the secret value refers to, or value itself when it is not a reference
+func (r *Resolver) Resolve(value string) (string, error) {
+	if !r.IsReference(value) {
+		return value, nil
+	}
+	i := strings.Index(value, ":")
+	scheme, reference := value[:i], value[i+1:]
+	if reference == "" {
+		return "", errors.New("Empty secret reference: " + value)
+	}
+	secret, err := r.providers[scheme].Resolve(reference)
+	if err != nil {
+		return "", errors.New("Unable to resolve secret " + value + ": " + err.Error())
+	}
+	return secret, nil
+}
+
+// readFile reads a secret from a file, without the line break editors and `echo` end files with
+func readFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Env returns a provider of the environment variables of environ, formatted like os.Environ
+func Env(environ []string) Provider {
+	return ProviderFunc(func(name string) (string, error) {
+		for _, variable := range environ {
+			if strings.HasPrefix(variable, name+"=") {
+				return strings.TrimPrefix(variable, name+"="), nil
+			}
+		}
+		return "", errors.New("Environment variable not set: " + name)
+	})
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "api-key"), []byte("12345\n"), 0600))
+
+	Register("test", ProviderFunc(func(reference string) (string, error) {
+		if reference == "missing" {
+			return "", errors.New("No such secret")
+		}
+		return "secret of " + reference, nil
+	}))
+	resolver := NewResolver([]string{"HOME=/root", "API_KEY=abcde", "EMPTY="}, nil)
+
+	tests := []struct {
+		name        string
+		value       string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:     "values are kept as they are",
+			value:    "12345",
+			expected: "12345",
+		},
+		{
+			name:     "URLs are not references",
+			value:    "https://eu.weather.example.com",
+			expected: "https://eu.weather.example.com",
+		},
+		{
+			name:     "file is read without its line break",
+			value:    "file:" + filepath.Join(dir, "api-key"),
+			expected: "12345",
+		},
+		{
+			name:        "missing file returns error",
+			value:       "file:" + filepath.Join(dir, "missing"),
+			expectedErr: errors.New("Unable to resolve secret file:" + filepath.Join(dir, "missing") + ": open " + filepath.Join(dir, "missing") + ": no such file or directory"),
+		},
+		{
+			name:     "environment variable is read",
+			value:    "env:API_KEY",
+			expected: "abcde",
+		},
+		{
+			name:     "empty environment variable is read",
+			value:    "env:EMPTY",
+			expected: "",
+		},
+		{
+			name:        "missing environment variable returns error",
+			value:       "env:API",
+			expectedErr: errors.New("Unable to resolve secret env:API: Environment variable not set: API"),
+		},
+		{
+			name:        "vault reference without a vault returns error",
+			value:       "vault:secret/data/weather#apiKey",
+			expectedErr: errors.New("Unable to resolve secret vault:secret/data/weather#apiKey: No vault configured to resolve the secret from"),
+		},
+		{
+			name:        "empty reference returns error",
+			value:       "env:",
+			expectedErr: errors.New("Empty secret reference: env:"),
+		},
+		{
+			name:     "registered provider is used for its scheme",
+			value:    "test:weather",
+			expected: "secret of weather",
+		},
+		{
+			name:        "registered provider failing returns error",
+			value:       "test:missing",
+			expectedErr: errors.New("Unable to resolve secret test:missing: No such secret"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			secret, err := resolver.Resolve(tc.value)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expected, secret)
+		})
+	}
+
+	require.Equal(t, []string{SchemeEnv, SchemeFile, "test", SchemeVault}, resolver.Schemes())
+	require.Panics(t, func() { Register("test", ProviderFunc(nil)) })
+	require.Panics(t, func() { Register(SchemeVault, &Vault{}) })
+}
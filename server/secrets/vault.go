@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultVaultTimeout is how long reading a secret from vault can take when no http client is given
+const DefaultVaultTimeout = 10 * time.Second
+
+// Vault reads secrets from the KV secrets engine, version 1 or 2, of HashiCorp Vault or of a compatible server. Its
+// references are the path of the secret, as in the HTTP API, then the key of the value: "secret/data/weather#apiKey"
+// for the apiKey of the weather secret of a version 2 engine mounted at secret/. Secrets are read once per Vault, so
+// that secrets with several keys are only read once when the configuration is loaded.
+type Vault struct {
+	Address    string       // i.e. "https://vault.example.com:8200"
+	Token      string       // sent in the X-Vault-Token header
+	Namespace  string       // sent in the X-Vault-Namespace header, for Vault Enterprise namespaces
+	HTTPClient *http.Client // defaults to a client with DefaultVaultTimeout
+
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{} // by path
+}
+
+// vaultResponse is the part of the responses of the KV engines that is used, data has the keys of the secret in
+// version 1 and the keys of the secret under "data" in version 2
+type vaultResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []string                   `json:"errors"`
+}
+
+// Resolve returns the value of a key of a secret, i.e. "secret/data/weather#apiKey"
+func (v *Vault) Resolve(reference string) (string, error) {
+	i := strings.LastIndex(reference, "#")
+	if i <= 0 || i == len(reference)-1 {
+		return "", errors.New("Invalid vault secret reference, expected the path of the secret then #key: " + reference)
+	}
+	path, key := strings.Trim(reference[:i], "/"), reference[i+1:]
+
+	secret, err := v.read(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret[key]
+	if !ok {
+		return "", errors.New("No key " + key + " in vault secret " + path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, _ := json.Marshal(value) // i.e. numbers, which are secrets too
+	return string(b), nil
+}
+
+// read returns the keys of the secret at path
+func (v *Vault) read(path string) (map[string]interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if secret, ok := v.secrets[path]; ok {
+		return secret, nil
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(v.Address, "/") + "/v1/" + path)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("Invalid vault address: " + v.Address)
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultVaultTimeout}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.New("Error communicating to vault: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	decoded := vaultResponse{}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&decoded)
+	if resp.StatusCode != http.StatusOK {
+		message := "status code " + strconv.Itoa(resp.StatusCode)
+		if len(decoded.Errors) > 0 {
+			message += ": " + strings.Join(decoded.Errors, ", ")
+		}
+		return nil, errors.New("Unable to read vault secret " + path + ", " + message)
+	}
+	if decodeErr != nil {
+		return nil, errors.New("Unable to decode vault secret " + path + ": " + decodeErr.Error())
+	}
+
+	secret := map[string]interface{}{}
+	data, versioned := decoded.Data["data"]
+	if _, ok := decoded.Data["metadata"]; versioned && ok {
+		err = json.Unmarshal(data, &secret) // version 2
+	} else {
+		for key, value := range decoded.Data {
+			var decodedValue interface{}
+			if err = json.Unmarshal(value, &decodedValue); err != nil {
+				break
+			}
+			secret[key] = decodedValue
+		}
+	}
+	if err != nil {
+		return nil, errors.New("Unable to decode vault secret " + path + ": " + err.Error())
+	}
+
+	if v.secrets == nil {
+		v.secrets = map[string]map[string]interface{}{}
+	}
+	v.secrets[path] = secret
+	return secret, nil
+}
@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeVault fakes a vault with a version 2 KV engine mounted at secret/ and a version 1 one mounted at kv/,
+// counting the reads of each secret
+func newFakeVault(t *testing.T, token string) (*httptest.Server, map[string]int) {
+	reads := map[string]int{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reads[r.URL.Path]++
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/weather":
+			w.Write([]byte(`{"data": {"data": {"openweathermap": "12345", "accuweather": "abcde", "retries": 3}, "metadata": {"version": 2}}}`))
+		case "/v1/kv/weather":
+			w.Write([]byte(`{"data": {"openweathermap": "67890"}, "lease_duration": 3600}`))
+		case "/v1/secret/data/broken":
+			w.Write([]byte(`not json`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	})), reads
+}
+
+func TestVault_Resolve(t *testing.T) {
+	vault, reads := newFakeVault(t, "s.token")
+	defer vault.Close()
+
+	tests := []struct {
+		name        string
+		token       string
+		reference   string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:      "key of a version 2 secret is read",
+			token:     "s.token",
+			reference: "secret/data/weather#openweathermap",
+			expected:  "12345",
+		},
+		{
+			name:      "key of a version 1 secret is read",
+			token:     "s.token",
+			reference: "/kv/weather#openweathermap",
+			expected:  "67890",
+		},
+		{
+			name:      "value that isn't a string is read as json",
+			token:     "s.token",
+			reference: "secret/data/weather#retries",
+			expected:  "3",
+		},
+		{
+			name:        "missing key returns error",
+			token:       "s.token",
+			reference:   "secret/data/weather#darksky",
+			expectedErr: errors.New("No key darksky in vault secret secret/data/weather"),
+		},
+		{
+			name:        "missing secret returns error",
+			token:       "s.token",
+			reference:   "secret/data/other#apiKey",
+			expectedErr: errors.New("Unable to read vault secret secret/data/other, status code 404"),
+		},
+		{
+			name:        "wrong token returns the errors of vault",
+			token:       "s.other",
+			reference:   "secret/data/weather#openweathermap",
+			expectedErr: errors.New("Unable to read vault secret secret/data/weather, status code 403: permission denied"),
+		},
+		{
+			name:        "invalid response returns error",
+			token:       "s.token",
+			reference:   "secret/data/broken#apiKey",
+			expectedErr: errors.New("Unable to decode vault secret secret/data/broken: invalid character 'o' in literal null (expecting 'u')"),
+		},
+		{
+			name:        "reference without a key returns error",
+			token:       "s.token",
+			reference:   "secret/data/weather",
+			expectedErr: errors.New("Invalid vault secret reference, expected the path of the secret then #key: secret/data/weather"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &Vault{Address: vault.URL + "/", Token: tc.token}
+			secret, err := v.Resolve(tc.reference)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expected, secret)
+		})
+	}
+
+	// secrets are read once, whatever the number of their keys used
+	before := reads["/v1/secret/data/weather"]
+	v := &Vault{Address: vault.URL, Token: "s.token", Namespace: "weather"}
+	resolver := NewResolver(nil, v)
+	for _, reference := range []string{"vault:secret/data/weather#openweathermap", "vault:secret/data/weather#accuweather"} {
+		_, err := resolver.Resolve(reference)
+		require.NoError(t, err)
+	}
+	require.Equal(t, before+1, reads["/v1/secret/data/weather"])
+
+	_, err := (&Vault{Address: "vault.example.com"}).Resolve("secret/data/weather#apiKey")
+	require.EqualError(t, err, "Invalid vault address: vault.example.com")
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// getAdminConfig sends the configuration in effect like -print-config does, with the defaults and without the secrets
+func (s *Server) getAdminConfig(c echo.Context) error {
+	s.reloadMu.Lock()
+	config := s.config
+	s.reloadMu.Unlock()
+
+	b, err := RedactConfig(config)
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
+
+// postAdminReload reloads the backends like SIGHUP does, see Reload, answering with the backends once reloaded
+func (s *Server) postAdminReload(c echo.Context) error {
+	if s.loadConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "Reloading the configuration is not enabled")
+	}
+	if err := s.Reload(s.loadConfig); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to reload the configuration: "+s.redactor.String(err.Error()))
+	}
+	return c.JSONPretty(http.StatusOK, BackendResponse{Backends: s.backends().names}, "  ")
+}
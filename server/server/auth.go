@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/auth"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyQueryParam is where clients that can't set headers, i.e. browsers opening a WebSocket or an EventSource, pass
+// their API key or token
+const apiKeyQueryParam = "api_key"
+
+// grpcAPIKeyMetadata is the metadata gRPC clients pass their API key or token in, JWTs are passed in the authorization
+// metadata like in the Authorization header
+const grpcAPIKeyMetadata = "x-api-key"
+
+func (s *Server) configureAuth(config *Config) error {
+	s.authenticator, s.jwtValidator = nil, nil
+	if len(config.Auth.Clients) > 0 {
+		clients := []auth.ClientOptions{}
+		for _, client := range config.Auth.Clients {
+			options := auth.ClientOptions{
+				Name:              client.Name,
+				KeyHashes:         client.APIKeyHashes,
+				TokenSecret:       client.TokenSecret,
+				Scopes:            client.Scopes,
+				Backends:          client.Backends,
+				RequestsPerSecond: client.RequestsPerSecond,
+				Burst:             client.Burst,
+				Quota:             client.Quota,
+			}
+			if client.QuotaPeriod != nil {
+				options.QuotaPeriod = client.QuotaPeriod.Duration
+			}
+			clients = append(clients, options)
+		}
+		authenticator, err := auth.New(clients, s.now)
+		if err != nil {
+			return err
+		}
+		s.authenticator = authenticator
+	}
+
+	jwtConfig := config.Auth.JWT
+	if jwtConfig.Issuer == "" {
+		return nil
+	}
+	options := auth.JWTOptions{
+		Issuer:     jwtConfig.Issuer,
+		Audience:   jwtConfig.Audience,
+		JWKSURL:    jwtConfig.JWKSURL,
+		ReadScope:  jwtConfig.ReadScope,
+		AdminScope: jwtConfig.AdminScope,
+
+		Backends:          jwtConfig.Backends,
+		RequestsPerSecond: jwtConfig.RequestsPerSecond,
+		Burst:             jwtConfig.Burst,
+		Quota:             jwtConfig.Quota,
+	}
+	if jwtConfig.QuotaPeriod != nil {
+		options.QuotaPeriod = jwtConfig.QuotaPeriod.Duration
+	}
+	if jwtConfig.RefreshInterval != nil {
+		options.RefreshInterval = jwtConfig.RefreshInterval.Duration
+	}
+	if jwtConfig.Leeway != nil {
+		options.Leeway = jwtConfig.Leeway.Duration
+	}
+	validator, err := auth.NewJWTValidator(options, s.httpClient, s.now)
+	if err != nil {
+		return err
+	}
+	// the identity provider may only be down for a moment, the keys are fetched again when the first user logs in
+	if err := validator.Refresh(); err != nil {
+		s.logger.Warn("unable to fetch the signing keys of the identity provider: ", err)
+	}
+	s.jwtValidator = validator
+	return nil
+}
+
+// authEnabled tells whether the clients of the API are authenticated, anyone can call it otherwise
+func (s *Server) authEnabled() bool {
+	return s.authenticator != nil || s.jwtValidator != nil
+}
+
+// authMiddleware authenticates the client of every request, checks that it was granted scope and counts the request
+// against its limits, when authentication is configured. The client can then be found in the context of the request,
+// see auth.FromContext. Endpoints needing auth.ScopeAdmin are disabled when authentication isn't configured.
+func (s *Server) authMiddleware(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !s.authEnabled() {
+				if scope == auth.ScopeAdmin {
+					return echo.NewHTTPError(http.StatusForbidden, "Admin endpoints are disabled until clients or JWTs are configured")
+				}
+				return next(c)
+			}
+			ctx, err := s.authenticate(c.Request().Context(), requestCredential(c.Request()), scope)
+			if limitErr, ok := err.(*auth.LimitError); ok {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limitErr.RetryAfter)))
+				return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+			}
+			switch err {
+			case nil:
+			case auth.ErrInsufficientScope:
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			case auth.ErrKeysUnavailable:
+				return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+			default:
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="go-weather-app"`)
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// authenticate finds the client of a credential, a JWT or an API key or token, checks that it was granted scope and
+// counts the request against its limits, returning ctx with the client. The errors are the ones of
+// auth.Authenticator.Authenticate and auth.JWTValidator.Validate, auth.ErrInsufficientScope, or a *auth.LimitError.
+func (s *Server) authenticate(ctx context.Context, credential string, scope string) (context.Context, error) {
+	var client *auth.Client
+	var err error
+	switch {
+	case s.jwtValidator != nil && auth.IsJWT(credential):
+		client, err = s.jwtValidator.Validate(credential)
+	case s.authenticator != nil:
+		client, err = s.authenticator.Authenticate(credential)
+	case credential == "":
+		err = auth.ErrMissingCredential
+	default:
+		err = auth.ErrInvalidCredential
+	}
+	if err != nil {
+		s.metrics.ClientRequestsTotal.WithLabelValues("", "unauthorized").Inc()
+		return ctx, err
+	}
+	if !client.HasScope(scope) {
+		s.metrics.ClientRequestsTotal.WithLabelValues(client.Name, "forbidden").Inc()
+		return ctx, auth.ErrInsufficientScope
+	}
+	if err := client.Take(); err != nil {
+		s.metrics.ClientRequestsTotal.WithLabelValues(client.Name, "limited").Inc()
+		return ctx, err
+	}
+	s.metrics.ClientRequestsTotal.WithLabelValues(client.Name, "allowed").Inc()
+	return auth.NewContext(ctx, client), nil
+}
+
+// takeRequests counts n more requests against the limits of the client of ctx, for requests that make more than one,
+// i.e. the items of a batch or the polls of a stream. It returns an error when the client is over its limits, never
+// when authentication isn't configured.
+func (s *Server) takeRequests(ctx context.Context, n int) *auth.LimitError {
+	client := auth.FromContext(ctx)
+	if client == nil || n <= 0 {
+		return nil
+	}
+	if err := client.TakeN(n); err != nil {
+		s.metrics.ClientRequestsTotal.WithLabelValues(client.Name, "limited").Inc()
+		return err.(*auth.LimitError)
+	}
+	return nil
+}
+
+// requestCredential returns the API key or token of a request, from the X-API-Key header, a bearer token (i.e. a JWT
+// or the token of a federated backend) or the api_key query parameter
+func requestCredential(req *http.Request) string {
+	if key := req.Header.Get(api.HeaderAPIKey); key != "" {
+		return key
+	}
+	if authorization := req.Header.Get(echo.HeaderAuthorization); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	return req.URL.Query().Get(apiKeyQueryParam)
+}
+
+// retryAfterSeconds rounds up how long a client has to wait, Retry-After is in whole seconds
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+}
+
+// grpcUnaryAuthInterceptor is authMiddleware for the calls of the gRPC API
+func (s *Server) grpcUnaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticateGRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcStreamAuthInterceptor is authMiddleware for the streams of the gRPC API
+func (s *Server) grpcStreamAuthInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateGRPC(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, authenticatedServerStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticateGRPC authenticates the client of a gRPC call by the x-api-key metadata or a bearer token in the
+// authorization metadata, with the matching status codes
+func (s *Server) authenticateGRPC(ctx context.Context) (context.Context, error) {
+	if !s.authEnabled() {
+		return ctx, nil
+	}
+	credential := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(grpcAPIKeyMetadata); len(keys) > 0 {
+			credential = keys[0]
+		} else if authorization := md.Get("authorization"); len(authorization) > 0 && strings.HasPrefix(authorization[0], "Bearer ") {
+			credential = strings.TrimSpace(strings.TrimPrefix(authorization[0], "Bearer "))
+		}
+	}
+	ctx, err := s.authenticate(ctx, credential, auth.ScopeRead)
+	if limitErr, ok := err.(*auth.LimitError); ok {
+		return ctx, grpcLimitError(limitErr)
+	}
+	switch err {
+	case nil:
+		return ctx, nil
+	case auth.ErrInsufficientScope:
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	case auth.ErrKeysUnavailable:
+		return ctx, status.Error(codes.Unavailable, err.Error())
+	}
+	return ctx, status.Error(codes.Unauthenticated, err.Error())
+}
+
+// grpcLimitError is the status of the calls of clients over their limits, with how long they have to wait
+func grpcLimitError(err *auth.LimitError) error {
+	return status.Error(codes.ResourceExhausted, err.Error()+", retry after "+strconv.Itoa(retryAfterSeconds(err.RetryAfter))+"s")
+}
+
+// authenticatedServerStream is a stream whose context has the client that opened it
+type authenticatedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// allowedBackends leaves out the default backends the client of the request can't use
+func allowedBackends(ctx context.Context, defaults []string) ([]string, error) {
+	client := auth.FromContext(ctx)
+	if client == nil {
+		return defaults, nil
+	}
+	allowed := []string{}
+	for _, backend := range defaults {
+		if client.AllowsBackend(backend) {
+			allowed = append(allowed, backend)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("None of the backends is allowed for this client")
+	}
+	return allowed, nil
+}
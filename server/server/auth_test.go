@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weather-app/server/auth"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/backends/openweathermap"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/types"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newAuthTestServer creates a server with the clients of the tests: ui has an API key and can only use foo, eu
+// authenticates with tokens, limited has a quota of 1 request per hour and batch a quota of 4
+func newAuthTestServer(t *testing.T, now func() time.Time) *Server {
+	config := &Config{
+		Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "test"}`)}},
+		Auth: AuthConfig{Clients: []ClientConfig{
+			{Name: "ui", APIKeyHashes: []string{auth.HashKey("0123456789abcdef")}, Backends: []string{"foo"}},
+			{Name: "eu", TokenSecret: "secret"},
+			{Name: "limited", APIKeyHashes: []string{auth.HashKey("fedcba9876543210")}, Quota: 1, QuotaPeriod: &Duration{Duration: time.Hour}},
+			{Name: "batch", APIKeyHashes: []string{auth.HashKey("00112233445566778899")}, Quota: 4, QuotaPeriod: &Duration{Duration: time.Hour}},
+		}},
+	}
+	s, err := New(WithConfig(config), WithClock(now))
+	require.NoError(t, err)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12}},
+		"bar": mockWeatherBackend{returnWeather: types.Weather{Source: "bar", Temperature: 14}},
+	}, []string{"foo", "bar"})
+	s.weatherCache = cache.New(0, now, s.metrics)
+	return s
+}
+
+func Test_authMiddleware(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newAuthTestServer(t, func() time.Time { return now })
+
+	tests := []struct {
+		name               string
+		target             string
+		header             http.Header
+		expectedHTTPStatus int
+		expectedBody       string
+		expectedHeader     http.Header
+	}{
+		{
+			name:               "request without credential is unauthorized",
+			target:             "/v1/backends",
+			expectedHTTPStatus: http.StatusUnauthorized,
+			expectedBody:       `{"message":"No API key or token provided"}`,
+			expectedHeader:     http.Header{"Www-Authenticate": {`Bearer realm="go-weather-app"`}},
+		},
+		{
+			name:               "request with an unknown API key is unauthorized",
+			target:             "/v1/backends",
+			header:             http.Header{"X-Api-Key": {"0123456789abcdeg"}},
+			expectedHTTPStatus: http.StatusUnauthorized,
+			expectedBody:       `{"message":"Invalid API key or token"}`,
+		},
+		{
+			name:               "API key in a header",
+			target:             "/v1/backends",
+			header:             http.Header{"X-Api-Key": {"0123456789abcdef"}},
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "API key in the query",
+			target:             "/v1/backends?api_key=0123456789abcdef",
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "API key as a bearer token",
+			target:             "/v1/backends",
+			header:             http.Header{"Authorization": {"Bearer 0123456789abcdef"}},
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "signed token",
+			target:             "/v1/backends",
+			header:             http.Header{"Authorization": {"Bearer " + auth.SignToken("eu", "secret", now.Add(time.Hour))}},
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "expired token is unauthorized",
+			target:             "/v1/backends",
+			header:             http.Header{"Authorization": {"Bearer " + auth.SignToken("eu", "secret", now.Add(-time.Hour))}},
+			expectedHTTPStatus: http.StatusUnauthorized,
+			expectedBody:       `{"message":"Expired token"}`,
+		},
+		{
+			name:               "v2 is unauthorized with a problem",
+			target:             "/v2/backends",
+			expectedHTTPStatus: http.StatusUnauthorized,
+			expectedBody:       `{"type":"urn:go-weather-app:problem:unauthorized","title":"Unauthorized","status":401,"detail":"No API key or token provided","instance":"/v2/backends","code":"unauthorized"}`,
+		},
+		{
+			name:               "admin endpoints need the admin scope",
+			target:             "/admin/config",
+			header:             http.Header{"X-Api-Key": {"0123456789abcdef"}},
+			expectedHTTPStatus: http.StatusForbidden,
+			expectedBody:       `{"message":"The API key or token doesn't grant access to this endpoint"}`,
+		},
+		{
+			name:               "metrics are not authenticated",
+			target:             "/metrics",
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "only the allowed backends of the client are used by default",
+			target:             "/v1/weather/ottawa",
+			header:             http.Header{"X-Api-Key": {"0123456789abcdef"}},
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"city":"ottawa","location":{"name":"ottawa"},"data":[{"source":"foo","temperature":12,"temperature_min":0,"temperature_max":0}]}`,
+		},
+		{
+			name:               "requesting a backend the client can't use is a bad request",
+			target:             "/v1/weather/ottawa?backend=bar",
+			header:             http.Header{"X-Api-Key": {"0123456789abcdef"}},
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       `{"city":"ottawa","error":"Backend not allowed for this client: bar"}`,
+		},
+		{
+			name:               "first request of the quota",
+			target:             "/v1/backends",
+			header:             http.Header{"X-Api-Key": {"fedcba9876543210"}},
+			expectedHTTPStatus: http.StatusOK,
+		},
+		{
+			name:               "request over the quota is limited",
+			target:             "/v1/backends",
+			header:             http.Header{"X-Api-Key": {"fedcba9876543210"}},
+			expectedHTTPStatus: http.StatusTooManyRequests,
+			expectedBody:       `{"message":"Quota exceeded"}`,
+			expectedHeader:     http.Header{"Retry-After": {"3600"}},
+		},
+		{
+			name:               "v2 request over the quota is limited with a problem",
+			target:             "/v2/backends",
+			header:             http.Header{"X-Api-Key": {"fedcba9876543210"}},
+			expectedHTTPStatus: http.StatusTooManyRequests,
+			expectedBody:       `{"type":"urn:go-weather-app:problem:too_many_requests","title":"Too many requests","status":429,"detail":"Quota exceeded","instance":"/v2/backends","code":"too_many_requests"}`,
+			expectedHeader:     http.Header{"Retry-After": {"3600"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code, rec.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, rec.Body.String())
+			}
+			for name, values := range tc.expectedHeader {
+				require.Equal(t, values, rec.Header()[name])
+			}
+		})
+	}
+}
+
+func Test_grpcAuthInterceptors(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newAuthTestServer(t, func() time.Time { return now })
+	ctx := context.Background()
+
+	_, err := s.authenticateGRPC(ctx)
+	require.Equal(t, status.Error(codes.Unauthenticated, "No API key or token provided"), err)
+
+	authenticated, err := s.authenticateGRPC(metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", "0123456789abcdef")))
+	require.NoError(t, err)
+	require.Equal(t, "ui", auth.FromContext(authenticated).Name)
+
+	limited := metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", "fedcba9876543210"))
+	_, err = s.authenticateGRPC(limited)
+	require.NoError(t, err)
+	_, err = s.authenticateGRPC(limited)
+	require.Equal(t, status.Error(codes.ResourceExhausted, "Quota exceeded, retry after 3600s"), err)
+
+	_, _, targetBackends, err := s.resolveGRPCRequest(authenticated, "ottawa", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, targetBackends, "only the allowed backends of the client are used by default")
+	_, _, _, err = s.resolveGRPCRequest(authenticated, "ottawa", []string{"bar"})
+	require.Equal(t, status.Error(codes.InvalidArgument, "Backend not allowed for this client: bar"), err)
+}
+
+func Test_postWeatherBatchLimits(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newAuthTestServer(t, func() time.Time { return now })
+
+	postBatch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/weather/batch", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-Api-Key", "00112233445566778899")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// every item counts as a request
+	rec := postBatch(`{"items": [{"city": "ottawa"}, {"city": "paris"}, {"city": "tokyo"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = postBatch(`{"items": [{"city": "ottawa"}, {"city": "paris"}]}`)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.JSONEq(t, `{"error":"Quota exceeded"}`, rec.Body.String())
+	require.Equal(t, "3600", rec.Header().Get("Retry-After"))
+}
+
+func Test_serveWebSocketClientLimits(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newAuthTestServer(t, func() time.Time { return now })
+	s.streamPollInterval = 10 * time.Millisecond
+	// the polls are answered from the cache, so that they don't publish updates
+	s.weatherCache = cache.New(time.Hour, func() time.Time { return now }, s.metrics)
+	server := httptest.NewServer(s)
+	defer func() {
+		server.Close()
+		s.closeWebSockets()
+	}()
+
+	ws, err := websocket.Dial(strings.Replace(server.URL, "http://", "ws://", 1)+"/v1/ws?api_key=00112233445566778899", "", "http://localhost")
+	require.NoError(t, err)
+	defer ws.Close()
+
+	// the connection, the subscription and its first poll take the quota of 4, the next poll drops the subscription
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "ottawa"})
+	require.Equal(t, WebSocketSnapshot, receiveWebSocketMessage(t, ws).Type)
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, City: "ottawa", Error: "Quota exceeded, subscription dropped"}, receiveWebSocketMessage(t, ws))
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketUnsubscribe, City: "ottawa"})
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, City: "ottawa", Error: "Not subscribed to city: ottawa"}, receiveWebSocketMessage(t, ws))
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "paris"})
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, City: "paris", Error: "Quota exceeded"}, receiveWebSocketMessage(t, ws))
+}
+
+func Test_jwtAuthentication(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/keys", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer provider.Close()
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	config := &Config{
+		Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "test"}`)}},
+		Auth:     AuthConfig{JWT: JWTConfig{Issuer: "https://login.example.com", Audience: "weather-ui", JWKSURL: provider.URL + "/keys", Backends: []string{"accuweather"}}},
+	}
+	reloads := 0
+	s, err := New(WithConfig(config), WithClock(func() time.Time { return now }), WithConfigLoader(func() (*Config, error) {
+		reloads++
+		return config, nil
+	}))
+	require.NoError(t, err)
+	token := func(scope string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": "https://login.example.com", "aud": "weather-ui", "sub": "alice", "exp": now.Add(time.Hour).Unix(), "scope": scope})
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name               string
+		method             string
+		target             string
+		token              string
+		expectedHTTPStatus int
+	}{
+		{name: "user with the read scope can read", method: http.MethodGet, target: "/v1/backends", token: token("openid weather:read"), expectedHTTPStatus: http.StatusOK},
+		{name: "user without the read scope can't read", method: http.MethodGet, target: "/v2/backends", token: token("openid"), expectedHTTPStatus: http.StatusForbidden},
+		{name: "token of another server is unauthorized", method: http.MethodGet, target: "/v1/backends", token: auth.SignToken("eu", "secret", now.Add(time.Hour)), expectedHTTPStatus: http.StatusUnauthorized},
+		{name: "reader can't see the configuration", method: http.MethodGet, target: "/admin/config", token: token("weather:read"), expectedHTTPStatus: http.StatusForbidden},
+		{name: "admin can see the configuration", method: http.MethodGet, target: "/admin/config", token: token("weather:admin"), expectedHTTPStatus: http.StatusOK},
+		{name: "admin can reload the configuration", method: http.MethodPost, target: "/admin/reload", token: token("weather:admin"), expectedHTTPStatus: http.StatusOK},
+		{name: "admin can read", method: http.MethodGet, target: "/v1/backends", token: token("weather:admin"), expectedHTTPStatus: http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code, rec.Body.String())
+		})
+	}
+	require.Equal(t, 1, reloads)
+
+	ctx, err := s.authenticateGRPC(metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token("weather:read"))))
+	require.NoError(t, err)
+	require.Equal(t, "alice", auth.FromContext(ctx).Name)
+	_, _, _, err = s.resolveGRPCRequest(ctx, "ottawa", []string{"openweathermap"})
+	require.Equal(t, status.Error(codes.InvalidArgument, "Backend not allowed for this client: openweathermap"), err)
+	_, err = s.authenticateGRPC(metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token(""))))
+	require.Equal(t, status.Error(codes.PermissionDenied, "The API key or token doesn't grant access to this endpoint"), err)
+}
+
+func Test_adminEndpointsWithoutAuth(t *testing.T) {
+	s := newTestServer(t, WithConfigLoader(func() (*Config, error) { return &Config{}, nil }))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.JSONEq(t, `{"message":"Admin endpoints are disabled until clients or JWTs are configured"}`, rec.Body.String())
+}
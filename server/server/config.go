@@ -0,0 +1,428 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-weather-app/server/backends"
+	_ "go-weather-app/server/backends/builtin"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/geoip"
+	"go-weather-app/server/location"
+	"go-weather-app/server/redact"
+
+	"github.com/labstack/gommon/log"
+)
+
+// DefaultLocationMismatchKm is the distance between the locations resolved by backends past which they are flagged as disagreeing
+const DefaultLocationMismatchKm = 50
+
+// DefaultCacheTTL is how long backend readings are reused for when no cache ttl is configured
+const DefaultCacheTTL = 5 * time.Minute
+
+// DefaultBatchMaxItems and DefaultBatchConcurrency are the batch limits used when none are configured
+const (
+	DefaultBatchMaxItems    = 100
+	DefaultBatchConcurrency = 8
+)
+
+// DefaultStreamHeartbeat, DefaultStreamPollInterval and DefaultStreamBufferSize are the stream settings used when none are configured
+const (
+	DefaultStreamHeartbeat    = 15 * time.Second
+	DefaultStreamPollInterval = time.Minute
+	DefaultStreamBufferSize   = 16
+)
+
+// DefaultGRPCAddress is where the gRPC API is served when no address is configured
+const DefaultGRPCAddress = ":9090"
+
+// DefaultAddress is where the REST API is served when no address is configured
+const DefaultAddress = ":8080"
+
+// DefaultShutdownTimeout is how long open requests are given to finish when the server is stopped, and
+// DefaultBackendTimeout how long a call to a backend can take, when they aren't configured
+const (
+	DefaultShutdownTimeout = 10 * time.Second
+	DefaultBackendTimeout  = 10 * time.Second
+)
+
+// DefaultAllowedOrigins are the browser origins allowed to call the API when none are configured, the UI in development
+var DefaultAllowedOrigins = []string{"http://localhost", "http://localhost:3000"}
+
+// DefaultWebSocketMessageRate, DefaultWebSocketMessageBurst and DefaultWebSocketMaxSubscriptions are the /v1/ws limits
+// used when none are configured
+const (
+	DefaultWebSocketMessageRate      = 5
+	DefaultWebSocketMessageBurst     = 20
+	DefaultWebSocketMaxSubscriptions = 50
+)
+
+// Config defines the server configurations
+type Config struct {
+	HTTP           HTTPConfig      `json:"http"`
+	Log            LogConfig       `json:"log"`
+	Backends       backends.Config `json:"backends" config:"required"`
+	BackendTimeout *Duration       `json:"backendTimeout"` // defaults to DefaultBackendTimeout
+	Location       LocationConfig  `json:"location"`
+	GeoIP          GeoIPConfig     `json:"geoip"`
+	Cache          CacheConfig     `json:"cache"`
+	Batch          BatchConfig     `json:"batch"`
+	Stream         StreamConfig    `json:"stream"`
+	WebSocket      WebSocketConfig `json:"websocket"`
+	GRPC           GRPCConfig      `json:"grpc"`
+	Secrets        SecretsConfig   `json:"secrets"`
+	Auth           AuthConfig      `json:"auth"`
+}
+
+// HTTPConfig defines where and how the REST API is served
+type HTTPConfig struct {
+	Address         string    `json:"address"`         // defaults to DefaultAddress
+	AllowedOrigins  []string  `json:"allowedOrigins"`  // browser origins allowed to call the API, defaults to DefaultAllowedOrigins
+	ReadTimeout     *Duration `json:"readTimeout"`     // how long reading a request can take, unlimited by default
+	ShutdownTimeout *Duration `json:"shutdownTimeout"` // defaults to DefaultShutdownTimeout
+}
+
+// LogConfig defines what the server logs
+type LogConfig struct {
+	Level string `json:"level"` // "debug", "info", "warn", "error" or "off", defaults to the level of the logger
+}
+
+// GRPCConfig defines where the gRPC API is served
+type GRPCConfig struct {
+	Address string `json:"address"` // defaults to DefaultGRPCAddress
+}
+
+// Duration is a time.Duration that is configured as a string, i.e. "5m" or "30s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("Durations must be strings, i.e. \"5m\"")
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// MarshalJSON formats the duration the same way it is configured
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// CacheConfig defines how backend readings are cached
+type CacheConfig struct {
+	TTL *Duration `json:"ttl"` // defaults to DefaultCacheTTL, "0s" disables caching
+}
+
+// BatchConfig defines the limits for /v1/weather/batch
+type BatchConfig struct {
+	MaxItems    int `json:"maxItems"`    // defaults to DefaultBatchMaxItems
+	Concurrency int `json:"concurrency"` // defaults to DefaultBatchConcurrency
+}
+
+// StreamConfig defines how /v1/weather/{city}/stream keeps clients up to date
+type StreamConfig struct {
+	Heartbeat    *Duration `json:"heartbeat"`    // defaults to DefaultStreamHeartbeat
+	PollInterval *Duration `json:"pollInterval"` // defaults to DefaultStreamPollInterval
+	BufferSize   int       `json:"bufferSize"`   // defaults to DefaultStreamBufferSize
+}
+
+// WebSocketConfig defines the per-connection limits of /v1/ws
+type WebSocketConfig struct {
+	MessagesPerSecond float64 `json:"messagesPerSecond"` // defaults to DefaultWebSocketMessageRate
+	MessageBurst      int     `json:"messageBurst"`      // defaults to DefaultWebSocketMessageBurst
+	MaxSubscriptions  int     `json:"maxSubscriptions"`  // defaults to DefaultWebSocketMaxSubscriptions
+}
+
+// LocationConfig defines the offline datasets used to resolve locations. When no GeoNames file is configured,
+// locations are passed to the backends as typed and they are left to resolve them.
+type LocationConfig struct {
+	GeoNamesFile    string `json:"geonamesFile"`    // i.e. cities15000.txt
+	Admin1CodesFile string `json:"admin1CodesFile"` // i.e. admin1CodesASCII.txt
+	PostalCodesFile string `json:"postalCodesFile"` // i.e. allCountries.txt from the postal code dump
+
+	MismatchDistanceKm float64 `json:"mismatchDistanceKm"` // defaults to DefaultLocationMismatchKm
+}
+
+// GeoIPConfig defines the offline database used to find the caller's location for /v1/weather/here
+type GeoIPConfig struct {
+	DatabaseFile   string   `json:"databaseFile"`   // i.e. GeoLite2-City.mmdb
+	TrustedProxies []string `json:"trustedProxies"` // addresses or ranges allowed to set X-Forwarded-For, i.e. the Caddy front end
+}
+
+// SecretsConfig defines the secret stores the secret references of the configuration are read from, see LoadConfig
+type SecretsConfig struct {
+	Vault VaultConfig `json:"vault"`
+}
+
+// VaultConfig defines the HashiCorp Vault compatible server "vault:" references are read from
+type VaultConfig struct {
+	Address   string    `json:"address"`   // defaults to the VAULT_ADDR environment variable
+	Token     string    `json:"token"`     // defaults to VAULT_TOKEN, can be a reference itself, i.e. "file:/run/secrets/vault-token"
+	Namespace string    `json:"namespace"` // defaults to VAULT_NAMESPACE
+	Timeout   *Duration `json:"timeout"`   // defaults to secrets.DefaultVaultTimeout
+}
+
+// AuthConfig defines the clients allowed to call the API, anyone can call it when there are none and JWTs aren't
+// accepted either
+type AuthConfig struct {
+	Clients []ClientConfig `json:"clients"`
+	JWT     JWTConfig      `json:"jwt"`
+}
+
+// ClientConfig defines a client of the API, how it authenticates and its limits, see auth.ClientOptions
+type ClientConfig struct {
+	Name              string    `json:"name" config:"required"`
+	APIKeyHashes      []string  `json:"apiKeyHashes"`      // the SHA-256 hashes of its API keys, i.e. `echo -n "$KEY" | sha256sum`
+	TokenSecret       string    `json:"tokenSecret"`       // signs its tokens, see auth.SignToken
+	Scopes            []string  `json:"scopes"`            // read and/or admin, defaults to read
+	Backends          []string  `json:"backends"`          // the backends it can use, every one by default
+	RequestsPerSecond float64   `json:"requestsPerSecond"` // unlimited by default
+	Burst             int       `json:"burst"`             // defaults to requestsPerSecond rounded up
+	Quota             int       `json:"quota"`             // requests per quotaPeriod, unlimited by default
+	QuotaPeriod       *Duration `json:"quotaPeriod"`       // defaults to auth.DefaultQuotaPeriod
+}
+
+// JWTConfig defines the JWTs of the identity provider users log in with (i.e. the users of the UI), see
+// auth.JWTOptions. JWTs aren't accepted when there is no issuer.
+type JWTConfig struct {
+	Issuer          string    `json:"issuer"`          // i.e. "https://login.example.com/realms/weather"
+	Audience        string    `json:"audience"`        // required along with the issuer
+	JWKSURL         string    `json:"jwksUrl"`         // defaults to the jwks_uri of the OpenID configuration of the issuer
+	ReadScope       string    `json:"readScope"`       // defaults to auth.DefaultReadScope
+	AdminScope      string    `json:"adminScope"`      // defaults to auth.DefaultAdminScope
+	RefreshInterval *Duration `json:"refreshInterval"` // how long the keys are cached, defaults to auth.DefaultRefreshInterval
+	Leeway          *Duration `json:"leeway"`          // defaults to auth.DefaultLeeway
+
+	// the backends and limits of every user, like the ones of ClientConfig: each user has a rate limit and a quota of
+	// their own
+	Backends          []string  `json:"backends"`
+	RequestsPerSecond float64   `json:"requestsPerSecond"`
+	Burst             int       `json:"burst"`
+	Quota             int       `json:"quota"`
+	QuotaPeriod       *Duration `json:"quotaPeriod"`
+}
+
+// configureBackends creates the backends with an API key, every one of them is a default backend for cases where none is
+// specified
+func (s *Server) configureBackends(config *Config) error {
+	if s.httpClient == nil {
+		timeout := DefaultBackendTimeout
+		if config.BackendTimeout != nil {
+			timeout = config.BackendTimeout.Duration
+		}
+		if timeout <= 0 {
+			return errors.New("Backend timeout must be greater than 0")
+		}
+		s.httpClient = &http.Client{Timeout: timeout, Transport: &redact.Transport{Redactor: s.redactor}}
+	}
+	configured, defaults, err := backends.Configure(config.Backends, s.backendEnvironment())
+	if err != nil {
+		return err
+	}
+	s.setBackends(configured, defaults)
+	return nil
+}
+
+// backendEnvironment is what the backends are created with
+func (s *Server) backendEnvironment() backends.Environment {
+	return backends.Environment{Logger: s.logger, HTTPClient: s.httpClient}
+}
+
+func (s *Server) configureLocationResolver(config *Config) error {
+	s.locationMismatchKm = DefaultLocationMismatchKm
+	if config.Location.MismatchDistanceKm > 0 {
+		s.locationMismatchKm = config.Location.MismatchDistanceKm
+	}
+
+	resolver, err := location.LoadResolver(config.Location.GeoNamesFile, config.Location.Admin1CodesFile, config.Location.PostalCodesFile)
+	if err != nil {
+		return err
+	}
+	if config.Location.GeoNamesFile != "" {
+		s.logger.Info("loaded location dataset from ", config.Location.GeoNamesFile)
+	}
+	s.locationResolver = resolver
+	return nil
+}
+
+func (s *Server) configureGeoIP(config *Config) error {
+	trustedProxies, err := geoip.ParseTrustedProxies(config.GeoIP.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = trustedProxies
+
+	s.ipLocator = nil
+	if config.GeoIP.DatabaseFile == "" {
+		return nil
+	}
+	reader, err := geoip.Open(config.GeoIP.DatabaseFile)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("loaded GeoIP database from ", config.GeoIP.DatabaseFile)
+	s.ipLocator = reader
+	return nil
+}
+
+func (s *Server) configureCache(config *Config) {
+	ttl := DefaultCacheTTL
+	if config.Cache.TTL != nil {
+		ttl = config.Cache.TTL.Duration
+	}
+	s.weatherCache = cache.New(ttl, s.now, s.metrics)
+}
+
+func (s *Server) configureBatch(config *Config) {
+	s.batchMaxItems = DefaultBatchMaxItems
+	if config.Batch.MaxItems > 0 {
+		s.batchMaxItems = config.Batch.MaxItems
+	}
+	s.batchConcurrency = DefaultBatchConcurrency
+	if config.Batch.Concurrency > 0 {
+		s.batchConcurrency = config.Batch.Concurrency
+	}
+}
+
+func (s *Server) configureStream(config *Config) error {
+	s.streamHeartbeat = DefaultStreamHeartbeat
+	if config.Stream.Heartbeat != nil {
+		s.streamHeartbeat = config.Stream.Heartbeat.Duration
+	}
+	s.streamPollInterval = DefaultStreamPollInterval
+	if config.Stream.PollInterval != nil {
+		s.streamPollInterval = config.Stream.PollInterval.Duration
+	}
+	if s.streamHeartbeat <= 0 || s.streamPollInterval <= 0 {
+		return errors.New("Stream heartbeat and poll interval must be greater than 0")
+	}
+	s.streamBufferSize = DefaultStreamBufferSize
+	if config.Stream.BufferSize > 0 {
+		s.streamBufferSize = config.Stream.BufferSize
+	}
+	return nil
+}
+
+func (s *Server) configureWebSocket(config *Config) {
+	s.webSocketMessageRate = DefaultWebSocketMessageRate
+	if config.WebSocket.MessagesPerSecond > 0 {
+		s.webSocketMessageRate = config.WebSocket.MessagesPerSecond
+	}
+	s.webSocketMessageBurst = DefaultWebSocketMessageBurst
+	if config.WebSocket.MessageBurst > 0 {
+		s.webSocketMessageBurst = config.WebSocket.MessageBurst
+	}
+	s.webSocketMaxSubscriptions = DefaultWebSocketMaxSubscriptions
+	if config.WebSocket.MaxSubscriptions > 0 {
+		s.webSocketMaxSubscriptions = config.WebSocket.MaxSubscriptions
+	}
+}
+
+func (s *Server) configureHTTP(config *Config) error {
+	s.httpAddress = DefaultAddress
+	if config.HTTP.Address != "" {
+		s.httpAddress = config.HTTP.Address
+	}
+	s.allowedOrigins = DefaultAllowedOrigins
+	if len(config.HTTP.AllowedOrigins) > 0 {
+		s.allowedOrigins = config.HTTP.AllowedOrigins
+	}
+	s.readTimeout = 0
+	if config.HTTP.ReadTimeout != nil {
+		s.readTimeout = config.HTTP.ReadTimeout.Duration
+	}
+	s.shutdownTimeout = DefaultShutdownTimeout
+	if config.HTTP.ShutdownTimeout != nil {
+		s.shutdownTimeout = config.HTTP.ShutdownTimeout.Duration
+	}
+	if s.readTimeout < 0 || s.shutdownTimeout <= 0 {
+		return errors.New("HTTP read timeout can't be negative and shutdown timeout must be greater than 0")
+	}
+	return nil
+}
+
+// logLevels are the levels the log can be configured with
+var logLevels = map[string]log.Lvl{
+	"debug": log.DEBUG,
+	"info":  log.INFO,
+	"warn":  log.WARN,
+	"error": log.ERROR,
+	"off":   log.OFF,
+}
+
+func (s *Server) configureLog(config *Config) error {
+	if config.Log.Level == "" {
+		return nil
+	}
+	level, ok := logLevels[strings.ToLower(config.Log.Level)]
+	if !ok {
+		return errors.New("Invalid log level: " + config.Log.Level)
+	}
+	s.logger.SetLevel(level)
+	return nil
+}
+
+func (s *Server) configureGRPC(config *Config) {
+	s.grpcAddress = DefaultGRPCAddress
+	if config.GRPC.Address != "" {
+		s.grpcAddress = config.GRPC.Address
+	}
+}
+
+// configure creates the backends and loads the datasets of the configuration, anything that isn't configured gets
+// its default. The backends are closed again when the rest of the configuration fails, so that no plugin outlives it.
+func (s *Server) configure(config *Config) (err error) {
+	s.redactor.Add(secretValues(config)...)
+	err = s.configureLog(config)
+	if err != nil {
+		return err
+	}
+
+	err = s.configureBackends(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			s.closeBackends(s.backends())
+		}
+	}()
+
+	err = s.configureLocationResolver(config)
+	if err != nil {
+		return err
+	}
+
+	err = s.configureGeoIP(config)
+	if err != nil {
+		return err
+	}
+
+	s.configureCache(config)
+	s.configureBatch(config)
+
+	err = s.configureStream(config)
+	if err != nil {
+		return err
+	}
+	s.configureWebSocket(config)
+	s.configureGRPC(config)
+
+	err = s.configureAuth(config)
+	if err != nil {
+		return err
+	}
+
+	return s.configureHTTP(config)
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/backends/accuweather"
+	"go-weather-app/server/backends/openweathermap"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configureBackends(t *testing.T) {
+	logger, httpClient := echo.New().Logger, &http.Client{}
+	tests := []struct {
+		name                       string
+		config                     *Config
+		expectedConfiguredBackends map[string]types.WeatherBackend
+		expectedDefaultBackends    []string
+		expectedErr                error
+	}{
+		{
+			name:                       "no configured backends returns error",
+			config:                     &Config{},
+			expectedConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedDefaultBackends:    []string{},
+			expectedErr:                errors.New("No weather backends configured"),
+		},
+		{
+			name: "configure accuweather",
+			config: &Config{
+				Backends: backends.Config{
+					{Type: accuweather.Type, Options: json.RawMessage(`{"apiKey": "foo"}`)},
+				},
+			},
+			expectedConfiguredBackends: map[string]types.WeatherBackend{
+				accuweather.Type: accuweather.Accuweather{
+					Name:       accuweather.Type,
+					APIKey:     "foo",
+					Logger:     logger,
+					HTTPClient: httpClient,
+				},
+			},
+			expectedDefaultBackends: []string{accuweather.Type},
+			expectedErr:             nil,
+		},
+		{
+			name: "configure openweathermap",
+			config: &Config{
+				Backends: backends.Config{
+					{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "foo"}`)},
+				},
+			},
+			expectedConfiguredBackends: map[string]types.WeatherBackend{
+				openweathermap.Type: openweathermap.Openweathermap{
+					Name:       openweathermap.Type,
+					APIKey:     "foo",
+					Logger:     logger,
+					HTTPClient: httpClient,
+				},
+			},
+			expectedDefaultBackends: []string{openweathermap.Type},
+			expectedErr:             nil,
+		},
+		{
+			name: "configure openweathermap and accuweather under another name",
+			config: &Config{
+				Backends: backends.Config{
+					{Type: accuweather.Type, Options: json.RawMessage(`{"apiKey": "bar"}`)},
+					{Name: "owm", Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "foo"}`)},
+				},
+			},
+			expectedConfiguredBackends: map[string]types.WeatherBackend{
+				accuweather.Type: accuweather.Accuweather{
+					Name:       accuweather.Type,
+					APIKey:     "bar",
+					Logger:     logger,
+					HTTPClient: httpClient,
+				},
+				"owm": openweathermap.Openweathermap{
+					Name:       "owm",
+					APIKey:     "foo",
+					Logger:     logger,
+					HTTPClient: httpClient,
+				},
+			},
+			expectedDefaultBackends: []string{accuweather.Type, "owm"},
+			expectedErr:             nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, WithHTTPClient(httpClient))
+			s.logger = logger
+			err := s.configureBackends(tc.config)
+			require.Equal(t, tc.expectedErr, err)
+
+			require.Equal(t, tc.expectedConfiguredBackends, s.backends().configured)
+			require.Equal(t, tc.expectedDefaultBackends, s.backends().defaults)
+		})
+	}
+}
+
+func Test_configureLocationResolver(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       *Config
+		query        string
+		expectedName string
+		expectedErr  bool
+	}{
+		{
+			name:         "no dataset configured passes locations through",
+			config:       &Config{},
+			query:        "Springfield, IL, US",
+			expectedName: "Springfield, IL, US",
+		},
+		{
+			name: "geonames dataset resolves canonical locations",
+			config: &Config{
+				Location: LocationConfig{
+					GeoNamesFile:    "../location/testdata/cities.txt",
+					Admin1CodesFile: "../location/testdata/admin1CodesASCII.txt",
+					PostalCodesFile: "../location/testdata/postalCodes.txt",
+				},
+			},
+			query:        "springfield, il",
+			expectedName: "Springfield, Illinois, US",
+		},
+		{
+			name: "missing dataset returns error",
+			config: &Config{
+				Location: LocationConfig{
+					GeoNamesFile: "../location/testdata/does-not-exist.txt",
+				},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+
+			err := s.configureLocationResolver(tc.config)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			loc, err := s.locationResolver.Resolve(tc.query)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedName, loc.String())
+		})
+	}
+}
+
+func Test_configureGeoIP(t *testing.T) {
+	tests := []struct {
+		name                string
+		config              *Config
+		expectedErr         bool
+		expectedIPLocator   bool
+		expectedTrustedSize int
+	}{
+		{
+			name:   "nothing configured",
+			config: &Config{},
+		},
+		{
+			name:                "trusted proxies without a database",
+			config:              &Config{GeoIP: GeoIPConfig{TrustedProxies: []string{"172.16.0.0/12", "10.0.0.1"}}},
+			expectedTrustedSize: 2,
+		},
+		{
+			name:        "invalid trusted proxy returns error",
+			config:      &Config{GeoIP: GeoIPConfig{TrustedProxies: []string{"foo"}}},
+			expectedErr: true,
+		},
+		{
+			name:        "missing database returns error",
+			config:      &Config{GeoIP: GeoIPConfig{DatabaseFile: "../geoip/testdata/does-not-exist.mmdb"}},
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+
+			err := s.configureGeoIP(tc.config)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedIPLocator, s.ipLocator != nil)
+			require.Len(t, s.trustedProxies, tc.expectedTrustedSize)
+		})
+	}
+}
+
+func Test_configureCacheAndBatch(t *testing.T) {
+	s := newTestServer(t)
+
+	config := &Config{}
+	err := json.Unmarshal([]byte("{\"cache\":{\"ttl\":\"30s\"},\"batch\":{\"maxItems\":10,\"concurrency\":2}}"), config)
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, config.Cache.TTL.Duration)
+
+	s.configureCache(config)
+	s.configureBatch(config)
+	require.Equal(t, 10, s.batchMaxItems)
+	require.Equal(t, 2, s.batchConcurrency)
+
+	s.configureBatch(&Config{})
+	require.Equal(t, DefaultBatchMaxItems, s.batchMaxItems)
+	require.Equal(t, DefaultBatchConcurrency, s.batchConcurrency)
+
+	err = json.Unmarshal([]byte("{\"cache\":{\"ttl\":\"soon\"}}"), &Config{})
+	require.EqualError(t, err, "time: invalid duration \"soon\"")
+	err = json.Unmarshal([]byte("{\"cache\":{\"ttl\":30}}"), &Config{})
+	require.EqualError(t, err, "Durations must be strings, i.e. \"5m\"")
+}
+
+func Test_configureStream(t *testing.T) {
+	s := newTestServer(t)
+
+	config := &Config{}
+	err := json.Unmarshal([]byte("{\"stream\":{\"heartbeat\":\"5s\",\"pollInterval\":\"30s\",\"bufferSize\":4}}"), config)
+	require.NoError(t, err)
+	require.NoError(t, s.configureStream(config))
+	require.Equal(t, 5*time.Second, s.streamHeartbeat)
+	require.Equal(t, 30*time.Second, s.streamPollInterval)
+	require.Equal(t, 4, s.streamBufferSize)
+
+	require.NoError(t, s.configureStream(&Config{}))
+	require.Equal(t, DefaultStreamHeartbeat, s.streamHeartbeat)
+	require.Equal(t, DefaultStreamPollInterval, s.streamPollInterval)
+	require.Equal(t, DefaultStreamBufferSize, s.streamBufferSize)
+
+	err = json.Unmarshal([]byte("{\"stream\":{\"heartbeat\":\"0s\"}}"), config)
+	require.NoError(t, err)
+	require.EqualError(t, s.configureStream(config), "Stream heartbeat and poll interval must be greater than 0")
+}
+
+func Test_configureHTTPAndLog(t *testing.T) {
+	s := newTestServer(t)
+
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{"http": {"address": ":8000", "allowedOrigins": ["https://weather.example.com"], "readTimeout": "5s", "shutdownTimeout": "1m"}, "log": {"level": "WARN"}}`), config)
+	require.NoError(t, err)
+	require.NoError(t, s.configureHTTP(config))
+	require.NoError(t, s.configureLog(config))
+	require.Equal(t, &http.Server{Addr: ":8000", Handler: s, ReadTimeout: 5 * time.Second}, s.HTTPServer())
+	require.Equal(t, time.Minute, s.ShutdownTimeout())
+	require.Equal(t, []string{"https://weather.example.com"}, s.allowedOrigins)
+	require.Equal(t, log.WARN, s.logger.Level())
+
+	require.NoError(t, s.configureHTTP(&Config{}))
+	require.Equal(t, &http.Server{Addr: DefaultAddress, Handler: s}, s.HTTPServer())
+	require.Equal(t, DefaultShutdownTimeout, s.ShutdownTimeout())
+	require.Equal(t, DefaultAllowedOrigins, s.allowedOrigins)
+
+	require.EqualError(t, s.configureHTTP(&Config{HTTP: HTTPConfig{ShutdownTimeout: &Duration{}}}), "HTTP read timeout can't be negative and shutdown timeout must be greater than 0")
+	require.EqualError(t, s.configureLog(&Config{Log: LogConfig{Level: "verbose"}}), "Invalid log level: verbose")
+}
+
+func Test_LoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"config.json": `{"backends": [{"name": "eu", "type": "openweathermap", "apiKey": "foo"}], "cache": {"ttl": "1m"}}`,
+		"config.yaml": "backends:\n- name: eu\n  type: openweathermap\n  apiKey: foo\ncache:\n  ttl: 1m\n",
+		"config.toml": "[[backends]]\nname = \"eu\"\ntype = \"openweathermap\"\napiKey = \"foo\"\n\n[cache]\nttl = \"1m\"\n",
+		"legacy.json": `{"backends": {"accuweather": {"apiKey": "foo"}}}`,
+		"config.ini":  "",
+		"empty.yaml":  "",
+		"bad.toml":    "backends = [",
+		"bad.json":    `{"backends": [{"type": "accuweather", "apiKey": "foo"}], "cache": {"ttl": 60}}`,
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	minute := &Duration{Duration: time.Minute}
+
+	tests := []struct {
+		name           string
+		file           string
+		environ        []string
+		expectedConfig *Config
+		expectedErr    string
+	}{
+		{
+			name:           "json",
+			file:           "config.json",
+			expectedConfig: &Config{Backends: backends.Config{{Name: "eu", Type: "openweathermap", Options: json.RawMessage(`{"apiKey":"foo","name":"eu","type":"openweathermap"}`)}}, Cache: CacheConfig{TTL: minute}},
+		},
+		{
+			name:           "yaml",
+			file:           "config.yaml",
+			expectedConfig: &Config{Backends: backends.Config{{Name: "eu", Type: "openweathermap", Options: json.RawMessage(`{"apiKey":"foo","name":"eu","type":"openweathermap"}`)}}, Cache: CacheConfig{TTL: minute}},
+		},
+		{
+			name:           "toml",
+			file:           "config.toml",
+			expectedConfig: &Config{Backends: backends.Config{{Name: "eu", Type: "openweathermap", Options: json.RawMessage(`{"apiKey":"foo","name":"eu","type":"openweathermap"}`)}}, Cache: CacheConfig{TTL: minute}},
+		},
+		{
+			name: "environment variables override the file, in any case",
+			file: "config.json",
+			environ: []string{
+				"WEATHER_BACKENDS_EU_APIKEY=bar",
+				"WEATHER_CACHE_TTL=30s",
+				"weather_http_address=:1234", // not ours
+				"WEATHER_CLIENT_API_KEY=foo", // the command line client's
+				"WEATHER_HTTP_ALLOWEDORIGINS=https://a.example.com, https://b.example.com",
+				"WEATHER_WEBSOCKET_MESSAGESPERSECOND=2.5",
+				"HOME=/root",
+			},
+			expectedConfig: &Config{
+				HTTP:      HTTPConfig{AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"}},
+				Backends:  backends.Config{{Name: "eu", Type: "openweathermap", Options: json.RawMessage(`{"apiKey":"bar","name":"eu","type":"openweathermap"}`)}},
+				Cache:     CacheConfig{TTL: &Duration{Duration: 30 * time.Second}},
+				WebSocket: WebSocketConfig{MessagesPerSecond: 2.5},
+			},
+		},
+		{
+			name:    "environment variables add backends",
+			environ: []string{"WEATHER_BACKENDS_ACCUWEATHER_APIKEY=12345", "WEATHER_BATCH_MAXITEMS=10"},
+			expectedConfig: &Config{
+				Backends: backends.Config{{Type: "accuweather", Options: json.RawMessage(`{"apiKey":"12345","type":"accuweather"}`)}},
+				Batch:    BatchConfig{MaxItems: 10},
+			},
+		},
+		{
+			name:    "environment variables override the object older versions used",
+			file:    "legacy.json",
+			environ: []string{"WEATHER_BACKENDS_ACCUWEATHER_APIKEY=bar"},
+			expectedConfig: &Config{
+				Backends: backends.Config{{Type: "accuweather", Options: json.RawMessage(`{"apiKey":"bar"}`)}},
+			},
+		},
+		{
+			name:    "empty file",
+			file:    "empty.yaml",
+			environ: []string{"WEATHER_BACKENDS_ACCUWEATHER_APIKEY=foo"},
+			expectedConfig: &Config{
+				Backends: backends.Config{{Type: "accuweather", Options: json.RawMessage(`{"apiKey":"foo","type":"accuweather"}`)}},
+			},
+		},
+		{
+			name:        "unknown setting returns error",
+			environ:     []string{"WEATHER_CACHE_SIZE=10"},
+			expectedErr: "Invalid environment variable WEATHER_CACHE_SIZE: unknown setting: SIZE",
+		},
+		{
+			name:        "unknown backend option returns error",
+			file:        "config.json",
+			environ:     []string{"WEATHER_BACKENDS_EU_REGION=eu"},
+			expectedErr: "Invalid environment variable WEATHER_BACKENDS_EU_REGION: unknown setting: REGION",
+		},
+		{
+			name:        "unknown backend type returns error",
+			environ:     []string{"WEATHER_BACKENDS_OTHER_APIKEY=foo"},
+			expectedErr: "Invalid environment variable WEATHER_BACKENDS_OTHER_APIKEY: unknown backend type: other",
+		},
+		{
+			name:        "invalid value returns error",
+			environ:     []string{"WEATHER_BATCH_MAXITEMS=many"},
+			expectedErr: "Invalid environment variable WEATHER_BATCH_MAXITEMS: invalid value: many",
+		},
+		{
+			name:        "unknown format returns error",
+			file:        "config.ini",
+			expectedErr: "Unknown config file format, expected .json, .yaml, .yml or .toml: " + filepath.Join(dir, "config.ini"),
+		},
+		{
+			name:        "invalid file returns error",
+			file:        "bad.toml",
+			expectedErr: "Unable to parse config file " + filepath.Join(dir, "bad.toml") + ": Near line 1 (last key parsed 'backends'): expected value but found '\\x00' instead",
+		},
+		{
+			name:        "invalid settings return error",
+			file:        "bad.json",
+			expectedErr: "Invalid configuration: " + filepath.Join(dir, "bad.json") + ":1:68: cache.ttl: expected a duration string, i.e. \"5m\" or \"30s\", got a number (60)",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := ""
+			if tc.file != "" {
+				file = filepath.Join(dir, tc.file)
+			}
+			config, err := LoadConfig(file, tc.environ)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedConfig, config)
+		})
+	}
+}
+
+func Test_RedactConfig(t *testing.T) {
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{"backends": [{"type": "accuweather", "apiKey": "foo"}, {"name": "eu", "type": "federated", "url": "https://eu.weather.example.com", "token": "bar"}], "cache": {"ttl": "1m"}, "secrets": {"vault": {"address": "https://vault.example.com", "token": "baz"}}, "auth": {"clients": [{"name": "ui", "tokenSecret": "qux", "quota": 1000}]}}`), config)
+	require.NoError(t, err)
+
+	b, err := RedactConfig(config)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "foo")
+	require.NotContains(t, string(b), "bar")
+	require.NotContains(t, string(b), "baz")
+	require.NotContains(t, string(b), "qux")
+	require.JSONEq(t, `{
+		"http": {"address": ":8080", "allowedOrigins": ["http://localhost", "http://localhost:3000"], "readTimeout": "0s", "shutdownTimeout": "10s"},
+		"log": {"level": ""},
+		"backends": [
+			{"type": "accuweather", "apiKey": "REDACTED"},
+			{"name": "eu", "type": "federated", "url": "https://eu.weather.example.com", "token": "REDACTED"}
+		],
+		"backendTimeout": "10s",
+		"location": {"geonamesFile": "", "admin1CodesFile": "", "postalCodesFile": "", "mismatchDistanceKm": 50},
+		"geoip": {"databaseFile": "", "trustedProxies": []},
+		"cache": {"ttl": "1m0s"},
+		"batch": {"maxItems": 100, "concurrency": 8},
+		"stream": {"heartbeat": "15s", "pollInterval": "1m0s", "bufferSize": 16},
+		"websocket": {"messagesPerSecond": 5, "messageBurst": 20, "maxSubscriptions": 50},
+		"grpc": {"address": ":9090"},
+		"secrets": {"vault": {"address": "https://vault.example.com", "token": "REDACTED", "namespace": "", "timeout": "10s"}},
+		"auth": {
+			"clients": [{"name": "ui", "apiKeyHashes": null, "tokenSecret": "REDACTED", "scopes": ["read"], "backends": null, "requestsPerSecond": 0, "burst": 0, "quota": 1000, "quotaPeriod": "24h0m0s"}],
+			"jwt": {"issuer": "", "audience": "", "jwksUrl": "", "readScope": "weather:read", "adminScope": "weather:admin", "refreshInterval": "1h0m0s", "leeway": "1m0s", "backends": null, "requestsPerSecond": 0, "burst": 0, "quota": 0, "quotaPeriod": null}
+		}
+	}`, string(b))
+	require.Contains(t, string(config.Backends[0].Options), "foo", "the config itself keeps its secrets")
+}
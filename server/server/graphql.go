@@ -0,0 +1,518 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weather-app/server/auth"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/dataloader"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/labstack/echo/v4"
+)
+
+const graphQLSchemaString = `
+schema {
+  query: Query
+}
+
+type Query {
+  # The configured backends, sorted by name
+  backends: [Backend!]!
+  # Resolves a city (any format /v1/weather/{city} accepts) to a location. Use aliases to ask about many cities at once,
+  # a query can get the weather and forecasts of as many locations as a batch request has items.
+  location(city: String!): Location
+  # Ranked locations matching the query, i.e. for autocomplete. The limit defaults to 10, and is at most 50.
+  searchLocations(query: String!, limit: Int): [Location!]!
+}
+
+type Backend {
+  name: String!
+  # Whether the backend is used when no backends are requested
+  isDefault: Boolean!
+  supportsForecasts: Boolean!
+  # How the last call to the backend went
+  status: BackendStatus!
+  # Set when the last call failed
+  lastError: String
+  # When the backend was last called, in RFC 3339 format
+  lastChecked: String
+}
+
+enum BackendStatus {
+  # Not called since the server started
+  UNKNOWN
+  OK
+  ERROR
+}
+
+type Location {
+  name: String!
+  adminRegion: String
+  country: String
+  postalCode: String
+  coordinates: Coordinates
+  timezone: String
+  # The current conditions from each backend, defaults to the default backends
+  weather(backends: [String!]): [Weather!]!
+  # The daily forecast from each backend, defaults to the default backends
+  forecast(backends: [String!]): [Forecast!]!
+}
+
+type Coordinates {
+  latitude: Float!
+  longitude: Float!
+}
+
+# The location a backend actually returned data for
+type ResolvedLocation {
+  name: String!
+  adminRegion: String
+  country: String
+  postalCode: String
+  coordinates: Coordinates
+  providerLocationId: String
+}
+
+type Weather {
+  source: String!
+  # Temperatures are in degrees Celsius, and null when the backend failed
+  temperature: Float
+  temperatureMin: Float
+  temperatureMax: Float
+  mainDescription: String
+  detailedDescription: String
+  location: ResolvedLocation
+  error: String
+}
+
+type Forecast {
+  source: String!
+  days: [ForecastDay!]!
+  location: ResolvedLocation
+  error: String
+}
+
+type ForecastDay {
+  # i.e. "2019-06-01", in the location's local time
+  date: String!
+  temperatureMin: Float!
+  temperatureMax: Float!
+  mainDescription: String
+  detailedDescription: String
+}
+`
+
+// graphQLMaxDepth keeps queries from nesting deeper than the schema allows anyway
+const graphQLMaxDepth = 8
+
+// graphQLBatchWait is how long backend calls are collected before being fetched together
+const graphQLBatchWait = 2 * time.Millisecond
+
+// GraphQLRequest defines the json request body of /graphql
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+func (s *Server) postGraphQL(c echo.Context) error {
+	request := &GraphQLRequest{}
+	err := json.NewDecoder(c.Request().Body).Decode(request)
+	if err != nil {
+		response := &graphql.Response{Errors: []*gqlerrors.QueryError{gqlerrors.Errorf("Unable to parse request body: %s", err)}}
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	ctx := context.WithValue(c.Request().Context(), graphQLLoadersKey{}, s.newGraphQLLoaders())
+	response := s.graphQLSchema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
+
+// graphQLLoaders batch and dedupe the backend calls of a single query, i.e. the same city asked about under two
+// aliases is only fetched once, and many cities are fetched together with the same limits as batch requests
+type graphQLLoaders struct {
+	weather  *dataloader.Loader
+	forecast *dataloader.Loader
+
+	// locations are the locations the query asked the backends about, at most maxLocations of them: aliases and
+	// searches would otherwise let a single query fan out to any number of backend calls
+	mu           sync.Mutex
+	locations    map[string]bool
+	maxLocations int
+	// takeRequests counts the locations after the first against the limits of the client, like the items of a batch
+	takeRequests func(ctx context.Context, n int) *auth.LimitError
+}
+
+// admit counts the location against the locations of the query and the limits of the client, failing once there are
+// too many
+func (l *graphQLLoaders) admit(ctx context.Context, loc location.Location) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locations[loc.Key()] {
+		return nil
+	}
+	if len(l.locations) >= l.maxLocations {
+		return errors.New("Too many locations in the query (the maximum is " + strconv.Itoa(l.maxLocations) + ")")
+	}
+	if len(l.locations) > 0 {
+		if err := l.takeRequests(ctx, 1); err != nil {
+			return err
+		}
+	}
+	l.locations[loc.Key()] = true
+	return nil
+}
+
+type graphQLLoadersKey struct{}
+
+// graphQLLoadersFromContext returns the loaders of the query being executed, which postGraphQL adds to its context
+func graphQLLoadersFromContext(ctx context.Context) (*graphQLLoaders, error) {
+	loaders, ok := ctx.Value(graphQLLoadersKey{}).(*graphQLLoaders)
+	if !ok {
+		return nil, errors.New("No data loaders found for the query")
+	}
+	return loaders, nil
+}
+
+// backendLocationKey identifies the data of a backend for a location
+type backendLocationKey struct {
+	backend string
+	loc     location.Location
+}
+
+func (k backendLocationKey) String() string {
+	return cache.Key(k.backend, k.loc)
+}
+
+func (s *Server) newGraphQLLoaders() *graphQLLoaders {
+	return &graphQLLoaders{
+		weather: dataloader.New(func(keys []dataloader.Key) []interface{} {
+			return s.loadBackendBatch(keys, func(key backendLocationKey) interface{} {
+				return s.fetchBackendWeather(key.backend, key.loc)
+			})
+		}, graphQLBatchWait, s.batchMaxItems),
+		forecast: dataloader.New(func(keys []dataloader.Key) []interface{} {
+			return s.loadBackendBatch(keys, func(key backendLocationKey) interface{} {
+				return s.fetchBackendForecast(key.backend, key.loc)
+			})
+		}, graphQLBatchWait, s.batchMaxItems),
+		locations:    map[string]bool{},
+		maxLocations: s.batchMaxItems,
+		takeRequests: s.takeRequests,
+	}
+}
+
+// loadBackendBatch fetches the keys concurrently, but never more than the batch concurrency at a time like batch requests
+func (s *Server) loadBackendBatch(keys []dataloader.Key, fetch func(key backendLocationKey) interface{}) []interface{} {
+	values := make([]interface{}, len(keys))
+	semaphore := make(chan struct{}, s.batchConcurrency)
+	wg := sync.WaitGroup{}
+	for i, key := range keys {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, key backendLocationKey) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			// graphql-go only recovers the panics of resolvers, the value is left unset for them to report instead
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					s.logger.Error("GraphQL data of ", key, " panicked: ", recovered, "\n", string(debug.Stack()))
+				}
+			}()
+			values[i] = fetch(key)
+		}(i, key.(backendLocationKey))
+	}
+	wg.Wait()
+	return values
+}
+
+// loadBackendData asks the loader about the location for each backend, returning the backends and their data in order
+func (s *Server) loadBackendData(ctx context.Context, loader *dataloader.Loader, loc location.Location, backends *[]string) ([]string, []interface{}, error) {
+	requested := []string{}
+	if backends != nil {
+		requested = *backends
+	}
+	targetBackends, err := s.requestedBackends(ctx, requested)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]dataloader.Key, len(targetBackends))
+	for i, backend := range targetBackends {
+		keys[i] = backendLocationKey{backend: backend, loc: loc}
+	}
+	return targetBackends, loader.LoadMany(keys), nil
+}
+
+// graphQLResolver resolves the queries of a single server
+type graphQLResolver struct {
+	s *Server
+}
+
+func (r *graphQLResolver) Backends() []*graphQLBackend {
+	set := r.s.backends()
+	isDefault := map[string]bool{}
+	for _, backend := range set.defaults {
+		isDefault[backend] = true
+	}
+
+	backends := []*graphQLBackend{}
+	for _, name := range set.names {
+		_, supportsForecasts := set.configured[name].(types.ForecastBackend)
+		backend := &graphQLBackend{Name: name, IsDefault: isDefault[name], SupportsForecasts: supportsForecasts, Status: "UNKNOWN"}
+		if status, ok := r.s.backendStatuses.Get(name); ok {
+			backend.Status = "OK"
+			if status.LastError != "" {
+				backend.Status = "ERROR"
+				backend.LastError = &status.LastError
+			}
+			lastChecked := status.LastChecked.Format(time.RFC3339)
+			backend.LastChecked = &lastChecked
+		}
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+func (r *graphQLResolver) Location(ctx context.Context, args struct{ City string }) (*graphQLLocation, error) {
+	city := strings.TrimSpace(args.City)
+	if len(city) == 0 {
+		return nil, errors.New("No city specified. Please provide a city.")
+	}
+
+	loc, err := r.s.locationResolver.Resolve(city)
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLLocation{s: r.s, loc: loc}, nil
+}
+
+func (r *graphQLResolver) SearchLocations(ctx context.Context, args struct {
+	Query string
+	Limit *int32
+}) ([]*graphQLLocation, error) {
+	limit := location.DefaultSearchLimit
+	if args.Limit != nil {
+		if *args.Limit <= 0 {
+			return nil, errors.New("Invalid limit specified: " + strconv.Itoa(int(*args.Limit)))
+		}
+		limit = int(*args.Limit)
+	}
+
+	locations := []*graphQLLocation{}
+	candidates, err := r.s.locationResolver.Search(strings.TrimSpace(args.Query), limit)
+	if err == location.ErrNotFound {
+		return locations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		locations = append(locations, &graphQLLocation{s: r.s, loc: candidate.Location})
+	}
+	return locations, nil
+}
+
+type graphQLBackend struct {
+	Name              string
+	IsDefault         bool
+	SupportsForecasts bool
+	Status            string
+	LastError         *string
+	LastChecked       *string
+}
+
+type graphQLLocation struct {
+	s   *Server
+	loc location.Location
+}
+
+func (l *graphQLLocation) Name() string {
+	return l.loc.Name
+}
+
+func (l *graphQLLocation) AdminRegion() *string {
+	return optionalString(l.loc.AdminRegion)
+}
+
+func (l *graphQLLocation) Country() *string {
+	return optionalString(l.loc.Country)
+}
+
+func (l *graphQLLocation) PostalCode() *string {
+	return optionalString(l.loc.PostalCode)
+}
+
+func (l *graphQLLocation) Coordinates() *graphQLCoordinates {
+	return newGraphQLCoordinates(l.loc.Coordinates)
+}
+
+func (l *graphQLLocation) Timezone() *string {
+	return optionalString(l.loc.Timezone)
+}
+
+func (l *graphQLLocation) Weather(ctx context.Context, args struct{ Backends *[]string }) ([]*graphQLWeather, error) {
+	loaders, err := graphQLLoadersFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := loaders.admit(ctx, l.loc); err != nil {
+		return nil, err
+	}
+	backends, values, err := l.s.loadBackendData(ctx, loaders.weather, l.loc, args.Backends)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []*graphQLWeather{}
+	for i, value := range values {
+		weather, ok := value.(types.Weather)
+		if !ok {
+			weather = types.WeatherError(types.ErrUnavailable)
+			weather.Source = backends[i]
+		}
+		data = append(data, newGraphQLWeather(weather))
+	}
+	return data, nil
+}
+
+func (l *graphQLLocation) Forecast(ctx context.Context, args struct{ Backends *[]string }) ([]*graphQLForecast, error) {
+	loaders, err := graphQLLoadersFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := loaders.admit(ctx, l.loc); err != nil {
+		return nil, err
+	}
+	backends, values, err := l.s.loadBackendData(ctx, loaders.forecast, l.loc, args.Backends)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []*graphQLForecast{}
+	for i, value := range values {
+		forecast, ok := value.(types.Forecast)
+		if !ok {
+			forecast = types.ForecastError(types.ErrUnavailable)
+			forecast.Source = backends[i]
+		}
+		data = append(data, newGraphQLForecast(forecast))
+	}
+	return data, nil
+}
+
+type graphQLCoordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func newGraphQLCoordinates(coordinates *location.Coordinates) *graphQLCoordinates {
+	if coordinates == nil {
+		return nil
+	}
+	return &graphQLCoordinates{Latitude: coordinates.Latitude, Longitude: coordinates.Longitude}
+}
+
+type graphQLResolvedLocation struct {
+	Name               string
+	AdminRegion        *string
+	Country            *string
+	PostalCode         *string
+	Coordinates        *graphQLCoordinates
+	ProviderLocationID *string
+}
+
+func newGraphQLResolvedLocation(resolved *types.ResolvedLocation) *graphQLResolvedLocation {
+	if resolved == nil {
+		return nil
+	}
+	return &graphQLResolvedLocation{
+		Name:               resolved.Name,
+		AdminRegion:        optionalString(resolved.AdminRegion),
+		Country:            optionalString(resolved.Country),
+		PostalCode:         optionalString(resolved.PostalCode),
+		Coordinates:        newGraphQLCoordinates(resolved.Coordinates),
+		ProviderLocationID: optionalString(resolved.ProviderLocationID),
+	}
+}
+
+type graphQLWeather struct {
+	Source              string
+	Temperature         *float64
+	TemperatureMin      *float64
+	TemperatureMax      *float64
+	MainDescription     *string
+	DetailedDescription *string
+	Location            *graphQLResolvedLocation
+	Error               *string
+}
+
+func newGraphQLWeather(weather types.Weather) *graphQLWeather {
+	if weather.Error != "" {
+		return &graphQLWeather{Source: weather.Source, Error: &weather.Error}
+	}
+	temperature, temperatureMin, temperatureMax := float64(weather.Temperature), float64(weather.TemperatureMin), float64(weather.TemperatureMax)
+	return &graphQLWeather{
+		Source:              weather.Source,
+		Temperature:         &temperature,
+		TemperatureMin:      &temperatureMin,
+		TemperatureMax:      &temperatureMax,
+		MainDescription:     optionalString(weather.MainDescription),
+		DetailedDescription: optionalString(weather.DetailedDescription),
+		Location:            newGraphQLResolvedLocation(weather.Location),
+	}
+}
+
+type graphQLForecast struct {
+	Source   string
+	Days     []*graphQLForecastDay
+	Location *graphQLResolvedLocation
+	Error    *string
+}
+
+type graphQLForecastDay struct {
+	Date                string
+	TemperatureMin      float64
+	TemperatureMax      float64
+	MainDescription     *string
+	DetailedDescription *string
+}
+
+func newGraphQLForecast(forecast types.Forecast) *graphQLForecast {
+	f := &graphQLForecast{
+		Source:   forecast.Source,
+		Days:     []*graphQLForecastDay{},
+		Location: newGraphQLResolvedLocation(forecast.Location),
+		Error:    optionalString(forecast.Error),
+	}
+	for _, day := range forecast.Days {
+		f.Days = append(f.Days, &graphQLForecastDay{
+			Date:                day.Date,
+			TemperatureMin:      float64(day.TemperatureMin),
+			TemperatureMax:      float64(day.TemperatureMax),
+			MainDescription:     optionalString(day.MainDescription),
+			DetailedDescription: optionalString(day.DetailedDescription),
+		})
+	}
+	return f
+}
+
+// optionalString maps empty strings to null
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weather-app/server/cache"
+	"go-weather-app/server/dataloader"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func execGraphQL(t *testing.T, s *Server, body string) (int, string) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, s.postGraphQL(e.NewContext(req, rec)))
+	return rec.Code, rec.Body.String()
+}
+
+func graphQLQuery(t *testing.T, query string) string {
+	b, err := json.Marshal(GraphQLRequest{Query: query})
+	require.NoError(t, err)
+	return string(b)
+}
+
+func Test_postGraphQL(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": mockForecastBackend{
+			mockWeatherBackend: mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12, TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}},
+			returnForecast:     types.Forecast{Source: "foo", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}}},
+		},
+		"bar": mockWeatherBackend{returnWeather: types.Weather{Error: "Error communicating to backend"}},
+	}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+	tests := []struct {
+		name               string
+		body               string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "backends before any call",
+			body:               graphQLQuery(t, "{ backends { name isDefault supportsForecasts status lastError } }"),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"data":{"backends":[{"name":"bar","isDefault":false,"supportsForecasts":false,"status":"UNKNOWN","lastError":null},{"name":"foo","isDefault":true,"supportsForecasts":true,"status":"UNKNOWN","lastError":null}]}}`,
+		},
+		{
+			name:               "weather and forecast for a location",
+			body:               graphQLQuery(t, `{ location(city: " foo, FR ") { name country weather(backends: ["foo", "bar"]) { source temperature mainDescription error } forecast { source days { date temperatureMax } } } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"data":{"location":{"name":"foo","country":"FR","weather":[{"source":"foo","temperature":12,"mainDescription":"Sunny","error":null},{"source":"bar","temperature":null,"mainDescription":null,"error":"Error communicating to backend"}],"forecast":[{"source":"foo","days":[{"date":"2019-06-01","temperatureMax":20}]}]}}}`,
+		},
+		{
+			name:               "backends after a call",
+			body:               graphQLQuery(t, "{ backends { name status lastError } }"),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"data":{"backends":[{"name":"bar","status":"ERROR","lastError":"Error communicating to backend"},{"name":"foo","status":"OK","lastError":null}]}}`,
+		},
+		{
+			name:               "invalid backend",
+			body:               graphQLQuery(t, `{ location(city: "foo") { weather(backends: ["baz"]) { source } } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"errors":[{"message":"Backend specified is invalid or inactive: baz","path":["location","weather"]}],"data":{"location":null}}`,
+		},
+		{
+			name:               "no city specified",
+			body:               graphQLQuery(t, `{ location(city: "") { name } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"errors":[{"message":"No city specified. Please provide a city.","path":["location"]}],"data":{"location":null}}`,
+		},
+		{
+			name:               "search locations",
+			body:               graphQLQuery(t, `{ searchLocations(query: "foo, FR", limit: 5) { name country } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"data":{"searchLocations":[{"name":"foo","country":"FR"}]}}`,
+		},
+		{
+			name:               "search locations with an invalid limit",
+			body:               graphQLQuery(t, `{ searchLocations(query: "foo", limit: 0) { name } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"errors":[{"message":"Invalid limit specified: 0","path":["searchLocations"]}],"data":null}`,
+		},
+		{
+			name:               "unknown field",
+			body:               graphQLQuery(t, `{ location(city: "foo") { population } }`),
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"errors":[{"message":"Cannot query field \"population\" on type \"Location\".","locations":[{"line":1,"column":27}]}]}`,
+		},
+		{
+			name:               "invalid body",
+			body:               "{",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       `{"errors":[{"message":"Unable to parse request body: unexpected EOF"}]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := execGraphQL(t, s, tc.body)
+			require.Equal(t, tc.expectedHTTPStatus, status)
+			require.JSONEq(t, tc.expectedBody, body)
+		})
+	}
+}
+
+func Test_postGraphQLBatching(t *testing.T) {
+	s := newTestServer(t)
+	backend := &countingWeatherBackend{}
+
+	s.setBackends(map[string]types.WeatherBackend{"foo": backend}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+	// the same city under two aliases is only fetched once per query, even without the cache
+	status, body := execGraphQL(t, s, graphQLQuery(t, `{
+		a: location(city: "ottawa") { weather { source } }
+		b: location(city: "ottawa") { weather { source } }
+		c: location(city: "paris") { weather { source } }
+	}`))
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"data":{"a":{"weather":[{"source":"foo"}]},"b":{"weather":[{"source":"foo"}]},"c":{"weather":[{"source":"foo"}]}}}`, body)
+	require.Equal(t, 2, backend.calls)
+
+	// but every query gets fresh data
+	execGraphQL(t, s, graphQLQuery(t, `{ location(city: "ottawa") { weather { source } } }`))
+	require.Equal(t, 3, backend.calls)
+}
+
+func Test_postGraphQLLimits(t *testing.T) {
+	s := newTestServer(t)
+	backend := &countingWeatherBackend{}
+	s.setBackends(map[string]types.WeatherBackend{"foo": backend}, []string{"foo"})
+	s.batchMaxItems = 2
+
+	// a query asks about as many locations as a batch request has items
+	status, body := execGraphQL(t, s, graphQLQuery(t, `{
+		a: location(city: "ottawa") { weather { source } }
+		b: location(city: "paris") { weather { source } }
+		c: location(city: "london") { weather { source } }
+	}`))
+	require.Equal(t, http.StatusOK, status)
+	response := struct {
+		Data   map[string]interface{}
+		Errors []struct{ Message string }
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &response))
+	rejected := 0
+	for _, data := range response.Data {
+		if data == nil {
+			rejected++
+		}
+	}
+	require.Equal(t, 1, rejected, "aliases are resolved concurrently, any of them could be the one rejected")
+	require.Len(t, response.Errors, 1)
+	require.Equal(t, "Too many locations in the query (the maximum is 2)", response.Errors[0].Message)
+	require.Equal(t, 2, backend.calls)
+
+	// asking again about the same location counts once
+	status, body = execGraphQL(t, s, graphQLQuery(t, `{
+		a: location(city: "ottawa") { weather { source } forecast { source } }
+		b: location(city: "ottawa") { weather { source } }
+	}`))
+	require.Equal(t, http.StatusOK, status)
+	require.NotContains(t, body, "errors")
+
+	status, body = execGraphQL(t, s, graphQLQuery(t, `{ searchLocations(query: "ottawa", limit: 100000) { name } }`))
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"data":{"searchLocations":[{"name":"ottawa"}]}}`, body)
+}
+
+func Test_loadBackendBatchPanic(t *testing.T) {
+	s := newTestServer(t)
+	keys := []dataloader.Key{backendLocationKey{backend: "foo", loc: location.Location{Name: "ottawa"}}, backendLocationKey{backend: "bar", loc: location.Location{Name: "ottawa"}}}
+	values := s.loadBackendBatch(keys, func(key backendLocationKey) interface{} {
+		if key.backend == "foo" {
+			panic("unexpected data")
+		}
+		return key.backend
+	})
+	require.Equal(t, []interface{}{nil, "bar"}, values, "a panic only leaves its own value unset")
+}
+
+func Test_graphQLWithoutLoaders(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{"foo": &countingWeatherBackend{}}, []string{"foo"})
+
+	// resolvers reached without going through postGraphQL fail the field rather than the server
+	response := s.graphQLSchema.Exec(context.Background(), `{ location(city: "ottawa") { weather { source } } }`, "", nil)
+	require.Len(t, response.Errors, 1)
+	require.Equal(t, "No data loaders found for the query", response.Errors[0].Message)
+}
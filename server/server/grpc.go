@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+	"go-weather-app/server/updates"
+	"go-weather-app/server/weatherpb"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// weatherService implements the gRPC API on top of the same backends, cache and updates hub as the REST handlers
+type weatherService struct {
+	s *Server
+}
+
+// GRPCServer creates a gRPC server for the gRPC API, to be served at GRPCAddress
+func (s *Server) GRPCServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(s.grpcUnaryInterceptor), grpc.StreamInterceptor(s.grpcStreamInterceptor))
+	weatherpb.RegisterWeatherServiceServer(server, weatherService{s: s})
+	return server
+}
+
+// grpcUnaryInterceptor authenticates the calls of the gRPC API, see grpcUnaryAuthInterceptor, and recovers their panics
+// like echo's Recover middleware does for the REST API: gRPC doesn't, and a panic would take the whole server down
+func (s *Server) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			resp, err = nil, s.grpcPanicked(info.FullMethod, recovered)
+		}
+	}()
+	return s.grpcUnaryAuthInterceptor(ctx, req, info, handler)
+}
+
+// grpcStreamInterceptor is grpcUnaryInterceptor for streams
+func (s *Server) grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = s.grpcPanicked(info.FullMethod, recovered)
+		}
+	}()
+	return s.grpcStreamAuthInterceptor(srv, stream, info, handler)
+}
+
+// grpcPanicked logs the panic of a gRPC call, returning the error to answer with
+func (s *Server) grpcPanicked(method string, recovered interface{}) error {
+	s.logger.Error("gRPC call ", method, " panicked: ", recovered, "\n", string(debug.Stack()))
+	return status.Error(codes.Internal, "Internal server error")
+}
+
+func (w weatherService) GetWeather(ctx context.Context, req *weatherpb.GetWeatherRequest) (*weatherpb.GetWeatherResponse, error) {
+	city, loc, targetBackends, err := w.s.resolveGRPCRequest(ctx, req.GetCity(), req.GetBackends())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &WeatherResponse{City: city}
+	w.s.fetchWeather(response, loc, targetBackends)
+	return weatherResponseToProto(response), nil
+}
+
+func (w weatherService) GetForecast(ctx context.Context, req *weatherpb.GetForecastRequest) (*weatherpb.GetForecastResponse, error) {
+	city, loc, targetBackends, err := w.s.resolveGRPCRequest(ctx, req.GetCity(), req.GetBackends())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ForecastResponse{City: city}
+	w.s.fetchForecast(response, loc, targetBackends)
+	resp := &weatherpb.GetForecastResponse{City: response.City, Location: locationToProto(response.Location)}
+	for _, forecast := range response.Data {
+		resp.Data = append(resp.Data, forecastToProto(forecast))
+	}
+	return resp, nil
+}
+
+func (w weatherService) ListBackends(ctx context.Context, req *weatherpb.ListBackendsRequest) (*weatherpb.ListBackendsResponse, error) {
+	return &weatherpb.ListBackendsResponse{Backends: w.s.backends().names}, nil
+}
+
+func (w weatherService) StreamWeather(req *weatherpb.StreamWeatherRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+	city, loc, targetBackends, err := w.s.resolveGRPCRequest(stream.Context(), req.GetCity(), req.GetBackends())
+	if err != nil {
+		return err
+	}
+
+	// unlike Last-Event-ID, 0 is never a valid event ID so it means there is nothing to resume from
+	lastEventID := req.GetLastEventId()
+	subscription := w.s.weatherUpdates.Subscribe(loc.Key(), w.s.streamBufferSize, lastEventID != 0, lastEventID)
+	defer subscription.Close()
+
+	isTarget := map[string]bool{}
+	for _, backend := range targetBackends {
+		isTarget[backend] = true
+	}
+
+	sentID := lastEventID
+	for _, update := range subscription.Missed {
+		if !isTarget[update.Weather.Source] {
+			continue
+		}
+		if err := stream.Send(readingToProto(update)); err != nil {
+			return err
+		}
+		sentID = update.ID
+	}
+	if !subscription.Resumed {
+		response := &WeatherResponse{City: city}
+		w.s.fetchWeather(response, loc, targetBackends)
+		// anything published up to now (including our own fetches) is already part of the snapshot
+		sentID = w.s.weatherUpdates.LastID()
+		snapshot := &weatherpb.WeatherUpdate{Id: sentID, Update: &weatherpb.WeatherUpdate_Snapshot{Snapshot: weatherResponseToProto(response)}}
+		if err := stream.Send(snapshot); err != nil {
+			return err
+		}
+	}
+
+	poll := time.NewTicker(w.s.streamPollInterval)
+	defer poll.Stop()
+	polling := make(chan struct{}, 1)
+	defer func() { polling <- struct{}{} }() // wait for the last poll, so it never outlives the stream
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case update, ok := <-subscription.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "Subscription dropped for falling behind, please resume from the last update")
+			}
+			if update.ID <= sentID || !isTarget[update.Weather.Source] {
+				continue
+			}
+			if err := stream.Send(readingToProto(update)); err != nil {
+				return err
+			}
+			sentID = update.ID
+		case <-poll.C:
+			// every poll counts as a request, the stream ends once the client is over its limits
+			if err := w.s.takeRequests(stream.Context(), 1); err != nil {
+				return grpcLimitError(err)
+			}
+			// new readings are published by fetchWeather, skip this poll if the last one is still waiting on a backend
+			select {
+			case polling <- struct{}{}:
+				go func() {
+					defer func() { <-polling }()
+					w.s.fetchWeather(&WeatherResponse{}, loc, targetBackends)
+				}()
+			default:
+			}
+		}
+	}
+}
+
+// resolveGRPCRequest validates a request the same way the REST handlers do, with the matching status codes
+func (s *Server) resolveGRPCRequest(ctx context.Context, city string, backends []string) (string, location.Location, []string, error) {
+	city = strings.TrimSpace(city)
+	if len(city) == 0 {
+		return "", location.Location{}, nil, status.Error(codes.InvalidArgument, "No city specified. Please provide a city.")
+	}
+
+	targetBackends, err := s.requestedBackends(ctx, backends)
+	if err != nil {
+		return "", location.Location{}, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	loc, err := s.locationResolver.Resolve(city)
+	if err != nil {
+		return "", location.Location{}, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return city, loc, targetBackends, nil
+}
+
+func weatherResponseToProto(response *WeatherResponse) *weatherpb.GetWeatherResponse {
+	resp := &weatherpb.GetWeatherResponse{City: response.City, Location: locationToProto(response.Location)}
+	for _, weather := range response.Data {
+		resp.Data = append(resp.Data, weatherToProto(weather))
+	}
+	if response.LocationMismatch != nil {
+		resp.LocationMismatch = &weatherpb.LocationMismatch{
+			Sources:    response.LocationMismatch.Sources,
+			DistanceKm: response.LocationMismatch.DistanceKm,
+		}
+	}
+	return resp
+}
+
+func readingToProto(update updates.Update) *weatherpb.WeatherUpdate {
+	// the hub only hands out valid times, the error can't happen
+	timestamp, _ := ptypes.TimestampProto(update.Time)
+	return &weatherpb.WeatherUpdate{
+		Id: update.ID,
+		Update: &weatherpb.WeatherUpdate_Reading{Reading: &weatherpb.Reading{
+			Location: locationToProto(&update.Location),
+			Data:     weatherToProto(update.Weather),
+			Time:     timestamp,
+		}},
+	}
+}
+
+func locationToProto(loc *location.Location) *weatherpb.Location {
+	if loc == nil {
+		return nil
+	}
+	pb := &weatherpb.Location{
+		Name:        loc.Name,
+		AdminRegion: loc.AdminRegion,
+		Country:     loc.Country,
+		PostalCode:  loc.PostalCode,
+		Timezone:    loc.Timezone,
+	}
+	if loc.Coordinates != nil {
+		pb.Coordinates = &weatherpb.Coordinates{Latitude: loc.Coordinates.Latitude, Longitude: loc.Coordinates.Longitude}
+	}
+	return pb
+}
+
+func resolvedLocationToProto(resolved *types.ResolvedLocation) *weatherpb.ResolvedLocation {
+	if resolved == nil {
+		return nil
+	}
+	return &weatherpb.ResolvedLocation{Location: locationToProto(&resolved.Location), ProviderLocationId: resolved.ProviderLocationID}
+}
+
+func weatherToProto(weather types.Weather) *weatherpb.Weather {
+	return &weatherpb.Weather{
+		Source:              weather.Source,
+		Temperature:         weather.Temperature,
+		TemperatureMin:      weather.TemperatureMin,
+		TemperatureMax:      weather.TemperatureMax,
+		MainDescription:     weather.MainDescription,
+		DetailedDescription: weather.DetailedDescription,
+		Location:            resolvedLocationToProto(weather.Location),
+		Error:               weather.Error,
+	}
+}
+
+func forecastToProto(forecast types.Forecast) *weatherpb.Forecast {
+	pb := &weatherpb.Forecast{Source: forecast.Source, Location: resolvedLocationToProto(forecast.Location), Error: forecast.Error}
+	for _, day := range forecast.Days {
+		pb.Days = append(pb.Days, &weatherpb.ForecastDay{
+			Date:                day.Date,
+			TemperatureMin:      day.TemperatureMin,
+			TemperatureMax:      day.TemperatureMax,
+			MainDescription:     day.MainDescription,
+			DetailedDescription: day.DetailedDescription,
+		})
+	}
+	return pb
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-weather-app/server/cache"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+	"go-weather-app/server/weatherpb"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestClient serves the gRPC API of a test server over an in-memory connection, with a counting backend called
+// foo and a forecasting backend called bar
+func newGRPCTestClient(t *testing.T) (*Server, weatherpb.WeatherServiceClient, func()) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": &countingWeatherBackend{},
+		"bar": mockForecastBackend{
+			returnForecast: types.Forecast{Source: "bar", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}}},
+		},
+	}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+	s.streamPollInterval = time.Hour
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := s.GRPCServer()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	require.NoError(t, err)
+	return s, weatherpb.NewWeatherServiceClient(conn), func() {
+		conn.Close()
+		server.GracefulStop() // waits for the handlers, which stop once the client is gone
+		<-served
+	}
+}
+
+func Test_grpcGetWeather(t *testing.T) {
+	_, client, cleanup := newGRPCTestClient(t)
+	defer cleanup()
+
+	tests := []struct {
+		name         string
+		req          *weatherpb.GetWeatherRequest
+		expected     *weatherpb.GetWeatherResponse
+		expectedCode codes.Code
+		expectedErr  string
+	}{
+		{
+			name: "default backends used",
+			req:  &weatherpb.GetWeatherRequest{City: " foo "},
+			expected: &weatherpb.GetWeatherResponse{
+				City:     "foo",
+				Location: &weatherpb.Location{Name: "foo"},
+				Data:     []*weatherpb.Weather{{Source: "foo", Temperature: 1}},
+			},
+		},
+		{
+			name:         "no city specified",
+			req:          &weatherpb.GetWeatherRequest{},
+			expectedCode: codes.InvalidArgument,
+			expectedErr:  "No city specified. Please provide a city.",
+		},
+		{
+			name:         "specified backend does not exist",
+			req:          &weatherpb.GetWeatherRequest{City: "foo", Backends: []string{"baz"}},
+			expectedCode: codes.InvalidArgument,
+			expectedErr:  "Backend specified is invalid or inactive: baz",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.GetWeather(context.Background(), tc.req)
+			if tc.expectedErr != "" {
+				require.Equal(t, tc.expectedCode, status.Code(err))
+				require.Equal(t, tc.expectedErr, status.Convert(err).Message())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected.String(), resp.String())
+		})
+	}
+}
+
+func Test_grpcGetForecast(t *testing.T) {
+	_, client, cleanup := newGRPCTestClient(t)
+	defer cleanup()
+
+	resp, err := client.GetForecast(context.Background(), &weatherpb.GetForecastRequest{City: "foo", Backends: []string{"bar", "foo"}})
+	require.NoError(t, err)
+	expected := &weatherpb.GetForecastResponse{
+		City:     "foo",
+		Location: &weatherpb.Location{Name: "foo"},
+		Data: []*weatherpb.Forecast{
+			{Source: "bar", Days: []*weatherpb.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}}},
+			{Source: "foo", Error: "Forecasts are not supported by this backend"},
+		},
+	}
+	require.Equal(t, expected.String(), resp.String())
+}
+
+func Test_grpcPanics(t *testing.T) {
+	s, client, cleanup := newGRPCTestClient(t)
+	defer cleanup()
+	s.locationResolver = location.Resolver{Geocoder: mockPanickingGeocoder{}}
+
+	_, err := client.GetWeather(context.Background(), &weatherpb.GetWeatherRequest{City: "foo"})
+	require.Equal(t, status.Error(codes.Internal, "Internal server error"), err)
+	stream, err := client.StreamWeather(context.Background(), &weatherpb.StreamWeatherRequest{City: "foo"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, status.Error(codes.Internal, "Internal server error"), err)
+
+	// the server goes on
+	_, err = client.ListBackends(context.Background(), &weatherpb.ListBackendsRequest{})
+	require.NoError(t, err)
+}
+
+func Test_grpcListBackends(t *testing.T) {
+	_, client, cleanup := newGRPCTestClient(t)
+	defer cleanup()
+
+	resp, err := client.ListBackends(context.Background(), &weatherpb.ListBackendsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar", "foo"}, resp.Backends)
+}
+
+func Test_grpcStreamWeather(t *testing.T) {
+	s, client, cleanup := newGRPCTestClient(t)
+	defer cleanup()
+
+	e := echo.New()
+	e.GET("/v1/weather/:city", s.getWeather)
+	rest := httptest.NewServer(e)
+	defer rest.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamWeather(ctx, &weatherpb.StreamWeatherRequest{City: "foo"})
+	require.NoError(t, err)
+
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), update.Id)
+	require.Equal(t, "foo", update.GetSnapshot().City)
+	require.Equal(t, float32(1), update.GetSnapshot().Data[0].Temperature)
+
+	// a REST request publishes a new reading to gRPC subscribers too
+	resp, err := http.Get(rest.URL + "/v1/weather/foo")
+	require.NoError(t, err)
+	resp.Body.Close()
+	update, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), update.Id)
+	require.Equal(t, "foo", update.GetReading().Location.Name)
+	require.Equal(t, float32(2), update.GetReading().Data.Temperature)
+	cancel()
+
+	// resuming sends the missed readings instead of a snapshot
+	resp, err = http.Get(rest.URL + "/v1/weather/foo")
+	require.NoError(t, err)
+	resp.Body.Close()
+	stream, err = client.StreamWeather(context.Background(), &weatherpb.StreamWeatherRequest{City: "foo", LastEventId: 2})
+	require.NoError(t, err)
+	update, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), update.Id)
+	require.Equal(t, float32(3), update.GetReading().Data.Temperature)
+
+	// errors of server streams only come with the first Recv
+	stream, err = client.StreamWeather(context.Background(), &weatherpb.StreamWeatherRequest{City: "foo", Backends: []string{"baz"}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
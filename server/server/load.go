@@ -0,0 +1,473 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-weather-app/server/auth"
+	"go-weather-app/server/backends"
+	"go-weather-app/server/redact"
+	"go-weather-app/server/secrets"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+)
+
+// EnvPrefix starts the names of the environment variables that override the configuration file, see LoadConfig
+const EnvPrefix = "WEATHER_"
+
+// ClientEnvPrefix starts the names of the environment variables of the command line client (i.e.
+// WEATHER_CLIENT_API_KEY), which aren't settings of the server
+const ClientEnvPrefix = "WEATHER_CLIENT_"
+
+// DefaultConfigFiles are looked for in the working directory when no configuration file is given, in this order
+var DefaultConfigFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FindConfigFile returns the first of DefaultConfigFiles that exists, or "" when none does
+func FindConfigFile() string {
+	for _, name := range DefaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// LoadConfigFile reads the configuration of a server from a json, yaml or toml file, told apart by their extension
+func LoadConfigFile(configFilePath string) (*Config, error) {
+	return LoadConfig(configFilePath, nil)
+}
+
+// LoadConfig reads the configuration of a server from a file, if any, then overrides it with the environment variables
+// starting with EnvPrefix but not ClientEnvPrefix (environ is formatted like os.Environ). The rest of the name of a variable is the path of the
+// setting, split by underscores and in any case, i.e. WEATHER_HTTP_ADDRESS or WEATHER_CACHE_TTL. Backends are picked
+// by name, or by type when they don't have one, and are added when they aren't configured yet:
+// WEATHER_BACKENDS_ACCUWEATHER_APIKEY sets the API key of the accuweather backend. Lists are comma separated.
+// Settings can be references to secrets, see package secrets, which are resolved before the configuration is validated.
+func LoadConfig(configFilePath string, environ []string) (*Config, error) {
+	tree, positions, err := readConfig(configFilePath, environ)
+	if err != nil {
+		return nil, err
+	}
+	if problems := validateConfig(configFilePath, tree, positions, environ); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(b, config); err != nil {
+		if configFilePath == "" {
+			return nil, errors.New("Invalid configuration: " + err.Error())
+		}
+		return nil, errors.New("Invalid configuration in " + configFilePath + ": " + err.Error())
+	}
+	return config, nil
+}
+
+// readConfig reads the configuration file, if any, then overrides it with the environment variables. Along with the
+// settings, it returns where they are in the file by path, i.e. "backends[0].apiKey".
+func readConfig(configFilePath string, environ []string) (map[string]interface{}, map[string]Position, error) {
+	tree, positions := map[string]interface{}{}, map[string]Position{}
+	if configFilePath != "" {
+		var err error
+		if tree, positions, err = readConfigFile(configFilePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := applyEnv(tree, environ); err != nil {
+		return nil, nil, err
+	}
+
+	// the settings of the environment variables are values of the configuration types, they are turned into what json
+	// decodes them into like the others
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, nil, err
+	}
+	return tree, positions, nil
+}
+
+// parseError is a configuration file that can't be parsed, along with where it went wrong when the parser says so
+type parseError struct {
+	path     string
+	position Position
+	err      error
+}
+
+func (e *parseError) Error() string {
+	return "Unable to parse config file " + e.path + ": " + e.err.Error()
+}
+
+// errorLineRegexp finds the line the yaml and toml parsers report errors at
+var errorLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+func newParseError(path string, err error) *parseError {
+	line := 0
+	if match := errorLineRegexp.FindStringSubmatch(err.Error()); match != nil {
+		line, _ = strconv.Atoi(match[1])
+	}
+	return &parseError{path: path, position: Position{Line: line}, err: err}
+}
+
+// readConfigFile reads a configuration file into the objects, lists and values json would decode it into, whatever
+// its format, along with where the settings are in the file
+func readConfigFile(configFilePath string) (map[string]interface{}, map[string]Position, error) {
+	source, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := source
+	var positions map[string]Position
+	format := strings.ToLower(filepath.Ext(configFilePath))
+	switch format {
+	case ".json":
+		positions = jsonPositions(source)
+	case ".yaml", ".yml":
+		if b, err = yaml.YAMLToJSON(source); err != nil {
+			return nil, nil, newParseError(configFilePath, err)
+		}
+		positions = yamlPositions(source)
+	case ".toml":
+		decoded := map[string]interface{}{}
+		if _, err := toml.Decode(string(source), &decoded); err != nil {
+			return nil, nil, newParseError(configFilePath, err)
+		}
+		if b, err = json.Marshal(decoded); err != nil {
+			return nil, nil, err
+		}
+		positions = tomlPositions(source)
+	default:
+		return nil, nil, errors.New("Unknown config file format, expected .json, .yaml, .yml or .toml: " + configFilePath)
+	}
+
+	tree := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber() // keeps integers as they are written
+	if err := decoder.Decode(&tree); err != nil {
+		parseErr := &parseError{path: configFilePath, err: err}
+		if syntaxErr, ok := err.(*json.SyntaxError); ok && format == ".json" && syntaxErr.Offset > 0 {
+			parseErr.position = offsetPosition(source, syntaxErr.Offset-1) // the offset is right after the invalid character
+		}
+		return nil, nil, parseErr
+	}
+	if tree == nil {
+		tree = map[string]interface{}{} // i.e. an empty yaml file
+	}
+	return tree, positions, nil
+}
+
+// applyEnv overrides the settings of tree with the environment variables starting with EnvPrefix, except the ones of
+// the command line client
+func applyEnv(tree map[string]interface{}, environ []string) error {
+	variables := []string{}
+	for _, variable := range environ {
+		if strings.HasPrefix(variable, EnvPrefix) && !strings.HasPrefix(variable, ClientEnvPrefix) {
+			variables = append(variables, variable)
+		}
+	}
+	sort.Strings(variables) // so that backends added by several variables are added in the same order every time
+
+	for _, variable := range variables {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		path := strings.Split(strings.TrimPrefix(parts[0], EnvPrefix), "_")
+		if _, err := setSetting(tree, reflect.TypeOf(Config{}), path, parts[1]); err != nil {
+			return errors.New("Invalid environment variable " + parts[0] + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+var (
+	backendsConfigType = reflect.TypeOf(backends.Config{})
+	durationType       = reflect.TypeOf(Duration{})
+)
+
+// setSetting sets the setting at path, below node whose configuration type is t, and returns the updated node
+func setSetting(node interface{}, t reflect.Type, path []string, value string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(path) == 0 {
+		return parseSetting(t, value)
+	}
+	if path[0] == "" {
+		return nil, errors.New("empty setting name")
+	}
+
+	if t == backendsConfigType {
+		return setBackendSetting(node, path, value)
+	}
+	if t.Kind() != reflect.Struct || t == durationType {
+		return nil, errors.New("unknown setting: " + strings.Join(path, "_"))
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || !strings.EqualFold(name, path[0]) {
+			continue
+		}
+		object := asObject(node)
+		key := objectKey(object, name)
+		updated, err := setSetting(object[key], field.Type, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = updated
+		return object, nil
+	}
+	return nil, errors.New("unknown setting: " + strings.Join(path, "_"))
+}
+
+// setBackendSetting sets an option of the backend named path[0], which is added when it isn't configured yet. The
+// options are those of the type of the backend.
+func setBackendSetting(node interface{}, path []string, value string) (interface{}, error) {
+	if len(path) < 2 {
+		return nil, errors.New("no option given for backend " + path[0])
+	}
+
+	// the object older versions used is keyed by type
+	if byType, ok := node.(map[string]interface{}); ok {
+		key := objectKey(byType, strings.ToLower(path[0]))
+		options := asObject(byType[key])
+		updated, err := setBackendOption(options, key, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		byType[key] = updated
+		return byType, nil
+	}
+
+	list, _ := node.([]interface{})
+	for i, item := range list {
+		instance := asObject(item)
+		name, _ := instance["name"].(string)
+		backendType, _ := instance["type"].(string)
+		if name == "" {
+			name = backendType
+		}
+		if strings.EqualFold(name, path[0]) {
+			updated, err := setBackendOption(instance, backendType, path[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = updated
+			return list, nil
+		}
+	}
+	backendType := strings.ToLower(path[0])
+	updated, err := setBackendOption(map[string]interface{}{"type": backendType}, backendType, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return append(list, updated), nil
+}
+
+// setBackendOption sets an option of a backend of the given type
+func setBackendOption(options map[string]interface{}, backendType string, path []string, value string) (interface{}, error) {
+	factory, ok := backends.Lookup(backendType)
+	if !ok {
+		return nil, errors.New("unknown backend type: " + backendType)
+	}
+	return setSetting(options, reflect.TypeOf(factory.Options), path, value)
+}
+
+// parseSetting turns the value of an environment variable into a value of the configuration type t: strings are taken
+// as is, lists are either comma separated or json, anything else is json
+func parseSetting(t reflect.Type, value string) (interface{}, error) {
+	if t.Kind() == reflect.String {
+		return value, nil
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+
+	parsed := reflect.New(t)
+	if err := json.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+		// i.e. durations, which are configured as strings
+		quoted, _ := json.Marshal(value)
+		if json.Unmarshal(quoted, parsed.Interface()) != nil {
+			return nil, errors.New("invalid value: " + value)
+		}
+	}
+	return parsed.Elem().Interface(), nil
+}
+
+// asObject returns node as an object, or a new object when it isn't one
+func asObject(node interface{}) map[string]interface{} {
+	if object, ok := node.(map[string]interface{}); ok {
+		return object
+	}
+	return map[string]interface{}{}
+}
+
+// objectKey returns the key of object that matches name regardless of case, json decodes them all the same, or name
+// when there is none
+func objectKey(object map[string]interface{}, name string) string {
+	if _, ok := object[name]; ok {
+		return name
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// secretSettingRegexp matches the names of the settings that are never printed, see RedactConfig
+var secretSettingRegexp = regexp.MustCompile(`(?i)(key|token|secret|password)$`)
+
+// RedactConfig formats the configuration as json, with the defaults of the settings that aren't configured and with the
+// values of its secrets (API keys, tokens and so on) replaced
+func RedactConfig(config *Config) ([]byte, error) {
+	b, err := json.Marshal(config.withDefaults())
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redactSecrets(tree, nil), "", "  ")
+}
+
+// secretValues returns the values of the secrets of the configuration, the ones RedactConfig replaces, so that they
+// can be redacted wherever else they could show up
+func secretValues(config *Config) []string {
+	values := []string{}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return values
+	}
+	var tree interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return values
+	}
+	redactSecrets(tree, func(secret string) { values = append(values, secret) })
+	return values
+}
+
+// redactSecrets replaces the secrets of a decoded json tree, passing each of them to found unless it is nil
+func redactSecrets(node interface{}, found func(secret string)) interface{} {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if s, ok := value.(string); ok && s != "" && secretSettingRegexp.MatchString(key) {
+				if found != nil {
+					found(s)
+				}
+				node[key] = redact.Placeholder
+				continue
+			}
+			node[key] = redactSecrets(value, found)
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = redactSecrets(value, found)
+		}
+	}
+	return node
+}
+
+// withDefaults returns a copy of the configuration with the defaults the server uses for the settings that aren't
+// configured
+func (c *Config) withDefaults() *Config {
+	d := *c
+	duration := func(configured *Duration, defaultValue time.Duration) *Duration {
+		if configured != nil {
+			return configured
+		}
+		return &Duration{Duration: defaultValue}
+	}
+	if d.HTTP.Address == "" {
+		d.HTTP.Address = DefaultAddress
+	}
+	if len(d.HTTP.AllowedOrigins) == 0 {
+		d.HTTP.AllowedOrigins = DefaultAllowedOrigins
+	}
+	d.HTTP.ReadTimeout = duration(d.HTTP.ReadTimeout, 0)
+	d.HTTP.ShutdownTimeout = duration(d.HTTP.ShutdownTimeout, DefaultShutdownTimeout)
+	d.BackendTimeout = duration(d.BackendTimeout, DefaultBackendTimeout)
+	if d.Backends == nil {
+		d.Backends = backends.Config{}
+	}
+	if d.Location.MismatchDistanceKm <= 0 {
+		d.Location.MismatchDistanceKm = DefaultLocationMismatchKm
+	}
+	if d.GeoIP.TrustedProxies == nil {
+		d.GeoIP.TrustedProxies = []string{}
+	}
+	d.Cache.TTL = duration(d.Cache.TTL, DefaultCacheTTL)
+	if d.Batch.MaxItems <= 0 {
+		d.Batch.MaxItems = DefaultBatchMaxItems
+	}
+	if d.Batch.Concurrency <= 0 {
+		d.Batch.Concurrency = DefaultBatchConcurrency
+	}
+	d.Stream.Heartbeat = duration(d.Stream.Heartbeat, DefaultStreamHeartbeat)
+	d.Stream.PollInterval = duration(d.Stream.PollInterval, DefaultStreamPollInterval)
+	if d.Stream.BufferSize <= 0 {
+		d.Stream.BufferSize = DefaultStreamBufferSize
+	}
+	if d.WebSocket.MessagesPerSecond <= 0 {
+		d.WebSocket.MessagesPerSecond = DefaultWebSocketMessageRate
+	}
+	if d.WebSocket.MessageBurst <= 0 {
+		d.WebSocket.MessageBurst = DefaultWebSocketMessageBurst
+	}
+	if d.WebSocket.MaxSubscriptions <= 0 {
+		d.WebSocket.MaxSubscriptions = DefaultWebSocketMaxSubscriptions
+	}
+	if d.GRPC.Address == "" {
+		d.GRPC.Address = DefaultGRPCAddress
+	}
+	d.Secrets.Vault.Timeout = duration(d.Secrets.Vault.Timeout, secrets.DefaultVaultTimeout)
+	clients := []ClientConfig{}
+	for _, client := range d.Auth.Clients {
+		if len(client.Scopes) == 0 {
+			client.Scopes = []string{auth.ScopeRead}
+		}
+		client.QuotaPeriod = duration(client.QuotaPeriod, auth.DefaultQuotaPeriod)
+		clients = append(clients, client)
+	}
+	d.Auth.Clients = clients
+	if d.Auth.JWT.ReadScope == "" {
+		d.Auth.JWT.ReadScope = auth.DefaultReadScope
+	}
+	if d.Auth.JWT.AdminScope == "" {
+		d.Auth.JWT.AdminScope = auth.DefaultAdminScope
+	}
+	d.Auth.JWT.RefreshInterval = duration(d.Auth.JWT.RefreshInterval, auth.DefaultRefreshInterval)
+	d.Auth.JWT.Leeway = duration(d.Auth.JWT.Leeway, auth.DefaultLeeway)
+	return &d
+}
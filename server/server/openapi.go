@@ -0,0 +1,521 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/openapi"
+	"go-weather-app/server/report"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/labstack/echo/v4"
+)
+
+// HTTPErrorResponse defines the json response echo sends for errors that don't come from the handlers, i.e. unknown
+// routes or the admin endpoints (/v2 sends a Problem instead)
+type HTTPErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// apiOperation documents a single route of the REST API, the spec served at /openapi.json is generated from these
+type apiOperation struct {
+	Method      string
+	Path        string // as registered with echo, i.e. "/v1/weather/:city"
+	ID          string
+	Tags        []string
+	Summary     string
+	Description string
+	Parameters  []*openapi3.Parameter
+	RequestBody interface{} // the request body is json of this type, if there is one
+	Responses   []apiResponse
+	Public      bool // served without an API key or token even when clients are configured, see authMiddleware
+}
+
+// apiResponse documents a single status code of an operation
+type apiResponse struct {
+	Status      int
+	Description string
+	ContentType string      // defaults to application/json when there is a body
+	Body        interface{} // the response body has this type, or any of the types of an anyOf, if there is one
+	Rendered    bool        // the body can also be negotiated in the other formats of the renderers
+}
+
+// anyOf documents a body that can be any of the types of the values
+type anyOf []interface{}
+
+func cityParameter(description string) *openapi3.Parameter {
+	return openapi3.NewPathParameter("city").WithDescription(description).WithSchema(openapi3.NewStringSchema())
+}
+
+func backendParameter(description string) *openapi3.Parameter {
+	return openapi3.NewQueryParameter("backend").WithDescription(description).WithSchema(openapi3.NewStringSchema())
+}
+
+func formatParameter(renderers rendererSet) *openapi3.Parameter {
+	enum := []interface{}{}
+	for _, format := range renderers.formats() {
+		enum = append(enum, format)
+	}
+	schema := openapi3.NewStringSchema()
+	schema.Enum = enum
+	return openapi3.NewQueryParameter("format").WithDescription("the format of the response, which can also be negotiated with the Accept header (defaults to json, or ansi for curl and wget)").WithSchema(schema)
+}
+
+func widthParameter() *openapi3.Parameter {
+	schema := openapi3.NewIntegerSchema().WithMin(report.MinWidth).WithMax(report.MaxWidth)
+	return openapi3.NewQueryParameter("width").WithDescription("the width of the terminal the ansi report is drawn for, defaults to 80").WithSchema(schema)
+}
+
+func colorParameter() *openapi3.Parameter {
+	return openapi3.NewQueryParameter("color").WithDescription("whether the ansi report is colored, defaults to true").WithSchema(openapi3.NewBoolSchema())
+}
+
+// apiOperations documents every route of the API, with the formats of the renderers of the server
+func apiOperations(renderers rendererSet) []apiOperation {
+	return []apiOperation{
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/backends",
+			ID:      "getBackends",
+			Tags:    []string{"backends"},
+			Summary: "provides a list of configured/available weather backends/sources",
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the configured backends, sorted by name", Body: BackendResponse{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/weather/here",
+			ID:      "getHereWeather",
+			Tags:    []string{"weather"},
+			Summary: "gets the weather from the specified backend(s) for the caller's approximate location, based on their IP address",
+			Parameters: []*openapi3.Parameter{
+				backendParameter("pass an optional backend string to specify which target backend to use (not specifying this will fetch data from all the default backends)"),
+				formatParameter(renderers),
+				widthParameter(),
+				colorParameter(),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "one reading per backend", Body: WeatherResponse{}, Rendered: true},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", Body: WeatherResponse{}, Rendered: true},
+				{Status: http.StatusNotFound, Description: "the caller's IP address could not be located", Body: WeatherResponse{}, Rendered: true},
+				{Status: http.StatusInternalServerError, Description: "the GeoIP database could not be read", Body: WeatherResponse{}, Rendered: true},
+				{Status: http.StatusNotImplemented, Description: "no GeoIP database is configured", Body: WeatherResponse{}, Rendered: true},
+			},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/v1/weather/batch",
+			ID:      "getBatchWeather",
+			Tags:    []string{"weather"},
+			Summary: "gets the weather from the specified backend(s) for many cities and/or coordinates in one request",
+			Description: "Items are fetched concurrently (up to a configured limit) and share the server's cache, so repeated " +
+				"locations only hit the backends once. Each item gets its own result, in the same order as the request, " +
+				"with its own error when it could not be resolved or fetched.",
+			RequestBody: BatchWeatherRequest{},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "one result per requested item, in request order", Body: BatchWeatherResponse{}},
+				{Status: http.StatusBadRequest, Description: "bad request body, no items, too many items, or an invalid backend", Body: BatchWeatherResponse{}},
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/v1/weather/:city",
+			ID:          "getCityWeather",
+			Tags:        []string{"weather"},
+			Summary:     "gets the weather from the specified backend(s) for the provided city",
+			Description: "By passing in the appropriate options, you can get the weather for the provided city from various weather backends",
+			Parameters: []*openapi3.Parameter{
+				cityParameter("city for which to fetch weather data for"),
+				backendParameter("pass an optional backend string to specify which target backend to use (not specifying this will fetch data from all the default backends)"),
+				formatParameter(renderers),
+				widthParameter(),
+				colorParameter(),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "one reading per backend", Body: WeatherResponse{}, Rendered: true},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", Body: WeatherResponse{}, Rendered: true},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/weather/:city/stream",
+			ID:      "streamCityWeather",
+			Tags:    []string{"weather"},
+			Summary: "streams new weather readings for the provided city as Server-Sent Events",
+			Description: "Starts with a \"snapshot\" event holding the same data as /v1/weather/{city}, followed by a \"weather\" event " +
+				"(see WeatherUpdateEvent) whenever a new reading is fetched for the city, whether by this stream's polling or by " +
+				"other clients. Comment lines are sent as heartbeats. Clients that reconnect with Last-Event-ID are sent the " +
+				"readings they missed instead of a new snapshot, when they are still available. Clients that fall too far behind " +
+				"are disconnected and expected to reconnect.",
+			Parameters: []*openapi3.Parameter{
+				cityParameter("city for which to stream weather data for"),
+				backendParameter("pass an optional backend string to specify which target backend to use (not specifying this will stream data from all the default backends)"),
+				openapi3.NewHeaderParameter("Last-Event-ID").WithDescription("the id of the last event received, to resume a stream").WithSchema(openapi3.NewStringSchema()),
+				openapi3.NewQueryParameter("lastEventId").WithDescription("same as the Last-Event-ID header, for clients that can't set headers").WithSchema(openapi3.NewStringSchema()),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "a stream of events", ContentType: "text/event-stream"},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", Body: WeatherResponse{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/forecast/:city",
+			ID:      "getCityForecast",
+			Tags:    []string{"weather"},
+			Summary: "gets the daily forecast from the specified backend(s) for the provided city",
+			Parameters: []*openapi3.Parameter{
+				cityParameter("city for which to fetch the forecast for"),
+				backendParameter("pass an optional backend string to specify which target backend to use (not specifying this will fetch data from all the default backends)"),
+				formatParameter(renderers),
+				widthParameter(),
+				colorParameter(),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "one forecast per backend, backends without forecasts report an error instead", Body: ForecastResponse{}, Rendered: true},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", Body: ForecastResponse{}, Rendered: true},
+			},
+		},
+		{
+			Method:  http.MethodOptions,
+			Path:    "/v1/weather",
+			ID:      "optionsWeather",
+			Tags:    []string{"weather"},
+			Summary: "lists the methods accepted by the weather routes in the Accept header",
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "an empty response"},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/locations/search",
+			ID:      "searchLocations",
+			Tags:    []string{"locations"},
+			Summary: "provides ranked candidate locations matching free text, i.e. for autocomplete",
+			Parameters: []*openapi3.Parameter{
+				openapi3.NewQueryParameter("q").WithDescription("a city name optionally followed by region and/or country (\"Springfield, IL, US\"), a postal code, or \"lat,lon\"").WithRequired(true).WithSchema(openapi3.NewStringSchema()),
+				openapi3.NewQueryParameter("limit").WithDescription("maximum number of candidates to return (defaults to 10, at most 50)").WithSchema(openapi3.NewInt64Schema().WithMin(1)),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "candidates matching the query, best match first", Body: LocationSearchResponse{}},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", Body: LocationSearchResponse{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/ws",
+			ID:      "weatherWebSocket",
+			Tags:    []string{"weather"},
+			Summary: "subscribes to new weather readings for many cities over a WebSocket",
+			Description: "After the upgrade, clients and the server exchange WebSocketMessage json messages. Clients send \"subscribe\" " +
+				"(with a city and optional backends) and \"unsubscribe\" (with a city) messages. The server answers a subscription " +
+				"with a \"snapshot\" holding the same data as /v1/weather/{city}, then sends an \"update\" for every new reading, " +
+				"and reports problems with \"error\" messages naming the city they are about. Messages are rate limited per " +
+				"connection.",
+			Responses: []apiResponse{
+				{Status: http.StatusSwitchingProtocols, Description: "switching to the WebSocket protocol"},
+				{Status: http.StatusForbidden, Description: "the Origin of a browser client isn't allowed"},
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/graphql",
+			ID:          "postGraphQL",
+			Tags:        []string{"weather"},
+			Summary:     "runs a GraphQL query over backends, locations, current conditions and forecasts",
+			RequestBody: GraphQLRequest{},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the query results, along with errors for the fields that failed (if any)", Body: graphql.Response{}},
+				{Status: http.StatusBadRequest, Description: "the request body could not be parsed", Body: graphql.Response{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v2/weather/:city",
+			ID:      "getCityWeatherV2",
+			Tags:    []string{"weather"},
+			Summary: "gets the weather from the specified backend(s) for the provided city, with a result per source",
+			Parameters: []*openapi3.Parameter{
+				cityParameter("city for which to fetch weather data for"),
+				backendParameter("a comma separated list of backends (defaults to all the default backends)"),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "at least one source returned data", Body: api.WeatherResponseV2{}},
+				{Status: http.StatusBadRequest, Description: "bad input parameter", ContentType: api.MIMEApplicationProblemJSON, Body: api.Problem{}},
+				{Status: http.StatusBadGateway, Description: "every source failed", ContentType: api.MIMEApplicationProblemJSON, Body: api.Problem{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v2/forecast/:city",
+			ID:      "getCityForecastV2",
+			Tags:    []string{"weather"},
+			Summary: "gets the daily forecast from the specified backend(s) for the provided city, with a result per source",
+			Parameters: []*openapi3.Parameter{
+				cityParameter("city for which to fetch the forecast for"),
+				backendParameter("a comma separated list of backends (defaults to all the default backends)"),
+			},
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "at least one source returned data", Body: api.ForecastResponseV2{}},
+				{Status: http.StatusBadRequest, Description: "bad input parameter, or none of the sources support forecasts", ContentType: api.MIMEApplicationProblemJSON, Body: api.Problem{}},
+				{Status: http.StatusBadGateway, Description: "every source failed", ContentType: api.MIMEApplicationProblemJSON, Body: api.Problem{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v2/backends",
+			ID:      "getBackendsV2",
+			Tags:    []string{"backends"},
+			Summary: "lists the configured backends and how the last call to each went",
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the configured backends, sorted by name", Body: api.BackendsResponseV2{}},
+			},
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/openapi.json",
+			ID:      "getOpenAPISpec",
+			Summary: "provides this document",
+			Public:  true,
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the OpenAPI 3 document of the REST API", Body: map[string]interface{}{}},
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/admin/config",
+			ID:          "getAdminConfig",
+			Tags:        []string{"admin"},
+			Summary:     "provides the configuration in effect, with the defaults and without the secrets",
+			Description: "Needs the admin scope. Admin endpoints are disabled until clients or JWTs are configured.",
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the configuration, as printed by -print-config", Body: map[string]interface{}{}},
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/admin/reload",
+			ID:          "reloadConfig",
+			Tags:        []string{"admin"},
+			Summary:     "reloads the backends from the configuration, like SIGHUP does",
+			Description: "Needs the admin scope. Admin endpoints are disabled until clients or JWTs are configured.",
+			Responses: []apiResponse{
+				{Status: http.StatusOK, Description: "the backends once reloaded, sorted by name", Body: BackendResponse{}},
+				{Status: http.StatusInternalServerError, Description: "the configuration could not be loaded or a backend could not be created, the current backends are kept", Body: HTTPErrorResponse{}},
+				{Status: http.StatusNotImplemented, Description: "reloading is not enabled", Body: HTTPErrorResponse{}},
+			},
+		},
+	}
+}
+
+// apiStreamTypes are only sent over streams, so they wouldn't be documented without being added explicitly
+var apiStreamTypes = []interface{}{WeatherUpdateEvent{}, WebSocketMessage{}}
+
+// newOpenAPISpec generates the OpenAPI 3 document of apiOperations, the one served at /openapi.json. A strict document
+// doesn't allow properties the types don't have, see openapi.Generator.
+func newOpenAPISpec(renderers rendererSet, strict bool) *openapi3.Swagger {
+	generator := openapi.NewGenerator()
+	generator.Strict = strict
+	generator.Name(graphql.Response{}, "GraphQLResponse")
+	generator.Name(gqlerrors.QueryError{}, "GraphQLError")
+	generator.Name(gqlerrors.Location{}, "GraphQLErrorLocation")
+
+	spec := &openapi3.Swagger{
+		OpenAPI: "3.0.0",
+		Info: openapi3.Info{
+			Title:       "go-weather-app API",
+			Description: "This is an API for fetching weather for a given location",
+			Version:     "1.0.0",
+			Contact:     &openapi3.Contact{Email: "go-weather-app@example.com"},
+			License:     &openapi3.License{Name: "Apache 2.0", URL: "http://www.apache.org/licenses/LICENSE-2.0.html"},
+		},
+		// clients only need an API key or a token when the server has clients configured
+		Security: openapi3.SecurityRequirements{
+			openapi3.NewSecurityRequirement().Authenticate("apiKey"),
+			openapi3.NewSecurityRequirement().Authenticate("apiKeyQuery"),
+			openapi3.NewSecurityRequirement().Authenticate("bearer"),
+			openapi3.NewSecurityRequirement(),
+		},
+		Components: openapi3.Components{SecuritySchemes: map[string]*openapi3.SecuritySchemeRef{
+			"apiKey": {Value: openapi3.NewSecurityScheme().WithType("apiKey").WithIn("header").WithName(api.HeaderAPIKey)},
+			"apiKeyQuery": {Value: openapi3.NewSecurityScheme().WithType("apiKey").WithIn("query").WithName(apiKeyQueryParam).
+				WithDescription("for clients that can't set headers, i.e. browsers opening a WebSocket")},
+			"bearer": {Value: openapi3.NewSecurityScheme().WithType("http").WithScheme("bearer").
+				WithDescription("an API key, a token signed with the token secret of the client, or a JWT of the identity provider")},
+		}},
+	}
+	for _, op := range apiOperations(renderers) {
+		operation := openapi3.NewOperation()
+		operation.OperationID = op.ID
+		operation.Tags = op.Tags
+		operation.Summary = op.Summary
+		operation.Description = op.Description
+		for _, parameter := range op.Parameters {
+			operation.AddParameter(parameter)
+		}
+		if op.RequestBody != nil {
+			operation.RequestBody = &openapi3.RequestBodyRef{
+				Value: openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(generator.SchemaRef(op.RequestBody)),
+			}
+		}
+		for _, r := range op.Responses {
+			response := openapi3.NewResponse().WithDescription(r.Description)
+			contentType := r.ContentType
+			if contentType == "" && r.Body != nil {
+				contentType = echo.MIMEApplicationJSON
+			}
+			if contentType != "" {
+				mediaType := openapi3.NewMediaType()
+				if r.Body != nil {
+					mediaType.Schema = bodySchemaRef(generator, r.Body)
+				}
+				response.Content = openapi3.Content{contentType: mediaType}
+				if r.Rendered {
+					for _, format := range renderers.formats() {
+						if format != DefaultFormat {
+							response.Content[renderers[format].MediaTypes()[0]] = openapi3.NewMediaType()
+						}
+					}
+				}
+			}
+			operation.AddResponse(r.Status, response)
+		}
+		if op.Public {
+			operation.Security = &openapi3.SecurityRequirements{}
+		} else {
+			addAuthResponses(generator, operation, strings.HasPrefix(op.Path, "/v2/"))
+		}
+		spec.AddOperation(openAPIPath(op.Path), op.Method, operation)
+	}
+	for _, v := range apiStreamTypes {
+		generator.Component(v)
+	}
+	spec.Components.Schemas = generator.Schemas
+	return spec
+}
+
+// addAuthResponses documents the errors of authMiddleware, which /v2 sends as problems
+func addAuthResponses(generator *openapi.Generator, operation *openapi3.Operation, problems bool) {
+	contentType, body := echo.MIMEApplicationJSON, interface{}(HTTPErrorResponse{})
+	if problems {
+		contentType, body = api.MIMEApplicationProblemJSON, api.Problem{}
+	}
+	for status, description := range map[int]string{
+		http.StatusUnauthorized:       "no API key or token was provided, or it is invalid or expired",
+		http.StatusForbidden:          "the API key or token doesn't grant the scope of the endpoint, read or admin",
+		http.StatusTooManyRequests:    "the client is over its rate limit or quota, the Retry-After header tells when to try again",
+		http.StatusServiceUnavailable: "the signing keys of the identity provider could not be fetched to check a JWT",
+	} {
+		mediaType := openapi3.NewMediaType()
+		mediaType.Schema = generator.SchemaRef(body)
+		operation.AddResponse(status, openapi3.NewResponse().WithDescription(description).WithContent(openapi3.Content{contentType: mediaType}))
+	}
+}
+
+func bodySchemaRef(generator *openapi.Generator, body interface{}) *openapi3.SchemaRef {
+	bodies, ok := body.(anyOf)
+	if !ok {
+		return generator.SchemaRef(body)
+	}
+	schema := &openapi3.Schema{}
+	for _, body := range bodies {
+		schema.AnyOf = append(schema.AnyOf, generator.SchemaRef(body))
+	}
+	return &openapi3.SchemaRef{Value: schema}
+}
+
+// openAPIPath turns an echo path into an OpenAPI one, i.e. "/v1/weather/:city" into "/v1/weather/{city}"
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func init() {
+	// problems are json too, for the validation of request and response bodies
+	openapi3filter.RegisterBodyDecoder(api.MIMEApplicationProblemJSON, func(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (interface{}, error) {
+		var value interface{}
+		err := json.NewDecoder(body).Decode(&value)
+		return value, err
+	})
+}
+
+// openAPIValidationMiddleware rejects the requests that don't match the spec (i.e. missing or malformed parameters or
+// bodies) before they reach the handlers. Requests for routes the spec doesn't document are let through. It is only
+// used for /v2, whose errors are problems: /v1 handlers check their requests themselves, and answer in their own shape.
+func openAPIValidationMiddleware(spec *openapi3.Swagger) echo.MiddlewareFunc {
+	router := openapi3filter.NewRouter().WithSwagger(spec)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			route, pathParams, err := router.FindRoute(req.Method, req.URL)
+			if err != nil {
+				return next(c)
+			}
+			err = openapi3filter.ValidateRequest(req.Context(), &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+			})
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, validationErrorMessage(err))
+			}
+			return next(c)
+		}
+	}
+}
+
+// validationErrorMessage describes why a request is invalid on a single line, without the schemas and values
+// kin-openapi adds to its errors
+func validationErrorMessage(err error) string {
+	requestErr, ok := err.(*openapi3filter.RequestError)
+	if !ok {
+		return err.Error()
+	}
+
+	reason := requestErr.Reason
+	switch cause := requestErr.Err.(type) {
+	case nil:
+	case *openapi3.SchemaError:
+		reason = cause.Reason
+		if cause.SchemaField == "type" {
+			// kin-openapi names the type that was sent instead of the one that was expected
+			reason = "Value must be of type " + cause.Schema.Type
+		}
+		if reason == "" {
+			reason = cause.Error()
+		}
+		if pointer := cause.JSONPointer(); len(pointer) > 0 {
+			reason += " (at /" + strings.Join(pointer, "/") + ")"
+		}
+	default:
+		if reason == "" {
+			reason = cause.Error()
+		} else if reason != cause.Error() {
+			reason += ": " + cause.Error()
+		}
+	}
+
+	switch {
+	case requestErr.Parameter != nil:
+		return "Invalid " + requestErr.Parameter.In + " parameter " + requestErr.Parameter.Name + ": " + reason
+	case requestErr.RequestBody != nil:
+		return "Invalid request body: " + reason
+	}
+	return "Invalid request: " + reason
+}
+
+func (s *Server) getOpenAPISpec(c echo.Context) error {
+	return c.JSONPretty(http.StatusOK, s.openAPISpec, "  ")
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weather-app/server/cache"
+	"go-weather-app/server/types"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newOpenAPITestEcho(s *Server) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler(e)
+	s.registerRoutes(e)
+	return e
+}
+
+func Test_openAPISpecMatchesRoutes(t *testing.T) {
+	s := newTestServer(t)
+	require.NoError(t, s.openAPISpec.Validate(context.Background()))
+
+	// echo adds not found routes for the prefixes of groups with middleware
+	notFoundHandler := runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+	routes := []string{}
+	for _, route := range newOpenAPITestEcho(s).Routes() {
+		if route.Name == notFoundHandler {
+			continue
+		}
+		routes = append(routes, route.Method+" "+openAPIPath(route.Path))
+	}
+	sort.Strings(routes)
+
+	documented := []string{}
+	for path, pathItem := range s.openAPISpec.Paths {
+		for method := range pathItem.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	require.Equal(t, documented, routes)
+}
+
+func Test_openAPIDrift(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": mockForecastBackend{
+			mockWeatherBackend: mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12, TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}},
+			returnForecast:     types.Forecast{Source: "foo", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}}},
+		},
+		"bar": mockWeatherBackend{returnWeather: types.Weather{Error: "Error communicating to backend"}},
+	}, []string{"foo", "bar"})
+	s.ipLocator = nil
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+	e := newOpenAPITestEcho(s)
+	// the handlers must send exactly what the spec says, nothing more
+	router := openapi3filter.NewRouter().WithSwagger(newOpenAPISpec(s.renderers, true))
+
+	tests := []struct {
+		method             string
+		target             string
+		body               string
+		expectedHTTPStatus int
+	}{
+		{method: http.MethodGet, target: "/v1/backends", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/weather/here", expectedHTTPStatus: http.StatusNotImplemented},
+		{method: http.MethodPost, target: "/v1/weather/batch", body: `{"items":[{"city":"foo"},{"coordinates":{"latitude":45.42,"longitude":-75.69}},{}]}`, expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/v1/weather/batch", body: `{"items":[]}`, expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/v1/weather/batch", body: `{"items":"foo"}`, expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/weather/foo", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/weather/foo?backend=baz", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/weather/foo?format=csv", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/weather/foo?format=xml&backend=baz", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/weather/foo?format=yaml", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/weather/foo?format=ansi&width=60&color=false", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/weather/foo?format=ansi&width=10", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/weather/%20/stream", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/forecast/foo", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/forecast/foo?backend=baz", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/forecast/foo?format=text", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodOptions, target: "/v1/weather", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/locations/search?q=foo", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v1/locations/search?q=foo&limit=0", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v1/locations/search", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/graphql", body: `{"query":"{ location(city: \"foo\") { weather { source temperature error } } }"}`, expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodPost, target: "/graphql", body: `{`, expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v2/weather/foo", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v2/weather/foo?backend=bar", expectedHTTPStatus: http.StatusBadGateway},
+		{method: http.MethodGet, target: "/v2/weather/foo?backend=baz", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v2/forecast/foo", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/v2/forecast/foo?backend=bar", expectedHTTPStatus: http.StatusBadRequest},
+		{method: http.MethodGet, target: "/v2/backends", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/openapi.json", expectedHTTPStatus: http.StatusOK},
+		{method: http.MethodGet, target: "/admin/config", expectedHTTPStatus: http.StatusForbidden},
+		{method: http.MethodPost, target: "/admin/reload", expectedHTTPStatus: http.StatusForbidden},
+	}
+	// the websocket can't be called through a recorder, and a stream that started never ends
+	tested := map[string]bool{"weatherWebSocket": true}
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.target+" "+tc.body, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code, rec.Body.String())
+
+			route, pathParams, err := router.FindRoute(tc.method, req.URL)
+			require.NoError(t, err)
+			tested[route.Operation.OperationID] = true
+			input := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: pathParams, Route: route},
+				Status:                 rec.Code,
+				Header:                 rec.Header(),
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			}
+			input.SetBodyBytes(rec.Body.Bytes())
+			require.NoError(t, openapi3filter.ValidateResponse(context.Background(), input))
+		})
+	}
+
+	for _, op := range apiOperations(s.renderers) {
+		require.True(t, tested[op.ID], "no test calls %s", op.ID)
+	}
+}
+
+func Test_openAPIValidationMiddleware(t *testing.T) {
+	// the middleware only guards /v2, use it everywhere to test it against the richer /v1 parameters and bodies
+	s := newTestServer(t)
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler(e)
+	e.Use(openAPIValidationMiddleware(s.openAPISpec))
+	s.registerRoutes(e)
+
+	tests := []struct {
+		name                string
+		method              string
+		target              string
+		contentType         string
+		body                string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "missing query parameter",
+			method:              http.MethodGet,
+			target:              "/v1/locations/search",
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Invalid query parameter q: must have a value"}`,
+		},
+		{
+			name:                "query parameter out of range",
+			method:              http.MethodGet,
+			target:              "/v1/locations/search?q=foo&limit=0",
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Invalid query parameter limit: Number must be at least 1"}`,
+		},
+		{
+			name:                "body of the wrong type",
+			method:              http.MethodPost,
+			target:              "/v1/weather/batch",
+			contentType:         echo.MIMEApplicationJSON,
+			body:                `{"items":[{"city":"foo"}],"backends":"foo"}`,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Invalid request body: Value must be of type array (at /backends)"}`,
+		},
+		{
+			name:                "body missing a property",
+			method:              http.MethodPost,
+			target:              "/graphql",
+			contentType:         echo.MIMEApplicationJSON,
+			body:                `{}`,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Invalid request body: Property 'query' is missing"}`,
+		},
+		{
+			name:                "body that isn't json",
+			method:              http.MethodPost,
+			target:              "/v1/weather/batch",
+			contentType:         echo.MIMEApplicationForm,
+			body:                `items=foo`,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Invalid request body: header 'Content-Type' has unexpected value: \"application/x-www-form-urlencoded\""}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, tc.contentType)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			require.Equal(t, tc.expectedContentType, rec.Header().Get(echo.HeaderContentType))
+			require.JSONEq(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func Test_openAPIValidationScope(t *testing.T) {
+	s := newTestServer(t)
+
+	// /v1 handlers check their requests themselves, and answer in the /v1 shape
+	req := httptest.NewRequest(http.MethodGet, "/v1/locations/search?q=foo&limit=0", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.JSONEq(t, `{"query":"foo","candidates":[],"error":"Invalid limit specified: 0"}`, rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/weather/batch", strings.NewReader(`{"items":"foo"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Contains(t, body["error"], "Unable to parse request body")
+}
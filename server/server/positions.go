@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// The parsers of the configuration files don't say where values are, so the files are scanned again for where the
+// settings are, by path (see joinSetting and indexSetting). The scanners only find the keys and list items, in the
+// layouts configuration files use. Settings they miss are reported at the closest setting they found instead.
+
+// offsetPosition returns the position of the byte at offset in b
+func offsetPosition(b []byte, offset int64) Position {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	return Position{Line: bytes.Count(before, []byte("\n")) + 1, Column: len(before) - bytes.LastIndexByte(before, '\n')}
+}
+
+// jsonPositions returns where the settings of a json configuration file are
+func jsonPositions(b []byte) map[string]Position {
+	s := &jsonScanner{b: b, positions: map[string]Position{}}
+	s.value("")
+	return s.positions
+}
+
+type jsonScanner struct {
+	b         []byte
+	i         int
+	positions map[string]Position
+}
+
+// value scans the value of setting, invalid json is left to the decoder to report
+func (s *jsonScanner) value(setting string) {
+	s.skipSpace()
+	if s.i >= len(s.b) {
+		return
+	}
+	switch s.b[s.i] {
+	case '{':
+		s.i++
+		for {
+			s.skipSpace()
+			if s.i >= len(s.b) || s.b[s.i] == '}' {
+				s.i++
+				return
+			}
+			if s.b[s.i] == ',' {
+				s.i++
+				continue
+			}
+			if s.b[s.i] != '"' {
+				return
+			}
+			start := s.i
+			key := joinSetting(setting, s.string())
+			s.skipSpace()
+			if s.i >= len(s.b) || s.b[s.i] != ':' {
+				return
+			}
+			s.i++
+			s.positions[key] = offsetPosition(s.b, int64(start))
+			s.value(key)
+		}
+	case '[':
+		s.i++
+		for index := 0; ; index++ {
+			s.skipSpace()
+			if s.i < len(s.b) && s.b[s.i] == ',' {
+				s.i++
+				s.skipSpace()
+			}
+			if s.i >= len(s.b) || s.b[s.i] == ']' {
+				s.i++
+				return
+			}
+			start := s.i
+			s.positions[indexSetting(setting, index)] = offsetPosition(s.b, int64(start))
+			s.value(indexSetting(setting, index))
+			if s.i == start {
+				return
+			}
+		}
+	case '"':
+		s.string()
+	default:
+		for s.i < len(s.b) && !strings.ContainsRune(" \t\r\n,]}", rune(s.b[s.i])) {
+			s.i++
+		}
+	}
+}
+
+// string scans a string and returns it decoded
+func (s *jsonScanner) string() string {
+	start := s.i
+	for s.i++; s.i < len(s.b) && s.b[s.i] != '"'; s.i++ {
+		if s.b[s.i] == '\\' {
+			s.i++
+		}
+	}
+	s.i++
+	if s.i > len(s.b) {
+		s.i = len(s.b)
+	}
+	decoded := ""
+	json.Unmarshal(s.b[start:s.i], &decoded)
+	return decoded
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.i < len(s.b) && strings.ContainsRune(" \t\r\n", rune(s.b[s.i])) {
+		s.i++
+	}
+}
+
+// yamlPositions returns where the settings of a yaml configuration file are, in block style. Settings in flow style,
+// i.e. {ttl: 1m}, are left out.
+func yamlPositions(b []byte) map[string]Position {
+	type frame struct {
+		indent   int
+		setting  string
+		sequence bool
+		index    int
+	}
+	positions := map[string]Position{}
+	stack := []*frame{}
+	last := ""        // the last key or list item, whose value may be on the lines that follow
+	blockIndent := -1 // the indentation of the key of a block scalar, its lines are more indented
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if blockIndent >= 0 {
+			if content == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if content == "" || content[0] == '#' || strings.HasPrefix(content, "---") || strings.HasPrefix(content, "...") {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		for content == "-" || strings.HasPrefix(content, "- ") {
+			if top := len(stack) - 1; top >= 0 && stack[top].sequence && stack[top].indent == indent {
+				stack[top].index++
+			} else {
+				stack = append(stack, &frame{indent: indent, setting: last, sequence: true})
+			}
+			top := stack[len(stack)-1]
+			last = indexSetting(top.setting, top.index)
+			positions[last] = Position{Line: n + 1, Column: indent + 1}
+			rest := strings.TrimLeft(content[1:], " ")
+			indent += len(content) - len(rest)
+			content = rest
+		}
+
+		key, value, ok := yamlKey(content)
+		if !ok {
+			continue // a value of a list, or the next line of a value
+		}
+		for len(stack) > 0 && stack[len(stack)-1].sequence && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if top := len(stack) - 1; top < 0 || stack[top].indent != indent {
+			stack = append(stack, &frame{indent: indent, setting: last})
+		}
+		last = joinSetting(stack[len(stack)-1].setting, key)
+		positions[last] = Position{Line: n + 1, Column: indent + 1}
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+	return positions
+}
+
+// yamlKey splits "key: value" into its key, unquoted, and its value
+func yamlKey(content string) (string, string, bool) {
+	if content == "" || strings.ContainsRune("{[#&*!|>", rune(content[0])) {
+		return "", "", false
+	}
+	end := -1
+	if quote := content[0]; quote == '"' || quote == '\'' {
+		if closing := strings.IndexByte(content[1:], quote); closing >= 0 {
+			end = closing + 2
+		}
+	} else if i := strings.Index(content+" ", ": "); i >= 0 {
+		end = i
+	}
+	if end < 0 || end >= len(content) || content[end] != ':' || (end+1 < len(content) && content[end+1] != ' ') {
+		return "", "", false
+	}
+	key := content[:end]
+	if key[0] == '"' || key[0] == '\'' {
+		key = key[1 : len(key)-1]
+	}
+	return key, strings.TrimSpace(content[end+1:]), true
+}
+
+// tomlPositions returns where the settings of a toml configuration file are
+func tomlPositions(b []byte) map[string]Position {
+	positions := map[string]Position{}
+	tableArrays := map[string]int{} // how many tables each array of tables has so far
+	table := ""
+	multiline := false
+	for n, line := range strings.Split(string(b), "\n") {
+		content := strings.TrimSpace(line)
+		column := strings.Index(line, content) + 1
+		if multiline {
+			multiline = (strings.Count(content, `"""`)+strings.Count(content, `'''`))%2 == 0
+			continue
+		}
+		if content == "" || content[0] == '#' {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(content, "[["):
+			end := strings.Index(content, "]]")
+			if end < 0 {
+				continue
+			}
+			array := tomlTable(content[2:end], tableArrays)
+			table = indexSetting(array, tableArrays[array])
+			tableArrays[array]++
+			positions[table] = Position{Line: n + 1, Column: column}
+		case strings.HasPrefix(content, "["):
+			end := strings.Index(content, "]")
+			if end < 0 {
+				continue
+			}
+			table = tomlTable(content[1:end], tableArrays)
+			positions[table] = Position{Line: n + 1, Column: column}
+		default:
+			equals := strings.Index(content, "=")
+			if equals < 0 {
+				continue // the next line of a list
+			}
+			setting := table
+			for _, key := range tomlKeys(content[:equals]) {
+				setting = joinSetting(setting, key)
+			}
+			positions[setting] = Position{Line: n + 1, Column: column}
+			value := content[equals+1:]
+			multiline = (strings.Count(value, `"""`)+strings.Count(value, `'''`))%2 == 1
+		}
+	}
+	return positions
+}
+
+// tomlTable returns the setting of a table header, the tables it is in are the last ones of their arrays of tables
+func tomlTable(header string, tableArrays map[string]int) string {
+	keys := tomlKeys(header)
+	setting := ""
+	for i, key := range keys {
+		setting = joinSetting(setting, key)
+		if count, ok := tableArrays[setting]; ok && i < len(keys)-1 {
+			setting = indexSetting(setting, count-1)
+		}
+	}
+	return setting
+}
+
+// tomlKeys splits a dotted key, i.e. `backends."eu".apiKey`, into its keys, unquoted
+func tomlKeys(dotted string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(dotted, ".") {
+		keys = append(keys, strings.Trim(strings.TrimSpace(key), `"'`))
+	}
+	return keys
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_jsonPositions(t *testing.T) {
+	positions := jsonPositions([]byte("{\n  \"http\": {\"allowedOrigins\": [\"a\", \"b\\\"\"]},\n  \"backends\": [\n    {\"type\": \"plugin\", \"config\": {\"x\": [1, {}]}}\n  ]\n}"))
+	require.Equal(t, map[string]Position{
+		"http":                    {Line: 2, Column: 3},
+		"http.allowedOrigins":     {Line: 2, Column: 12},
+		"http.allowedOrigins[0]":  {Line: 2, Column: 31},
+		"http.allowedOrigins[1]":  {Line: 2, Column: 36},
+		"backends":                {Line: 3, Column: 3},
+		"backends[0]":             {Line: 4, Column: 5},
+		"backends[0].type":        {Line: 4, Column: 6},
+		"backends[0].config":      {Line: 4, Column: 24},
+		"backends[0].config.x":    {Line: 4, Column: 35},
+		"backends[0].config.x[0]": {Line: 4, Column: 41},
+		"backends[0].config.x[1]": {Line: 4, Column: 44},
+	}, positions)
+}
+
+func Test_yamlPositions(t *testing.T) {
+	positions := yamlPositions([]byte(`# the server
+http:
+  address: ":8080"
+  allowedOrigins:
+    - https://a.example.com # the UI
+    - https://b.example.com
+backends:
+- name: eu
+  type: plugin
+  command:
+  - ./model
+  config: |
+    key: not a setting
+- "type": accuweather
+
+  apiKey: foo
+cache: {ttl: 1m}
+`))
+	require.Equal(t, map[string]Position{
+		"http":                   {Line: 2, Column: 1},
+		"http.address":           {Line: 3, Column: 3},
+		"http.allowedOrigins":    {Line: 4, Column: 3},
+		"http.allowedOrigins[0]": {Line: 5, Column: 5},
+		"http.allowedOrigins[1]": {Line: 6, Column: 5},
+		"backends":               {Line: 7, Column: 1},
+		"backends[0]":            {Line: 8, Column: 1},
+		"backends[0].name":       {Line: 8, Column: 3},
+		"backends[0].type":       {Line: 9, Column: 3},
+		"backends[0].command":    {Line: 10, Column: 3},
+		"backends[0].command[0]": {Line: 11, Column: 3},
+		"backends[0].config":     {Line: 12, Column: 3},
+		"backends[1]":            {Line: 14, Column: 1},
+		"backends[1].type":       {Line: 14, Column: 3},
+		"backends[1].apiKey":     {Line: 16, Column: 3},
+		"cache":                  {Line: 17, Column: 1},
+	}, positions)
+}
+
+func Test_tomlPositions(t *testing.T) {
+	positions := tomlPositions([]byte(`# the server
+[http]
+address = ":8080"
+allowedOrigins = [
+  "https://a.example.com",
+]
+
+[[backends]]
+type = "plugin"
+command = ["./model"]
+  [backends.config]
+  text = """
+key = not a setting
+"""
+
+[[backends]]
+"type" = "accuweather"
+apiKey = "foo"
+
+[cache]
+ttl = "1m"
+`))
+	require.Equal(t, map[string]Position{
+		"http":                    {Line: 2, Column: 1},
+		"http.address":            {Line: 3, Column: 1},
+		"http.allowedOrigins":     {Line: 4, Column: 1},
+		"backends[0]":             {Line: 8, Column: 1},
+		"backends[0].type":        {Line: 9, Column: 1},
+		"backends[0].command":     {Line: 10, Column: 1},
+		"backends[0].config":      {Line: 11, Column: 3},
+		"backends[0].config.text": {Line: 12, Column: 3},
+		"backends[1]":             {Line: 16, Column: 1},
+		"backends[1].type":        {Line: 17, Column: 1},
+		"backends[1].apiKey":      {Line: 18, Column: 1},
+		"cache":                   {Line: 20, Column: 1},
+		"cache.ttl":               {Line: 21, Column: 1},
+	}, positions)
+}
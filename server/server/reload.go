@@ -0,0 +1,149 @@
+package server
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/types"
+)
+
+// DefaultConfigWatchInterval is how often the configuration file is checked for changes, see WatchConfigFile
+const DefaultConfigWatchInterval = 2 * time.Second
+
+// backendSet is the configured backends, it is replaced as a whole when the configuration is reloaded so that a
+// request never sees half of the old backends and half of the new ones
+type backendSet struct {
+	configured map[string]types.WeatherBackend
+	defaults   []string // used when no backends are specified explicitly
+	names      []string // every configured backend, sorted
+
+	// inFlight are the calls to the backends, they are only closed once the calls are done
+	inFlight sync.WaitGroup
+}
+
+func newBackendSet(configured map[string]types.WeatherBackend, defaults []string) *backendSet {
+	set := &backendSet{configured: configured, defaults: defaults, names: []string{}}
+	for name := range configured {
+		set.names = append(set.names, name)
+	}
+	sort.Strings(set.names)
+	return set
+}
+
+// backends returns the backends as they are currently configured
+func (s *Server) backends() *backendSet {
+	s.backendsMu.RLock()
+	defer s.backendsMu.RUnlock()
+	return s.backendSet
+}
+
+// acquireBackend returns a backend as it is currently configured, along with the function to call once done with it,
+// or nil when there is no such backend (anymore)
+func (s *Server) acquireBackend(name string) (types.WeatherBackend, func()) {
+	s.backendsMu.RLock()
+	defer s.backendsMu.RUnlock()
+	backend := s.backendSet.configured[name]
+	if backend == nil {
+		return nil, func() {}
+	}
+	set := s.backendSet
+	set.inFlight.Add(1)
+	return backend, set.inFlight.Done
+}
+
+// setBackends replaces the backends, and closes the ones it replaced (see io.Closer) once the calls to them are done
+func (s *Server) setBackends(configured map[string]types.WeatherBackend, defaults []string) {
+	s.backendsMu.Lock()
+	old := s.backendSet
+	s.backendSet = newBackendSet(configured, defaults)
+	s.backendsMu.Unlock()
+
+	if old != nil {
+		s.closeBackends(old)
+	}
+}
+
+// closeBackends closes the backends of set that can be closed, i.e. plugins, once the calls to them are done
+func (s *Server) closeBackends(set *backendSet) {
+	set.inFlight.Wait()
+	for _, name := range set.names {
+		if closer, ok := set.configured[name].(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				s.logger.Error("unable to close backend ", name, ": ", err)
+			}
+		}
+	}
+}
+
+// Reload replaces the backends with the ones of the configuration returned by load, i.e. to rotate an API key or to
+// enable a backend, without restarting the server. The other settings are only read by New. The current backends
+// keep serving until every new one is created, and are left as they are when the configuration can't be loaded or
+// any backend can't be created. The outcome is logged and counted in the metrics.
+func (s *Server) Reload(load func() (*Config, error)) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	config, err := load()
+	if err == nil {
+		s.redactor.Add(secretValues(config)...)
+		var configured map[string]types.WeatherBackend
+		var defaults []string
+		configured, defaults, err = backends.Configure(config.Backends, s.backendEnvironment())
+		if err == nil {
+			s.setBackends(configured, defaults)
+			// only the backends are reloaded, the configuration in effect is the one of New with the new backends
+			current := *s.config
+			current.Backends = config.Backends
+			s.config = &current
+		}
+	}
+
+	if err != nil {
+		s.logger.Error("unable to reload the configuration, keeping the current one: ", err)
+		s.metrics.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		s.metrics.ConfigLastReloadSuccessful.Set(0)
+		return err
+	}
+	s.logger.Info("reloaded the configuration, backends: ", strings.Join(s.backends().names, ", "))
+	s.metrics.ConfigReloadsTotal.WithLabelValues("success").Inc()
+	s.metrics.ConfigLastReloadSuccessful.Set(1)
+	s.metrics.ConfigLastReloadSuccessTimestamp.Set(float64(s.now().Unix()))
+	return nil
+}
+
+// Close closes the open /v1/ws connections, then the backends that can be closed, i.e. plugins, once the calls to them
+// are done. The server must not be used afterwards.
+func (s *Server) Close() error {
+	s.closeWebSockets()
+	s.closeBackends(s.backends())
+	return nil
+}
+
+// WatchConfigFile calls changed whenever the configuration file at path is modified, checking it every interval until
+// stop is closed. Files that are replaced rather than written to, i.e. Kubernetes ConfigMaps, are noticed too.
+func WatchConfigFile(path string, interval time.Duration, stop <-chan struct{}, changed func()) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(path)
+		if err != nil {
+			continue // i.e. in the middle of being replaced, the next check sees the new file
+		}
+		if last == nil || !current.ModTime().Equal(last.ModTime()) || current.Size() != last.Size() || !os.SameFile(current, last) {
+			last = current
+			changed()
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/backends/accuweather"
+	"go-weather-app/server/backends/openweathermap"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// mockClosingBackend records when it is closed, and how many calls to it were still running by then
+type mockClosingBackend struct {
+	mockWeatherBackend
+	calls   *int
+	closed  chan int
+	release chan struct{}
+}
+
+func (m mockClosingBackend) GetWeather(loc location.Location) types.Weather {
+	*m.calls++
+	<-m.release
+	*m.calls--
+	return m.returnWeather
+}
+
+func (m mockClosingBackend) Close() error {
+	m.closed <- *m.calls
+	return nil
+}
+
+// gaugeValue returns the value of the gauge called name in registry
+func gaugeValue(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatal("no such metric: ", name)
+	return 0
+}
+
+func Test_Reload(t *testing.T) {
+	tests := []struct {
+		name               string
+		load               func() (*Config, error)
+		expectedBackends   []string
+		expectedDefaults   []string
+		expectedSuccessful float64
+		expectedErr        error
+	}{
+		{
+			name: "backends of the new configuration replace the current ones",
+			load: func() (*Config, error) {
+				return &Config{Backends: backends.Config{
+					{Type: accuweather.Type, Options: json.RawMessage(`{"apiKey": "test"}`)},
+					{Name: "owm", Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "rotated"}`)},
+				}}, nil
+			},
+			expectedBackends:   []string{accuweather.Type, "owm"},
+			expectedDefaults:   []string{accuweather.Type, "owm"},
+			expectedSuccessful: 1,
+		},
+		{
+			name:               "configuration that can't be loaded keeps the current backends",
+			load:               func() (*Config, error) { return nil, errors.New("Unable to read the configuration") },
+			expectedBackends:   []string{openweathermap.Type},
+			expectedDefaults:   []string{openweathermap.Type},
+			expectedSuccessful: 0,
+			expectedErr:        errors.New("Unable to read the configuration"),
+		},
+		{
+			name: "configuration with a backend that can't be created keeps the current backends",
+			load: func() (*Config, error) {
+				return &Config{Backends: backends.Config{{Type: "unknown"}}}, nil
+			},
+			expectedBackends:   []string{openweathermap.Type},
+			expectedDefaults:   []string{openweathermap.Type},
+			expectedSuccessful: 0,
+			expectedErr:        errors.New("Unknown backend type for backend unknown: unknown"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			s, err := New(WithConfig(&Config{Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "test"}`)}}}), WithRegistry(registry, registry), WithClock(func() time.Time { return time.Unix(1560000000, 0) }))
+			require.NoError(t, err)
+			require.Equal(t, float64(1), gaugeValue(t, registry, "weather_config_last_reload_successful"))
+
+			err = s.Reload(tc.load)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedBackends, s.backends().names)
+			require.Equal(t, tc.expectedDefaults, s.backends().defaults)
+			require.Equal(t, tc.expectedSuccessful, gaugeValue(t, registry, "weather_config_last_reload_successful"))
+			require.Equal(t, float64(1560000000), gaugeValue(t, registry, "weather_config_last_reload_success_timestamp_seconds"))
+		})
+	}
+}
+
+func Test_setBackendsClosesReplacedBackends(t *testing.T) {
+	s := newTestServer(t)
+	calls, closed, release := 0, make(chan int, 1), make(chan struct{})
+	s.setBackends(map[string]types.WeatherBackend{
+		"plugin": mockClosingBackend{calls: &calls, closed: closed, release: release},
+	}, []string{"plugin"})
+
+	backend, done := s.acquireBackend("plugin")
+	require.NotNil(t, backend)
+	go func() {
+		defer done()
+		backend.GetWeather(location.Location{Name: "Ottawa"})
+	}()
+
+	replaced := make(chan struct{})
+	go func() {
+		s.setBackends(map[string]types.WeatherBackend{"foo": mockWeatherBackend{}}, []string{"foo"})
+		close(replaced)
+	}()
+
+	// the new backends are used right away, the replaced one is only closed once the call to it is done
+	for deadline := time.Now().Add(time.Second); s.backends().names[0] != "foo" && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, []string{"foo"}, s.backends().names)
+	removed, _ := s.acquireBackend("plugin")
+	require.Nil(t, removed)
+	select {
+	case <-closed:
+		t.Fatal("backend closed while a call to it is running")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	require.Equal(t, 0, <-closed)
+	<-replaced
+}
+
+func Test_Close(t *testing.T) {
+	s := newTestServer(t)
+	calls, closed, release := 0, make(chan int, 1), make(chan struct{})
+	close(release)
+	s.setBackends(map[string]types.WeatherBackend{
+		"plugin": mockClosingBackend{calls: &calls, closed: closed, release: release},
+		"foo":    mockWeatherBackend{},
+	}, []string{"plugin", "foo"})
+
+	require.NoError(t, s.Close())
+	require.Equal(t, 0, <-closed)
+}
+
+func Test_WatchConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{}`), 0644))
+
+	changed := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		WatchConfigFile(path, time.Millisecond, stop, func() { changed <- struct{}{} })
+		close(stopped)
+	}()
+
+	select {
+	case <-changed:
+		t.Fatal("unmodified file reported as changed")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// replaced rather than written to, the way Kubernetes updates mounted ConfigMaps
+	replacement := filepath.Join(dir, "config.json.new")
+	require.NoError(t, ioutil.WriteFile(replacement, []byte(`{"backends": []}`), 0644))
+	require.NoError(t, os.Rename(replacement, path))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("replaced file not reported as changed")
+	}
+
+	close(stop)
+	<-stopped
+}
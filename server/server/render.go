@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/report"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Renderer writes weather and forecast responses in a single format, see WithRenderer
+type Renderer interface {
+	// MediaTypes are the media types of Accept headers the renderer is picked for, the first one is the content type of
+	// what it writes
+	MediaTypes() []string
+	RenderWeather(w io.Writer, response *WeatherResponse) error
+	RenderForecast(w io.Writer, response *ForecastResponse) error
+}
+
+// DefaultFormat is the format used when clients don't ask for any (or for one we don't have)
+const DefaultFormat = "json"
+
+// TerminalFormat is the format sent to terminals (see terminalUserAgents), it is never picked by the Accept header since
+// it is plain text that only looks right on a terminal
+const TerminalFormat = "ansi"
+
+// terminalUserAgents are the User-Agent prefixes of command line clients that get TerminalFormat unless they ask for
+// another format
+var terminalUserAgents = []string{"curl/", "Wget/"}
+
+// rendererSet is the formats the weather and forecast endpoints of a server can answer in, by the name clients pass as
+// the format query parameter
+type rendererSet map[string]Renderer
+
+// defaultRenderers are the formats every server has, others are added with WithRenderer
+func defaultRenderers() rendererSet {
+	return rendererSet{
+		DefaultFormat:  jsonRenderer{},
+		"csv":          csvRenderer{},
+		"xml":          xmlRenderer{},
+		"text":         textRenderer{},
+		TerminalFormat: ansiRenderer{Options: report.Options{Width: report.DefaultWidth, Color: true}},
+	}
+}
+
+// WithRenderer lets the weather and forecast endpoints answer in another format, by the name clients pass as the format
+// query parameter. A renderer replaces the default one of the same name.
+func WithRenderer(format string, renderer Renderer) Option {
+	return func(s *Server) {
+		s.renderers[format] = renderer
+	}
+}
+
+// requestRenderer is implemented by renderers with options of their own, it returns the renderer with the options of
+// the request
+type requestRenderer interface {
+	forRequest(c echo.Context) (Renderer, error)
+}
+
+// reportRenderer is implemented by renderers that show the forecast along with the current weather, the weather
+// endpoints fetch both for them
+type reportRenderer interface {
+	Renderer
+	RenderReport(w io.Writer, weather *WeatherResponse, forecast *ForecastResponse) error
+}
+
+// formats returns the names of the renderers, DefaultFormat first then sorted
+func (renderers rendererSet) formats() []string {
+	names := []string{DefaultFormat}
+	for name := range renderers {
+		if name != DefaultFormat {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// negotiate picks the renderer asked for by the format query parameter, or else the preferred one of the Accept
+// header. Terminals that accept anything get TerminalFormat, other clients that don't accept any of our formats get
+// DefaultFormat anyway.
+func (renderers rendererSet) negotiate(c echo.Context) (Renderer, error) {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	c.Response().Header().Add(echo.HeaderVary, "User-Agent")
+
+	format := strings.TrimSpace(c.QueryParam("format"))
+	if format == "" {
+		format = renderers.acceptedFormat(c.Request().Header.Get(echo.HeaderAccept))
+		if acceptsAnything(c.Request().Header.Get(echo.HeaderAccept)) && isTerminal(c.Request().UserAgent()) {
+			format = TerminalFormat
+		}
+	}
+	renderer, ok := renderers[format]
+	if !ok {
+		return nil, errors.New("Unknown format specified: " + format)
+	}
+	if r, ok := renderer.(requestRenderer); ok {
+		return r.forRequest(c)
+	}
+	return renderer, nil
+}
+
+// acceptsAnything is true for the Accept headers command line clients send by default
+func acceptsAnything(accept string) bool {
+	accept = strings.TrimSpace(accept)
+	return accept == "" || accept == "*/*"
+}
+
+func isTerminal(userAgent string) bool {
+	for _, prefix := range terminalUserAgents {
+		if strings.HasPrefix(userAgent, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedFormat returns the format of the Accept header's media range with the highest quality we have a renderer for
+func (renderers rendererSet) acceptedFormat(accept string) string {
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	for _, r := range ranges {
+		if r.quality <= 0 {
+			break
+		}
+		if r.mediaType == "*/*" {
+			return DefaultFormat
+		}
+		for _, format := range renderers.formats() {
+			if format == TerminalFormat {
+				continue
+			}
+			for _, mediaType := range renderers[format].MediaTypes() {
+				if mediaType == r.mediaType || strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")) {
+					return format
+				}
+			}
+		}
+	}
+	return DefaultFormat
+}
+
+// renderWeather writes the response with the renderer negotiated with the client
+func renderWeather(c echo.Context, renderer Renderer, code int, response *WeatherResponse) error {
+	b := &bytes.Buffer{}
+	if err := renderer.RenderWeather(b, response); err != nil {
+		return err
+	}
+	return c.Blob(code, renderer.MediaTypes()[0]+"; charset=UTF-8", b.Bytes())
+}
+
+// renderForecast writes the response with the renderer negotiated with the client
+func renderForecast(c echo.Context, renderer Renderer, code int, response *ForecastResponse) error {
+	b := &bytes.Buffer{}
+	if err := renderer.RenderForecast(b, response); err != nil {
+		return err
+	}
+	return c.Blob(code, renderer.MediaTypes()[0]+"; charset=UTF-8", b.Bytes())
+}
+
+// renderReport writes the current weather and the forecast with a renderer that shows both
+func renderReport(c echo.Context, renderer reportRenderer, code int, weather *WeatherResponse, forecast *ForecastResponse) error {
+	b := &bytes.Buffer{}
+	if err := renderer.RenderReport(b, weather, forecast); err != nil {
+		return err
+	}
+	return c.Blob(code, renderer.MediaTypes()[0]+"; charset=UTF-8", b.Bytes())
+}
+
+// jsonRenderer writes the same json as the other endpoints
+type jsonRenderer struct{}
+
+func (jsonRenderer) MediaTypes() []string {
+	return []string{echo.MIMEApplicationJSON}
+}
+
+func (jsonRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	return writeIndentedJSON(w, response)
+}
+
+func (jsonRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	return writeIndentedJSON(w, response)
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// xmlRenderer writes the same fields as the json, with the same names
+type xmlRenderer struct{}
+
+func (xmlRenderer) MediaTypes() []string {
+	return []string{echo.MIMEApplicationXML, echo.MIMETextXML}
+}
+
+func (xmlRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	return writeIndentedXML(w, struct {
+		XMLName xml.Name `xml:"weather"`
+		*WeatherResponse
+	}{WeatherResponse: response})
+}
+
+func (xmlRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	return writeIndentedXML(w, struct {
+		XMLName xml.Name `xml:"forecast"`
+		*ForecastResponse
+	}{ForecastResponse: response})
+}
+
+func writeIndentedXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// csvRenderer writes a header and a row for every reading (or forecast day), for spreadsheets
+type csvRenderer struct{}
+
+func (csvRenderer) MediaTypes() []string {
+	return []string{"text/csv"}
+}
+
+func (csvRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"city", "location", "source", "temperature", "temperature_min", "temperature_max", "main_description", "detailed_description", "error"})
+	if response.Error != "" {
+		out.Write([]string{response.City, responseLocation(response.Location), "", "", "", "", "", "", response.Error})
+	}
+	for _, weather := range response.Data {
+		if weather.Error != "" {
+			out.Write([]string{response.City, responseLocation(response.Location), weather.Source, "", "", "", "", "", weather.Error})
+			continue
+		}
+		out.Write([]string{
+			response.City, responseLocation(response.Location), weather.Source,
+			formatTemperature(weather.Temperature), formatTemperature(weather.TemperatureMin), formatTemperature(weather.TemperatureMax),
+			weather.MainDescription, weather.DetailedDescription, "",
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (csvRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"city", "location", "source", "date", "temperature_min", "temperature_max", "main_description", "detailed_description", "error"})
+	if response.Error != "" {
+		out.Write([]string{response.City, responseLocation(response.Location), "", "", "", "", "", "", response.Error})
+	}
+	for _, forecast := range response.Data {
+		if forecast.Error != "" {
+			out.Write([]string{response.City, responseLocation(response.Location), forecast.Source, "", "", "", "", "", forecast.Error})
+			continue
+		}
+		for _, day := range forecast.Days {
+			out.Write([]string{
+				response.City, responseLocation(response.Location), forecast.Source, day.Date,
+				formatTemperature(day.TemperatureMin), formatTemperature(day.TemperatureMax),
+				day.MainDescription, day.DetailedDescription, "",
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// textRenderer writes a single line, for shell scripts and status bars, i.e.
+// "Ottawa, ON, CA: openweathermap 12°C (9°C/14°C) Rain | accuweather 11°C (8°C/13°C) Showers"
+type textRenderer struct{}
+
+func (textRenderer) MediaTypes() []string {
+	return []string{echo.MIMETextPlain}
+}
+
+func (textRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	sources := []string{}
+	for _, weather := range response.Data {
+		if weather.Error != "" {
+			sources = append(sources, weather.Source+" error: "+weather.Error)
+			continue
+		}
+		sources = append(sources, strings.TrimSpace(weather.Source+" "+formatTemperature(weather.Temperature)+"°C ("+
+			formatTemperature(weather.TemperatureMin)+"°C/"+formatTemperature(weather.TemperatureMax)+"°C) "+weather.MainDescription))
+	}
+	return writeTextLine(w, response.City, response.Location, response.Error, sources)
+}
+
+func (textRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	sources := []string{}
+	for _, forecast := range response.Data {
+		if forecast.Error != "" {
+			sources = append(sources, forecast.Source+" error: "+forecast.Error)
+			continue
+		}
+		days := []string{}
+		for _, day := range forecast.Days {
+			days = append(days, strings.TrimSpace(day.Date+" "+formatTemperature(day.TemperatureMin)+"°C/"+
+				formatTemperature(day.TemperatureMax)+"°C "+day.MainDescription))
+		}
+		sources = append(sources, forecast.Source+" "+strings.Join(days, ", "))
+	}
+	return writeTextLine(w, response.City, response.Location, response.Error, sources)
+}
+
+func writeTextLine(w io.Writer, city string, loc *location.Location, errMsg string, sources []string) error {
+	label := responseLabel(city, loc)
+	line := strings.Join(sources, " | ")
+	if errMsg != "" {
+		line = "error: " + errMsg
+	}
+	if label != "" {
+		line = label + ": " + line
+	}
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+// responseLocation returns the canonical location of a response, when it was resolved
+func responseLocation(loc *location.Location) string {
+	if loc == nil {
+		return ""
+	}
+	return loc.String()
+}
+
+func formatTemperature(temperature float32) string {
+	return strconv.FormatFloat(float64(temperature), 'f', -1, 32)
+}
+
+// ansiRenderer writes a colored report with icons for the conditions, for people curl'ing the server from a terminal
+type ansiRenderer struct {
+	report.Options
+}
+
+func (r ansiRenderer) forRequest(c echo.Context) (Renderer, error) {
+	if width := c.QueryParam("width"); width != "" {
+		n, err := strconv.Atoi(width)
+		if err != nil || n < report.MinWidth || n > report.MaxWidth {
+			return nil, errors.New("Invalid width specified: " + width)
+		}
+		r.Width = n
+	}
+	if color := c.QueryParam("color"); color != "" {
+		b, err := strconv.ParseBool(color)
+		if err != nil {
+			return nil, errors.New("Invalid color specified: " + color)
+		}
+		r.Color = b
+	}
+	return r, nil
+}
+
+func (ansiRenderer) MediaTypes() []string {
+	return []string{echo.MIMETextPlain}
+}
+
+func (r ansiRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	return r.RenderReport(w, response, nil)
+}
+
+func (r ansiRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	return r.RenderReport(w, nil, response)
+}
+
+func (r ansiRenderer) RenderReport(w io.Writer, weather *WeatherResponse, forecast *ForecastResponse) error {
+	rep := report.Report{}
+	if weather != nil {
+		rep.Label, rep.Weather, rep.Error = responseLabel(weather.City, weather.Location), weather.Data, weather.Error
+	}
+	if forecast != nil {
+		rep.Forecast = forecast.Data
+		if weather == nil {
+			rep.Label, rep.Error = responseLabel(forecast.City, forecast.Location), forecast.Error
+		}
+	}
+	return report.Write(w, rep, r.Options)
+}
+
+// responseLabel names the location of a response, canonical when it was resolved
+func responseLabel(city string, loc *location.Location) string {
+	if loc != nil {
+		return loc.String()
+	}
+	return city
+}
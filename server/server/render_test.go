@@ -0,0 +1,264 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-weather-app/server/cache"
+	"go-weather-app/server/location"
+	"go-weather-app/server/report"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rendererSet_acceptedFormat(t *testing.T) {
+	tests := []struct {
+		accept         string
+		expectedFormat string
+	}{
+		{accept: "", expectedFormat: "json"},
+		{accept: "*/*", expectedFormat: "json"},
+		{accept: "text/csv", expectedFormat: "csv"},
+		{accept: "text/xml", expectedFormat: "xml"},
+		{accept: "text/html,application/xml;q=0.9,*/*;q=0.8", expectedFormat: "xml"},
+		{accept: "text/plain;q=0.5, text/csv", expectedFormat: "csv"},
+		{accept: "text/csv;q=0, text/plain;q=0.1", expectedFormat: "text"},
+		{accept: "text/*", expectedFormat: "csv"},
+		{accept: "image/png", expectedFormat: "json"},
+		{accept: "not a media type", expectedFormat: "json"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.accept, func(t *testing.T) {
+			require.Equal(t, tc.expectedFormat, defaultRenderers().acceptedFormat(tc.accept))
+		})
+	}
+}
+
+func Test_rendererSet_negotiate(t *testing.T) {
+	tests := []struct {
+		name             string
+		target           string
+		accept           string
+		userAgent        string
+		expectedRenderer Renderer
+		expectedError    string
+	}{
+		{name: "browser", accept: "text/html,*/*;q=0.8", userAgent: "Mozilla/5.0", expectedRenderer: jsonRenderer{}},
+		{name: "curl", accept: "*/*", userAgent: "curl/7.64.1", expectedRenderer: ansiRenderer{Options: report.Options{Width: 80, Color: true}}},
+		{name: "wget", userAgent: "Wget/1.20.3 (linux-gnu)", expectedRenderer: ansiRenderer{Options: report.Options{Width: 80, Color: true}}},
+		{name: "curl asking for csv", accept: "text/csv", userAgent: "curl/7.64.1", expectedRenderer: csvRenderer{}},
+		{name: "curl asking for json", target: "/?format=json", userAgent: "curl/7.64.1", expectedRenderer: jsonRenderer{}},
+		{name: "text/plain is never ansi", accept: "text/plain", expectedRenderer: textRenderer{}},
+		{name: "ansi options", target: "/?format=ansi&width=120&color=false", expectedRenderer: ansiRenderer{Options: report.Options{Width: 120, Color: false}}},
+		{name: "width too narrow", target: "/?width=20", userAgent: "curl/7.64.1", expectedError: "Invalid width specified: 20"},
+		{name: "invalid color", target: "/?format=ansi&color=sure", expectedError: "Invalid color specified: sure"},
+		{name: "unknown format", target: "/?format=yaml", expectedError: "Unknown format specified: yaml"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := tc.target
+			if target == "" {
+				target = "/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set(echo.HeaderAccept, tc.accept)
+			req.Header.Set("User-Agent", tc.userAgent)
+			rec := httptest.NewRecorder()
+			renderer, err := defaultRenderers().negotiate(echo.New().NewContext(req, rec))
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRenderer, renderer)
+			require.Equal(t, []string{echo.HeaderAccept, "User-Agent"}, rec.Header()[echo.HeaderVary])
+		})
+	}
+}
+
+func Test_renderWeatherAndForecast(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": mockForecastBackend{
+			mockWeatherBackend: mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12.5, TemperatureMin: -2, TemperatureMax: 20, MainDescription: "Sunny", DetailedDescription: "Clear, warm"}},
+			returnForecast: types.Forecast{Source: "foo", Days: []types.ForecastDay{
+				{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"},
+				{Date: "2019-06-02", TemperatureMin: 3, TemperatureMax: 18, MainDescription: "Rain"},
+			}},
+		},
+		// backends don't name themselves when they fail
+		"bar": mockWeatherBackend{returnWeather: types.Weather{Error: "Error communicating to backend"}},
+	}, []string{"foo", "bar"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+	s.locationResolver = location.Resolver{Geocoder: location.Passthrough{}}
+
+	e := echo.New()
+	e.GET("/v1/weather/:city", s.getWeather)
+	e.GET("/v1/forecast/:city", s.getForecast)
+
+	tests := []struct {
+		name                string
+		target              string
+		accept              string
+		userAgent           string
+		expectedHTTPStatus  int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "json by default",
+			target:              "/v1/weather/ottawa?backend=bar",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        "{\n  \"city\": \"ottawa\",\n  \"location\": {\n    \"name\": \"ottawa\"\n  },\n  \"data\": [\n    {\n      \"source\": \"\",\n      \"temperature\": 0,\n      \"temperature_min\": 0,\n      \"temperature_max\": 0,\n      \"error\": \"Error communicating to backend\"\n    }\n  ]\n}\n",
+		},
+		{
+			name:                "csv through the Accept header",
+			target:              "/v1/weather/ottawa",
+			accept:              "text/csv",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "text/csv; charset=UTF-8",
+			expectedBody:        "city,location,source,temperature,temperature_min,temperature_max,main_description,detailed_description,error\nottawa,ottawa,foo,12.5,-2,20,Sunny,\"Clear, warm\",\nottawa,ottawa,bar,,,,,,Error communicating to backend\n",
+		},
+		{
+			name:                "the format parameter wins over the Accept header",
+			target:              "/v1/weather/ottawa?format=text",
+			accept:              "text/csv",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMETextPlainCharsetUTF8,
+			expectedBody:        "ottawa: foo 12.5°C (-2°C/20°C) Sunny | bar error: Error communicating to backend\n",
+		},
+		{
+			name:                "xml",
+			target:              "/v1/weather/ottawa?format=xml&backend=foo",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationXMLCharsetUTF8,
+			expectedBody:        xmlHeader + "<weather>\n  <city>ottawa</city>\n  <location>\n    <name>ottawa</name>\n  </location>\n  <data>\n    <weather>\n      <source>foo</source>\n      <temperature>12.5</temperature>\n      <temperature_min>-2</temperature_min>\n      <temperature_max>20</temperature_max>\n      <main_description>Sunny</main_description>\n      <detailed_description>Clear, warm</detailed_description>\n    </weather>\n  </data>\n</weather>\n",
+		},
+		{
+			name:                "errors are rendered too",
+			target:              "/v1/weather/ottawa?format=text&backend=baz",
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: echo.MIMETextPlainCharsetUTF8,
+			expectedBody:        "ottawa: error: Backend specified is invalid or inactive: baz\n",
+		},
+		{
+			name:                "unknown format",
+			target:              "/v1/weather/ottawa?format=yaml",
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        "{\n  \"error\": \"Unknown format specified: yaml\"\n}\n",
+		},
+		{
+			name:                "forecast as csv",
+			target:              "/v1/forecast/ottawa?format=csv",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: "text/csv; charset=UTF-8",
+			expectedBody:        "city,location,source,date,temperature_min,temperature_max,main_description,detailed_description,error\nottawa,ottawa,foo,2019-06-01,2,20,Sunny,,\nottawa,ottawa,foo,2019-06-02,3,18,Rain,,\nottawa,ottawa,bar,,,,,,Forecasts are not supported by this backend\n",
+		},
+		{
+			name:                "forecast as text",
+			target:              "/v1/forecast/ottawa?format=text",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMETextPlainCharsetUTF8,
+			expectedBody:        "ottawa: foo 2019-06-01 2°C/20°C Sunny, 2019-06-02 3°C/18°C Rain | bar error: Forecasts are not supported by this backend\n",
+		},
+		{
+			name:                "terminal report with the forecast",
+			target:              "/v1/weather/ottawa?color=false",
+			userAgent:           "curl/7.64.1",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMETextPlainCharsetUTF8,
+			expectedBody: `Weather report: ottawa
+
+foo
+    \   /     Sunny
+     .-.      12.5°C (-2°C/20°C)
+  -- (   ) -- Clear, warm
+     ` + "`-'" + `
+    /   \
+
+bar
+error: Error communicating to backend
+
+Forecast  2019-06-01          2019-06-02
+foo       2°C/20°C Sunny      3°C/18°C Rain
+bar       error: Forecasts are not supported by this backend
+`,
+		},
+		{
+			name:                "terminal forecast",
+			target:              "/v1/forecast/ottawa?format=ansi&color=false&backend=foo",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMETextPlainCharsetUTF8,
+			expectedBody:        "Weather report: ottawa\n\nForecast  2019-06-01          2019-06-02\nfoo       2°C/20°C Sunny      3°C/18°C Rain\n",
+		},
+		{
+			name:                "forecast as xml",
+			target:              "/v1/forecast/ottawa?backend=foo",
+			accept:              "application/xml",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationXMLCharsetUTF8,
+			expectedBody:        xmlHeader + "<forecast>\n  <city>ottawa</city>\n  <location>\n    <name>ottawa</name>\n  </location>\n  <data>\n    <forecast>\n      <source>foo</source>\n      <days>\n        <day>\n          <date>2019-06-01</date>\n          <temperature_min>2</temperature_min>\n          <temperature_max>20</temperature_max>\n          <main_description>Sunny</main_description>\n        </day>\n        <day>\n          <date>2019-06-02</date>\n          <temperature_min>3</temperature_min>\n          <temperature_max>18</temperature_max>\n          <main_description>Rain</main_description>\n        </day>\n      </days>\n    </forecast>\n  </data>\n</forecast>\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tc.accept)
+			}
+			req.Header.Set("User-Agent", tc.userAgent)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedContentType, rec.Header().Get(echo.HeaderContentType))
+			require.Equal(t, echo.HeaderAccept, rec.Header().Get(echo.HeaderVary))
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+// yamlRenderer is a format servers don't have by default, see Test_WithRenderer
+type yamlRenderer struct{}
+
+func (yamlRenderer) MediaTypes() []string {
+	return []string{"application/yaml"}
+}
+
+func (yamlRenderer) RenderWeather(w io.Writer, response *WeatherResponse) error {
+	_, err := io.WriteString(w, "city: "+response.City+"\n")
+	return err
+}
+
+func (yamlRenderer) RenderForecast(w io.Writer, response *ForecastResponse) error {
+	_, err := io.WriteString(w, "city: "+response.City+"\n")
+	return err
+}
+
+func Test_WithRenderer(t *testing.T) {
+	s := newTestServer(t, WithRenderer("yaml", yamlRenderer{}))
+	s.setBackends(map[string]types.WeatherBackend{"foo": mockWeatherBackend{returnWeather: types.Weather{Source: "foo"}}}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/weather/ottawa", nil)
+	req.Header.Set(echo.HeaderAccept, "application/yaml")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/yaml; charset=UTF-8", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, "city: ottawa\n", rec.Body.String())
+
+	// the format is documented for this server only
+	format := s.openAPISpec.Paths["/v1/weather/{city}"].Get.Parameters.GetByInAndName("query", "format")
+	require.Contains(t, format.Schema.Value.Enum, "yaml")
+	format = newTestServer(t).openAPISpec.Paths["/v1/weather/{city}"].Get.Parameters.GetByInAndName("query", "format")
+	require.NotContains(t, format.Schema.Value.Enum, "yaml")
+}
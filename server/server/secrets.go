@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"go-weather-app/server/secrets"
+)
+
+// resolveSecrets replaces the secret references of tree with the secrets, in place. The ones of the secrets settings
+// are resolved first, since they say how to reach the secret stores, i.e. the token of vault can come from a file.
+func (v *validator) resolveSecrets(tree map[string]interface{}, environ []string) {
+	key := objectKey(tree, "secrets")
+	if _, ok := tree[key]; ok {
+		tree[key] = v.resolveSecret(secrets.NewResolver(environ, nil), tree[key], key)
+	}
+
+	resolver := secrets.NewResolver(environ, newVault(tree[key], environ))
+	for _, setting := range sortedKeys(tree) {
+		if setting != key {
+			tree[setting] = v.resolveSecret(resolver, tree[setting], setting)
+		}
+	}
+}
+
+// resolveSecret returns node with its secret references replaced by the secrets, the references that can't be resolved
+// are left as they are and reported
+func (v *validator) resolveSecret(resolver *secrets.Resolver, node interface{}, setting string) interface{} {
+	switch node := node.(type) {
+	case string:
+		secret, err := resolver.Resolve(node)
+		if err != nil {
+			v.add(setting, err.Error())
+			return node
+		}
+		return secret
+	case map[string]interface{}:
+		for _, key := range sortedKeys(node) {
+			node[key] = v.resolveSecret(resolver, node[key], joinSetting(setting, key))
+		}
+	case []interface{}:
+		for i := range node {
+			node[i] = v.resolveSecret(resolver, node[i], indexSetting(setting, i))
+		}
+	}
+	return node
+}
+
+// newVault returns the provider of "vault:" references of the secrets settings, or nil when there is no vault to read
+// them from. Invalid settings are left to the validation to report.
+func newVault(node interface{}, environ []string) secrets.Provider {
+	config := SecretsConfig{}
+	if b, err := json.Marshal(node); err == nil && node != nil {
+		json.NewDecoder(bytes.NewReader(b)).Decode(&config)
+	}
+	env := secrets.Env(environ)
+	fromEnv := func(configured string, variable string) string {
+		if configured != "" {
+			return configured
+		}
+		value, _ := env.Resolve(variable)
+		return value
+	}
+
+	vault := &secrets.Vault{
+		Address:   fromEnv(config.Vault.Address, "VAULT_ADDR"),
+		Token:     fromEnv(config.Vault.Token, "VAULT_TOKEN"),
+		Namespace: fromEnv(config.Vault.Namespace, "VAULT_NAMESPACE"),
+	}
+	if vault.Address == "" {
+		return nil
+	}
+	if config.Vault.Timeout != nil {
+		vault.HTTPClient = &http.Client{Timeout: config.Vault.Timeout.Duration}
+	}
+	return vault
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-weather-app/server/backends"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadConfigResolvesSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.URL.Path != "/v1/secret/data/weather" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"accuweather": "abcde"}, "metadata": {"version": 1}}}`))
+	}))
+	defer vault.Close()
+
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"vault-token": "s.token\n",
+		"owm-api-key": "12345\n",
+		"config.yaml": `secrets:
+  vault:
+    address: ` + vault.URL + `
+    token: file:` + filepath.Join(dir, "vault-token") + `
+backends:
+- type: openweathermap
+  apiKey: file:` + filepath.Join(dir, "owm-api-key") + `
+- type: accuweather
+  apiKey: vault:secret/data/weather#accuweather
+- name: eu
+  type: federated
+  url: https://eu.weather.example.com
+  token: env:EU_TOKEN
+  backends: [openweathermap]
+`,
+		"missing.yaml": `backends:
+- type: openweathermap
+  apiKey: file:` + filepath.Join(dir, "missing") + `
+- type: accuweather
+  apiKey: vault:secret/data/weather#accuweather
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	t.Run("references are replaced with their secrets", func(t *testing.T) {
+		config, err := LoadConfig(filepath.Join(dir, "config.yaml"), []string{"EU_TOKEN=secret", "WEATHER_HTTP_ADDRESS=env:ADDRESS", "ADDRESS=:8000"})
+		require.NoError(t, err)
+		options := []map[string]interface{}{}
+		for _, instance := range config.Backends {
+			decoded := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(instance.Options, &decoded))
+			options = append(options, decoded)
+		}
+		require.Equal(t, []map[string]interface{}{
+			{"type": "openweathermap", "apiKey": "12345"},
+			{"type": "accuweather", "apiKey": "abcde"},
+			{"name": "eu", "type": "federated", "url": "https://eu.weather.example.com", "token": "secret", "backends": []interface{}{"openweathermap"}},
+		}, options)
+		require.Equal(t, ":8000", config.HTTP.Address, "settings of environment variables can be references too")
+		require.Equal(t, "s.token", config.Secrets.Vault.Token)
+	})
+
+	t.Run("vault can be configured by its environment variables", func(t *testing.T) {
+		config, err := LoadConfig("", []string{"VAULT_ADDR=" + vault.URL, "VAULT_TOKEN=s.token", "WEATHER_BACKENDS_ACCUWEATHER_APIKEY=vault:secret/data/weather#accuweather"})
+		require.NoError(t, err)
+		require.Equal(t, backends.Config{{Type: "accuweather", Options: json.RawMessage(`{"apiKey":"abcde","type":"accuweather"}`)}}, config.Backends)
+	})
+
+	t.Run("references that can't be resolved are reported where they are", func(t *testing.T) {
+		path := filepath.Join(dir, "missing.yaml")
+		problems := []string{}
+		for _, problem := range ValidateConfig(path, []string{"VAULT_ADDR=" + vault.URL, "VAULT_TOKEN=s.other"}) {
+			problems = append(problems, problem.String())
+		}
+		require.Equal(t, []string{
+			path + ":3:3: backends[0].apiKey: Unable to resolve secret file:" + filepath.Join(dir, "missing") + ": open " + filepath.Join(dir, "missing") + ": no such file or directory",
+			path + ":5:3: backends[1].apiKey: Unable to resolve secret vault:secret/data/weather#accuweather: Unable to read vault secret secret/data/weather, status code 403: permission denied",
+		}, problems)
+	})
+}
@@ -0,0 +1,280 @@
+// Package server serves the weather APIs (REST, GraphQL, gRPC and WebSockets) on top of the configured backends.
+// Everything a server needs is given to New, so that several servers can run side by side, i.e. embedded in other
+// binaries or in parallel tests.
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/auth"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/geoip"
+	"go-weather-app/server/location"
+	"go-weather-app/server/metrics"
+	"go-weather-app/server/redact"
+	"go-weather-app/server/updates"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/websocket"
+)
+
+// Server serves the weather APIs, it is an http.Handler. The gRPC API is served separately, see GRPCServer.
+type Server struct {
+	config     *Config // the configuration in effect, guarded by reloadMu once the server is created
+	logger     echo.Logger
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	now        func() time.Time
+	httpClient *http.Client
+	// redactor replaces the secrets of the configuration, and anything that looks like a credential, in the errors of
+	// the backends and in the logs
+	redactor *redact.Redactor
+	// authenticator authenticates the clients of the API by their API keys and tokens, and jwtValidator the users of
+	// the UI by the JWTs of the identity provider. Anyone can call the API when both are nil, see authMiddleware.
+	authenticator *auth.Authenticator
+	jwtValidator  *auth.JWTValidator
+	// loadConfig loads the configuration again for POST /admin/reload, see WithConfigLoader
+	loadConfig func() (*Config, error)
+
+	echo          *echo.Echo
+	metrics       *metrics.Metrics
+	graphQLSchema *graphql.Schema
+	// renderers are the formats of the weather and forecast endpoints, see WithRenderer, and openAPISpec the document
+	// served at /openapi.json, which lists them
+	renderers   rendererSet
+	openAPISpec *openapi3.Swagger
+
+	// backendSet is the configured backends, see backends. It is replaced when the configuration is reloaded.
+	backendSet *backendSet
+	backendsMu sync.RWMutex
+	// reloadMu makes reloads wait for each other, see Reload
+	reloadMu sync.Mutex
+	// backendStatuses tracks the status of the configured backends, for /v2/backends and the GraphQL API
+	backendStatuses *BackendStatusTracker
+
+	// locationResolver is used to turn the free text provided by users into canonical locations
+	locationResolver location.Resolver
+	// locationMismatchKm is the distance past which backends are flagged as disagreeing on location
+	locationMismatchKm float64
+	// ipLocator is used to find the approximate location of callers, it is nil when no GeoIP database is configured
+	ipLocator geoip.Locator
+	// trustedProxies are the peers allowed to tell us the caller's address through X-Forwarded-For
+	trustedProxies []*net.IPNet
+
+	// weatherCache is shared by every request, so that the same reading is only fetched from a backend once per ttl
+	weatherCache *cache.Cache
+	// batchMaxItems is the maximum number of items accepted in a single batch request
+	batchMaxItems int
+	// batchConcurrency is the maximum number of items of a single batch request that are fetched at the same time
+	batchConcurrency int
+
+	// weatherUpdates receives every new reading fetched from a backend, for stream and WebSocket subscribers
+	weatherUpdates *updates.Hub
+	// streamHeartbeat is how often a comment is sent to idle streams, so that proxies and clients don't time them out
+	streamHeartbeat time.Duration
+	// streamPollInterval is how often every open stream asks for the weather (through the cache), so that streams get
+	// new readings even when nobody else is asking about the same city
+	streamPollInterval time.Duration
+	// streamBufferSize is the number of updates buffered for each stream, streams that fall further behind are
+	// disconnected
+	streamBufferSize int
+
+	// allowedOrigins are the browser origins allowed to call the API, through CORS or WebSockets
+	allowedOrigins []string
+	// webSocketMessageRate and webSocketMessageBurst limit how many messages a single /v1/ws connection can send, per
+	// second and all at once
+	webSocketMessageRate  float64
+	webSocketMessageBurst int
+	// webSocketMaxSubscriptions is the maximum number of cities a single /v1/ws connection can be subscribed to
+	webSocketMaxSubscriptions int
+	// webSocketsMu guards webSockets, the open /v1/ws connections, which the http server stops tracking once they are
+	// upgraded: Close closes them instead, and waits for their handlers through webSocketConnections
+	webSocketsMu         sync.Mutex
+	webSockets           map[*websocket.Conn]bool
+	webSocketsClosed     bool
+	webSocketConnections sync.WaitGroup
+
+	// grpcAddress is where the gRPC API is served, next to the REST API
+	grpcAddress string
+	// httpAddress is where the REST API is served, see HTTPServer
+	httpAddress string
+	// readTimeout is how long reading a request can take, 0 means unlimited
+	readTimeout time.Duration
+	// shutdownTimeout is how long open requests are given to finish when the server is stopped
+	shutdownTimeout time.Duration
+}
+
+// Option configures a Server, see New
+type Option func(*Server)
+
+// WithConfig configures the backends, datasets and limits of the server, see Config
+func WithConfig(config *Config) Option {
+	return func(s *Server) {
+		s.config = config
+	}
+}
+
+// WithLogger sets the logger of the server and its backends, it defaults to the logger of echo. The server logs (the
+// access log included) through a logger of its own with the prefix, level and output of logger, redacting the secrets
+// of the configuration, so that logger itself is left as it is.
+func WithLogger(logger echo.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithRegistry registers the metrics of the server with registerer, and serves the metrics of gatherer at /metrics.
+// By default every server has a registry of its own.
+func WithRegistry(registerer prometheus.Registerer, gatherer prometheus.Gatherer) Option {
+	return func(s *Server) {
+		s.registerer = registerer
+		s.gatherer = gatherer
+	}
+}
+
+// WithClock sets what the server considers to be the current time, it defaults to time.Now
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithConfigLoader sets how POST /admin/reload loads the configuration again, it is only enabled with one. It should be
+// the loader SIGHUP reloads the configuration with, see Reload.
+func WithConfigLoader(load func() (*Config, error)) Option {
+	return func(s *Server) {
+		s.loadConfig = load
+	}
+}
+
+// WithHTTPClient sets the client the backends call their APIs with, it defaults to a client with the backend timeout of
+// the configuration and a redact.Transport. The client is used as it is.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Server) {
+		s.httpClient = client
+	}
+}
+
+// New creates a server. Only the configuration is required, the backends are created from it.
+func New(options ...Option) (*Server, error) {
+	e := echo.New()
+	s := &Server{
+		config:    &Config{},
+		logger:    e.Logger,
+		now:       time.Now,
+		echo:      e,
+		redactor:  redact.New(),
+		renderers: defaultRenderers(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.registerer == nil {
+		registry := prometheus.NewRegistry()
+		s.registerer, s.gatherer = registry, registry
+	}
+	s.logger = newRedactingLogger(s.logger, s.redactor)
+	e.Logger = s.logger
+
+	var err error
+	s.metrics, err = metrics.New(s.registerer)
+	if err != nil {
+		return nil, err
+	}
+	s.backendStatuses = newBackendStatusTracker(s.now)
+	s.weatherUpdates = updates.NewHub(updates.DefaultHistorySize, s.now, s.metrics)
+	s.openAPISpec = newOpenAPISpec(s.renderers, false)
+	s.graphQLSchema = graphql.MustParseSchema(graphQLSchemaString, &graphQLResolver{s: s}, graphql.UseFieldResolvers(), graphql.MaxDepth(graphQLMaxDepth))
+	if err := s.configure(s.config); err != nil {
+		return nil, err
+	}
+	s.metrics.ConfigLastReloadSuccessful.Set(1) // the configuration the server started with
+	s.metrics.ConfigLastReloadSuccessTimestamp.Set(float64(s.now().Unix()))
+
+	e.HTTPErrorHandler = httpErrorHandler(e)
+
+	// Middleware
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Output: s.logger.Output()}))
+	e.Use(middleware.Recover())
+	e.Use(middleware.RequestID())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: s.allowedOrigins,
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, api.HeaderAPIKey},
+	}))
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(s.gatherer, promhttp.HandlerOpts{})))
+
+	s.registerRoutes(e)
+	return s, nil
+}
+
+// newRedactingLogger creates a logger like logger, writing to its output through redactor
+func newRedactingLogger(logger echo.Logger, redactor *redact.Redactor) echo.Logger {
+	redacting := log.New(logger.Prefix())
+	redacting.SetLevel(logger.Level())
+	redacting.SetOutput(redactor.Writer(logger.Output()))
+	return redacting
+}
+
+// ServeHTTP serves the REST, GraphQL and WebSocket APIs
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.echo.ServeHTTP(w, r)
+}
+
+// HTTPServer creates an http server for the REST, GraphQL and WebSocket APIs, at the configured address
+func (s *Server) HTTPServer() *http.Server {
+	return &http.Server{Addr: s.httpAddress, Handler: s, ReadTimeout: s.readTimeout}
+}
+
+// ShutdownTimeout is how long open requests are configured to be given to finish when the server is stopped
+func (s *Server) ShutdownTimeout() time.Duration {
+	return s.shutdownTimeout
+}
+
+// GRPCAddress is where the gRPC API is configured to be served
+func (s *Server) GRPCAddress() string {
+	return s.grpcAddress
+}
+
+// requestMetricsMiddleware is used to gather metrics on every incoming request
+func (s *Server) requestMetricsMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			s.metrics.HTTPRequestsTotal.With(prometheus.Labels{"path": c.Path(), "method": c.Request().Method}).Inc()
+			return next(c)
+		}
+	}
+}
+
+// registerRoutes registers every route of the API, they must all be documented in apiOperations
+func (s *Server) registerRoutes(e *echo.Echo) {
+	v1Api := e.Group("/v1")
+	v1Api.Use(s.requestMetricsMiddleware()) // only track metrics on requests under /v1 (i.e. don't track /metrics)
+	v1Api.Use(s.authMiddleware(auth.ScopeRead))
+	v1Api.GET("/weather/here", s.getWeatherHere)
+	v1Api.POST("/weather/batch", s.postWeatherBatch)
+	v1Api.GET("/weather/:city", s.getWeather)
+	v1Api.GET("/weather/:city/stream", s.streamWeather)
+	v1Api.GET("/forecast/:city", s.getForecast)
+	v1Api.OPTIONS("/weather", optionsWeather)
+	v1Api.GET("/backends", s.getBackends)
+	v1Api.GET("/locations/search", s.searchLocations)
+	v1Api.GET("/ws", echo.WrapHandler(s.webSocketServer()))
+	e.POST("/graphql", s.postGraphQL, s.requestMetricsMiddleware(), s.authMiddleware(auth.ScopeRead))
+	s.registerV2(e)
+	e.GET("/openapi.json", s.getOpenAPISpec)
+
+	admin := e.Group("/admin")
+	admin.Use(s.authMiddleware(auth.ScopeAdmin))
+	admin.GET("/config", s.getAdminConfig)
+	admin.POST("/reload", s.postAdminReload)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go-weather-app/server/backends"
+	"go-weather-app/server/backends/accuweather"
+	"go-weather-app/server/backends/openweathermap"
+	"go-weather-app/server/backends/plugin"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/require"
+)
+
+// pluginHelperEnv makes the test binary act as a plugin backend instead of running the tests, see servePluginHelper
+const pluginHelperEnv = "SERVER_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginHelperEnv) != "" {
+		servePluginHelper()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// servePluginHelper serves a mock backend as a plugin, and creates the file named by the config of the backend once
+// the server closed it
+func servePluginHelper() {
+	closedFile := ""
+	err := plugin.Serve(os.Stdin, os.Stdout, func(name string, config json.RawMessage) (types.WeatherBackend, error) {
+		return mockWeatherBackend{}, json.Unmarshal(config, &closedFile)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(closedFile, nil, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newTestServer creates a server without any backends, tests configure the ones they need
+func newTestServer(t *testing.T, options ...Option) *Server {
+	config := &Config{Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "test"}`)}}}
+	s, err := New(append([]Option{WithConfig(config)}, options...)...)
+	require.NoError(t, err)
+	s.setBackends(map[string]types.WeatherBackend{}, []string{})
+	return s
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []Option
+		expectedErr error
+	}{
+		{
+			name:        "no configured backends returns error",
+			expectedErr: errors.New("No weather backends configured"),
+		},
+		{
+			name: "invalid stream settings return error",
+			options: []Option{WithConfig(&Config{
+				Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "foo"}`)}},
+				Stream:   StreamConfig{Heartbeat: &Duration{Duration: -time.Second}},
+			})},
+			expectedErr: errors.New("Stream heartbeat and poll interval must be greater than 0"),
+		},
+		{
+			name:    "configured backends",
+			options: []Option{WithConfig(&Config{Backends: backends.Config{{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "foo"}`)}}})},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := New(tc.options...)
+			require.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr != nil {
+				return
+			}
+			require.Equal(t, []string{openweathermap.Type}, s.backends().defaults)
+			require.Equal(t, DefaultGRPCAddress, s.GRPCAddress())
+		})
+	}
+}
+
+func Test_NewClosesBackendsOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	closedFile := filepath.Join(dir, "closed")
+
+	options, err := json.Marshal(map[string]interface{}{"command": []string{"env", pluginHelperEnv + "=1", os.Args[0]}, "config": closedFile})
+	require.NoError(t, err)
+	config := &Config{
+		Backends: backends.Config{{Name: "in-house", Type: plugin.Type, Options: options}},
+		Location: LocationConfig{GeoNamesFile: filepath.Join(dir, "missing.txt")},
+	}
+	_, err = New(WithConfig(config))
+	require.Error(t, err)
+
+	// the plugin was started before the dataset failed to load, and stopped again
+	_, err = os.Stat(closedFile)
+	require.NoError(t, err)
+}
+
+func Test_ServerSideBySide(t *testing.T) {
+	clock := func() time.Time { return time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC) }
+	servers := []*Server{newTestServer(t, WithClock(clock)), newTestServer(t, WithClock(clock))}
+	for i, s := range servers {
+		temperature := float32(10 * (i + 1))
+		s.setBackends(map[string]types.WeatherBackend{"foo": mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: temperature}}}, []string{"foo"})
+	}
+
+	// every server answers with its own backends, and only counts its own requests
+	var wg sync.WaitGroup
+	codes := make([][]int, len(servers))
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+			for j := 0; j <= i; j++ {
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/weather/ottawa", nil))
+				codes[i] = append(codes[i], rec.Code)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	require.Equal(t, [][]int{{http.StatusOK}, {http.StatusOK, http.StatusOK}}, codes)
+
+	for i, s := range servers {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/weather/ottawa", nil))
+		require.Contains(t, rec.Body.String(), `"temperature": `+[]string{"10", "20"}[i]+",")
+
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/backends", nil))
+		require.Contains(t, rec.Body.String(), `"last_checked": "2019-06-01T12:00:00Z"`)
+
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Contains(t, rec.Body.String(), `http_requests_total{method="GET",path="/v1/weather/:city"} `+[]string{"2", "3"}[i])
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_NewRedactsSecrets(t *testing.T) {
+	owmKey, accuweatherKey := "0123456789abcdef0123456789abcdef", "fedcba9876543210"
+	logOutput := &bytes.Buffer{}
+	logger := log.New("test")
+	logger.SetOutput(logOutput)
+	unreachable := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})}
+	config := &Config{Backends: backends.Config{
+		{Type: openweathermap.Type, Options: json.RawMessage(`{"apiKey": "` + owmKey + `"}`)},
+		{Type: accuweather.Type, Options: json.RawMessage(`{"apiKey": "` + accuweatherKey + `"}`)},
+	}}
+	s, err := New(WithConfig(config), WithLogger(logger), WithHTTPClient(unreachable))
+	require.NoError(t, err)
+
+	// the errors of the backends quote the URLs they called, API keys included
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/weather/ottawa", nil))
+	require.Contains(t, rec.Body.String(), `APPID=REDACTED\": connection refused`)
+
+	for _, path := range []string{"/v1/weather/ottawa?appid=" + owmKey, "/v1/forecast/ottawa", "/v2/weather/ottawa", "/v2/forecast/ottawa", "/v2/backends", "/v1/" + accuweatherKey, "/metrics"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.NotContains(t, rec.Body.String(), owmKey, path)
+		require.NotContains(t, rec.Body.String(), accuweatherKey, path)
+	}
+
+	require.Error(t, s.Reload(func() (*Config, error) {
+		return nil, errors.New("Invalid API key: " + owmKey)
+	}))
+	require.Contains(t, logOutput.String(), "Invalid API key: REDACTED")
+	require.Contains(t, logOutput.String(), `"uri":"/v1/REDACTED"`, "the access log is redacted too")
+	require.NotContains(t, logOutput.String(), owmKey)
+	require.NotContains(t, logOutput.String(), accuweatherKey)
+
+	// the logger given to the server is left as it is, so that other servers logging through it don't redact the
+	// secrets of this one
+	require.Equal(t, logOutput, logger.Output())
+}
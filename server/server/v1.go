@@ -0,0 +1,692 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/auth"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/geoip"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+	"go-weather-app/server/updates"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WeatherResponse defines a json response for multiple weather responses (i.e. from multiple backends)
+type WeatherResponse struct {
+	City     string             `json:"city,omitempty" xml:"city,omitempty"`
+	Location *location.Location `json:"location,omitempty" xml:"location,omitempty"` // the canonical location the city was resolved to
+	Data     []types.Weather    `json:"data,omitempty" xml:"data>weather,omitempty"`
+	Error    string             `json:"error,omitempty" xml:"error,omitempty"` // this is used as a response whenever a bad request comes in
+
+	LocationMismatch *api.LocationMismatch `json:"location_mismatch,omitempty" xml:"location_mismatch,omitempty"` // set when the backends returned weather for different places
+}
+
+// ForecastResponse defines a json response for multiple forecast responses (i.e. from multiple backends)
+type ForecastResponse struct {
+	City     string             `json:"city,omitempty" xml:"city,omitempty"`
+	Location *location.Location `json:"location,omitempty" xml:"location,omitempty"` // the canonical location the city was resolved to
+	Data     []types.Forecast   `json:"data,omitempty" xml:"data>forecast,omitempty"`
+	Error    string             `json:"error,omitempty" xml:"error,omitempty"` // this is used as a response whenever a bad request comes in
+}
+
+// MarshalJSON leaves the source of the readings of backends that failed empty, as /v1 json always did since backends
+// don't name themselves when they fail. Every other format names them, see fetchBackendWeather.
+func (r WeatherResponse) MarshalJSON() ([]byte, error) {
+	type plainWeatherResponse WeatherResponse
+	plain := plainWeatherResponse(r)
+	plain.Data = make([]types.Weather, len(r.Data))
+	for i, weather := range r.Data {
+		if weather.Error != "" {
+			weather.Source = ""
+		}
+		plain.Data[i] = weather
+	}
+	if r.Data == nil {
+		plain.Data = nil
+	}
+	return json.Marshal(plain)
+}
+
+// MarshalJSON is WeatherResponse.MarshalJSON for forecasts, the backends that don't support forecasts were always named
+func (r ForecastResponse) MarshalJSON() ([]byte, error) {
+	type plainForecastResponse ForecastResponse
+	plain := plainForecastResponse(r)
+	plain.Data = make([]types.Forecast, len(r.Data))
+	for i, forecast := range r.Data {
+		if forecast.Error != "" && forecast.ErrorCode != types.ErrorNotSupported {
+			forecast.Source = ""
+		}
+		plain.Data[i] = forecast
+	}
+	if r.Data == nil {
+		plain.Data = nil
+	}
+	return json.Marshal(plain)
+}
+
+// BatchWeatherRequest defines the json request body for fetching the weather for many locations at once
+type BatchWeatherRequest struct {
+	Items    []BatchWeatherItem `json:"items"`
+	Backends []string           `json:"backends,omitempty"` // defaults to the default backends
+}
+
+// BatchWeatherItem defines a single location in a batch request, either a city (any format /v1/weather/{city} accepts) or coordinates
+type BatchWeatherItem struct {
+	City        string                `json:"city,omitempty"`
+	Coordinates *location.Coordinates `json:"coordinates,omitempty"`
+}
+
+// BatchWeatherResponse defines a json response for a batch request, with one result per requested item in the same order
+type BatchWeatherResponse struct {
+	Results []WeatherResponse `json:"results,omitempty"`
+	Error   string            `json:"error,omitempty"` // this is used as a response whenever a bad request comes in
+}
+
+// WeatherUpdateEvent defines the json data of the "weather" events sent by /v1/weather/{city}/stream for every new reading
+type WeatherUpdateEvent struct {
+	Location location.Location `json:"location"`
+	Data     types.Weather     `json:"data"`
+	Time     time.Time         `json:"time"`
+}
+
+// LocationSearchResponse defines a json response for the ranked locations matching a query
+type LocationSearchResponse struct {
+	Query      string               `json:"query,omitempty"`
+	Candidates []location.Candidate `json:"candidates"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// BackendResponse defines a json response for the configured/known backends
+type BackendResponse struct {
+	Backends []string `json:"backends"`
+}
+
+// errBackendRemoved is the error of a backend that was asked for, but removed by a reload before it was called
+func errBackendRemoved(backend string) error {
+	return types.NewBackendError(types.ErrorUnavailable, "Backend specified is invalid or inactive: "+backend)
+}
+
+// errBackendPanicked is the error of a backend that panicked, i.e. on a response it didn't expect, after logging the
+// panic. Backends are mostly called from goroutines of our own (streams, WebSockets, batches, GraphQL and gRPC) that no
+// middleware recovers, where a panic would take the whole server down.
+func (s *Server) errBackendPanicked(backend string, recovered interface{}) error {
+	s.logger.Error("backend ", backend, " panicked: ", recovered, "\n", string(debug.Stack()))
+	return types.NewBackendError(types.ErrorUnknown, "Backend failed unexpectedly: "+backend)
+}
+
+// validateBackends checks that the backends are configured, and that the client of the request can use them
+func (s *Server) validateBackends(ctx context.Context, backends []string) error {
+	configured := s.backends().configured
+	client := auth.FromContext(ctx)
+	for _, backend := range backends {
+		if configured[backend] == nil {
+			return errors.New("Backend specified is invalid or inactive: " + backend)
+		}
+		if client != nil && !client.AllowsBackend(backend) {
+			return errors.New("Backend not allowed for this client: " + backend)
+		}
+	}
+	return nil
+}
+
+// requestedBackends returns the backends a request asked for once validated, or the default backends the client of
+// the request can use when it didn't ask for any
+func (s *Server) requestedBackends(ctx context.Context, backends []string) ([]string, error) {
+	if len(backends) == 0 {
+		return allowedBackends(ctx, s.backends().defaults)
+	}
+	if err := s.validateBackends(ctx, backends); err != nil {
+		return nil, err
+	}
+	return backends, nil
+}
+
+func (s *Server) getWeather(c echo.Context) error {
+	response := &WeatherResponse{}
+
+	renderer, err := s.renderers.negotiate(c)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	response.City = strings.TrimSpace(c.Param("city"))
+	if len(response.City) == 0 {
+		response.Error = "No city specified. Please provide a city query parameter."
+		return renderWeather(c, renderer, http.StatusBadRequest, response)
+	}
+
+	targetBackends, err := s.selectBackends(c)
+	if err != nil {
+		response.Error = err.Error()
+		return renderWeather(c, renderer, http.StatusBadRequest, response)
+	}
+
+	loc, err := s.locationResolver.Resolve(response.City)
+	if err != nil {
+		response.Error = err.Error()
+		return renderWeather(c, renderer, http.StatusBadRequest, response)
+	}
+
+	return s.renderFetchedWeather(c, renderer, response, loc, targetBackends)
+}
+
+// renderFetchedWeather fetches the weather of the target backends into the response and renders it, along with their
+// forecast for renderers that show both
+func (s *Server) renderFetchedWeather(c echo.Context, renderer Renderer, response *WeatherResponse, loc location.Location, targetBackends []string) error {
+	s.fetchWeather(response, loc, targetBackends)
+	reporter, ok := renderer.(reportRenderer)
+	if !ok {
+		return renderWeather(c, renderer, http.StatusOK, response)
+	}
+	forecast := &ForecastResponse{City: response.City}
+	s.fetchForecast(forecast, loc, targetBackends)
+	return renderReport(c, reporter, http.StatusOK, response, forecast)
+}
+
+func (s *Server) getForecast(c echo.Context) error {
+	response := &ForecastResponse{}
+
+	renderer, err := s.renderers.negotiate(c)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	response.City = strings.TrimSpace(c.Param("city"))
+	if len(response.City) == 0 {
+		response.Error = "No city specified. Please provide a city query parameter."
+		return renderForecast(c, renderer, http.StatusBadRequest, response)
+	}
+
+	targetBackends, err := s.selectBackends(c)
+	if err != nil {
+		response.Error = err.Error()
+		return renderForecast(c, renderer, http.StatusBadRequest, response)
+	}
+
+	loc, err := s.locationResolver.Resolve(response.City)
+	if err != nil {
+		response.Error = err.Error()
+		return renderForecast(c, renderer, http.StatusBadRequest, response)
+	}
+
+	s.fetchForecast(response, loc, targetBackends)
+	return renderForecast(c, renderer, http.StatusOK, response)
+}
+
+func (s *Server) getWeatherHere(c echo.Context) error {
+	response := &WeatherResponse{}
+
+	renderer, err := s.renderers.negotiate(c)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	if s.ipLocator == nil {
+		response.Error = "Location by IP address is not configured"
+		return renderWeather(c, renderer, http.StatusNotImplemented, response)
+	}
+
+	targetBackends, err := s.selectBackends(c)
+	if err != nil {
+		response.Error = err.Error()
+		return renderWeather(c, renderer, http.StatusBadRequest, response)
+	}
+
+	ip := geoip.ClientIP(c.Request(), s.trustedProxies)
+	loc, found, err := s.ipLocator.Locate(ip)
+	if err != nil {
+		c.Logger().Error("unable to look up location for ", ip, ": ", err)
+		response.Error = "Unable to determine location for IP address"
+		return renderWeather(c, renderer, http.StatusInternalServerError, response)
+	}
+	if !found {
+		response.Error = "Unable to determine location for IP address: " + ip.String()
+		return renderWeather(c, renderer, http.StatusNotFound, response)
+	}
+	response.City = loc.String()
+
+	return s.renderFetchedWeather(c, renderer, response, loc, targetBackends)
+}
+
+// streamWeather sends Server-Sent Events for a city: a "snapshot" event with the same data as /v1/weather/{city}, then a
+// "weather" event whenever any backend reading is fetched for it, whether by this stream's polling or by other clients.
+// Clients reconnecting with Last-Event-ID get the readings they missed instead of a new snapshot, when we still have them.
+func (s *Server) streamWeather(c echo.Context) error {
+	response := &WeatherResponse{}
+
+	response.City = strings.TrimSpace(c.Param("city"))
+	if len(response.City) == 0 {
+		response.Error = "No city specified. Please provide a city query parameter."
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	targetBackends, err := s.selectBackends(c)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	loc, err := s.locationResolver.Resolve(response.City)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	// browsers send the header when reconnecting, the query parameter allows resuming on a fresh EventSource
+	lastEventIDParam := c.Request().Header.Get("Last-Event-ID")
+	if lastEventIDParam == "" {
+		lastEventIDParam = c.QueryParam("lastEventId")
+	}
+	lastEventID, err := strconv.ParseUint(strings.TrimSpace(lastEventIDParam), 10, 64)
+	resume := err == nil
+
+	subscription := s.weatherUpdates.Subscribe(loc.Key(), s.streamBufferSize, resume, lastEventID)
+	defer subscription.Close()
+
+	isTarget := map[string]bool{}
+	for _, backend := range targetBackends {
+		isTarget[backend] = true
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no") // keep nginx style proxies from buffering the stream
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, "retry: 3000\n\n") // have EventSource reconnect after 3 seconds
+
+	sentID := lastEventID
+	for _, update := range subscription.Missed {
+		if !isTarget[update.Weather.Source] {
+			continue
+		}
+		if err := writeWeatherUpdateEvent(res, update); err != nil {
+			return nil
+		}
+		sentID = update.ID
+	}
+	if !subscription.Resumed {
+		s.fetchWeather(response, loc, targetBackends)
+		// anything published up to now (including our own fetches) is already part of the snapshot
+		sentID = s.weatherUpdates.LastID()
+		if err := writeStreamEvent(res, sentID, "snapshot", response); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	poll := time.NewTicker(s.streamPollInterval)
+	defer poll.Stop()
+	polling := make(chan struct{}, 1)
+	defer func() { polling <- struct{}{} }() // wait for the last poll, so it never outlives the stream
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case update, ok := <-subscription.C:
+			if !ok {
+				// dropped for falling behind, the client will reconnect and resume from the last event it got
+				return nil
+			}
+			if update.ID <= sentID || !isTarget[update.Weather.Source] {
+				continue
+			}
+			if err := writeWeatherUpdateEvent(res, update); err != nil {
+				return nil
+			}
+			sentID = update.ID
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case <-poll.C:
+			// every poll counts as a request, the stream ends once the client is over its limits
+			if err := s.takeRequests(c.Request().Context(), 1); err != nil {
+				return nil
+			}
+			// new readings are published by fetchWeather, skip this poll if the last one is still waiting on a backend
+			select {
+			case polling <- struct{}{}:
+				go func() {
+					defer func() { <-polling }()
+					s.fetchWeather(&WeatherResponse{}, loc, targetBackends)
+				}()
+			default:
+			}
+			continue
+		}
+		res.Flush()
+	}
+}
+
+func writeWeatherUpdateEvent(res *echo.Response, update updates.Update) error {
+	return writeStreamEvent(res, update.ID, "weather", WeatherUpdateEvent{Location: update.Location, Data: update.Weather, Time: update.Time})
+}
+
+// writeStreamEvent writes a single Server-Sent Event with json data
+func writeStreamEvent(res *echo.Response, id uint64, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", id, event, b)
+	return err
+}
+
+// batchItemMaxBytes is how large batch requests can be per item they may hold, see postWeatherBatch
+const batchItemMaxBytes = 1 << 10
+
+func (s *Server) postWeatherBatch(c echo.Context) error {
+	response := &BatchWeatherResponse{}
+
+	request := &BatchWeatherRequest{}
+	// the body is read up to what batchMaxItems items can take, before knowing how many items it holds
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, int64(s.batchMaxItems+1)*batchItemMaxBytes)
+	err := json.NewDecoder(body).Decode(request)
+	if err != nil {
+		response.Error = "Unable to parse request body: " + err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+	if len(request.Items) == 0 {
+		response.Error = "No items specified. Please provide at least one city or coordinates."
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+	if len(request.Items) > s.batchMaxItems {
+		response.Error = "Too many items specified: " + strconv.Itoa(len(request.Items)) + " (the maximum is " + strconv.Itoa(s.batchMaxItems) + ")"
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	targetBackends, err := s.requestedBackends(c.Request().Context(), request.Backends)
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	// the request itself was counted by authMiddleware, every other item is counted as a request of its own
+	if err := s.takeRequests(c.Request().Context(), len(request.Items)-1); err != nil {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(err.RetryAfter)))
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusTooManyRequests, response, "  ")
+	}
+
+	// items are fetched concurrently, but never more than the batch concurrency at a time so one batch can't starve everyone else
+	response.Results = make([]WeatherResponse, len(request.Items))
+	semaphore := make(chan struct{}, s.batchConcurrency)
+	wg := sync.WaitGroup{}
+	for i, item := range request.Items {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, item BatchWeatherItem) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			// echo's Recover middleware doesn't cover our goroutines, a panic only fails its item
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					s.logger.Error("batch item ", i, " panicked: ", recovered, "\n", string(debug.Stack()))
+					response.Results[i] = WeatherResponse{City: item.City, Error: "Unable to fetch the weather for this item"}
+				}
+			}()
+			response.Results[i] = s.getBatchItemWeather(item, targetBackends)
+		}(i, item)
+	}
+	wg.Wait()
+
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
+
+func (s *Server) getBatchItemWeather(item BatchWeatherItem, targetBackends []string) WeatherResponse {
+	response := WeatherResponse{}
+
+	response.City = strings.TrimSpace(item.City)
+	if item.Coordinates != nil {
+		response.City = item.Coordinates.String()
+	}
+	if len(response.City) == 0 {
+		response.Error = "No city or coordinates specified"
+		return response
+	}
+
+	loc, err := s.locationResolver.Resolve(response.City)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	s.fetchWeather(&response, loc, targetBackends)
+	return response
+}
+
+// selectBackends returns the backends requested through the backend query parameter, or the defaults when there are none,
+// see requestedBackends. Requests relayed by another server never go to backends that would relay them again.
+func (s *Server) selectBackends(c echo.Context) ([]string, error) {
+	set := s.backends()
+	backendParam := strings.TrimSpace(c.QueryParam("backend"))
+	if len(backendParam) == 0 {
+		if relayed(c) {
+			return allowedBackends(c.Request().Context(), set.local(set.defaults))
+		}
+		return allowedBackends(c.Request().Context(), set.defaults)
+	}
+
+	targetBackends := strings.Split(backendParam, ",")
+	err := s.validateBackends(c.Request().Context(), targetBackends)
+	if err != nil {
+		return nil, err
+	}
+	if relayed(c) {
+		for _, backend := range targetBackends {
+			if _, ok := set.configured[backend].(types.RelayBackend); ok {
+				return nil, errors.New("Backend would relay the request again: " + backend)
+			}
+		}
+	}
+	return targetBackends, nil
+}
+
+// relayed tells whether the request was relayed by another server, see api.HeaderHops
+func relayed(c echo.Context) bool {
+	hops := strings.TrimSpace(c.Request().Header.Get(api.HeaderHops))
+	return hops != "" && hops != "0"
+}
+
+// local returns the backends that don't relay requests to another server
+func (set *backendSet) local(backends []string) []string {
+	local := []string{}
+	for _, backend := range backends {
+		if _, ok := set.configured[backend].(types.RelayBackend); !ok {
+			local = append(local, backend)
+		}
+	}
+	return local
+}
+
+// fetchWeather fans out to the target backends for the resolved location and fills in the response
+func (s *Server) fetchWeather(response *WeatherResponse, loc location.Location, targetBackends []string) {
+	response.Location = &loc
+	for _, backend := range targetBackends {
+		response.Data = append(response.Data, s.fetchBackendWeather(backend, loc))
+	}
+	response.LocationMismatch = findLocationMismatch(response.Data, s.locationMismatchKm)
+}
+
+// BackendStatus defines how the last upstream call to a backend went
+type BackendStatus struct {
+	LastError   string
+	LastChecked time.Time
+}
+
+// BackendStatusTracker records the status of every backend as they are called
+type BackendStatusTracker struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	statuses map[string]BackendStatus
+}
+
+func newBackendStatusTracker(now func() time.Time) *BackendStatusTracker {
+	return &BackendStatusTracker{now: now, statuses: map[string]BackendStatus{}}
+}
+
+// Record records the outcome of a call to the backend, errMsg is empty on success
+func (t *BackendStatusTracker) Record(backend string, errMsg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.statuses[backend] = BackendStatus{LastError: errMsg, LastChecked: t.now()}
+}
+
+// Get returns the status of the backend, if it was called at all
+func (t *BackendStatusTracker) Get(backend string) (BackendStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.statuses[backend]
+	return status, ok
+}
+
+// fetchBackendWeather gets the weather for the location from a single backend through the cache, publishing new readings.
+// The reading is named after the backend, which backends don't do themselves when they fail.
+func (s *Server) fetchBackendWeather(backend string, loc location.Location) types.Weather {
+	weather := s.weatherCache.Get(cache.Key(backend, loc), func() (weather types.Weather) {
+		weatherBackend, done := s.acquireBackend(backend)
+		defer done()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				weather = types.WeatherError(s.errBackendPanicked(backend, recovered))
+				s.backendStatuses.Record(backend, weather.Error)
+			}
+		}()
+		if weatherBackend == nil {
+			return types.WeatherError(errBackendRemoved(backend)) // removed by a reload
+		}
+		weather = weatherBackend.GetWeather(loc)
+		weather.Error = s.redactor.String(weather.Error) // i.e. an http.Client error quoting a URL with the API key
+		s.backendStatuses.Record(backend, weather.Error)
+		if weather.Error == "" {
+			s.weatherUpdates.Publish(loc, weather)
+		}
+		return weather
+	})
+	weather.Source = backend
+	return weather
+}
+
+// fetchForecast fans out to the target backends for the resolved location and fills in the response
+func (s *Server) fetchForecast(response *ForecastResponse, loc location.Location, targetBackends []string) {
+	response.Location = &loc
+	for _, backend := range targetBackends {
+		response.Data = append(response.Data, s.fetchBackendForecast(backend, loc))
+	}
+}
+
+// fetchBackendForecast gets the forecast for the location from a single backend through the cache, named after the
+// backend like fetchBackendWeather
+func (s *Server) fetchBackendForecast(backend string, loc location.Location) types.Forecast {
+	forecast := types.ForecastError(types.ErrForecastsNotSupported)
+	if _, ok := s.backends().configured[backend].(types.ForecastBackend); ok {
+		forecast = s.weatherCache.GetForecast(cache.ForecastKey(backend, loc), func() (forecast types.Forecast) {
+			weatherBackend, done := s.acquireBackend(backend)
+			defer done()
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					forecast = types.ForecastError(s.errBackendPanicked(backend, recovered))
+					s.backendStatuses.Record(backend, forecast.Error)
+				}
+			}()
+			forecastBackend, ok := weatherBackend.(types.ForecastBackend)
+			if !ok {
+				return types.ForecastError(errBackendRemoved(backend)) // removed by a reload
+			}
+			forecast = forecastBackend.GetForecast(loc)
+			forecast.Error = s.redactor.String(forecast.Error)
+			s.backendStatuses.Record(backend, forecast.Error)
+			return forecast
+		})
+	}
+	forecast.Source = backend
+	return forecast
+}
+
+// findLocationMismatch checks whether the backends resolved the request to places further apart than maxDistanceKm
+func findLocationMismatch(data []types.Weather, maxDistanceKm float64) *api.LocationMismatch {
+	sources := []string{}
+	points := []location.Coordinates{}
+	for _, weather := range data {
+		if weather.Location == nil || weather.Location.Coordinates == nil {
+			continue
+		}
+		sources = append(sources, weather.Source)
+		points = append(points, *weather.Location.Coordinates)
+	}
+
+	i, j, distance := location.FarthestPair(points)
+	if distance <= maxDistanceKm {
+		return nil
+	}
+	return &api.LocationMismatch{
+		Sources:    []string{sources[i], sources[j]},
+		DistanceKm: math.Round(distance),
+	}
+}
+
+func (s *Server) getBackends(c echo.Context) error {
+	set := s.backends()
+	if relayed(c) {
+		return c.JSONPretty(http.StatusOK, BackendResponse{Backends: set.local(set.names)}, "  ")
+	}
+	return c.JSONPretty(http.StatusOK, BackendResponse{Backends: set.names}, "  ")
+}
+
+func optionsWeather(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderAccept, "GET, OPTIONS")
+	return c.String(http.StatusOK, "")
+}
+
+func (s *Server) searchLocations(c echo.Context) error {
+	response := &LocationSearchResponse{Candidates: []location.Candidate{}}
+
+	response.Query = strings.TrimSpace(c.QueryParam("q"))
+	if len(response.Query) == 0 {
+		response.Error = "No query specified. Please provide a q query parameter."
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+
+	limit := location.DefaultSearchLimit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			response.Error = "Invalid limit specified: " + limitParam
+			return c.JSONPretty(http.StatusBadRequest, response, "  ")
+		}
+	}
+
+	candidates, err := s.locationResolver.Search(response.Query, limit)
+	if err == location.ErrNotFound {
+		// an empty list is a perfectly fine answer for autocomplete
+		return c.JSONPretty(http.StatusOK, response, "  ")
+	}
+	if err != nil {
+		response.Error = err.Error()
+		return c.JSONPretty(http.StatusBadRequest, response, "  ")
+	}
+	response.Candidates = candidates
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
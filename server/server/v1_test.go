@@ -0,0 +1,958 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"go-weather-app/server/api"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/geoip"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type mockWeatherBackend struct {
+	returnWeather types.Weather
+}
+
+func (m mockWeatherBackend) GetWeather(loc location.Location) types.Weather {
+	return m.returnWeather
+}
+
+func Test_validateBackends(t *testing.T) {
+	tests := []struct {
+		name               string
+		backends           []string
+		ConfiguredBackends map[string]types.WeatherBackend
+		expectedErr        error
+	}{
+		{
+			name:               "no default backends, no provided backends",
+			backends:           []string{},
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedErr:        nil,
+		},
+		{
+			name:               "no default backends, backend not found",
+			backends:           []string{"foo"},
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			expectedErr:        errors.New("Backend specified is invalid or inactive: foo"),
+		},
+		{
+			name:     "default backend set, provided backend found",
+			backends: []string{"foo"},
+			ConfiguredBackends: map[string]types.WeatherBackend{
+				"foo": mockWeatherBackend{},
+			},
+			expectedErr: nil,
+		},
+		{
+			name:     "multiple default backend set, all provided backends found",
+			backends: []string{"foo", "bar"},
+			ConfiguredBackends: map[string]types.WeatherBackend{
+				"foo": mockWeatherBackend{},
+				"bar": mockWeatherBackend{},
+			},
+			expectedErr: nil,
+		},
+		{
+			name:     "multiple default backend set, NOT all provided backends found",
+			backends: []string{"foo", "bar", "baz"},
+			ConfiguredBackends: map[string]types.WeatherBackend{
+				"foo": mockWeatherBackend{},
+				"baz": mockWeatherBackend{},
+			},
+			expectedErr: errors.New("Backend specified is invalid or inactive: bar"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setBackends(tc.ConfiguredBackends, []string{})
+
+			err := s.validateBackends(context.Background(), tc.backends)
+			require.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func Test_getWeather(t *testing.T) {
+	tests := []struct {
+		name               string
+		city               string
+		backendParam       string
+		ConfiguredBackends map[string]types.WeatherBackend
+		DefaultBackends    []string
+		expectedErr        error
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "no city specified",
+			city:               "",
+			backendParam:       "",
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			DefaultBackends:    []string{},
+			expectedErr:        nil,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"No city specified. Please provide a city query parameter.\"\n}\n",
+		},
+		{
+			name:         "default backends used",
+			city:         "foo",
+			backendParam: "",
+			ConfiguredBackends: map[string]types.WeatherBackend{
+				"fooBackend": mockWeatherBackend{
+					returnWeather: types.Weather{
+						Source:              "fooBackend",
+						Temperature:         12,
+						TemperatureMin:      2,
+						TemperatureMax:      20,
+						MainDescription:     "Sunny",
+						DetailedDescription: "Mix of sun and clouds",
+					},
+				},
+			},
+			DefaultBackends:    []string{"fooBackend"},
+			expectedErr:        nil,
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"city\": \"foo\",\n  \"location\": {\n    \"name\": \"foo\"\n  },\n  \"data\": [\n    {\n      \"source\": \"fooBackend\",\n      \"temperature\": 12,\n      \"temperature_min\": 2,\n      \"temperature_max\": 20,\n      \"main_description\": \"Sunny\",\n      \"detailed_description\": \"Mix of sun and clouds\"\n    }\n  ]\n}\n",
+		},
+		{
+			name:               "specified backend does not exist",
+			city:               "foo",
+			backendParam:       "?backend=fooBackend",
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			DefaultBackends:    []string{},
+			expectedErr:        nil,
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"city\": \"foo\",\n  \"error\": \"Backend specified is invalid or inactive: fooBackend\"\n}\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setBackends(tc.ConfiguredBackends, tc.DefaultBackends)
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/weather/"+tc.city+tc.backendParam, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/weather/:city")
+			if len(tc.city) > 0 {
+				c.SetParamNames("city")
+				c.SetParamValues(tc.city)
+			}
+
+			err := s.getWeather(c)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+type mockForecastBackend struct {
+	mockWeatherBackend
+	returnForecast types.Forecast
+}
+
+func (m mockForecastBackend) GetForecast(loc location.Location) types.Forecast {
+	return m.returnForecast
+}
+
+func Test_getForecast(t *testing.T) {
+	tests := []struct {
+		name               string
+		city               string
+		backendParam       string
+		ConfiguredBackends map[string]types.WeatherBackend
+		DefaultBackends    []string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "no city specified",
+			city:               "",
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			DefaultBackends:    []string{},
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"No city specified. Please provide a city query parameter.\"\n}\n",
+		},
+		{
+			name: "forecasts from the default backends",
+			city: "foo",
+			ConfiguredBackends: map[string]types.WeatherBackend{
+				"fooBackend": mockForecastBackend{
+					returnForecast: types.Forecast{
+						Source: "fooBackend",
+						Days:   []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny", DetailedDescription: "Mix of sun and clouds"}},
+					},
+				},
+				"barBackend": mockWeatherBackend{},
+			},
+			DefaultBackends:    []string{"fooBackend", "barBackend"},
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"city\": \"foo\",\n  \"location\": {\n    \"name\": \"foo\"\n  },\n  \"data\": [\n    {\n      \"source\": \"fooBackend\",\n      \"days\": [\n        {\n          \"date\": \"2019-06-01\",\n          \"temperature_min\": 2,\n          \"temperature_max\": 20,\n          \"main_description\": \"Sunny\",\n          \"detailed_description\": \"Mix of sun and clouds\"\n        }\n      ]\n    },\n    {\n      \"source\": \"barBackend\",\n      \"error\": \"Forecasts are not supported by this backend\",\n      \"error_code\": \"not_supported\"\n    }\n  ]\n}\n",
+		},
+		{
+			name:               "specified backend does not exist",
+			city:               "foo",
+			backendParam:       "?backend=fooBackend",
+			ConfiguredBackends: map[string]types.WeatherBackend{},
+			DefaultBackends:    []string{},
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"city\": \"foo\",\n  \"error\": \"Backend specified is invalid or inactive: fooBackend\"\n}\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setBackends(tc.ConfiguredBackends, tc.DefaultBackends)
+			s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/forecast/"+tc.city+tc.backendParam, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/forecast/:city")
+			if len(tc.city) > 0 {
+				c.SetParamNames("city")
+				c.SetParamValues(tc.city)
+			}
+
+			require.NoError(t, s.getForecast(c))
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func Test_optionsWeather(t *testing.T) {
+	t.Run("OPTIONS", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodOptions, "/weather/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/weather")
+
+		err := optionsWeather(c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "GET, OPTIONS", rec.Header()["Accept"][0])
+	})
+
+}
+
+func Test_getBackends(t *testing.T) {
+	tests := []struct {
+		name               string
+		configuredBackends map[string]types.WeatherBackend
+		expectedBody       string
+		expectedErr        error
+	}{
+		{
+			name: "lists the configured backends sorted",
+			configuredBackends: map[string]types.WeatherBackend{
+				"foo": mockWeatherBackend{},
+				"bar": mockWeatherBackend{},
+			},
+			expectedBody: "{\n  \"backends\": [\n    \"bar\",\n    \"foo\"\n  ]\n}\n",
+			expectedErr:  nil,
+		},
+		{
+			name:               "lists no backends when none are configured",
+			configuredBackends: map[string]types.WeatherBackend{},
+			expectedBody:       "{\n  \"backends\": []\n}\n",
+			expectedErr:        nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setBackends(tc.configuredBackends, []string{})
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/backends", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/backends")
+
+			err := s.getBackends(c)
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+type mockRelayBackend struct {
+	mockWeatherBackend
+}
+
+func (m mockRelayBackend) RelaysTo() string {
+	return "https://eu.weather.example.com"
+}
+
+func Test_relayedRequests(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo":    mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12}},
+		"eu/foo": mockRelayBackend{mockWeatherBackend{returnWeather: types.Weather{Source: "eu/foo", Temperature: 14}}},
+	}, []string{"eu/foo", "foo"})
+
+	e := echo.New()
+	e.GET("/v1/weather/:city", s.getWeather)
+	e.GET("/v1/backends", s.getBackends)
+
+	tests := []struct {
+		name               string
+		target             string
+		hops               string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "requests from clients use every backend",
+			target:             "/v1/weather/foo",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"city": "foo", "location": {"name": "foo"}, "data": [{"source": "eu/foo", "temperature": 14, "temperature_min": 0, "temperature_max": 0}, {"source": "foo", "temperature": 12, "temperature_min": 0, "temperature_max": 0}]}`,
+		},
+		{
+			name:               "relayed requests leave out the backends that relay by default",
+			target:             "/v1/weather/foo",
+			hops:               "1",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"city": "foo", "location": {"name": "foo"}, "data": [{"source": "foo", "temperature": 12, "temperature_min": 0, "temperature_max": 0}]}`,
+		},
+		{
+			name:               "relayed requests for backends that relay return error",
+			target:             "/v1/weather/foo?backend=foo,eu/foo",
+			hops:               "1",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       `{"city": "foo", "error": "Backend would relay the request again: eu/foo"}`,
+		},
+		{
+			name:               "zero hops is a request from a client",
+			target:             "/v1/weather/foo?backend=eu/foo",
+			hops:               "0",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"city": "foo", "location": {"name": "foo"}, "data": [{"source": "eu/foo", "temperature": 14, "temperature_min": 0, "temperature_max": 0}]}`,
+		},
+		{
+			name:               "backends are listed for clients",
+			target:             "/v1/backends",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"backends": ["eu/foo", "foo"]}`,
+		},
+		{
+			name:               "backends that relay are not listed for other servers",
+			target:             "/v1/backends",
+			hops:               "1",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       `{"backends": ["foo"]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.hops != "" {
+				req.Header.Set(api.HeaderHops, tc.hops)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.JSONEq(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+// mockLocatingBackend reports the location it was asked about as its reading
+type mockLocatingBackend struct{}
+
+func (mockLocatingBackend) GetWeather(loc location.Location) types.Weather {
+	return types.Weather{Location: &types.ResolvedLocation{Location: loc}}
+}
+
+func Test_getWeatherUnknownLocation(t *testing.T) {
+	s := newTestServer(t)
+	geonames, err := location.LoadGeoNames("../location/testdata/cities.txt")
+	require.NoError(t, err)
+	s.locationResolver = location.Resolver{Geocoder: geonames}
+
+	s.setBackends(map[string]types.WeatherBackend{"foo": mockLocatingBackend{}}, []string{"foo"})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/weather/Atlantis", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/weather/:city")
+	c.SetParamNames("city")
+	c.SetParamValues("Atlantis")
+
+	err = s.getWeather(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code, "places the dataset doesn't know are passed through to the backends")
+	response := WeatherResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Equal(t, location.Location{Name: "Atlantis"}, response.Data[0].Location.Location)
+}
+
+func Test_searchLocations(t *testing.T) {
+	geonames, err := location.LoadGeoNames("../location/testdata/cities.txt")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		query              string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "no query specified",
+			query:              "",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"candidates\": [],\n  \"error\": \"No query specified. Please provide a q query parameter.\"\n}\n",
+		},
+		{
+			name:               "invalid limit",
+			query:              "?q=Ottawa&limit=foo",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"query\": \"Ottawa\",\n  \"candidates\": [],\n  \"error\": \"Invalid limit specified: foo\"\n}\n",
+		},
+		{
+			name:               "no matches returns an empty list",
+			query:              "?q=Atlantis",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"query\": \"Atlantis\",\n  \"candidates\": []\n}\n",
+		},
+		{
+			name:               "ranked candidates with limit",
+			query:              "?q=London&limit=1",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"query\": \"London\",\n  \"candidates\": [\n    {\n      \"location\": {\n        \"name\": \"London\",\n        \"admin_region\": \"ENG\",\n        \"country\": \"GB\",\n        \"coordinates\": {\n          \"latitude\": 51.50853,\n          \"longitude\": -0.12574\n        },\n        \"timezone\": \"Europe/London\"\n      },\n      \"population\": 8961989,\n      \"score\": 1\n    }\n  ]\n}\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.locationResolver = location.Resolver{Geocoder: geonames}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/locations/search"+tc.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/locations/search")
+
+			err := s.searchLocations(c)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func Test_findLocationMismatch(t *testing.T) {
+	londonGB := &types.ResolvedLocation{Location: location.Location{Name: "London", Country: "GB", Coordinates: &location.Coordinates{Latitude: 51.50853, Longitude: -0.12574}}}
+	londonCA := &types.ResolvedLocation{Location: location.Location{Name: "London", Country: "CA", Coordinates: &location.Coordinates{Latitude: 42.98339, Longitude: -81.23304}}}
+	londonCANearby := &types.ResolvedLocation{Location: location.Location{Name: "London", Country: "CA", Coordinates: &location.Coordinates{Latitude: 42.984, Longitude: -81.245}}}
+
+	tests := []struct {
+		name          string
+		data          []types.Weather
+		maxDistanceKm float64
+		want          *api.LocationMismatch
+	}{
+		{
+			name: "no data",
+			data: []types.Weather{},
+			want: nil,
+		},
+		{
+			name: "backends without locations are ignored",
+			data: []types.Weather{
+				{Source: "foo", Location: londonGB},
+				{Source: "bar", Error: "Error communicating to backend"},
+			},
+			maxDistanceKm: 50,
+			want:          nil,
+		},
+		{
+			name: "backends within the distance agree",
+			data: []types.Weather{
+				{Source: "foo", Location: londonCA},
+				{Source: "bar", Location: londonCANearby},
+			},
+			maxDistanceKm: 50,
+			want:          nil,
+		},
+		{
+			name: "backends further than the distance disagree",
+			data: []types.Weather{
+				{Source: "foo", Location: londonCA},
+				{Source: "bar", Location: londonCANearby},
+				{Source: "baz", Location: londonGB},
+			},
+			maxDistanceKm: 50,
+			want: &api.LocationMismatch{
+				Sources:    []string{"bar", "baz"},
+				DistanceKm: 5876,
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := findLocationMismatch(tc.data, tc.maxDistanceKm)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+type mockIPLocator struct {
+	locations map[string]location.Location
+	err       error
+}
+
+func (m mockIPLocator) Locate(ip net.IP) (location.Location, bool, error) {
+	loc, found := m.locations[ip.String()]
+	return loc, found, m.err
+}
+
+func Test_getWeatherHere(t *testing.T) {
+	ottawa := location.Location{Name: "Ottawa", AdminRegion: "Ontario", Country: "CA", Coordinates: &location.Coordinates{Latitude: 45.4, Longitude: -75.7}}
+	locator := mockIPLocator{locations: map[string]location.Location{"81.2.69.160": ottawa}}
+
+	tests := []struct {
+		name               string
+		ipLocator          geoip.Locator
+		remoteAddr         string
+		forwardedFor       string
+		backendParam       string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "not configured",
+			ipLocator:          nil,
+			remoteAddr:         "81.2.69.160:1234",
+			expectedHTTPStatus: http.StatusNotImplemented,
+			expectedBody:       "{\n  \"error\": \"Location by IP address is not configured\"\n}\n",
+		},
+		{
+			name:               "specified backend does not exist",
+			ipLocator:          locator,
+			remoteAddr:         "81.2.69.160:1234",
+			backendParam:       "?backend=foo",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"Backend specified is invalid or inactive: foo\"\n}\n",
+		},
+		{
+			name:               "unknown address",
+			ipLocator:          locator,
+			remoteAddr:         "10.1.1.1:1234",
+			expectedHTTPStatus: http.StatusNotFound,
+			expectedBody:       "{\n  \"error\": \"Unable to determine location for IP address: 10.1.1.1\"\n}\n",
+		},
+		{
+			name:               "locator error",
+			ipLocator:          mockIPLocator{err: errors.New("corrupt database")},
+			remoteAddr:         "81.2.69.160:1234",
+			expectedHTTPStatus: http.StatusInternalServerError,
+			expectedBody:       "{\n  \"error\": \"Unable to determine location for IP address\"\n}\n",
+		},
+		{
+			name:               "forwarded address from trusted proxy is located",
+			ipLocator:          locator,
+			remoteAddr:         "172.18.0.2:1234",
+			forwardedFor:       "81.2.69.160",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"city\": \"Ottawa, Ontario, CA\",\n  \"location\": {\n    \"name\": \"Ottawa\",\n    \"admin_region\": \"Ontario\",\n    \"country\": \"CA\",\n    \"coordinates\": {\n      \"latitude\": 45.4,\n      \"longitude\": -75.7\n    }\n  },\n  \"data\": [\n    {\n      \"source\": \"fooBackend\",\n      \"temperature\": 12,\n      \"temperature_min\": 0,\n      \"temperature_max\": 0\n    }\n  ]\n}\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.ipLocator = tc.ipLocator
+			s.trustedProxies, _ = geoip.ParseTrustedProxies([]string{"172.16.0.0/12"})
+
+			s.setBackends(map[string]types.WeatherBackend{
+				"fooBackend": mockWeatherBackend{returnWeather: types.Weather{Source: "fooBackend", Temperature: 12}},
+			}, []string{"fooBackend"})
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/weather/here"+tc.backendParam, nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/weather/here")
+
+			err := s.getWeatherHere(c)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+// slowWeatherBackend keeps track of how many requests it is serving at the same time
+type slowWeatherBackend struct {
+	mu          sync.Mutex
+	current     int
+	maxCurrent  int
+	calls       int
+	failForCity string
+}
+
+func (s *slowWeatherBackend) GetWeather(loc location.Location) types.Weather {
+	s.mu.Lock()
+	s.current++
+	s.calls++
+	if s.current > s.maxCurrent {
+		s.maxCurrent = s.current
+	}
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	if loc.Name == s.failForCity {
+		return types.Weather{Error: "Error communicating to backend"}
+	}
+	return types.Weather{Source: "slow", Temperature: 10}
+}
+
+func Test_postWeatherBatch(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedHTTPStatus int
+		expectedBody       string
+	}{
+		{
+			name:               "invalid body",
+			body:               "{",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"Unable to parse request body: unexpected EOF\"\n}\n",
+		},
+		{
+			name:               "body too large",
+			body:               "{\"items\":[{\"city\":\"" + strings.Repeat("a", 5*batchItemMaxBytes) + "\"}]}",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"Unable to parse request body: http: request body too large\"\n}\n",
+		},
+		{
+			name:               "no items",
+			body:               "{\"items\":[]}",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"No items specified. Please provide at least one city or coordinates.\"\n}\n",
+		},
+		{
+			name:               "too many items",
+			body:               "{\"items\":[{\"city\":\"a\"},{\"city\":\"b\"},{\"city\":\"c\"},{\"city\":\"d\"}]}",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"Too many items specified: 4 (the maximum is 3)\"\n}\n",
+		},
+		{
+			name:               "specified backend does not exist",
+			body:               "{\"items\":[{\"city\":\"a\"}],\"backends\":[\"foo\"]}",
+			expectedHTTPStatus: http.StatusBadRequest,
+			expectedBody:       "{\n  \"error\": \"Backend specified is invalid or inactive: foo\"\n}\n",
+		},
+		{
+			name:               "per item results and errors in request order",
+			body:               "{\"items\":[{\"city\":\"a\"},{\"coordinates\":{\"latitude\":45.5,\"longitude\":-73.5}},{},{\"city\":\"fail\"}],\"backends\":[\"slow\"]}",
+			expectedHTTPStatus: http.StatusOK,
+			expectedBody:       "{\n  \"results\": [\n    {\n      \"city\": \"a\",\n      \"location\": {\n        \"name\": \"a\"\n      },\n      \"data\": [\n        {\n          \"source\": \"slow\",\n          \"temperature\": 10,\n          \"temperature_min\": 0,\n          \"temperature_max\": 0\n        }\n      ]\n    },\n    {\n      \"city\": \"45.5,-73.5\",\n      \"location\": {\n        \"name\": \"\",\n        \"coordinates\": {\n          \"latitude\": 45.5,\n          \"longitude\": -73.5\n        }\n      },\n      \"data\": [\n        {\n          \"source\": \"slow\",\n          \"temperature\": 10,\n          \"temperature_min\": 0,\n          \"temperature_max\": 0\n        }\n      ]\n    },\n    {\n      \"error\": \"No city or coordinates specified\"\n    },\n    {\n      \"city\": \"fail\",\n      \"location\": {\n        \"name\": \"fail\"\n      },\n      \"data\": [\n        {\n          \"source\": \"\",\n          \"temperature\": 0,\n          \"temperature_min\": 0,\n          \"temperature_max\": 0,\n          \"error\": \"Error communicating to backend\"\n        }\n      ]\n    }\n  ]\n}\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setBackends(map[string]types.WeatherBackend{"slow": &slowWeatherBackend{failForCity: "fail"}}, []string{"slow"})
+			s.batchMaxItems = 4
+			if tc.name == "too many items" {
+				s.batchMaxItems = 3
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/weather/batch", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/weather/batch")
+
+			err := s.postWeatherBatch(c)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+// mockPanickingGeocoder panics on every search
+type mockPanickingGeocoder struct{}
+
+func (mockPanickingGeocoder) Search(q location.Query, limit int) ([]location.Candidate, error) {
+	panic("unexpected query")
+}
+
+func Test_postWeatherBatchPanic(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{"slow": &slowWeatherBackend{}}, []string{"slow"})
+	s.locationResolver = location.Resolver{Geocoder: mockPanickingGeocoder{}}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/weather/batch", strings.NewReader("{\"items\":[{\"city\":\"a\"},{\"coordinates\":{\"latitude\":45.5,\"longitude\":-73.5}}]}"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	require.NoError(t, s.postWeatherBatch(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	response := BatchWeatherResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Equal(t, WeatherResponse{City: "a", Error: "Unable to fetch the weather for this item"}, response.Results[0], "a panic only fails its item")
+	require.Equal(t, "", response.Results[1].Error)
+}
+
+func Test_postWeatherBatchConcurrencyAndCaching(t *testing.T) {
+	s := newTestServer(t)
+	backend := &slowWeatherBackend{}
+
+	s.setBackends(map[string]types.WeatherBackend{"slow": backend}, []string{"slow"})
+	s.weatherCache = cache.New(time.Minute, time.Now, s.metrics)
+	s.batchConcurrency = 2
+
+	items := []string{}
+	for i := 0; i < 10; i++ {
+		items = append(items, "{\"city\":\"city"+strconv.Itoa(i%5)+"\"}")
+	}
+	body := "{\"items\":[" + strings.Join(items, ",") + "]}"
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/weather/batch", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := s.postWeatherBatch(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, backend.maxCurrent <= 2, "backend saw %d concurrent requests", backend.maxCurrent)
+	require.Equal(t, 5, backend.calls, "duplicate cities should be served from the cache")
+}
+
+// countingWeatherBackend reports a new temperature on every call, so that each reading can be told apart
+type countingWeatherBackend struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *countingWeatherBackend) GetWeather(loc location.Location) types.Weather {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	return types.Weather{Source: "foo", Temperature: float32(b.calls)}
+}
+
+type streamEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// readStreamEvent reads Server-Sent Events until one with an id, event, data or comment comes along
+func readStreamEvent(t *testing.T, r *bufio.Reader) streamEvent {
+	event := streamEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if event != (streamEvent{}) {
+				return event
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		value := strings.TrimSpace(parts[1])
+		switch parts[0] {
+		case "":
+			event.comment = value
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			event.data = value
+		}
+	}
+}
+
+func Test_streamWeather(t *testing.T) {
+	s := newTestServer(t)
+	backend := &countingWeatherBackend{}
+
+	s.setBackends(map[string]types.WeatherBackend{"foo": backend}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+	s.streamHeartbeat = 20 * time.Millisecond
+	s.streamPollInterval = time.Hour
+
+	e := echo.New()
+	e.GET("/v1/weather/:city", s.getWeather)
+	e.GET("/v1/weather/:city/stream", s.streamWeather)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	openStream := func(url string, lastEventID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+url, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	t.Run("snapshot, then readings fetched by other clients and heartbeats", func(t *testing.T) {
+		resp, r := openStream("/v1/weather/foo/stream", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "text/event-stream", resp.Header.Get(echo.HeaderContentType))
+
+		event := readStreamEvent(t, r)
+		require.Equal(t, streamEvent{id: "1", event: "snapshot", data: "{\"city\":\"foo\",\"location\":{\"name\":\"foo\"},\"data\":[{\"source\":\"foo\",\"temperature\":1,\"temperature_min\":0,\"temperature_max\":0}]}"}, event)
+
+		weatherResp, err := client.Get(server.URL + "/v1/weather/foo")
+		require.NoError(t, err)
+		weatherResp.Body.Close()
+
+		for event = readStreamEvent(t, r); event.comment != ""; event = readStreamEvent(t, r) {
+			require.Equal(t, "heartbeat", event.comment)
+		}
+		require.Equal(t, "2", event.id)
+		require.Equal(t, "weather", event.event)
+		update := WeatherUpdateEvent{}
+		require.NoError(t, json.Unmarshal([]byte(event.data), &update))
+		require.Equal(t, location.Location{Name: "foo"}, update.Location)
+		require.Equal(t, types.Weather{Source: "foo", Temperature: 2}, update.Data)
+
+		require.Equal(t, "heartbeat", readStreamEvent(t, r).comment)
+	})
+
+	t.Run("resume with Last-Event-ID", func(t *testing.T) {
+		resp, r := openStream("/v1/weather/foo/stream", "1")
+		defer resp.Body.Close()
+
+		event := readStreamEvent(t, r)
+		require.Equal(t, "2", event.id)
+		require.Equal(t, "weather", event.event)
+		require.Contains(t, event.data, "\"temperature\":2")
+		require.Equal(t, "heartbeat", readStreamEvent(t, r).comment)
+		require.Equal(t, 2, backend.calls, "resuming shouldn't fetch a new snapshot")
+	})
+
+	t.Run("resume from an unknown event gets a new snapshot", func(t *testing.T) {
+		resp, r := openStream("/v1/weather/foo/stream?lastEventId=42", "")
+		defer resp.Body.Close()
+
+		event := readStreamEvent(t, r)
+		require.Equal(t, "3", event.id)
+		require.Equal(t, "snapshot", event.event)
+	})
+
+	t.Run("polling publishes new readings", func(t *testing.T) {
+		// wait for the previous streams to wind down before changing their settings
+		for i := 0; s.weatherUpdates.Subscribers(location.Location{Name: "foo"}.Key()) > 0; i++ {
+			require.True(t, i < 100, "previous streams are still open")
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.streamPollInterval = 10 * time.Millisecond
+
+		resp, r := openStream("/v1/weather/foo/stream", "")
+		defer resp.Body.Close()
+
+		require.Equal(t, "snapshot", readStreamEvent(t, r).event)
+		event := readStreamEvent(t, r)
+		for event.comment != "" {
+			event = readStreamEvent(t, r)
+		}
+		require.Equal(t, "weather", event.event)
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		resp, err := client.Get(server.URL + "/v1/weather/foo/stream?backend=bar")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// mockPanickingBackend panics like a backend surprised by the response of its API
+type mockPanickingBackend struct{}
+
+func (mockPanickingBackend) GetWeather(loc location.Location) types.Weather {
+	var details []string
+	return types.Weather{MainDescription: details[0]}
+}
+
+func (mockPanickingBackend) GetForecast(loc location.Location) types.Forecast {
+	panic("unexpected forecast")
+}
+
+func Test_fetchBackendPanic(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{"foo": mockPanickingBackend{}}, []string{"foo"})
+
+	weather := s.fetchBackendWeather("foo", location.Location{Name: "ottawa"})
+	require.Equal(t, types.Weather{Source: "foo", Error: "Backend failed unexpectedly: foo", ErrorCode: types.ErrorUnknown}, weather)
+	forecast := s.fetchBackendForecast("foo", location.Location{Name: "ottawa"})
+	require.Equal(t, types.Forecast{Source: "foo", Error: "Backend failed unexpectedly: foo", ErrorCode: types.ErrorUnknown}, forecast)
+	status, ok := s.backendStatuses.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "Backend failed unexpectedly: foo", status.LastError)
+}
+
+func Test_streamWeatherPanickingBackend(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{"foo": mockPanickingBackend{}}, []string{"foo"})
+	s.streamHeartbeat = 10 * time.Millisecond
+	s.streamPollInterval = 5 * time.Millisecond
+
+	e := echo.New()
+	e.GET("/v1/weather/:city/stream", s.streamWeather)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(server.URL + "/v1/weather/foo/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	event := readStreamEvent(t, r)
+	require.Equal(t, "snapshot", event.event)
+	require.Contains(t, event.data, "Backend failed unexpectedly: foo")
+	// the polls in the background panic too, but the stream (and the server) go on
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "heartbeat", readStreamEvent(t, r).comment)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/auth"
+	"go-weather-app/server/location"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) registerV2(e *echo.Echo) *echo.Group {
+	v2Api := e.Group("/v2")
+	v2Api.Use(s.requestMetricsMiddleware())
+	v2Api.Use(s.authMiddleware(auth.ScopeRead))
+	v2Api.Use(openAPIValidationMiddleware(s.openAPISpec))
+	v2Api.GET("/weather/:city", s.getWeatherV2)
+	v2Api.GET("/forecast/:city", s.getForecastV2)
+	v2Api.GET("/backends", s.getBackendsV2)
+	return v2Api
+}
+
+// httpErrorHandler renders the errors of /v2 routes (i.e. unknown routes, panics) as problems, and leaves /v1 alone
+func httpErrorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if !strings.HasPrefix(c.Request().URL.Path, "/v2/") {
+			e.DefaultHTTPErrorHandler(err, c)
+			return
+		}
+		if c.Response().Committed {
+			return
+		}
+
+		problem := &api.Problem{Status: http.StatusInternalServerError, Code: api.ProblemInternal}
+		if he, ok := err.(*echo.HTTPError); ok {
+			problem.Status = he.Code
+			switch he.Code {
+			case http.StatusNotFound:
+				problem.Code = api.ProblemNotFound
+			case http.StatusMethodNotAllowed:
+				problem.Code = api.ProblemMethodNotAllowed
+			case http.StatusUnauthorized:
+				problem.Code, problem.Detail = api.ProblemUnauthorized, fmt.Sprint(he.Message)
+			case http.StatusForbidden:
+				problem.Code, problem.Detail = api.ProblemForbidden, fmt.Sprint(he.Message)
+			case http.StatusTooManyRequests:
+				problem.Code, problem.Detail = api.ProblemTooManyRequests, fmt.Sprint(he.Message)
+			case http.StatusServiceUnavailable:
+				problem.Code, problem.Detail = api.ProblemUnavailable, fmt.Sprint(he.Message)
+			default:
+				if he.Code < http.StatusInternalServerError {
+					problem.Code = api.ProblemBadRequest
+					problem.Detail = fmt.Sprint(he.Message)
+				}
+			}
+		}
+		if problem.Code == api.ProblemInternal {
+			c.Logger().Error(err)
+		}
+		if err := writeProblem(c, problem); err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
+
+// writeProblem fills in the type, title and instance of the problem from its code and the request, then writes it
+func writeProblem(c echo.Context, problem *api.Problem) error {
+	problem.Type = "urn:go-weather-app:problem:" + problem.Code
+	problem.Title = api.ProblemTitles[problem.Code]
+	problem.Instance = c.Request().URL.RequestURI()
+	b, err := json.MarshalIndent(problem, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(problem.Status, api.MIMEApplicationProblemJSON, append(b, '\n'))
+}
+
+// resolveV2Request validates the city and backend parameters like /v1 does, returning a problem when they are invalid
+func (s *Server) resolveV2Request(c echo.Context) (string, location.Location, []string, *api.Problem) {
+	city := strings.TrimSpace(c.Param("city"))
+	if len(city) == 0 {
+		return "", location.Location{}, nil, &api.Problem{Status: http.StatusBadRequest, Code: api.ProblemCityMissing, Detail: "No city specified. Please provide a city."}
+	}
+
+	targetBackends, err := s.selectBackends(c)
+	if err != nil {
+		return "", location.Location{}, nil, &api.Problem{Status: http.StatusBadRequest, Code: api.ProblemInvalidBackend, Detail: err.Error()}
+	}
+
+	loc, err := s.locationResolver.Resolve(city)
+	if err != nil {
+		return "", location.Location{}, nil, &api.Problem{Status: http.StatusBadRequest, Code: api.ProblemInvalidLocation, Detail: err.Error()}
+	}
+	return city, loc, targetBackends, nil
+}
+
+func (s *Server) getWeatherV2(c echo.Context) error {
+	city, loc, targetBackends, problem := s.resolveV2Request(c)
+	if problem != nil {
+		return writeProblem(c, problem)
+	}
+
+	fetched := &WeatherResponse{}
+	s.fetchWeather(fetched, loc, targetBackends)
+
+	response, problem := api.NewWeatherResponseV2(city, loc, targetBackends, fetched.Data)
+	if problem != nil {
+		return writeProblem(c, problem)
+	}
+	response.LocationMismatch = fetched.LocationMismatch
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
+
+func (s *Server) getForecastV2(c echo.Context) error {
+	city, loc, targetBackends, problem := s.resolveV2Request(c)
+	if problem != nil {
+		return writeProblem(c, problem)
+	}
+
+	fetched := &ForecastResponse{}
+	s.fetchForecast(fetched, loc, targetBackends)
+
+	response, problem := api.NewForecastResponseV2(city, loc, targetBackends, fetched.Data)
+	if problem != nil {
+		return writeProblem(c, problem)
+	}
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
+
+func (s *Server) getBackendsV2(c echo.Context) error {
+	set := s.backends()
+	isDefault := map[string]bool{}
+	for _, backend := range set.defaults {
+		isDefault[backend] = true
+	}
+
+	response := &api.BackendsResponseV2{Backends: []api.BackendV2{}}
+	for _, name := range set.names {
+		_, supportsForecasts := set.configured[name].(types.ForecastBackend)
+		backend := api.BackendV2{Name: name, Default: isDefault[name], SupportsForecasts: supportsForecasts}
+		if status, ok := s.backendStatuses.Get(name); ok {
+			backend.LastError = status.LastError
+			lastChecked := status.LastChecked
+			backend.LastChecked = &lastChecked
+		}
+		response.Backends = append(response.Backends, backend)
+	}
+	return c.JSONPretty(http.StatusOK, response, "  ")
+}
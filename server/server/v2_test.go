@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-weather-app/server/api"
+	"go-weather-app/server/cache"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_v2(t *testing.T) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{
+		"foo": mockForecastBackend{
+			mockWeatherBackend: mockWeatherBackend{returnWeather: types.Weather{Source: "foo", Temperature: 12, TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny", DetailedDescription: "Clear sky"}},
+			returnForecast:     types.Forecast{Source: "foo", Days: []types.ForecastDay{{Date: "2019-06-01", TemperatureMin: 2, TemperatureMax: 20, MainDescription: "Sunny"}}},
+		},
+		"bar": mockWeatherBackend{returnWeather: types.WeatherError(types.ErrUnavailable)},
+		"baz": mockWeatherBackend{returnWeather: types.Weather{Error: "Get http://example.com: EOF"}},
+	}, []string{"foo", "bar"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler(e)
+	e.GET("/v1/weather/:city", s.getWeather)
+	s.registerV2(e)
+
+	tests := []struct {
+		name                string
+		target              string
+		expectedHTTPStatus  int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "backends before any call",
+			target:              "/v2/backends",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"backends":[{"name":"bar","default":true,"supports_forecasts":false},{"name":"baz","default":false,"supports_forecasts":false},{"name":"foo","default":true,"supports_forecasts":true}]}`,
+		},
+		{
+			name:                "partial success",
+			target:              "/v2/weather/foo",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"city":"foo","location":{"name":"foo"},"sources":[{"source":"foo","status":"ok","data":{"temperature":12,"temperature_min":2,"temperature_max":20,"main_description":"Sunny","detailed_description":"Clear sky"}},{"source":"bar","status":"error","error":{"code":"backend_unavailable","message":"Error communicating to backend"}}]}`,
+		},
+		{
+			name:                "every source failed",
+			target:              "/v2/weather/foo?backend=bar,baz",
+			expectedHTTPStatus:  http.StatusBadGateway,
+			expectedContentType: api.MIMEApplicationProblemJSON,
+			expectedBody:        `{"type":"urn:go-weather-app:problem:all_sources_failed","title":"Every source failed","status":502,"detail":"None of the requested backends returned data","instance":"/v2/weather/foo?backend=bar,baz","code":"all_sources_failed","sources":[{"source":"bar","status":"error","error":{"code":"backend_unavailable","message":"Error communicating to backend"}},{"source":"baz","status":"error","error":{"code":"backend_error","message":"Get http://example.com: EOF"}}]}`,
+		},
+		{
+			name:                "invalid backend",
+			target:              "/v2/weather/foo?backend=qux",
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: api.MIMEApplicationProblemJSON,
+			expectedBody:        `{"type":"urn:go-weather-app:problem:invalid_backend","title":"Invalid backend","status":400,"detail":"Backend specified is invalid or inactive: qux","instance":"/v2/weather/foo?backend=qux","code":"invalid_backend"}`,
+		},
+		{
+			name:                "no city specified",
+			target:              "/v2/weather/%20",
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: api.MIMEApplicationProblemJSON,
+			expectedBody:        `{"type":"urn:go-weather-app:problem:city_missing","title":"No city specified","status":400,"detail":"No city specified. Please provide a city.","instance":"/v2/weather/%20","code":"city_missing"}`,
+		},
+		{
+			name:                "forecast from a source without forecasts",
+			target:              "/v2/forecast/foo",
+			expectedHTTPStatus:  http.StatusOK,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"city":"foo","location":{"name":"foo"},"sources":[{"source":"foo","status":"ok","data":{"days":[{"date":"2019-06-01","temperature_min":2,"temperature_max":20,"main_description":"Sunny"}]}},{"source":"bar","status":"error","error":{"code":"not_supported","message":"Forecasts are not supported by this backend"}}]}`,
+		},
+		{
+			name:                "forecast from sources without forecasts only",
+			target:              "/v2/forecast/foo?backend=bar",
+			expectedHTTPStatus:  http.StatusBadRequest,
+			expectedContentType: api.MIMEApplicationProblemJSON,
+			expectedBody:        `{"type":"urn:go-weather-app:problem:all_sources_unsupported","title":"No source supports this request","status":400,"detail":"None of the requested backends support this request","instance":"/v2/forecast/foo?backend=bar","code":"all_sources_unsupported","sources":[{"source":"bar","status":"error","error":{"code":"not_supported","message":"Forecasts are not supported by this backend"}}]}`,
+		},
+		{
+			name:                "unknown route",
+			target:              "/v2/nope",
+			expectedHTTPStatus:  http.StatusNotFound,
+			expectedContentType: api.MIMEApplicationProblemJSON,
+			expectedBody:        `{"type":"urn:go-weather-app:problem:not_found","title":"Not found","status":404,"instance":"/v2/nope","code":"not_found"}`,
+		},
+		{
+			name:                "v1 errors are left alone",
+			target:              "/v1/nope",
+			expectedHTTPStatus:  http.StatusNotFound,
+			expectedContentType: echo.MIMEApplicationJSONCharsetUTF8,
+			expectedBody:        `{"message":"Not Found"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedHTTPStatus, rec.Code)
+			require.Equal(t, tc.expectedContentType, rec.Header().Get(echo.HeaderContentType))
+			require.JSONEq(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
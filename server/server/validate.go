@@ -0,0 +1,476 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-weather-app/server/backends"
+)
+
+// Problem is something wrong with a configuration, along with where it is in the configuration file
+type Problem struct {
+	File     string   // the configuration file, "" when there is none
+	Position Position // the zero Position when it is unknown, i.e. for settings of environment variables
+	Setting  string   // the path of the setting, i.e. "backends[0].apiKey", "" for the whole configuration
+	Message  string
+}
+
+// String formats the problem the way compilers do, i.e. "config.yaml:4:3: backends[0].apikey: unknown setting, did
+// you mean apiKey?"
+func (p Problem) String() string {
+	parts := []string{}
+	if p.File != "" {
+		parts = append(parts, p.File+p.Position.String())
+	}
+	if p.Setting != "" {
+		parts = append(parts, p.Setting)
+	}
+	return strings.Join(append(parts, p.Message), ": ")
+}
+
+// Position is where a setting is in a configuration file, lines and columns start at 1
+type Position struct {
+	Line   int
+	Column int
+}
+
+// String formats the position to follow the name of the file, i.e. ":4:3", or "" when it is unknown
+func (p Position) String() string {
+	if p.Line == 0 {
+		return ""
+	}
+	if p.Column == 0 {
+		return ":" + strconv.Itoa(p.Line)
+	}
+	return ":" + strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
+
+// ValidationError is returned by LoadConfig when the configuration has problems, all of them
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.String()
+	}
+	return "Invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// ValidateConfig returns every problem with the configuration LoadConfig would load from the file and environ, sorted
+// by where they are in the file. Secret references that can't be resolved are problems too. The settings are checked
+// against the configuration types and the options of the types of backends: unknown settings (there are suggestions
+// for typos), missing settings (fields tagged `config:"required"`), values of the wrong type, invalid durations
+// (Duration fields and the ones tagged `config:"duration"`), unknown types of backends and backends configured twice.
+// None of the backends are created.
+func ValidateConfig(configFilePath string, environ []string) []Problem {
+	tree, positions, err := readConfig(configFilePath, environ)
+	if err != nil {
+		problem := Problem{File: configFilePath, Message: err.Error()}
+		if parseErr, ok := err.(*parseError); ok {
+			problem.Position, problem.Message = parseErr.position, parseErr.err.Error()
+		}
+		return []Problem{problem}
+	}
+	return validateConfig(configFilePath, tree, positions, environ)
+}
+
+// validateConfig replaces the secret references of a configuration read by readConfig with the secrets, then checks it
+func validateConfig(configFilePath string, tree map[string]interface{}, positions map[string]Position, environ []string) []Problem {
+	v := &validator{file: configFilePath, positions: positions}
+	v.resolveSecrets(tree, environ)
+	v.validate(tree, reflect.TypeOf(Config{}), "", "")
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i].Position, v.problems[j].Position
+		if a.Line == 0 || b.Line == 0 {
+			return a.Line != 0 && b.Line == 0 // the ones without a position go last
+		}
+		if a != b {
+			return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+		}
+		return v.problems[i].Setting < v.problems[j].Setting
+	})
+	return v.problems
+}
+
+// validator collects the problems of a configuration, the way json would decode it into the configuration types
+type validator struct {
+	file      string
+	positions map[string]Position
+	problems  []Problem
+}
+
+func (v *validator) add(setting string, message string) {
+	v.problems = append(v.problems, Problem{File: v.file, Position: v.position(setting), Setting: setting, Message: message})
+}
+
+// position returns where setting is in the file, or where the closest setting that contains it is, i.e. the backend
+// an option is missing from
+func (v *validator) position(setting string) Position {
+	for {
+		if position, ok := v.positions[setting]; ok || setting == "" {
+			return position
+		}
+		setting = parentSetting(setting)
+	}
+}
+
+// validate checks node against the configuration type t, tag is the config tag of the field node is decoded into
+func (v *validator) validate(node interface{}, t reflect.Type, setting string, tag string) {
+	if node == nil {
+		return // json leaves the zero value, missing settings are checked by their object
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == backendsConfigType:
+		v.validateBackends(node, setting)
+		return
+	case t == durationType || hasTag(tag, "duration"):
+		v.validateDuration(node, setting)
+		return
+	case t == rawMessageType || t.Kind() == reflect.Interface:
+		return // anything goes, i.e. the configuration of plugins
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			v.add(setting, "expected an object, got "+describe(node))
+			return
+		}
+		v.validateObject(object, jsonFields(t), setting, true)
+	case reflect.Map:
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			v.add(setting, "expected an object, got "+describe(node))
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			v.validate(object[key], t.Elem(), joinSetting(setting, key), "")
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := node.([]interface{})
+		if !ok {
+			v.add(setting, "expected a list, got "+describe(node))
+			return
+		}
+		for i, item := range list {
+			v.validate(item, t.Elem(), indexSetting(setting, i), tag)
+		}
+	case reflect.String:
+		if _, ok := node.(string); !ok {
+			v.add(setting, "expected a string, got "+describe(node))
+		}
+	case reflect.Bool:
+		if _, ok := node.(bool); !ok {
+			v.add(setting, "expected true or false, got "+describe(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number, ok := node.(json.Number); !ok {
+			v.add(setting, "expected an integer, got "+describe(node))
+		} else if _, err := strconv.ParseInt(string(number), 10, t.Bits()); err != nil {
+			v.add(setting, "expected an integer, got "+string(number))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if number, ok := node.(json.Number); !ok {
+			v.add(setting, "expected a positive integer, got "+describe(node))
+		} else if _, err := strconv.ParseUint(string(number), 10, t.Bits()); err != nil {
+			v.add(setting, "expected a positive integer, got "+string(number))
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := node.(json.Number); !ok {
+			v.add(setting, "expected a number, got "+describe(node))
+		}
+	}
+}
+
+// validateObject checks the settings of object against fields, by json name. Missing settings are only reported when
+// required is set.
+func (v *validator) validateObject(object map[string]interface{}, fields map[string]reflect.StructField, setting string, required bool) {
+	for _, key := range sortedKeys(object) {
+		field, ok := fields[key]
+		if !ok {
+			v.add(joinSetting(setting, key), "unknown setting"+suggest(key, fieldNames(fields)))
+			continue
+		}
+		v.validate(object[key], field.Type, joinSetting(setting, key), field.Tag.Get("config"))
+	}
+	if !required {
+		return
+	}
+	for _, name := range fieldNames(fields) {
+		if !hasTag(fields[name].Tag.Get("config"), "required") {
+			continue
+		}
+		if value, ok := object[name]; !ok || isEmpty(value) {
+			v.add(setting, "missing required setting "+name)
+		}
+	}
+}
+
+// validateBackends checks the list of backends, or the object older versions used, keyed by type
+func (v *validator) validateBackends(node interface{}, setting string) {
+	if byType, ok := node.(map[string]interface{}); ok {
+		for _, backendType := range sortedKeys(byType) {
+			options, ok := byType[backendType].(map[string]interface{})
+			factory, known := backends.Lookup(backendType)
+			switch {
+			case !known:
+				v.add(joinSetting(setting, backendType), "unknown backend type"+suggest(backendType, backendTypes()))
+			case !ok:
+				v.add(joinSetting(setting, backendType), "expected an object, got "+describe(byType[backendType]))
+			default:
+				// backends without an API key are left out rather than missing it
+				v.validateObject(options, backendFields(factory), joinSetting(setting, backendType), false)
+			}
+		}
+		return
+	}
+
+	list, ok := node.([]interface{})
+	if !ok {
+		v.add(setting, "expected a list, got "+describe(node))
+		return
+	}
+	names := map[string]string{}
+	for i, item := range list {
+		instance, ok := item.(map[string]interface{})
+		if !ok {
+			v.add(indexSetting(setting, i), "expected an object, got "+describe(item))
+			continue
+		}
+		backendType, _ := instance["type"].(string)
+		name, _ := instance["name"].(string)
+		if name == "" {
+			name = backendType
+		}
+		if name != "" {
+			if first, ok := names[name]; ok {
+				v.add(indexSetting(setting, i), "backend "+name+" is already configured by "+first)
+			} else {
+				names[name] = indexSetting(setting, i)
+			}
+		}
+
+		if _, ok := instance["name"]; ok {
+			v.validate(instance["name"], reflect.TypeOf(""), joinSetting(indexSetting(setting, i), "name"), "")
+		}
+		factory, known := backends.Lookup(backendType)
+		switch {
+		case instance["type"] == nil || instance["type"] == "":
+			v.add(indexSetting(setting, i), "missing required setting type, one of "+strings.Join(backendTypes(), ", "))
+		case !known:
+			v.validate(instance["type"], reflect.TypeOf(""), joinSetting(indexSetting(setting, i), "type"), "")
+			if backendType != "" {
+				v.add(joinSetting(indexSetting(setting, i), "type"), "unknown backend type "+backendType+suggest(backendType, backendTypes()))
+			}
+		default:
+			options := map[string]interface{}{}
+			for key, value := range instance {
+				if key != "name" && key != "type" {
+					options[key] = value
+				}
+			}
+			v.validateObject(options, backendFields(factory), indexSetting(setting, i), true)
+		}
+	}
+}
+
+func (v *validator) validateDuration(node interface{}, setting string) {
+	s, ok := node.(string)
+	if !ok {
+		v.add(setting, "expected a duration string, i.e. \"5m\" or \"30s\", got "+describe(node))
+		return
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		v.add(setting, "invalid duration "+strconv.Quote(s)+", expected i.e. \"5m\" or \"30s\"")
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// jsonFields returns the fields of the struct type t by the name json decodes them from, along with the fields of its
+// embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range jsonFields(field.Type) {
+				fields[embeddedName] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// backendFields returns the options of a type of backend, along with the name and type every backend has
+func backendFields(factory backends.Factory) map[string]reflect.StructField {
+	fields := jsonFields(reflect.TypeOf(factory.Options))
+	delete(fields, "name")
+	delete(fields, "type")
+	return fields
+}
+
+// backendTypes returns the registered types of backends, sorted
+func backendTypes() []string {
+	types := []string{}
+	for _, factory := range backends.Factories() {
+		types = append(types, factory.Type)
+	}
+	return types
+}
+
+func fieldNames(fields map[string]reflect.StructField) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isEmpty reports whether a value of the tree is null or empty, which is as good as missing
+func isEmpty(node interface{}) bool {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		return len(node) == 0
+	case []interface{}:
+		return len(node) == 0
+	case string:
+		return node == ""
+	}
+	return node == nil
+}
+
+// hasTag reports whether the config tag of a field, i.e. `config:"required,duration"`, has option
+func hasTag(tag string, option string) bool {
+	for _, o := range strings.Split(tag, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// describe names the json type of a value of the tree, i.e. "a number (5)"
+func describe(node interface{}) string {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string (" + strconv.Quote(node) + ")"
+	case json.Number:
+		return "a number (" + string(node) + ")"
+	case bool:
+		return strconv.FormatBool(node)
+	}
+	return "null"
+}
+
+// suggest returns the candidate closest to a misspelled name, or the candidates when none is close, to follow a message
+func suggest(name string, candidates []string) string {
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		distance := editDistance(normalizeName(name), normalizeName(candidate))
+		if len(name) >= 4 && strings.HasPrefix(normalizeName(candidate), normalizeName(name)) {
+			distance = 1 // i.e. "openweather" for "openweathermap"
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best != "" && bestDistance <= 2 && bestDistance < len(best) {
+		return ", did you mean " + best + "?"
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return ", expected one of " + strings.Join(candidates, ", ")
+}
+
+// normalizeName leaves out what typos of setting names tend to differ by, i.e. "api_key" for "apiKey"
+func normalizeName(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// joinSetting returns the path of the setting key of the object at setting, i.e. "cache.ttl"
+func joinSetting(setting string, key string) string {
+	if setting == "" {
+		return key
+	}
+	return setting + "." + key
+}
+
+// indexSetting returns the path of an item of the list at setting, i.e. "backends[0]"
+func indexSetting(setting string, i int) string {
+	return setting + "[" + strconv.Itoa(i) + "]"
+}
+
+// parentSetting returns the path of the object or list the setting is in, "" for the top level settings
+func parentSetting(setting string) string {
+	if i := strings.LastIndexAny(setting, ".["); i >= 0 {
+		return setting[:i]
+	}
+	return ""
+}
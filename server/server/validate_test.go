@@ -0,0 +1,179 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"valid.json": `{"backends": [{"type": "accuweather", "apiKey": "foo"}], "cache": {"ttl": "1m"}}`,
+		"config.json": `{
+  "backends": [
+    {"name": "eu", "type": "openweathermap", "apikey": "foo"},
+    {"name": "eu", "type": "accuweather", "apiKey": "bar"},
+    {"type": "openweather", "apiKey": "baz"},
+    {"type": "plugin", "command": ["./model"], "timeout": "5 seconds"}
+  ],
+  "cache": {"ttl": "1x"},
+  "batch": {"maxItems": "ten"},
+  "geoip": {"databseFile": "GeoLite2-City.mmdb"}
+}`,
+		"config.yaml": `backends:
+- name: eu
+  type: openweathermap
+  api_key: foo
+- type: federated
+cache:
+  ttl: 60
+http:
+  allowedOrigins:
+  - https://a.example.com
+  - 8080
+`,
+		"config.toml": `[[backends]]
+type = "accuweather"
+apiKey = "foo"
+
+[[backends]]
+type = "accuweather"
+apiKey = "bar"
+
+[stream]
+heartbeat = "soon"
+`,
+		"legacy.json": `{"backends": {"accuweather": {"apiKey": "foo"}, "openweathermap": {}, "darksky": {"apiKey": "bar"}}}`,
+		"bad.yaml":    "backends:\n  - type: accuweather\n apiKey: foo\n",
+		"bad.json":    "{\n  \"backends\": [}\n",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name             string
+		file             string
+		environ          []string
+		expectedProblems []string
+	}{
+		{
+			name:             "valid configuration has no problems",
+			file:             "valid.json",
+			expectedProblems: []string{},
+		},
+		{
+			name: "every problem of a json file is reported where it is",
+			file: "config.json",
+			expectedProblems: []string{
+				"config.json:3:5: backends[0]: missing required setting apiKey",
+				"config.json:3:46: backends[0].apikey: unknown setting, did you mean apiKey?",
+				"config.json:4:5: backends[1]: backend eu is already configured by backends[0]",
+				"config.json:5:6: backends[2].type: unknown backend type openweather, did you mean openweathermap?",
+				"config.json:6:48: backends[3].timeout: invalid duration \"5 seconds\", expected i.e. \"5m\" or \"30s\"",
+				"config.json:8:13: cache.ttl: invalid duration \"1x\", expected i.e. \"5m\" or \"30s\"",
+				"config.json:9:13: batch.maxItems: expected an integer, got a string (\"ten\")",
+				"config.json:10:13: geoip.databseFile: unknown setting, did you mean databaseFile?",
+			},
+		},
+		{
+			name: "every problem of a yaml file is reported where it is",
+			file: "config.yaml",
+			expectedProblems: []string{
+				"config.yaml:2:1: backends[0]: missing required setting apiKey",
+				"config.yaml:4:3: backends[0].api_key: unknown setting, did you mean apiKey?",
+				"config.yaml:5:1: backends[1]: missing required setting backends",
+				"config.yaml:5:1: backends[1]: missing required setting url",
+				"config.yaml:7:3: cache.ttl: expected a duration string, i.e. \"5m\" or \"30s\", got a number (60)",
+				"config.yaml:11:3: http.allowedOrigins[1]: expected a string, got a number (8080)",
+			},
+		},
+		{
+			name: "every problem of a toml file is reported where it is",
+			file: "config.toml",
+			expectedProblems: []string{
+				"config.toml:5:1: backends[1]: backend accuweather is already configured by backends[0]",
+				"config.toml:10:1: stream.heartbeat: invalid duration \"soon\", expected i.e. \"5m\" or \"30s\"",
+			},
+		},
+		{
+			name: "backends without an API key are left out of the object older versions used",
+			file: "legacy.json",
+			expectedProblems: []string{
+				"legacy.json:1:71: backends.darksky: unknown backend type, expected one of accuweather, federated, openweathermap, plugin",
+			},
+		},
+		{
+			name:    "settings of environment variables are checked too",
+			file:    "valid.json",
+			environ: []string{"WEATHER_BACKENDS_PLUGIN_TIMEOUT=5x"},
+			expectedProblems: []string{
+				"valid.json:1:2: backends[1]: missing required setting command",
+				"valid.json:1:2: backends[1].timeout: invalid duration \"5x\", expected i.e. \"5m\" or \"30s\"",
+			},
+		},
+		{
+			name:             "missing backends are reported",
+			environ:          []string{"WEATHER_CACHE_TTL=1m"},
+			expectedProblems: []string{"missing required setting backends"},
+		},
+		{
+			name:             "invalid yaml is reported where it is",
+			file:             "bad.yaml",
+			expectedProblems: []string{"bad.yaml:2: yaml: line 2: did not find expected key"},
+		},
+		{
+			name:             "invalid json is reported where it is",
+			file:             "bad.json",
+			expectedProblems: []string{"bad.json:2:16: invalid character '}' looking for beginning of value"},
+		},
+		{
+			name:             "invalid environment variables are reported",
+			file:             "valid.json",
+			environ:          []string{"WEATHER_CACHE_SIZE=10"},
+			expectedProblems: []string{"valid.json: Invalid environment variable WEATHER_CACHE_SIZE: unknown setting: SIZE"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(dir)) // so that the problems name the files the way they are given
+			defer os.Chdir(wd)
+
+			problems := []string{}
+			for _, problem := range ValidateConfig(tc.file, tc.environ) {
+				problems = append(problems, problem.String())
+			}
+			require.Equal(t, tc.expectedProblems, problems)
+		})
+	}
+}
+
+func Test_LoadConfigReportsEveryProblem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"backends": [{"type": "accuweather"}], "cache": {"tll": "1m"}}`), 0644))
+
+	_, err = LoadConfig(path, nil)
+	require.IsType(t, &ValidationError{}, err)
+	require.Len(t, err.(*ValidationError).Problems, 2)
+	require.EqualError(t, err, "Invalid configuration: "+path+":1:15: backends[0]: missing required setting apiKey; "+path+":1:51: cache.tll: unknown setting, did you mean ttl?")
+}
+
+func Test_suggest(t *testing.T) {
+	candidates := []string{"apiKey", "name", "type"}
+	require.Equal(t, ", did you mean apiKey?", suggest("api_key", candidates))
+	require.Equal(t, ", did you mean apiKey?", suggest("apikey", candidates))
+	require.Equal(t, ", did you mean type?", suggest("typ", candidates))
+	require.Equal(t, ", expected one of apiKey, name, type", suggest("region", candidates))
+	require.Equal(t, "", suggest("region", []string{}))
+}
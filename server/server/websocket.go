@@ -0,0 +1,347 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/ratelimit"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket message types, see WebSocketMessage
+const (
+	WebSocketSubscribe   = "subscribe"
+	WebSocketUnsubscribe = "unsubscribe"
+	WebSocketSnapshot    = "snapshot"
+	WebSocketUpdate      = "update"
+	WebSocketError       = "error"
+)
+
+// WebSocketMessage defines the json messages exchanged over /v1/ws. Clients send "subscribe" (with a city and optional
+// backends) and "unsubscribe" (with a city) messages. The server answers a subscription with a "snapshot" of the
+// current weather, then sends an "update" for every new reading, and reports problems with "error" messages that name
+// the city they are about, if any.
+type WebSocketMessage struct {
+	Type     string              `json:"type"`
+	City     string              `json:"city,omitempty"`     // as provided in the subscribe message
+	Backends []string            `json:"backends,omitempty"` // subscribe only, defaults to the default backends
+	ID       uint64              `json:"id,omitempty"`       // snapshot and update only, the same event IDs as /v1/weather/{city}/stream
+	Weather  *WeatherResponse    `json:"weather,omitempty"`  // snapshot only
+	Update   *WeatherUpdateEvent `json:"update,omitempty"`   // update only
+	Error    string              `json:"error,omitempty"`
+}
+
+// webSocketMaxMessageBytes is the largest message accepted from clients, subscribe messages are much smaller than this
+const webSocketMaxMessageBytes = 4096
+
+// webSocketWriteTimeout is how long a client has to accept a message before it is disconnected
+const webSocketWriteTimeout = 10 * time.Second
+
+func (s *Server) webSocketServer() websocket.Server {
+	return websocket.Server{Handshake: s.checkWebSocketOrigin, Handler: s.serveWebSocket}
+}
+
+// checkWebSocketOrigin keeps other sites from opening connections with our users' browsers. Non-browser clients don't
+// send an Origin and are let through.
+func (s *Server) checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	for _, allowed := range s.allowedOrigins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	return errors.New("Origin not allowed: " + origin)
+}
+
+// webSocketConnection holds the subscriptions of a single /v1/ws client. Messages are read and handled one at a time,
+// while every subscription forwards its updates from its own goroutine through out.
+type webSocketConnection struct {
+	s       *Server
+	ws      *websocket.Conn
+	limiter *ratelimit.Bucket
+	out     chan WebSocketMessage
+	done    chan struct{} // closed once the client is gone, to stop the subscriptions and the writer
+	// writerGone is closed once writeMessages returns, i.e. when the client doesn't accept messages anymore, so that
+	// nothing waits on out forever
+	writerGone chan struct{}
+
+	mu            sync.Mutex
+	subscriptions map[string]chan struct{} // closed to stop the subscription
+	wg            sync.WaitGroup
+}
+
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	if !s.trackWebSocket(ws) {
+		ws.Close()
+		return
+	}
+	defer s.untrackWebSocket(ws)
+	ws.MaxPayloadBytes = webSocketMaxMessageBytes
+	conn := &webSocketConnection{
+		s:             s,
+		ws:            ws,
+		limiter:       ratelimit.NewBucket(s.webSocketMessageRate, s.webSocketMessageBurst, s.now),
+		out:           make(chan WebSocketMessage, s.streamBufferSize),
+		done:          make(chan struct{}),
+		writerGone:    make(chan struct{}),
+		subscriptions: map[string]chan struct{}{},
+	}
+
+	go func() {
+		defer close(conn.writerGone)
+		conn.writeMessages()
+	}()
+
+	conn.readMessages()
+
+	conn.mu.Lock()
+	for city, stop := range conn.subscriptions {
+		close(stop)
+		delete(conn.subscriptions, city)
+	}
+	conn.mu.Unlock()
+	close(conn.done)
+	conn.wg.Wait()
+	<-conn.writerGone
+}
+
+// trackWebSocket adds a connection to the ones Close closes, it returns false once the server is closed
+func (s *Server) trackWebSocket(ws *websocket.Conn) bool {
+	s.webSocketsMu.Lock()
+	defer s.webSocketsMu.Unlock()
+	if s.webSocketsClosed {
+		return false
+	}
+	if s.webSockets == nil {
+		s.webSockets = map[*websocket.Conn]bool{}
+	}
+	s.webSockets[ws] = true
+	s.webSocketConnections.Add(1)
+	return true
+}
+
+func (s *Server) untrackWebSocket(ws *websocket.Conn) {
+	s.webSocketsMu.Lock()
+	delete(s.webSockets, ws)
+	s.webSocketsMu.Unlock()
+	s.webSocketConnections.Done()
+}
+
+// closeWebSockets closes the open connections and waits for their handlers to return
+func (s *Server) closeWebSockets() {
+	s.webSocketsMu.Lock()
+	s.webSocketsClosed = true
+	for ws := range s.webSockets {
+		ws.Close()
+	}
+	s.webSocketsMu.Unlock()
+	s.webSocketConnections.Wait()
+}
+
+func (conn *webSocketConnection) readMessages() {
+	for {
+		msg := WebSocketMessage{}
+		err := websocket.JSON.Receive(conn.ws, &msg)
+		switch err.(type) {
+		case nil, *json.SyntaxError, *json.UnmarshalTypeError:
+		default:
+			return // closed by the client, or too large to bother with
+		}
+
+		// messages that can't be parsed count too, or they would be a way around the limit
+		if !conn.limiter.Allow() {
+			if !conn.send(WebSocketMessage{Type: WebSocketError, City: msg.City, Error: "Too many messages, please slow down"}) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if !conn.send(WebSocketMessage{Type: WebSocketError, Error: "Unable to parse message: " + err.Error()}) {
+				return
+			}
+			continue
+		}
+
+		if errMsg := conn.handleMessage(msg); errMsg != "" {
+			if !conn.send(WebSocketMessage{Type: WebSocketError, City: msg.City, Error: errMsg}) {
+				return
+			}
+		}
+	}
+}
+
+func (conn *webSocketConnection) writeMessages() {
+	for {
+		select {
+		case <-conn.done:
+			return
+		case msg := <-conn.out:
+			conn.ws.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := websocket.JSON.Send(conn.ws, msg); err != nil {
+				// closing the connection stops readMessages, which cleans everything up
+				conn.ws.Close()
+				return
+			}
+		}
+	}
+}
+
+// send queues a message for the client, it returns false once the connection is closing
+func (conn *webSocketConnection) send(msg WebSocketMessage) bool {
+	select {
+	case conn.out <- msg:
+		return true
+	case <-conn.done:
+		return false
+	case <-conn.writerGone:
+		return false
+	}
+}
+
+// handleMessage handles a single client message, returning the error to report to the client if any
+func (conn *webSocketConnection) handleMessage(msg WebSocketMessage) string {
+	if msg.City == "" {
+		return "No city specified. Please provide a city."
+	}
+
+	switch msg.Type {
+	case WebSocketSubscribe:
+		targetBackends, err := conn.s.requestedBackends(conn.ws.Request().Context(), msg.Backends)
+		if err != nil {
+			return err.Error()
+		}
+
+		loc, err := conn.s.locationResolver.Resolve(msg.City)
+		if err != nil {
+			return err.Error()
+		}
+		// every subscription counts as a request, and so does every poll it makes later on
+		if err := conn.s.takeRequests(conn.ws.Request().Context(), 1); err != nil {
+			return err.Error()
+		}
+
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if stop, ok := conn.subscriptions[msg.City]; ok {
+			// subscribing again replaces the subscription, i.e. to change backends
+			close(stop)
+		} else if len(conn.subscriptions) >= conn.s.webSocketMaxSubscriptions {
+			return "Too many subscriptions, please unsubscribe from a city first"
+		}
+		stop := make(chan struct{})
+		conn.subscriptions[msg.City] = stop
+		conn.wg.Add(1)
+		go func() {
+			defer conn.wg.Done()
+			conn.follow(msg.City, loc, targetBackends, stop)
+		}()
+		return ""
+
+	case WebSocketUnsubscribe:
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		stop, ok := conn.subscriptions[msg.City]
+		if !ok {
+			return "Not subscribed to city: " + msg.City
+		}
+		close(stop)
+		delete(conn.subscriptions, msg.City)
+		return ""
+	}
+	return "Unknown message type: " + msg.Type
+}
+
+// follow sends a snapshot of the weather for the location, then every new reading, until stopped
+func (conn *webSocketConnection) follow(city string, loc location.Location, targetBackends []string, stop chan struct{}) {
+	subscription := conn.s.weatherUpdates.Subscribe(loc.Key(), conn.s.streamBufferSize, false, 0)
+	defer subscription.Close()
+
+	isTarget := map[string]bool{}
+	for _, backend := range targetBackends {
+		isTarget[backend] = true
+	}
+
+	response := &WeatherResponse{City: city}
+	conn.s.fetchWeather(response, loc, targetBackends)
+	// anything published up to now (including our own fetches) is already part of the snapshot
+	sentID := conn.s.weatherUpdates.LastID()
+	if !conn.sendUnlessStopped(WebSocketMessage{Type: WebSocketSnapshot, City: city, ID: sentID, Weather: response}, stop) {
+		return
+	}
+
+	poll := time.NewTicker(conn.s.streamPollInterval)
+	defer poll.Stop()
+	polling := make(chan struct{}, 1)
+	defer func() { polling <- struct{}{} }() // wait for the last poll, so it never outlives the subscription
+
+	for {
+		select {
+		case <-stop:
+			return
+		case update, ok := <-subscription.C:
+			if !ok {
+				conn.sendUnlessStopped(WebSocketMessage{Type: WebSocketError, City: city, Error: "Subscription dropped for falling behind, please subscribe again"}, stop)
+				return
+			}
+			if update.ID <= sentID || !isTarget[update.Weather.Source] {
+				continue
+			}
+			event := WeatherUpdateEvent{Location: update.Location, Data: update.Weather, Time: update.Time}
+			if !conn.sendUnlessStopped(WebSocketMessage{Type: WebSocketUpdate, City: city, ID: update.ID, Update: &event}, stop) {
+				return
+			}
+			sentID = update.ID
+		case <-poll.C:
+			if err := conn.s.takeRequests(conn.ws.Request().Context(), 1); err != nil {
+				conn.unsubscribe(city, stop)
+				conn.sendUnlessStopped(WebSocketMessage{Type: WebSocketError, City: city, Error: err.Error() + ", subscription dropped"}, stop)
+				return
+			}
+			// new readings are published by fetchWeather, skip this poll if the last one is still waiting on a backend
+			select {
+			case polling <- struct{}{}:
+				go func() {
+					defer func() { <-polling }()
+					conn.s.fetchWeather(&WeatherResponse{}, loc, targetBackends)
+				}()
+			default:
+			}
+		}
+	}
+}
+
+// unsubscribe drops a subscription that ended on its own, unless it was already replaced by a new one
+func (conn *webSocketConnection) unsubscribe(city string, stop chan struct{}) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.subscriptions[city] == stop {
+		delete(conn.subscriptions, city)
+	}
+}
+
+// sendUnlessStopped is send for subscriptions, which also give up once they are unsubscribed
+func (conn *webSocketConnection) sendUnlessStopped(msg WebSocketMessage, stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	select {
+	case conn.out <- msg:
+		return true
+	case <-stop:
+		return false
+	case <-conn.done:
+		return false
+	case <-conn.writerGone:
+		return false
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-weather-app/server/cache"
+	"go-weather-app/server/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// newWebSocketTestServer serves /v1/ws and /v1/weather/{city} of a test server with a single counting backend called
+// foo. Clients must be closed before calling the returned cleanup.
+func newWebSocketTestServer(t *testing.T) (*Server, *httptest.Server, func()) {
+	s := newTestServer(t)
+	s.setBackends(map[string]types.WeatherBackend{"foo": &countingWeatherBackend{}}, []string{"foo"})
+	s.weatherCache = cache.New(0, time.Now, s.metrics)
+	s.streamPollInterval = time.Hour
+
+	e := echo.New()
+	e.GET("/v1/weather/:city", s.getWeather)
+	e.GET("/v1/ws", echo.WrapHandler(s.webSocketServer()))
+	server := httptest.NewServer(e)
+	return s, server, func() {
+		server.Close()
+		s.closeWebSockets()
+	}
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, origin string) *websocket.Conn {
+	ws, err := websocket.Dial(strings.Replace(server.URL, "http://", "ws://", 1)+"/v1/ws", "", origin)
+	require.NoError(t, err)
+	return ws
+}
+
+func sendWebSocketMessage(t *testing.T, ws *websocket.Conn, msg interface{}) {
+	var err error
+	if raw, ok := msg.(string); ok {
+		err = websocket.Message.Send(ws, raw)
+	} else {
+		err = websocket.JSON.Send(ws, msg)
+	}
+	require.NoError(t, err)
+}
+
+func receiveWebSocketMessage(t *testing.T, ws *websocket.Conn) WebSocketMessage {
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+	msg := WebSocketMessage{}
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	return msg
+}
+
+func Test_serveWebSocket(t *testing.T) {
+	_, server, cleanup := newWebSocketTestServer(t)
+	defer cleanup()
+
+	ws := dialWebSocket(t, server, "http://localhost:3000")
+	defer ws.Close()
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "foo"})
+	msg := receiveWebSocketMessage(t, ws)
+	require.Equal(t, WebSocketSnapshot, msg.Type)
+	require.Equal(t, "foo", msg.City)
+	require.Equal(t, uint64(1), msg.ID)
+	require.Equal(t, []types.Weather{{Source: "foo", Temperature: 1}}, msg.Weather.Data)
+
+	// another client's request publishes a new reading
+	resp, err := http.Get(server.URL + "/v1/weather/foo")
+	require.NoError(t, err)
+	resp.Body.Close()
+	msg = receiveWebSocketMessage(t, ws)
+	require.Equal(t, WebSocketUpdate, msg.Type)
+	require.Equal(t, "foo", msg.City)
+	require.Equal(t, uint64(2), msg.ID)
+	require.Equal(t, types.Weather{Source: "foo", Temperature: 2}, msg.Update.Data)
+
+	tests := []struct {
+		name     string
+		msg      interface{}
+		expected WebSocketMessage
+	}{
+		{
+			name:     "invalid backend",
+			msg:      WebSocketMessage{Type: WebSocketSubscribe, City: "bar", Backends: []string{"baz"}},
+			expected: WebSocketMessage{Type: WebSocketError, City: "bar", Error: "Backend specified is invalid or inactive: baz"},
+		},
+		{
+			name:     "no city",
+			msg:      WebSocketMessage{Type: WebSocketSubscribe},
+			expected: WebSocketMessage{Type: WebSocketError, Error: "No city specified. Please provide a city."},
+		},
+		{
+			name:     "unsubscribe from a city that isn't subscribed to",
+			msg:      WebSocketMessage{Type: WebSocketUnsubscribe, City: "bar"},
+			expected: WebSocketMessage{Type: WebSocketError, City: "bar", Error: "Not subscribed to city: bar"},
+		},
+		{
+			name:     "unknown message type",
+			msg:      WebSocketMessage{Type: "publish", City: "bar"},
+			expected: WebSocketMessage{Type: WebSocketError, City: "bar", Error: "Unknown message type: publish"},
+		},
+		{
+			name:     "invalid json",
+			msg:      "{",
+			expected: WebSocketMessage{Type: WebSocketError, Error: "Unable to parse message: unexpected end of JSON input"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sendWebSocketMessage(t, ws, tc.msg)
+			require.Equal(t, tc.expected, receiveWebSocketMessage(t, ws))
+		})
+	}
+
+	// once unsubscribed, new readings for foo aren't sent anymore: the next message is bar's snapshot
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketUnsubscribe, City: "foo"})
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketUnsubscribe, City: "foo"})
+	require.Equal(t, "Not subscribed to city: foo", receiveWebSocketMessage(t, ws).Error)
+	resp, err = http.Get(server.URL + "/v1/weather/foo")
+	require.NoError(t, err)
+	resp.Body.Close()
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "bar"})
+	msg = receiveWebSocketMessage(t, ws)
+	require.Equal(t, WebSocketSnapshot, msg.Type)
+	require.Equal(t, "bar", msg.City)
+}
+
+func Test_serveWebSocketLimits(t *testing.T) {
+	s, server, cleanup := newWebSocketTestServer(t)
+	defer cleanup()
+
+	s.webSocketMessageRate, s.webSocketMessageBurst, s.webSocketMaxSubscriptions = 0.001, 2, 1
+
+	ws := dialWebSocket(t, server, "http://localhost")
+	defer ws.Close()
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "foo"})
+	require.Equal(t, WebSocketSnapshot, receiveWebSocketMessage(t, ws).Type)
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "bar"})
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, City: "bar", Error: "Too many subscriptions, please unsubscribe from a city first"}, receiveWebSocketMessage(t, ws))
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketUnsubscribe, City: "foo"})
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, City: "foo", Error: "Too many messages, please slow down"}, receiveWebSocketMessage(t, ws))
+
+	// messages that can't be parsed are limited too
+	require.NoError(t, websocket.Message.Send(ws, "{"))
+	require.Equal(t, WebSocketMessage{Type: WebSocketError, Error: "Too many messages, please slow down"}, receiveWebSocketMessage(t, ws))
+}
+
+func Test_serveWebSocketPanickingBackend(t *testing.T) {
+	s, server, cleanup := newWebSocketTestServer(t)
+	defer cleanup()
+	s.setBackends(map[string]types.WeatherBackend{"foo": &countingWeatherBackend{}, "bar": mockPanickingBackend{}}, []string{"foo"})
+	s.streamPollInterval = 5 * time.Millisecond
+
+	ws := dialWebSocket(t, server, "http://localhost")
+	defer ws.Close()
+
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "ottawa", Backends: []string{"bar"}})
+	msg := receiveWebSocketMessage(t, ws)
+	require.Equal(t, WebSocketSnapshot, msg.Type)
+	require.Equal(t, []types.Weather{{Error: "Backend failed unexpectedly: bar", ErrorCode: types.ErrorUnknown}}, msg.Weather.Data)
+
+	// the polls of the subscription panic too, but the connection (and the server) go on
+	time.Sleep(50 * time.Millisecond)
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "foo"})
+	msg = receiveWebSocketMessage(t, ws)
+	require.Equal(t, WebSocketSnapshot, msg.Type)
+	require.Equal(t, "foo", msg.City)
+}
+
+func Test_webSocketConnectionSend(t *testing.T) {
+	conn := &webSocketConnection{out: make(chan WebSocketMessage), done: make(chan struct{}), writerGone: make(chan struct{})}
+	close(conn.writerGone)
+
+	// once the client stopped accepting messages, queuing more gives up rather than waiting on out forever
+	require.False(t, conn.send(WebSocketMessage{Type: WebSocketError}))
+	require.False(t, conn.sendUnlessStopped(WebSocketMessage{Type: WebSocketUpdate}, make(chan struct{})))
+}
+
+func Test_closeWebSockets(t *testing.T) {
+	s, server, cleanup := newWebSocketTestServer(t)
+	defer cleanup()
+
+	ws := dialWebSocket(t, server, "http://localhost")
+	defer ws.Close()
+	sendWebSocketMessage(t, ws, WebSocketMessage{Type: WebSocketSubscribe, City: "foo"})
+	require.Equal(t, WebSocketSnapshot, receiveWebSocketMessage(t, ws).Type)
+
+	s.closeWebSockets()
+	msg := WebSocketMessage{}
+	require.Error(t, websocket.JSON.Receive(ws, &msg))
+
+	// connections made once the server is closed are closed straight away
+	late := dialWebSocket(t, server, "http://localhost")
+	defer late.Close()
+	require.Error(t, websocket.JSON.Receive(late, &msg))
+}
+
+func Test_checkWebSocketOrigin(t *testing.T) {
+	s, server, cleanup := newWebSocketTestServer(t)
+	defer cleanup()
+
+	_, err := websocket.Dial(strings.Replace(server.URL, "http://", "ws://", 1)+"/v1/ws", "", "http://evil.example")
+	require.Error(t, err)
+
+	// non-browser clients don't send an origin at all
+	req := httptest.NewRequest(http.MethodGet, "/v1/ws", nil)
+	require.NoError(t, s.checkWebSocketOrigin(nil, req))
+	req.Header.Set("Origin", "http://localhost:3000")
+	require.NoError(t, s.checkWebSocketOrigin(nil, req))
+	req.Header.Set("Origin", "http://evil.example")
+	require.EqualError(t, s.checkWebSocketOrigin(nil, req), "Origin not allowed: http://evil.example")
+}
@@ -0,0 +1,52 @@
+package types
+
+// Error codes of Weather and Forecast, the machine-readable reason a backend failed
+const (
+	ErrorUnavailable      = "backend_unavailable"
+	ErrorBadResponse      = "backend_bad_response"
+	ErrorLocationNotFound = "backend_location_not_found"
+	ErrorNotSupported     = "not_supported"
+	ErrorUnknown          = "backend_error"
+)
+
+// BackendError is an error of a backend along with its error code
+type BackendError struct {
+	Code    string
+	Message string
+}
+
+func (e *BackendError) Error() string {
+	return e.Message
+}
+
+// The errors backends commonly fail with
+var (
+	ErrUnavailable           = &BackendError{Code: ErrorUnavailable, Message: "Error communicating to backend"}
+	ErrBadResponse           = &BackendError{Code: ErrorBadResponse, Message: "Unable to decode response from backend"}
+	ErrCityNotFound          = &BackendError{Code: ErrorLocationNotFound, Message: "Unable to determine location for provided city"}
+	ErrCoordinatesNotFound   = &BackendError{Code: ErrorLocationNotFound, Message: "Unable to determine location for provided coordinates"}
+	ErrForecastsNotSupported = &BackendError{Code: ErrorNotSupported, Message: "Forecasts are not supported by this backend"}
+)
+
+// NewBackendError creates an error with the provided code
+func NewBackendError(code string, message string) error {
+	return &BackendError{Code: code, Message: message}
+}
+
+// ErrorCode returns the code of a BackendError, ErrorUnknown for other errors
+func ErrorCode(err error) string {
+	if backendErr, ok := err.(*BackendError); ok && backendErr.Code != "" {
+		return backendErr.Code
+	}
+	return ErrorUnknown
+}
+
+// WeatherError is the Weather of a backend that failed with err
+func WeatherError(err error) Weather {
+	return Weather{Error: err.Error(), ErrorCode: ErrorCode(err)}
+}
+
+// ForecastError is the Forecast of a backend that failed with err
+func ForecastError(err error) Forecast {
+	return Forecast{Error: err.Error(), ErrorCode: ErrorCode(err)}
+}
@@ -1,28 +1,63 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"go-weather-app/server/location"
+)
 
 // Weather defines the structure of a weather response
 type Weather struct {
-	Source              string  `json:"source"`
-	Temperature         float32 `json:"temperature"`
-	TemperatureMin      float32 `json:"temperature_min"`
-	TemperatureMax      float32 `json:"temperature_max"`
-	MainDescription     string  `json:"main_description,omitempty"`
-	DetailedDescription string  `json:"detailed_description,omitempty"`
-	Error               string  `json:"error,omitempty"` // this is used to give an error if the target backend returned an error
+	Source              string            `json:"source" xml:"source"`
+	Temperature         float32           `json:"temperature" xml:"temperature"`
+	TemperatureMin      float32           `json:"temperature_min" xml:"temperature_min"`
+	TemperatureMax      float32           `json:"temperature_max" xml:"temperature_max"`
+	MainDescription     string            `json:"main_description,omitempty" xml:"main_description,omitempty"`
+	DetailedDescription string            `json:"detailed_description,omitempty" xml:"detailed_description,omitempty"`
+	Location            *ResolvedLocation `json:"location,omitempty" xml:"location,omitempty"`     // the location the backend actually returned weather for
+	Error               string            `json:"error,omitempty" xml:"error,omitempty"`           // this is used to give an error if the target backend returned an error
+	ErrorCode           string            `json:"error_code,omitempty" xml:"error_code,omitempty"` // why the backend failed, see ErrorCode
+}
+
+// ResolvedLocation defines the location a backend resolved a request to, along with the backend's own ID for it
+type ResolvedLocation struct {
+	location.Location
+	ProviderLocationID string `json:"provider_location_id,omitempty" xml:"provider_location_id,omitempty"`
 }
 
 // WeatherBackend describes the interface for getting weather
 type WeatherBackend interface {
-	GetWeather(city string) Weather
+	GetWeather(loc location.Location) Weather
 }
 
-// ACCUWEATHER defines the key for refering to the accuweather backend
-const ACCUWEATHER = "accuweather"
+// RelayBackend describes backends that get their weather from another server of this app, see api.HeaderHops
+type RelayBackend interface {
+	WeatherBackend
+	RelaysTo() string // the URL of the other server
+}
 
-// OPENWEATHERMAP defines the key for refering to the openweathermap backend
-const OPENWEATHERMAP = "openweathermap"
+// Forecast defines the structure of a daily forecast response
+type Forecast struct {
+	Source    string            `json:"source" xml:"source"`
+	Days      []ForecastDay     `json:"days,omitempty" xml:"days>day,omitempty"`
+	Location  *ResolvedLocation `json:"location,omitempty" xml:"location,omitempty"`     // the location the backend actually returned the forecast for
+	Error     string            `json:"error,omitempty" xml:"error,omitempty"`           // this is used to give an error if the target backend returned an error
+	ErrorCode string            `json:"error_code,omitempty" xml:"error_code,omitempty"` // why the backend failed, see ErrorCode
+}
+
+// ForecastDay defines the forecast for a single day, in the location's local time
+type ForecastDay struct {
+	Date                string  `json:"date" xml:"date"` // i.e. "2019-06-01"
+	TemperatureMin      float32 `json:"temperature_min" xml:"temperature_min"`
+	TemperatureMax      float32 `json:"temperature_max" xml:"temperature_max"`
+	MainDescription     string  `json:"main_description,omitempty" xml:"main_description,omitempty"`
+	DetailedDescription string  `json:"detailed_description,omitempty" xml:"detailed_description,omitempty"`
+}
+
+// ForecastBackend describes the interface for backends that can also forecast the weather
+type ForecastBackend interface {
+	GetForecast(loc location.Location) Forecast
+}
 
 // WeatherSchema is an example schema for what it might look like to store this data in a relational db
 type WeatherSchema struct {
@@ -0,0 +1,200 @@
+package updates
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/metrics"
+	"go-weather-app/server/types"
+)
+
+// Update defines a new reading fetched from a backend for a location
+type Update struct {
+	ID       uint64 // increases with every update published to the hub, across all topics
+	Topic    string // the location's key, see location.Location.Key
+	Location location.Location
+	Weather  types.Weather
+	Time     time.Time
+}
+
+// DefaultHistorySize is the number of updates kept per topic for subscribers resuming after a disconnect
+const DefaultHistorySize = 32
+
+// maxIdleTopics is the number of topics without subscribers that keep their history around for resuming clients
+const maxIdleTopics = 1024
+
+// Hub fans out new readings to everyone subscribed to the same location. Publishing never blocks: subscribers that
+// fall behind by more than their buffer are dropped and are expected to reconnect, resuming from the last update they saw.
+type Hub struct {
+	historySize int
+	now         func() time.Time
+	metrics     *metrics.Metrics
+
+	mu     sync.Mutex
+	lastID uint64
+	topics map[string]*topic
+}
+
+type topic struct {
+	history     []Update
+	trimmedID   uint64 // updates up to this ID are not in history, either trimmed or published before the topic was created
+	lastID      uint64 // the ID of the last update published to the topic
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the updates published for a single topic
+type Subscription struct {
+	// C receives the updates, it is closed when the subscription is closed or dropped for falling behind
+	C <-chan Update
+	// Missed are the updates published after the ID resumed from that are still in the topic's history
+	Missed []Update
+	// Resumed is set when Missed holds every update published for the topic since the ID resumed from
+	Resumed bool
+
+	c       chan Update
+	hub     *Hub
+	topic   string
+	dropped bool
+	closed  bool
+}
+
+// NewHub creates a hub keeping the last historySize updates of every topic, timed by now
+func NewHub(historySize int, now func() time.Time, m *metrics.Metrics) *Hub {
+	return &Hub{
+		historySize: historySize,
+		now:         now,
+		metrics:     m,
+		topics:      map[string]*topic{},
+	}
+}
+
+// Publish sends a reading to every subscriber of the location
+func (h *Hub) Publish(loc location.Location, weather types.Weather) Update {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(loc.Key())
+	h.lastID++
+	u := Update{ID: h.lastID, Topic: loc.Key(), Location: loc, Weather: weather, Time: h.now()}
+	t.history = append(t.history, u)
+	if len(t.history) > h.historySize {
+		trimmed := len(t.history) - h.historySize
+		t.trimmedID = t.history[trimmed-1].ID
+		t.history = append([]Update{}, t.history[trimmed:]...)
+	}
+	t.lastID = u.ID
+
+	for s := range t.subscribers {
+		select {
+		case s.c <- u:
+		default:
+			// never let a slow client hold up the fetch path
+			s.dropped = true
+			h.remove(s)
+			h.metrics.StreamSubscribersDroppedTotal.Inc()
+		}
+	}
+	h.pruneIdle()
+	return u
+}
+
+// Subscribe starts receiving the updates for topic, buffering up to bufferSize of them. When resume is set, the updates
+// published after lastEventID are returned in Missed so that reconnecting subscribers don't miss anything.
+func (h *Hub) Subscribe(topicKey string, bufferSize int, resume bool, lastEventID uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(topicKey)
+	c := make(chan Update, bufferSize)
+	s := &Subscription{C: c, c: c, hub: h, topic: topicKey}
+	t.subscribers[s] = struct{}{}
+	h.metrics.StreamSubscribers.Inc()
+
+	if !resume {
+		return s
+	}
+	for _, u := range t.history {
+		if u.ID > lastEventID {
+			s.Missed = append(s.Missed, u)
+		}
+	}
+	// IDs from before a restart (or from topics we have since forgotten) can't be resumed from
+	s.Resumed = lastEventID >= t.trimmedID && lastEventID <= h.lastID
+	return s
+}
+
+// Close stops the subscription, closing C
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Dropped reports whether the subscription was closed for falling too far behind
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// LastID returns the ID of the last update published to the hub, for any topic
+func (h *Hub) LastID() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastID
+}
+
+// Subscribers returns the number of subscribers to topic
+func (h *Hub) Subscribers(topicKey string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.topics[topicKey]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// topic returns the topic for key, creating it when needed; h.mu must be held
+func (h *Hub) topic(key string) *topic {
+	t, ok := h.topics[key]
+	if !ok {
+		t = &topic{trimmedID: h.lastID, subscribers: map[*Subscription]struct{}{}}
+		h.topics[key] = t
+	}
+	return t
+}
+
+// remove unsubscribes s; h.mu must be held
+func (h *Hub) remove(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.c)
+	delete(h.topics[s.topic].subscribers, s)
+	h.metrics.StreamSubscribers.Dec()
+}
+
+// pruneIdle forgets the history of the least recently updated topics nobody is subscribed to; h.mu must be held
+func (h *Hub) pruneIdle() {
+	if len(h.topics) <= maxIdleTopics {
+		return
+	}
+	idle := []string{}
+	for key, t := range h.topics {
+		if len(t.subscribers) == 0 {
+			idle = append(idle, key)
+		}
+	}
+	if len(idle) <= maxIdleTopics {
+		return
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return h.topics[idle[i]].lastID < h.topics[idle[j]].lastID
+	})
+	for _, key := range idle[:len(idle)-maxIdleTopics] {
+		delete(h.topics, key)
+	}
+}
@@ -0,0 +1,155 @@
+package updates
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go-weather-app/server/location"
+	"go-weather-app/server/metrics"
+	"go-weather-app/server/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub(DefaultHistorySize, time.Now, metrics.NewUnregistered())
+	foo := location.Location{Name: "foo"}
+	bar := location.Location{Name: "bar"}
+
+	subscription := hub.Subscribe(foo.Key(), 2, false, 0)
+	require.Equal(t, 1, hub.Subscribers(foo.Key()))
+
+	hub.Publish(foo, types.Weather{Source: "a"})
+	hub.Publish(bar, types.Weather{Source: "a"})
+	hub.Publish(foo, types.Weather{Source: "b"})
+
+	update := <-subscription.C
+	require.Equal(t, uint64(1), update.ID)
+	require.Equal(t, foo, update.Location)
+	require.Equal(t, "a", update.Weather.Source)
+	update = <-subscription.C
+	require.Equal(t, uint64(3), update.ID)
+	require.Equal(t, "b", update.Weather.Source)
+
+	subscription.Close()
+	subscription.Close() // closing twice is fine
+	_, ok := <-subscription.C
+	require.False(t, ok)
+	require.False(t, subscription.Dropped())
+	require.Equal(t, 0, hub.Subscribers(foo.Key()))
+}
+
+func TestHub_PublishDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub(DefaultHistorySize, time.Now, metrics.NewUnregistered())
+	foo := location.Location{Name: "foo"}
+
+	slow := hub.Subscribe(foo.Key(), 1, false, 0)
+	fast := hub.Subscribe(foo.Key(), 1, false, 0)
+
+	hub.Publish(foo, types.Weather{Source: "a"})
+	<-fast.C
+	hub.Publish(foo, types.Weather{Source: "b"})
+
+	// the slow subscriber still gets what was buffered, then its channel is closed
+	update, ok := <-slow.C
+	require.True(t, ok)
+	require.Equal(t, uint64(1), update.ID)
+	_, ok = <-slow.C
+	require.False(t, ok)
+	require.True(t, slow.Dropped())
+
+	update = <-fast.C
+	require.Equal(t, uint64(2), update.ID)
+	require.False(t, fast.Dropped())
+	require.Equal(t, 1, hub.Subscribers(foo.Key()))
+}
+
+func TestHub_SubscribeResume(t *testing.T) {
+	hub := NewHub(2, time.Now, metrics.NewUnregistered())
+	foo := location.Location{Name: "foo"}
+	bar := location.Location{Name: "bar"}
+	hub.Publish(bar, types.Weather{Source: "a"}) // 1, published before foo's topic existed
+	hub.Publish(foo, types.Weather{Source: "a"}) // 2, trimmed from history
+	hub.Publish(foo, types.Weather{Source: "b"}) // 3
+	hub.Publish(bar, types.Weather{Source: "b"}) // 4
+	hub.Publish(foo, types.Weather{Source: "c"}) // 5
+
+	tests := []struct {
+		name            string
+		resume          bool
+		lastEventID     uint64
+		expectedMissed  []uint64
+		expectedResumed bool
+	}{
+		{
+			name:            "not resuming",
+			expectedResumed: false,
+		},
+		{
+			name:            "resume from an update still in history",
+			resume:          true,
+			lastEventID:     3,
+			expectedMissed:  []uint64{5},
+			expectedResumed: true,
+		},
+		{
+			name:            "resume from an update for another topic",
+			resume:          true,
+			lastEventID:     2,
+			expectedMissed:  []uint64{3, 5},
+			expectedResumed: true,
+		},
+		{
+			name:            "resume from before the history",
+			resume:          true,
+			lastEventID:     1,
+			expectedMissed:  []uint64{3, 5},
+			expectedResumed: false,
+		},
+		{
+			name:            "resume from the latest update",
+			resume:          true,
+			lastEventID:     5,
+			expectedResumed: true,
+		},
+		{
+			name:            "resume from an unknown update, i.e. before a restart",
+			resume:          true,
+			lastEventID:     42,
+			expectedResumed: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			subscription := hub.Subscribe(foo.Key(), 1, tc.resume, tc.lastEventID)
+			defer subscription.Close()
+
+			missed := []uint64{}
+			for _, update := range subscription.Missed {
+				missed = append(missed, update.ID)
+			}
+			if tc.expectedMissed == nil {
+				tc.expectedMissed = []uint64{}
+			}
+			require.Equal(t, tc.expectedMissed, missed)
+			require.Equal(t, tc.expectedResumed, subscription.Resumed)
+			require.Equal(t, uint64(5), hub.LastID())
+		})
+	}
+}
+
+func TestHub_pruneIdle(t *testing.T) {
+	hub := NewHub(1, time.Now, metrics.NewUnregistered())
+	subscribed := location.Location{Name: "subscribed"}
+	subscription := hub.Subscribe(subscribed.Key(), 1, false, 0)
+	defer subscription.Close()
+
+	for i := 0; i < maxIdleTopics+10; i++ {
+		hub.Publish(location.Location{Name: fmt.Sprint("city", i)}, types.Weather{})
+	}
+	require.Len(t, hub.topics, maxIdleTopics+1)
+	require.Contains(t, hub.topics, subscribed.Key())
+	require.NotContains(t, hub.topics, location.Location{Name: "city0"}.Key())
+	require.Contains(t, hub.topics, location.Location{Name: fmt.Sprint("city", maxIdleTopics+9)}.Key())
+}
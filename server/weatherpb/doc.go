@@ -0,0 +1,4 @@
+// Package weatherpb holds the protobuf messages and service of the gRPC API, generated from weather.proto
+package weatherpb
+
+//go:generate protoc --go_out=plugins=grpc:. weather.proto
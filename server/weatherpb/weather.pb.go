@@ -0,0 +1,1249 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: weather.proto
+
+package weatherpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	grpc "google.golang.org/grpc"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Coordinates struct {
+	Latitude             float64  `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude            float64  `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Coordinates) Reset()         { *m = Coordinates{} }
+func (m *Coordinates) String() string { return proto.CompactTextString(m) }
+func (*Coordinates) ProtoMessage()    {}
+func (*Coordinates) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{0}
+}
+
+func (m *Coordinates) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Coordinates.Unmarshal(m, b)
+}
+func (m *Coordinates) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Coordinates.Marshal(b, m, deterministic)
+}
+func (m *Coordinates) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Coordinates.Merge(m, src)
+}
+func (m *Coordinates) XXX_Size() int {
+	return xxx_messageInfo_Coordinates.Size(m)
+}
+func (m *Coordinates) XXX_DiscardUnknown() {
+	xxx_messageInfo_Coordinates.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Coordinates proto.InternalMessageInfo
+
+func (m *Coordinates) GetLatitude() float64 {
+	if m != nil {
+		return m.Latitude
+	}
+	return 0
+}
+
+func (m *Coordinates) GetLongitude() float64 {
+	if m != nil {
+		return m.Longitude
+	}
+	return 0
+}
+
+type Location struct {
+	Name                 string       `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	AdminRegion          string       `protobuf:"bytes,2,opt,name=admin_region,json=adminRegion,proto3" json:"admin_region,omitempty"`
+	Country              string       `protobuf:"bytes,3,opt,name=country,proto3" json:"country,omitempty"`
+	PostalCode           string       `protobuf:"bytes,4,opt,name=postal_code,json=postalCode,proto3" json:"postal_code,omitempty"`
+	Coordinates          *Coordinates `protobuf:"bytes,5,opt,name=coordinates,proto3" json:"coordinates,omitempty"`
+	Timezone             string       `protobuf:"bytes,6,opt,name=timezone,proto3" json:"timezone,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *Location) Reset()         { *m = Location{} }
+func (m *Location) String() string { return proto.CompactTextString(m) }
+func (*Location) ProtoMessage()    {}
+func (*Location) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{1}
+}
+
+func (m *Location) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Location.Unmarshal(m, b)
+}
+func (m *Location) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Location.Marshal(b, m, deterministic)
+}
+func (m *Location) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Location.Merge(m, src)
+}
+func (m *Location) XXX_Size() int {
+	return xxx_messageInfo_Location.Size(m)
+}
+func (m *Location) XXX_DiscardUnknown() {
+	xxx_messageInfo_Location.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Location proto.InternalMessageInfo
+
+func (m *Location) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Location) GetAdminRegion() string {
+	if m != nil {
+		return m.AdminRegion
+	}
+	return ""
+}
+
+func (m *Location) GetCountry() string {
+	if m != nil {
+		return m.Country
+	}
+	return ""
+}
+
+func (m *Location) GetPostalCode() string {
+	if m != nil {
+		return m.PostalCode
+	}
+	return ""
+}
+
+func (m *Location) GetCoordinates() *Coordinates {
+	if m != nil {
+		return m.Coordinates
+	}
+	return nil
+}
+
+func (m *Location) GetTimezone() string {
+	if m != nil {
+		return m.Timezone
+	}
+	return ""
+}
+
+// ResolvedLocation is the location a backend actually returned data for
+type ResolvedLocation struct {
+	Location             *Location `protobuf:"bytes,1,opt,name=location,proto3" json:"location,omitempty"`
+	ProviderLocationId   string    `protobuf:"bytes,2,opt,name=provider_location_id,json=providerLocationId,proto3" json:"provider_location_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ResolvedLocation) Reset()         { *m = ResolvedLocation{} }
+func (m *ResolvedLocation) String() string { return proto.CompactTextString(m) }
+func (*ResolvedLocation) ProtoMessage()    {}
+func (*ResolvedLocation) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{2}
+}
+
+func (m *ResolvedLocation) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolvedLocation.Unmarshal(m, b)
+}
+func (m *ResolvedLocation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolvedLocation.Marshal(b, m, deterministic)
+}
+func (m *ResolvedLocation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolvedLocation.Merge(m, src)
+}
+func (m *ResolvedLocation) XXX_Size() int {
+	return xxx_messageInfo_ResolvedLocation.Size(m)
+}
+func (m *ResolvedLocation) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolvedLocation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolvedLocation proto.InternalMessageInfo
+
+func (m *ResolvedLocation) GetLocation() *Location {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *ResolvedLocation) GetProviderLocationId() string {
+	if m != nil {
+		return m.ProviderLocationId
+	}
+	return ""
+}
+
+type Weather struct {
+	Source               string            `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Temperature          float32           `protobuf:"fixed32,2,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	TemperatureMin       float32           `protobuf:"fixed32,3,opt,name=temperature_min,json=temperatureMin,proto3" json:"temperature_min,omitempty"`
+	TemperatureMax       float32           `protobuf:"fixed32,4,opt,name=temperature_max,json=temperatureMax,proto3" json:"temperature_max,omitempty"`
+	MainDescription      string            `protobuf:"bytes,5,opt,name=main_description,json=mainDescription,proto3" json:"main_description,omitempty"`
+	DetailedDescription  string            `protobuf:"bytes,6,opt,name=detailed_description,json=detailedDescription,proto3" json:"detailed_description,omitempty"`
+	Location             *ResolvedLocation `protobuf:"bytes,7,opt,name=location,proto3" json:"location,omitempty"`
+	Error                string            `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Weather) Reset()         { *m = Weather{} }
+func (m *Weather) String() string { return proto.CompactTextString(m) }
+func (*Weather) ProtoMessage()    {}
+func (*Weather) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{3}
+}
+
+func (m *Weather) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Weather.Unmarshal(m, b)
+}
+func (m *Weather) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Weather.Marshal(b, m, deterministic)
+}
+func (m *Weather) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Weather.Merge(m, src)
+}
+func (m *Weather) XXX_Size() int {
+	return xxx_messageInfo_Weather.Size(m)
+}
+func (m *Weather) XXX_DiscardUnknown() {
+	xxx_messageInfo_Weather.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Weather proto.InternalMessageInfo
+
+func (m *Weather) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *Weather) GetTemperature() float32 {
+	if m != nil {
+		return m.Temperature
+	}
+	return 0
+}
+
+func (m *Weather) GetTemperatureMin() float32 {
+	if m != nil {
+		return m.TemperatureMin
+	}
+	return 0
+}
+
+func (m *Weather) GetTemperatureMax() float32 {
+	if m != nil {
+		return m.TemperatureMax
+	}
+	return 0
+}
+
+func (m *Weather) GetMainDescription() string {
+	if m != nil {
+		return m.MainDescription
+	}
+	return ""
+}
+
+func (m *Weather) GetDetailedDescription() string {
+	if m != nil {
+		return m.DetailedDescription
+	}
+	return ""
+}
+
+func (m *Weather) GetLocation() *ResolvedLocation {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *Weather) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type LocationMismatch struct {
+	Sources              []string `protobuf:"bytes,1,rep,name=sources,proto3" json:"sources,omitempty"`
+	DistanceKm           float64  `protobuf:"fixed64,2,opt,name=distance_km,json=distanceKm,proto3" json:"distance_km,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LocationMismatch) Reset()         { *m = LocationMismatch{} }
+func (m *LocationMismatch) String() string { return proto.CompactTextString(m) }
+func (*LocationMismatch) ProtoMessage()    {}
+func (*LocationMismatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{4}
+}
+
+func (m *LocationMismatch) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LocationMismatch.Unmarshal(m, b)
+}
+func (m *LocationMismatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LocationMismatch.Marshal(b, m, deterministic)
+}
+func (m *LocationMismatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LocationMismatch.Merge(m, src)
+}
+func (m *LocationMismatch) XXX_Size() int {
+	return xxx_messageInfo_LocationMismatch.Size(m)
+}
+func (m *LocationMismatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_LocationMismatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LocationMismatch proto.InternalMessageInfo
+
+func (m *LocationMismatch) GetSources() []string {
+	if m != nil {
+		return m.Sources
+	}
+	return nil
+}
+
+func (m *LocationMismatch) GetDistanceKm() float64 {
+	if m != nil {
+		return m.DistanceKm
+	}
+	return 0
+}
+
+type GetWeatherRequest struct {
+	City string `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	// defaults to all the default backends
+	Backends             []string `protobuf:"bytes,2,rep,name=backends,proto3" json:"backends,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetWeatherRequest) Reset()         { *m = GetWeatherRequest{} }
+func (m *GetWeatherRequest) String() string { return proto.CompactTextString(m) }
+func (*GetWeatherRequest) ProtoMessage()    {}
+func (*GetWeatherRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{5}
+}
+
+func (m *GetWeatherRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetWeatherRequest.Unmarshal(m, b)
+}
+func (m *GetWeatherRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetWeatherRequest.Marshal(b, m, deterministic)
+}
+func (m *GetWeatherRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetWeatherRequest.Merge(m, src)
+}
+func (m *GetWeatherRequest) XXX_Size() int {
+	return xxx_messageInfo_GetWeatherRequest.Size(m)
+}
+func (m *GetWeatherRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetWeatherRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetWeatherRequest proto.InternalMessageInfo
+
+func (m *GetWeatherRequest) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *GetWeatherRequest) GetBackends() []string {
+	if m != nil {
+		return m.Backends
+	}
+	return nil
+}
+
+type GetWeatherResponse struct {
+	City                 string            `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	Location             *Location         `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
+	Data                 []*Weather        `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty"`
+	LocationMismatch     *LocationMismatch `protobuf:"bytes,4,opt,name=location_mismatch,json=locationMismatch,proto3" json:"location_mismatch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *GetWeatherResponse) Reset()         { *m = GetWeatherResponse{} }
+func (m *GetWeatherResponse) String() string { return proto.CompactTextString(m) }
+func (*GetWeatherResponse) ProtoMessage()    {}
+func (*GetWeatherResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{6}
+}
+
+func (m *GetWeatherResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetWeatherResponse.Unmarshal(m, b)
+}
+func (m *GetWeatherResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetWeatherResponse.Marshal(b, m, deterministic)
+}
+func (m *GetWeatherResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetWeatherResponse.Merge(m, src)
+}
+func (m *GetWeatherResponse) XXX_Size() int {
+	return xxx_messageInfo_GetWeatherResponse.Size(m)
+}
+func (m *GetWeatherResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetWeatherResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetWeatherResponse proto.InternalMessageInfo
+
+func (m *GetWeatherResponse) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *GetWeatherResponse) GetLocation() *Location {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *GetWeatherResponse) GetData() []*Weather {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *GetWeatherResponse) GetLocationMismatch() *LocationMismatch {
+	if m != nil {
+		return m.LocationMismatch
+	}
+	return nil
+}
+
+type ForecastDay struct {
+	// i.e. "2019-06-01", in the location's local time
+	Date                 string   `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"`
+	TemperatureMin       float32  `protobuf:"fixed32,2,opt,name=temperature_min,json=temperatureMin,proto3" json:"temperature_min,omitempty"`
+	TemperatureMax       float32  `protobuf:"fixed32,3,opt,name=temperature_max,json=temperatureMax,proto3" json:"temperature_max,omitempty"`
+	MainDescription      string   `protobuf:"bytes,4,opt,name=main_description,json=mainDescription,proto3" json:"main_description,omitempty"`
+	DetailedDescription  string   `protobuf:"bytes,5,opt,name=detailed_description,json=detailedDescription,proto3" json:"detailed_description,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ForecastDay) Reset()         { *m = ForecastDay{} }
+func (m *ForecastDay) String() string { return proto.CompactTextString(m) }
+func (*ForecastDay) ProtoMessage()    {}
+func (*ForecastDay) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{7}
+}
+
+func (m *ForecastDay) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ForecastDay.Unmarshal(m, b)
+}
+func (m *ForecastDay) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ForecastDay.Marshal(b, m, deterministic)
+}
+func (m *ForecastDay) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ForecastDay.Merge(m, src)
+}
+func (m *ForecastDay) XXX_Size() int {
+	return xxx_messageInfo_ForecastDay.Size(m)
+}
+func (m *ForecastDay) XXX_DiscardUnknown() {
+	xxx_messageInfo_ForecastDay.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ForecastDay proto.InternalMessageInfo
+
+func (m *ForecastDay) GetDate() string {
+	if m != nil {
+		return m.Date
+	}
+	return ""
+}
+
+func (m *ForecastDay) GetTemperatureMin() float32 {
+	if m != nil {
+		return m.TemperatureMin
+	}
+	return 0
+}
+
+func (m *ForecastDay) GetTemperatureMax() float32 {
+	if m != nil {
+		return m.TemperatureMax
+	}
+	return 0
+}
+
+func (m *ForecastDay) GetMainDescription() string {
+	if m != nil {
+		return m.MainDescription
+	}
+	return ""
+}
+
+func (m *ForecastDay) GetDetailedDescription() string {
+	if m != nil {
+		return m.DetailedDescription
+	}
+	return ""
+}
+
+type Forecast struct {
+	Source               string            `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Days                 []*ForecastDay    `protobuf:"bytes,2,rep,name=days,proto3" json:"days,omitempty"`
+	Location             *ResolvedLocation `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
+	Error                string            `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Forecast) Reset()         { *m = Forecast{} }
+func (m *Forecast) String() string { return proto.CompactTextString(m) }
+func (*Forecast) ProtoMessage()    {}
+func (*Forecast) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{8}
+}
+
+func (m *Forecast) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Forecast.Unmarshal(m, b)
+}
+func (m *Forecast) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Forecast.Marshal(b, m, deterministic)
+}
+func (m *Forecast) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Forecast.Merge(m, src)
+}
+func (m *Forecast) XXX_Size() int {
+	return xxx_messageInfo_Forecast.Size(m)
+}
+func (m *Forecast) XXX_DiscardUnknown() {
+	xxx_messageInfo_Forecast.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Forecast proto.InternalMessageInfo
+
+func (m *Forecast) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *Forecast) GetDays() []*ForecastDay {
+	if m != nil {
+		return m.Days
+	}
+	return nil
+}
+
+func (m *Forecast) GetLocation() *ResolvedLocation {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *Forecast) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type GetForecastRequest struct {
+	City string `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	// defaults to all the default backends
+	Backends             []string `protobuf:"bytes,2,rep,name=backends,proto3" json:"backends,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetForecastRequest) Reset()         { *m = GetForecastRequest{} }
+func (m *GetForecastRequest) String() string { return proto.CompactTextString(m) }
+func (*GetForecastRequest) ProtoMessage()    {}
+func (*GetForecastRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{9}
+}
+
+func (m *GetForecastRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetForecastRequest.Unmarshal(m, b)
+}
+func (m *GetForecastRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetForecastRequest.Marshal(b, m, deterministic)
+}
+func (m *GetForecastRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetForecastRequest.Merge(m, src)
+}
+func (m *GetForecastRequest) XXX_Size() int {
+	return xxx_messageInfo_GetForecastRequest.Size(m)
+}
+func (m *GetForecastRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetForecastRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetForecastRequest proto.InternalMessageInfo
+
+func (m *GetForecastRequest) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *GetForecastRequest) GetBackends() []string {
+	if m != nil {
+		return m.Backends
+	}
+	return nil
+}
+
+type GetForecastResponse struct {
+	City                 string      `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	Location             *Location   `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
+	Data                 []*Forecast `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *GetForecastResponse) Reset()         { *m = GetForecastResponse{} }
+func (m *GetForecastResponse) String() string { return proto.CompactTextString(m) }
+func (*GetForecastResponse) ProtoMessage()    {}
+func (*GetForecastResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{10}
+}
+
+func (m *GetForecastResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetForecastResponse.Unmarshal(m, b)
+}
+func (m *GetForecastResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetForecastResponse.Marshal(b, m, deterministic)
+}
+func (m *GetForecastResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetForecastResponse.Merge(m, src)
+}
+func (m *GetForecastResponse) XXX_Size() int {
+	return xxx_messageInfo_GetForecastResponse.Size(m)
+}
+func (m *GetForecastResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetForecastResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetForecastResponse proto.InternalMessageInfo
+
+func (m *GetForecastResponse) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *GetForecastResponse) GetLocation() *Location {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *GetForecastResponse) GetData() []*Forecast {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type ListBackendsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListBackendsRequest) Reset()         { *m = ListBackendsRequest{} }
+func (m *ListBackendsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListBackendsRequest) ProtoMessage()    {}
+func (*ListBackendsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{11}
+}
+
+func (m *ListBackendsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListBackendsRequest.Unmarshal(m, b)
+}
+func (m *ListBackendsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListBackendsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListBackendsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListBackendsRequest.Merge(m, src)
+}
+func (m *ListBackendsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListBackendsRequest.Size(m)
+}
+func (m *ListBackendsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListBackendsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListBackendsRequest proto.InternalMessageInfo
+
+type ListBackendsResponse struct {
+	Backends             []string `protobuf:"bytes,1,rep,name=backends,proto3" json:"backends,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListBackendsResponse) Reset()         { *m = ListBackendsResponse{} }
+func (m *ListBackendsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListBackendsResponse) ProtoMessage()    {}
+func (*ListBackendsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{12}
+}
+
+func (m *ListBackendsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListBackendsResponse.Unmarshal(m, b)
+}
+func (m *ListBackendsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListBackendsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListBackendsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListBackendsResponse.Merge(m, src)
+}
+func (m *ListBackendsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListBackendsResponse.Size(m)
+}
+func (m *ListBackendsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListBackendsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListBackendsResponse proto.InternalMessageInfo
+
+func (m *ListBackendsResponse) GetBackends() []string {
+	if m != nil {
+		return m.Backends
+	}
+	return nil
+}
+
+type StreamWeatherRequest struct {
+	City string `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	// defaults to all the default backends
+	Backends []string `protobuf:"bytes,2,rep,name=backends,proto3" json:"backends,omitempty"`
+	// resume after this update instead of starting with a snapshot, when set
+	LastEventId          uint64   `protobuf:"varint,3,opt,name=last_event_id,json=lastEventId,proto3" json:"last_event_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamWeatherRequest) Reset()         { *m = StreamWeatherRequest{} }
+func (m *StreamWeatherRequest) String() string { return proto.CompactTextString(m) }
+func (*StreamWeatherRequest) ProtoMessage()    {}
+func (*StreamWeatherRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{13}
+}
+
+func (m *StreamWeatherRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamWeatherRequest.Unmarshal(m, b)
+}
+func (m *StreamWeatherRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamWeatherRequest.Marshal(b, m, deterministic)
+}
+func (m *StreamWeatherRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamWeatherRequest.Merge(m, src)
+}
+func (m *StreamWeatherRequest) XXX_Size() int {
+	return xxx_messageInfo_StreamWeatherRequest.Size(m)
+}
+func (m *StreamWeatherRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamWeatherRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamWeatherRequest proto.InternalMessageInfo
+
+func (m *StreamWeatherRequest) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *StreamWeatherRequest) GetBackends() []string {
+	if m != nil {
+		return m.Backends
+	}
+	return nil
+}
+
+func (m *StreamWeatherRequest) GetLastEventId() uint64 {
+	if m != nil {
+		return m.LastEventId
+	}
+	return 0
+}
+
+type WeatherUpdate struct {
+	// the same event IDs as /v1/weather/{city}/stream
+	Id uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Types that are valid to be assigned to Update:
+	//	*WeatherUpdate_Snapshot
+	//	*WeatherUpdate_Reading
+	Update               isWeatherUpdate_Update `protobuf_oneof:"update"`
+	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
+	XXX_unrecognized     []byte                 `json:"-"`
+	XXX_sizecache        int32                  `json:"-"`
+}
+
+func (m *WeatherUpdate) Reset()         { *m = WeatherUpdate{} }
+func (m *WeatherUpdate) String() string { return proto.CompactTextString(m) }
+func (*WeatherUpdate) ProtoMessage()    {}
+func (*WeatherUpdate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{14}
+}
+
+func (m *WeatherUpdate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WeatherUpdate.Unmarshal(m, b)
+}
+func (m *WeatherUpdate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WeatherUpdate.Marshal(b, m, deterministic)
+}
+func (m *WeatherUpdate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WeatherUpdate.Merge(m, src)
+}
+func (m *WeatherUpdate) XXX_Size() int {
+	return xxx_messageInfo_WeatherUpdate.Size(m)
+}
+func (m *WeatherUpdate) XXX_DiscardUnknown() {
+	xxx_messageInfo_WeatherUpdate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WeatherUpdate proto.InternalMessageInfo
+
+func (m *WeatherUpdate) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type isWeatherUpdate_Update interface {
+	isWeatherUpdate_Update()
+}
+
+type WeatherUpdate_Snapshot struct {
+	Snapshot *GetWeatherResponse `protobuf:"bytes,2,opt,name=snapshot,proto3,oneof"`
+}
+
+type WeatherUpdate_Reading struct {
+	Reading *Reading `protobuf:"bytes,3,opt,name=reading,proto3,oneof"`
+}
+
+func (*WeatherUpdate_Snapshot) isWeatherUpdate_Update() {}
+
+func (*WeatherUpdate_Reading) isWeatherUpdate_Update() {}
+
+func (m *WeatherUpdate) GetUpdate() isWeatherUpdate_Update {
+	if m != nil {
+		return m.Update
+	}
+	return nil
+}
+
+func (m *WeatherUpdate) GetSnapshot() *GetWeatherResponse {
+	if x, ok := m.GetUpdate().(*WeatherUpdate_Snapshot); ok {
+		return x.Snapshot
+	}
+	return nil
+}
+
+func (m *WeatherUpdate) GetReading() *Reading {
+	if x, ok := m.GetUpdate().(*WeatherUpdate_Reading); ok {
+		return x.Reading
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*WeatherUpdate) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*WeatherUpdate_Snapshot)(nil),
+		(*WeatherUpdate_Reading)(nil),
+	}
+}
+
+type Reading struct {
+	Location             *Location            `protobuf:"bytes,1,opt,name=location,proto3" json:"location,omitempty"`
+	Data                 *Weather             `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Time                 *timestamp.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *Reading) Reset()         { *m = Reading{} }
+func (m *Reading) String() string { return proto.CompactTextString(m) }
+func (*Reading) ProtoMessage()    {}
+func (*Reading) Descriptor() ([]byte, []int) {
+	return fileDescriptor_231dcd72b885f4be, []int{15}
+}
+
+func (m *Reading) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Reading.Unmarshal(m, b)
+}
+func (m *Reading) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Reading.Marshal(b, m, deterministic)
+}
+func (m *Reading) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Reading.Merge(m, src)
+}
+func (m *Reading) XXX_Size() int {
+	return xxx_messageInfo_Reading.Size(m)
+}
+func (m *Reading) XXX_DiscardUnknown() {
+	xxx_messageInfo_Reading.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Reading proto.InternalMessageInfo
+
+func (m *Reading) GetLocation() *Location {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *Reading) GetData() *Weather {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Reading) GetTime() *timestamp.Timestamp {
+	if m != nil {
+		return m.Time
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Coordinates)(nil), "weather.v1.Coordinates")
+	proto.RegisterType((*Location)(nil), "weather.v1.Location")
+	proto.RegisterType((*ResolvedLocation)(nil), "weather.v1.ResolvedLocation")
+	proto.RegisterType((*Weather)(nil), "weather.v1.Weather")
+	proto.RegisterType((*LocationMismatch)(nil), "weather.v1.LocationMismatch")
+	proto.RegisterType((*GetWeatherRequest)(nil), "weather.v1.GetWeatherRequest")
+	proto.RegisterType((*GetWeatherResponse)(nil), "weather.v1.GetWeatherResponse")
+	proto.RegisterType((*ForecastDay)(nil), "weather.v1.ForecastDay")
+	proto.RegisterType((*Forecast)(nil), "weather.v1.Forecast")
+	proto.RegisterType((*GetForecastRequest)(nil), "weather.v1.GetForecastRequest")
+	proto.RegisterType((*GetForecastResponse)(nil), "weather.v1.GetForecastResponse")
+	proto.RegisterType((*ListBackendsRequest)(nil), "weather.v1.ListBackendsRequest")
+	proto.RegisterType((*ListBackendsResponse)(nil), "weather.v1.ListBackendsResponse")
+	proto.RegisterType((*StreamWeatherRequest)(nil), "weather.v1.StreamWeatherRequest")
+	proto.RegisterType((*WeatherUpdate)(nil), "weather.v1.WeatherUpdate")
+	proto.RegisterType((*Reading)(nil), "weather.v1.Reading")
+}
+
+func init() { proto.RegisterFile("weather.proto", fileDescriptor_231dcd72b885f4be) }
+
+var fileDescriptor_231dcd72b885f4be = []byte{
+	// 910 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x96, 0x4d, 0x6f, 0xe4, 0x34,
+	0x18, 0xc7, 0x37, 0x99, 0xb4, 0x9d, 0x3e, 0xd9, 0x76, 0xbb, 0x9e, 0x01, 0xc2, 0x68, 0xa1, 0x43,
+	0x2e, 0x5b, 0x84, 0x34, 0xed, 0x0e, 0x17, 0x90, 0x38, 0xb5, 0x85, 0xdd, 0x6a, 0xb7, 0x48, 0x64,
+	0x41, 0x48, 0x5c, 0x46, 0x6e, 0x6c, 0xa6, 0x66, 0x93, 0x38, 0xd8, 0x9e, 0x61, 0xcb, 0x9d, 0x6f,
+	0xc0, 0x81, 0x1b, 0x1f, 0x86, 0x3b, 0x02, 0xf1, 0x85, 0x50, 0x1c, 0x3b, 0xe3, 0xcc, 0x8b, 0x28,
+	0x85, 0x9b, 0x9f, 0x17, 0x3f, 0xf6, 0xf3, 0xf7, 0xcf, 0x4e, 0x60, 0xef, 0x07, 0x8a, 0xd5, 0x35,
+	0x15, 0xa3, 0x52, 0x70, 0xc5, 0x11, 0x58, 0x73, 0xfe, 0x64, 0x70, 0x38, 0xe5, 0x7c, 0x9a, 0xd1,
+	0x63, 0x1d, 0xb9, 0x9a, 0x7d, 0x7b, 0xac, 0x58, 0x4e, 0xa5, 0xc2, 0x79, 0x59, 0x27, 0xc7, 0x4f,
+	0x21, 0x3c, 0xe3, 0x5c, 0x10, 0x56, 0x60, 0x45, 0x25, 0x1a, 0x40, 0x37, 0xc3, 0x8a, 0xa9, 0x19,
+	0xa1, 0x91, 0x37, 0xf4, 0x8e, 0xbc, 0xa4, 0xb1, 0xd1, 0x23, 0xd8, 0xcd, 0x78, 0x31, 0xad, 0x83,
+	0xbe, 0x0e, 0x2e, 0x1c, 0xf1, 0x5f, 0x1e, 0x74, 0x5f, 0xf0, 0x14, 0x2b, 0xc6, 0x0b, 0x84, 0x20,
+	0x28, 0x70, 0x5e, 0x97, 0xd8, 0x4d, 0xf4, 0x18, 0xbd, 0x07, 0xf7, 0x31, 0xc9, 0x59, 0x31, 0x11,
+	0x74, 0xca, 0x78, 0xa1, 0x2b, 0xec, 0x26, 0xa1, 0xf6, 0x25, 0xda, 0x85, 0x22, 0xd8, 0x49, 0xf9,
+	0xac, 0x50, 0xe2, 0x26, 0xea, 0xe8, 0xa8, 0x35, 0xd1, 0x21, 0x84, 0x25, 0x97, 0x0a, 0x67, 0x93,
+	0x94, 0x13, 0x1a, 0x05, 0x3a, 0x0a, 0xb5, 0xeb, 0x8c, 0x13, 0x8a, 0x3e, 0x86, 0x30, 0x5d, 0xf4,
+	0x11, 0x6d, 0x0d, 0xbd, 0xa3, 0x70, 0xfc, 0xd6, 0x68, 0x21, 0xc5, 0xc8, 0x69, 0x33, 0x09, 0xd3,
+	0x76, 0xcf, 0x95, 0x2a, 0x3f, 0xf2, 0x82, 0x46, 0xdb, 0xba, 0x70, 0x63, 0xc7, 0x73, 0x38, 0x48,
+	0xa8, 0xe4, 0xd9, 0x9c, 0x92, 0xa6, 0xb9, 0x13, 0xe8, 0x66, 0x66, 0xac, 0x1b, 0x0c, 0xc7, 0x7d,
+	0x77, 0x1d, 0x9b, 0x97, 0x34, 0x59, 0xe8, 0x04, 0xfa, 0xa5, 0xe0, 0x73, 0x46, 0xa8, 0x98, 0x58,
+	0xe7, 0x84, 0x11, 0x23, 0x01, 0xb2, 0x31, 0x3b, 0xf3, 0x82, 0xc4, 0xbf, 0xf9, 0xb0, 0xf3, 0x75,
+	0x5d, 0x13, 0xbd, 0x09, 0xdb, 0x92, 0xcf, 0x44, 0x6a, 0xe5, 0x34, 0x16, 0x1a, 0x42, 0xa8, 0x68,
+	0x5e, 0x52, 0x81, 0xd5, 0x4c, 0xd4, 0x27, 0xe2, 0x27, 0xae, 0x0b, 0x3d, 0x86, 0x07, 0x8e, 0x39,
+	0xc9, 0x59, 0xa1, 0x75, 0xf5, 0x93, 0x7d, 0xc7, 0x7d, 0xc9, 0x8a, 0x95, 0x44, 0xfc, 0x3a, 0x0a,
+	0x56, 0x13, 0xf1, 0x6b, 0xf4, 0x3e, 0x1c, 0xe4, 0x98, 0x15, 0x13, 0x42, 0x65, 0x2a, 0x58, 0xa9,
+	0x35, 0xd8, 0xd2, 0xbb, 0x7a, 0x50, 0xf9, 0xcf, 0x17, 0x6e, 0xf4, 0x04, 0xfa, 0x84, 0x2a, 0xcc,
+	0x32, 0x4a, 0x5a, 0xe9, 0xb5, 0xc4, 0x3d, 0x1b, 0x73, 0xa7, 0x7c, 0xe4, 0x28, 0xbb, 0xa3, 0x95,
+	0x7d, 0xe4, 0x2a, 0xbb, 0x7c, 0x12, 0x8e, 0xc2, 0x7d, 0xd8, 0xa2, 0x42, 0x70, 0x11, 0x75, 0x75,
+	0xf5, 0xda, 0x88, 0x2f, 0xe1, 0xc0, 0xe6, 0x5e, 0x32, 0x99, 0x63, 0x95, 0x5e, 0x57, 0x8c, 0xd5,
+	0xfa, 0xc9, 0xc8, 0x1b, 0x76, 0x2a, 0xc6, 0x8c, 0x59, 0x31, 0x46, 0x98, 0x54, 0xb8, 0x48, 0xe9,
+	0xe4, 0x55, 0x6e, 0x08, 0x07, 0xeb, 0x7a, 0x9e, 0xc7, 0x67, 0xf0, 0xf0, 0x29, 0x55, 0xe6, 0x58,
+	0x12, 0xfa, 0xfd, 0x8c, 0x4a, 0x55, 0xa1, 0x9e, 0x32, 0x75, 0x63, 0x51, 0xaf, 0xc6, 0x15, 0x51,
+	0x57, 0x38, 0x7d, 0x45, 0x0b, 0x22, 0x23, 0x5f, 0x2f, 0xd2, 0xd8, 0xf1, 0xef, 0x1e, 0x20, 0xb7,
+	0x8a, 0x2c, 0x79, 0x21, 0xe9, 0xda, 0x32, 0x2e, 0x68, 0xfe, 0xad, 0x40, 0x7b, 0x0c, 0x01, 0xc1,
+	0x0a, 0x47, 0x9d, 0x61, 0xe7, 0x28, 0x1c, 0xf7, 0xdc, 0x6c, 0xbb, 0xa0, 0x4e, 0x40, 0x17, 0xf0,
+	0xb0, 0x01, 0x31, 0x37, 0xd2, 0x44, 0xc1, 0xaa, 0xe4, 0xcb, 0xf2, 0x25, 0x07, 0xd9, 0x92, 0x27,
+	0xfe, 0xd3, 0x83, 0xf0, 0x33, 0x2e, 0x68, 0x8a, 0xa5, 0x3a, 0xc7, 0x37, 0x55, 0x27, 0x04, 0xab,
+	0xe6, 0xee, 0x57, 0xe3, 0x75, 0x20, 0xfa, 0xb7, 0x05, 0xb1, 0x73, 0x6b, 0x10, 0x83, 0x7f, 0x07,
+	0xe2, 0xd6, 0x46, 0x10, 0xe3, 0x5f, 0x3d, 0xe8, 0xda, 0x9e, 0x36, 0xde, 0xbf, 0x0f, 0xaa, 0x46,
+	0x6f, 0xea, 0x13, 0x5e, 0x7a, 0x6b, 0x1c, 0x3d, 0x12, 0x9d, 0xd4, 0x42, 0xbb, 0x73, 0x37, 0xb4,
+	0x03, 0x17, 0xed, 0x73, 0x4d, 0x91, 0x5d, 0xe7, 0xae, 0x30, 0xfe, 0xe4, 0x41, 0xaf, 0x55, 0xe6,
+	0x7f, 0xa5, 0xf1, 0xa8, 0x45, 0x63, 0x7f, 0x9d, 0x40, 0x35, 0x8e, 0xf1, 0x1b, 0xd0, 0x7b, 0xc1,
+	0xa4, 0x3a, 0x35, 0xfb, 0x32, 0xed, 0xc4, 0x63, 0xe8, 0xb7, 0xdd, 0x66, 0x7b, 0x6e, 0x4b, 0xde,
+	0x52, 0x4b, 0xdf, 0x41, 0xff, 0xa5, 0x12, 0x14, 0xe7, 0xff, 0xed, 0x9e, 0xa2, 0x18, 0xf6, 0x32,
+	0x2c, 0xd5, 0x84, 0xce, 0x69, 0xa1, 0xaa, 0xc7, 0xba, 0x3a, 0xb5, 0x20, 0x09, 0x2b, 0xe7, 0xa7,
+	0x95, 0xef, 0x82, 0xc4, 0xbf, 0x78, 0xb0, 0x67, 0x96, 0xf9, 0xaa, 0xd4, 0xa0, 0xef, 0x83, 0xcf,
+	0x88, 0x5e, 0x23, 0x48, 0x7c, 0x46, 0xd0, 0x27, 0xd0, 0x95, 0x05, 0x2e, 0xe5, 0x35, 0x57, 0x46,
+	0xb4, 0x77, 0x5d, 0x19, 0x56, 0x1f, 0x82, 0x67, 0xf7, 0x92, 0x66, 0x06, 0x3a, 0x86, 0x1d, 0x41,
+	0x31, 0x61, 0xc5, 0xd4, 0x30, 0xd3, 0x6b, 0x33, 0xa3, 0x43, 0xcf, 0xee, 0x25, 0x36, 0xeb, 0xb4,
+	0x0b, 0xdb, 0x33, 0xbd, 0x91, 0xf8, 0x67, 0x0f, 0x76, 0x4c, 0xc2, 0x1d, 0x3e, 0x58, 0xf6, 0x1d,
+	0xf1, 0x57, 0x57, 0x6d, 0xbf, 0x23, 0x23, 0x08, 0xaa, 0x6f, 0xa5, 0xd9, 0xde, 0x60, 0x54, 0xff,
+	0x6e, 0x8c, 0xec, 0xef, 0xc6, 0xe8, 0x4b, 0xfb, 0xbb, 0x91, 0xe8, 0xbc, 0xf1, 0x1f, 0x3e, 0xec,
+	0x9b, 0x0a, 0x2f, 0xa9, 0x98, 0xb3, 0x94, 0xa2, 0xe7, 0x00, 0x0b, 0x19, 0xd0, 0x3b, 0x9b, 0xe4,
+	0xd1, 0xa7, 0x38, 0xf8, 0x07, 0xf5, 0xd0, 0xe7, 0x10, 0x3a, 0x3c, 0xa3, 0xe5, 0xf4, 0xa5, 0xfb,
+	0x32, 0x38, 0xdc, 0x18, 0x37, 0xf5, 0xbe, 0x80, 0xfb, 0x2e, 0x81, 0xa8, 0x35, 0x61, 0x0d, 0xb2,
+	0x83, 0xe1, 0xe6, 0x84, 0x66, 0x8b, 0x7b, 0x2d, 0x40, 0x51, 0x6b, 0xca, 0x3a, 0x76, 0x07, 0x6f,
+	0xaf, 0x39, 0x80, 0x1a, 0xb8, 0x13, 0xef, 0x34, 0xfc, 0x66, 0xd7, 0x44, 0xcb, 0xab, 0xab, 0x6d,
+	0xad, 0xfc, 0x87, 0x7f, 0x0f, 0x00, 0x76, 0xb4, 0xee, 0xa0, 0x13, 0x0a, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// WeatherServiceClient is the client API for WeatherService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type WeatherServiceClient interface {
+	// GetWeather gets the current weather from the requested backends, like /v1/weather/{city}
+	GetWeather(ctx context.Context, in *GetWeatherRequest, opts ...grpc.CallOption) (*GetWeatherResponse, error)
+	// GetForecast gets the daily forecast from the requested backends, like /v1/forecast/{city}
+	GetForecast(ctx context.Context, in *GetForecastRequest, opts ...grpc.CallOption) (*GetForecastResponse, error)
+	// ListBackends lists the configured backends, like /v1/backends
+	ListBackends(ctx context.Context, in *ListBackendsRequest, opts ...grpc.CallOption) (*ListBackendsResponse, error)
+	// StreamWeather sends a snapshot of the current weather, then every new reading, like /v1/weather/{city}/stream
+	StreamWeather(ctx context.Context, in *StreamWeatherRequest, opts ...grpc.CallOption) (WeatherService_StreamWeatherClient, error)
+}
+
+type weatherServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewWeatherServiceClient(cc *grpc.ClientConn) WeatherServiceClient {
+	return &weatherServiceClient{cc}
+}
+
+func (c *weatherServiceClient) GetWeather(ctx context.Context, in *GetWeatherRequest, opts ...grpc.CallOption) (*GetWeatherResponse, error) {
+	out := new(GetWeatherResponse)
+	err := c.cc.Invoke(ctx, "/weather.v1.WeatherService/GetWeather", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *weatherServiceClient) GetForecast(ctx context.Context, in *GetForecastRequest, opts ...grpc.CallOption) (*GetForecastResponse, error) {
+	out := new(GetForecastResponse)
+	err := c.cc.Invoke(ctx, "/weather.v1.WeatherService/GetForecast", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *weatherServiceClient) ListBackends(ctx context.Context, in *ListBackendsRequest, opts ...grpc.CallOption) (*ListBackendsResponse, error) {
+	out := new(ListBackendsResponse)
+	err := c.cc.Invoke(ctx, "/weather.v1.WeatherService/ListBackends", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *weatherServiceClient) StreamWeather(ctx context.Context, in *StreamWeatherRequest, opts ...grpc.CallOption) (WeatherService_StreamWeatherClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WeatherService_serviceDesc.Streams[0], "/weather.v1.WeatherService/StreamWeather", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &weatherServiceStreamWeatherClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WeatherService_StreamWeatherClient interface {
+	Recv() (*WeatherUpdate, error)
+	grpc.ClientStream
+}
+
+type weatherServiceStreamWeatherClient struct {
+	grpc.ClientStream
+}
+
+func (x *weatherServiceStreamWeatherClient) Recv() (*WeatherUpdate, error) {
+	m := new(WeatherUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WeatherServiceServer is the server API for WeatherService service.
+type WeatherServiceServer interface {
+	// GetWeather gets the current weather from the requested backends, like /v1/weather/{city}
+	GetWeather(context.Context, *GetWeatherRequest) (*GetWeatherResponse, error)
+	// GetForecast gets the daily forecast from the requested backends, like /v1/forecast/{city}
+	GetForecast(context.Context, *GetForecastRequest) (*GetForecastResponse, error)
+	// ListBackends lists the configured backends, like /v1/backends
+	ListBackends(context.Context, *ListBackendsRequest) (*ListBackendsResponse, error)
+	// StreamWeather sends a snapshot of the current weather, then every new reading, like /v1/weather/{city}/stream
+	StreamWeather(*StreamWeatherRequest, WeatherService_StreamWeatherServer) error
+}
+
+func RegisterWeatherServiceServer(s *grpc.Server, srv WeatherServiceServer) {
+	s.RegisterService(&_WeatherService_serviceDesc, srv)
+}
+
+func _WeatherService_GetWeather_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWeatherRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WeatherServiceServer).GetWeather(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weather.v1.WeatherService/GetWeather",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WeatherServiceServer).GetWeather(ctx, req.(*GetWeatherRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WeatherService_GetForecast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetForecastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WeatherServiceServer).GetForecast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weather.v1.WeatherService/GetForecast",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WeatherServiceServer).GetForecast(ctx, req.(*GetForecastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WeatherService_ListBackends_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBackendsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WeatherServiceServer).ListBackends(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/weather.v1.WeatherService/ListBackends",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WeatherServiceServer).ListBackends(ctx, req.(*ListBackendsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WeatherService_StreamWeather_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamWeatherRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WeatherServiceServer).StreamWeather(m, &weatherServiceStreamWeatherServer{stream})
+}
+
+type WeatherService_StreamWeatherServer interface {
+	Send(*WeatherUpdate) error
+	grpc.ServerStream
+}
+
+type weatherServiceStreamWeatherServer struct {
+	grpc.ServerStream
+}
+
+func (x *weatherServiceStreamWeatherServer) Send(m *WeatherUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _WeatherService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "weather.v1.WeatherService",
+	HandlerType: (*WeatherServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetWeather",
+			Handler:    _WeatherService_GetWeather_Handler,
+		},
+		{
+			MethodName: "GetForecast",
+			Handler:    _WeatherService_GetForecast_Handler,
+		},
+		{
+			MethodName: "ListBackends",
+			Handler:    _WeatherService_ListBackends_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamWeather",
+			Handler:       _WeatherService_StreamWeather_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "weather.proto",
+}
@@ -10,6 +10,7 @@ class Weather extends React.Component {
     super(props)
     this.state = {
       city: "",
+      suggestions: [],
       sources: [],
       selectedSource: "Defaults",
       validated: false,
@@ -33,12 +34,23 @@ class Weather extends React.Component {
         sources.unshift("Defaults")
         this.setState({ sources: sources })
       })
+    // start with the weather near the user, when the server can figure out where that is
+    fetch('http://localhost:8080/v1/weather/here').then(res => res.ok ? res.json() : Promise.reject(res))
+      .then((data) => {
+        if (this.state.currentWeather.city.length === 0) {
+          this.setState({ currentWeather: data })
+        }
+      })
+      .catch(() => {})
   }
 
   render() {
     let dropDownItems = this.state.sources.map((source) =>
       <Dropdown.Item key={source} eventKey={source}>{source}</Dropdown.Item>
     )
+    let suggestions = this.state.suggestions.map((suggestion) =>
+      <option key={suggestion} value={suggestion} />
+    )
     return (
       <div>
         <h1>Weather</h1>
@@ -59,8 +71,12 @@ class Weather extends React.Component {
               onChange={this.handleChange}
               value={this.state.city}
               type="text"
+              list="city-suggestions"
               required
             />
+            <datalist id="city-suggestions">
+              {suggestions}
+            </datalist>
             <InputGroup.Append>
 
               <Dropdown
@@ -86,13 +102,28 @@ class Weather extends React.Component {
           </InputGroup>
 
         </Form>
-        <WeatherTable city={this.state.currentWeather.city} weatherData={this.state.currentWeather.data} error={this.state.currentWeather.error} />
+        <WeatherTable city={this.state.currentWeather.city} weatherData={this.state.currentWeather.data} error={this.state.currentWeather.error} locationMismatch={this.state.currentWeather.location_mismatch} />
       </div>
     )
   }
 
   handleChange(e) {
-    this.setState({ city: e.target.value })
+    let city = e.target.value
+    this.setState({ city: city })
+    if (city.trim().length < 3) {
+      this.setState({ suggestions: [] })
+      return
+    }
+    fetch('http://localhost:8080/v1/locations/search?limit=5&q=' + encodeURIComponent(city)).then(res => res.json())
+      .then((data) => {
+        let suggestions = (data.candidates || []).map((candidate) =>
+          [candidate.location.name, candidate.location.admin_region, candidate.location.country].filter(part => part).join(", ")
+        )
+        this.setState({ suggestions: suggestions })
+      })
+      .catch(() => {
+        this.setState({ suggestions: [] })
+      })
   }
 
   handleSelect(selectedValue) {
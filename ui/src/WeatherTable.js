@@ -4,19 +4,26 @@ import Alert from 'react-bootstrap/Alert'
 
 class WeatherTable extends React.Component {
   render() {
-    let { city, weatherData, error } = this.props
+    let { city, weatherData, error, locationMismatch } = this.props
     if (city.length > 0 && weatherData && weatherData.length > 0) {
       let weatherRows = weatherData.map((data) => {
         let details = data.error && data.error.length > 0 ? data.error : data.detailed_description
-        return <tr key={data.source}><td>{data.source}</td><td>{data.temperature}&deg;C</td><td>{data.temperature_min}&deg;C</td><td>{data.temperature_max}&deg;C</td><td>{data.main_description}</td><td>{details}</td></tr>
+        let location = data.location ? [data.location.name, data.location.admin_region, data.location.country].filter(part => part).join(", ") : ""
+        return <tr key={data.source}><td>{data.source}</td><td>{location}</td><td>{data.temperature}&deg;C</td><td>{data.temperature_min}&deg;C</td><td>{data.temperature_max}&deg;C</td><td>{data.main_description}</td><td>{details}</td></tr>
       })
+      let mismatchWarning = null
+      if (locationMismatch) {
+        mismatchWarning = <Alert key="location-mismatch" variant="warning">{locationMismatch.sources.join(" and ")} returned weather for places {locationMismatch.distance_km}km apart</Alert>
+      }
       return (
         <div>
           <h3>Weather Results for {this.props.city}</h3>
+          {mismatchWarning}
           <Table striped bordered hover variant="dark" responsive>
             <thead>
               <tr>
                 <th>Source</th>
+                <th>Location</th>
                 <th>Temperature</th>
                 <th>Temperature Min</th>
                 <th>Temperature Max</th>